@@ -88,6 +88,47 @@ type FileMatch struct {
 
 	// Commit SHA1 (hex) of the (sub)repo holding the file.
 	Version string
+
+	// OriginalFileName is set if FileName is a virtual path of text
+	// extracted from another file, eg. a member of an archive. It is the
+	// name of that file, which links should point to.
+	OriginalFileName string `json:",omitempty"`
+
+	// Encoding is the character encoding of the file if it isn't UTF-8.
+	// Content and matches were converted to UTF-8 when indexing.
+	Encoding string `json:",omitempty"`
+
+	// Commit is set for commit messages and diffs from history shards.
+	Commit *Commit `json:",omitempty"`
+}
+
+// Kinds of documents in history shards.
+const (
+	// CommitKindMessage documents hold the message of a commit. They are
+	// named like the commit hash.
+	CommitKindMessage = "commit"
+
+	// CommitKindDiff documents hold the changes a commit made to a file, in
+	// unified diff format. They are named like the file.
+	CommitKindDiff = "diff"
+)
+
+// Commit describes the commit of a document in a history shard.
+type Commit struct {
+	// Kind is CommitKindMessage or CommitKindDiff.
+	Kind string
+
+	// Hash is the SHA1 (hex) of the commit.
+	Hash string
+
+	// Author is formatted as "Name <email>".
+	Author string
+
+	// Date is the author date of the commit.
+	Date time.Time
+
+	// Subject is the first line of the commit message.
+	Subject string
 }
 
 func (m *FileMatch) sizeBytes() (sz uint64) {
@@ -108,6 +149,10 @@ func (m *FileMatch) sizeBytes() (sz uint64) {
 	} {
 		sz += stringHeaderBytes + uint64(len(s))
 	}
+	sz += uint64(len(m.OriginalFileName) + len(m.Encoding))
+	if c := m.Commit; c != nil {
+		sz += uint64(len(c.Kind) + len(c.Hash) + len(c.Author) + len(c.Subject))
+	}
 
 	// Branches
 	sz += sliceHeaderBytes
@@ -164,6 +209,11 @@ type ChunkMatch struct {
 	// its length will equal that of Ranges. Any of its elements may be nil.
 	SymbolInfo []*Symbol
 
+	// Blame holds the commit which last modified each line of Content. It
+	// is only set if SearchOptions.IncludeBlame is set and the shard has
+	// blame information. Any of its elements may be nil.
+	Blame []*Blame `json:",omitempty"`
+
 	Score      float64
 	DebugScore string
 }
@@ -199,6 +249,12 @@ func (cm *ChunkMatch) sizeBytes() (sz uint64) {
 	// DebugScore
 	sz += stringHeaderBytes + uint64(len(cm.DebugScore))
 
+	for _, b := range cm.Blame {
+		if b != nil {
+			sz += b.sizeBytes()
+		}
+	}
+
 	return
 }
 
@@ -247,6 +303,27 @@ type LineMatch struct {
 	DebugScore string
 
 	LineFragments []LineFragmentMatch
+
+	// Blame is the commit which last modified the line. It is only set if
+	// SearchOptions.IncludeBlame is set and the shard has blame
+	// information.
+	Blame *Blame `json:",omitempty"`
+}
+
+// Blame identifies the commit which last modified a line.
+type Blame struct {
+	// Hash is the SHA1 (hex) of the commit.
+	Hash string
+
+	// Author is formatted as "Name <email>".
+	Author string
+
+	// Date is the author date of the commit.
+	Date time.Time
+}
+
+func (b *Blame) sizeBytes() uint64 {
+	return uint64(len(b.Hash) + len(b.Author))
 }
 
 func (lm *LineMatch) sizeBytes() (sz uint64) {
@@ -277,6 +354,10 @@ func (lm *LineMatch) sizeBytes() (sz uint64) {
 		sz += lf.sizeBytes()
 	}
 
+	if lm.Blame != nil {
+		sz += lm.Blame.sizeBytes()
+	}
+
 	return
 }
 
@@ -285,10 +366,18 @@ type Symbol struct {
 	Kind       string
 	Parent     string
 	ParentKind string
+
+	// ID is the fully qualified identifier of the symbol if it was imported
+	// from a precise code intelligence index, eg. a SCIP symbol.
+	ID string
+
+	// Ref is true if the match is a reference to the symbol rather than its
+	// definition. References only carry Sym, see query.SymbolRef.
+	Ref bool
 }
 
 func (s *Symbol) sizeBytes() uint64 {
-	return 4*stringHeaderBytes + uint64(len(s.Sym)+len(s.Kind)+len(s.Parent)+len(s.ParentKind))
+	return 4*stringHeaderBytes + uint64(len(s.Sym)+len(s.Kind)+len(s.Parent)+len(s.ParentKind)+len(s.ID))
 }
 
 // LineFragmentMatch a segment of matching text within a line.
@@ -387,6 +476,11 @@ type Stats struct {
 	// ngram filter indicating it had no matches.
 	ShardsSkippedFilter int
 
+	// Shards that we did not process completely because they were too slow:
+	// they hit the per shard timeout or were quarantined after recent slow
+	// searches.
+	ShardsTimedOut int
+
 	// Number of non-overlapping matches
 	MatchCount int
 
@@ -404,7 +498,7 @@ type Stats struct {
 }
 
 func (s *Stats) sizeBytes() (sz uint64) {
-	sz = 16 * 8 // This assumes we are running on a 64-bit architecture
+	sz = 17 * 8 // This assumes we are running on a 64-bit architecture
 	sz += 1     // FlushReason
 
 	return
@@ -424,6 +518,7 @@ func (s *Stats) Add(o Stats) {
 	s.ShardsScanned += o.ShardsScanned
 	s.ShardsSkipped += o.ShardsSkipped
 	s.ShardsSkippedFilter += o.ShardsSkippedFilter
+	s.ShardsTimedOut += o.ShardsTimedOut
 	s.Wait += o.Wait
 	s.RegexpsConsidered += o.RegexpsConsidered
 
@@ -453,6 +548,7 @@ func (s *Stats) Zero() bool {
 		s.ShardsScanned > 0 ||
 		s.ShardsSkipped > 0 ||
 		s.ShardsSkippedFilter > 0 ||
+		s.ShardsTimedOut > 0 ||
 		s.Wait > 0 ||
 		s.RegexpsConsidered > 0)
 }
@@ -594,9 +690,30 @@ type Repository struct {
 	// before this field was added.
 	LatestCommitDate time.Time
 
+	// Snapshot is the revision, eg. a release tag, of a snapshot shard of
+	// the repository. Snapshot shards are kept next to the shards of the
+	// indexed branches and are only searched by queries with a query.At
+	// atom. Branches holds the revision and its commit.
+	Snapshot string `json:",omitempty"`
+
 	// FileTombstones is a set of file paths that should be ignored across all branches
 	// in this shard.
 	FileTombstones map[string]struct{} `json:",omitempty"`
+
+	// FileStates records the files indexed from a plain directory by path,
+	// so later runs only need to index the files which changed, see
+	// dirindex. Searchers drop it when loading a shard.
+	FileStates map[string]FileState `json:",omitempty"`
+}
+
+// FileState identifies the version of an indexed file.
+type FileState struct {
+	Size    int64
+	ModTime time.Time
+
+	// Hash is the SHA1 (hex) of the content. It is empty if the file was
+	// too large to be indexed.
+	Hash string `json:",omitempty"`
 }
 
 func (r *Repository) UnmarshalJSON(data []byte) error {
@@ -831,6 +948,17 @@ type SearchOptions struct {
 	// results
 	MaxDocDisplayCount int
 
+	// TopK, if positive, returns only the TopK highest scoring files.
+	// Instead of returning the first ShardMaxMatchCount matches, shards
+	// return the best files among the documents they evaluate, so important
+	// documents late in a shard aren't lost. Documents which can't score high
+	// enough are skipped only after their matches were found, so every
+	// candidate is still evaluated. ShardMaxMatchCount bounds that cost: a
+	// shard stops after evaluating that many matches, returned or not.
+	// TotalMaxMatchCount doesn't apply. It is ignored if UseDocumentRanks is
+	// set.
+	TopK int
+
 	// If set to a number greater than zero then up to this many number
 	// of context lines will be added before and after each matched line.
 	// Note that the included context lines might contain matches and
@@ -857,8 +985,48 @@ type SearchOptions struct {
 	// If set, the search results will contain debug information for scoring.
 	DebugScore bool
 
+	// IncludeBlame sets the commit which last modified each line of
+	// LineMatches and ChunkMatches, if the shard was indexed with blame
+	// information.
+	IncludeBlame bool
+
 	// SpanContext is the opentracing span context, if it exists, from the zoekt client
 	SpanContext map[string]string
+
+	// Class declares the kind of search, which decides the queue it is
+	// scheduled in. The zero value is SearchClassInteractive.
+	Class SearchClass
+}
+
+// SearchClass is the scheduling class of a search. Each class runs in its own
+// queue of limited concurrency, so for example bulk exports never delay
+// searches of users.
+type SearchClass uint8
+
+const (
+	// SearchClassInteractive is for searches a user is waiting on. They are
+	// moved to the batch queue if they run for long.
+	SearchClassInteractive SearchClass = iota
+
+	// SearchClassBatch is for searches which are expected to run for long,
+	// eg. of API clients. They run in the batch queue from the start.
+	SearchClassBatch
+
+	// SearchClassBackground is for bulk jobs like exports. They run in their
+	// own queue and never take capacity from the other classes.
+	SearchClassBackground
+)
+
+func (c SearchClass) String() string {
+	switch c {
+	case SearchClassInteractive:
+		return "interactive"
+	case SearchClassBatch:
+		return "batch"
+	case SearchClassBackground:
+		return "background"
+	}
+	return fmt.Sprintf("SearchClass(%d)", c)
 }
 
 func (s *SearchOptions) String() string {
@@ -0,0 +1,147 @@
+package zoekt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var errMalformedBlame = errors.New("malformed blame")
+
+// marshalBlame encodes the blame of a document. The distinct commits are
+// stored once, as binary hash, author and unix date, followed by the hunks
+// as line delta and commit index.
+func marshalBlame(hunks []BlameHunk) ([]byte, error) {
+	if len(hunks) == 0 {
+		return nil, nil
+	}
+
+	var commits []*Blame
+	commitIdx := map[string]int{}
+	for i := range hunks {
+		h := &hunks[i]
+		if h.Line < 1 || (i > 0 && hunks[i-1].Line >= h.Line) {
+			return nil, fmt.Errorf("blame hunks must start at increasing lines, got %d", h.Line)
+		}
+		if _, ok := commitIdx[h.Hash]; !ok {
+			commitIdx[h.Hash] = len(commits)
+			commits = append(commits, &h.Blame)
+		}
+	}
+
+	var buf []byte
+	var enc [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) {
+		buf = append(buf, enc[:binary.PutUvarint(enc[:], x)]...)
+	}
+
+	putUvarint(uint64(len(commits)))
+	for _, c := range commits {
+		hash, err := hex.DecodeString(c.Hash)
+		if err != nil || len(hash) != 20 {
+			return nil, fmt.Errorf("blame: invalid commit hash %q", c.Hash)
+		}
+		buf = append(buf, hash...)
+		putUvarint(uint64(len(c.Author)))
+		buf = append(buf, c.Author...)
+		buf = append(buf, enc[:binary.PutVarint(enc[:], c.Date.Unix())]...)
+	}
+
+	putUvarint(uint64(len(hunks)))
+	last := 0
+	for _, h := range hunks {
+		putUvarint(uint64(h.Line - last))
+		putUvarint(uint64(commitIdx[h.Hash]))
+		last = h.Line
+	}
+	return buf, nil
+}
+
+// unmarshalBlame decodes the output of marshalBlame. Dates are in UTC.
+func unmarshalBlame(b []byte) ([]BlameHunk, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	r := &blameReader{b: b}
+	n := r.uvarint()
+	if n > len(r.b)/20 {
+		return nil, errMalformedBlame
+	}
+	commits := make([]Blame, n)
+	for i := range commits {
+		if len(r.b) < 20 {
+			return nil, errMalformedBlame
+		}
+		commits[i].Hash = hex.EncodeToString(r.b[:20])
+		r.b = r.b[20:]
+		commits[i].Author = r.str()
+		date, m := binary.Varint(r.b)
+		if m <= 0 {
+			return nil, errMalformedBlame
+		}
+		r.b = r.b[m:]
+		commits[i].Date = time.Unix(date, 0).UTC()
+	}
+
+	n = r.uvarint()
+	if n > len(r.b)/2 {
+		return nil, errMalformedBlame
+	}
+	hunks := make([]BlameHunk, n)
+	line := 0
+	for i := range hunks {
+		line += r.uvarint()
+		c := r.uvarint()
+		if c >= len(commits) {
+			return nil, errMalformedBlame
+		}
+		hunks[i] = BlameHunk{Line: line, Blame: commits[c]}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return hunks, nil
+}
+
+// blameAt returns the blame of the 1-based line number, or nil if hunks
+// don't cover it.
+func blameAt(hunks []BlameHunk, line int) *Blame {
+	i := sort.Search(len(hunks), func(i int) bool { return hunks[i].Line > line })
+	if i == 0 {
+		return nil
+	}
+	b := hunks[i-1].Blame
+	return &b
+}
+
+type blameReader struct {
+	b   []byte
+	err error
+}
+
+func (r *blameReader) uvarint() int {
+	x, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.b = nil
+		r.err = errMalformedBlame
+		return 0
+	}
+	r.b = r.b[n:]
+	return int(x)
+}
+
+func (r *blameReader) str() string {
+	l := r.uvarint()
+	if l > len(r.b) {
+		r.b = nil
+		r.err = errMalformedBlame
+		return ""
+	}
+	s := string(r.b[:l])
+	r.b = r.b[l:]
+	return s
+}
@@ -0,0 +1,49 @@
+package zoekt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlameRoundTrip(t *testing.T) {
+	alice := Blame{Hash: strings.Repeat("a1", 20), Author: "Alice <alice@example.com>", Date: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+	bob := Blame{Hash: strings.Repeat("b2", 20), Author: "Bob <bob@example.com>", Date: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)}
+	hunks := []BlameHunk{{1, alice}, {3, bob}, {10, alice}}
+
+	b, err := marshalBlame(hunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshalBlame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, hunks) {
+		t.Errorf("got %v, want %v", got, hunks)
+	}
+
+	for line, want := range map[int]*Blame{0: nil, 1: &alice, 2: &alice, 3: &bob, 9: &bob, 10: &alice, 100: &alice} {
+		if got := blameAt(got, line); !reflect.DeepEqual(got, want) {
+			t.Errorf("line %d: got %v, want %v", line, got, want)
+		}
+	}
+
+	if _, err := unmarshalBlame(b[:len(b)-1]); err == nil {
+		t.Error("want error for truncated blame")
+	}
+}
+
+func TestBlameInvalid(t *testing.T) {
+	c := Blame{Hash: strings.Repeat("a1", 20)}
+	for _, hunks := range [][]BlameHunk{
+		{{0, c}},
+		{{2, c}, {2, c}},
+		{{1, Blame{Hash: "abc"}}},
+	} {
+		if _, err := marshalBlame(hunks); err == nil {
+			t.Errorf("%v: want error", hunks)
+		}
+	}
+}
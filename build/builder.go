@@ -44,6 +44,7 @@ import (
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/ctags"
+	"github.com/sourcegraph/zoekt/internal/scip"
 )
 
 var DefaultDir = filepath.Join(os.Getenv("HOME"), ".zoekt")
@@ -87,6 +88,22 @@ type Options struct {
 	// If set, ctags must succeed.
 	CTagsMustSucceed bool
 
+	// NativeSymbols extracts symbols of Go, Java, Python, TypeScript and C++
+	// files in-process instead of with ctags, see ctags.NewNativeParser.
+	// Other languages are still parsed by ctags if it is available. It has
+	// no effect if DisableCTags is set.
+	NativeSymbols bool
+
+	// SymbolRefs indexes the identifiers of files with symbols which refer
+	// to symbols, so they can be searched with query.SymbolRef. It has no
+	// effect if no symbols are extracted.
+	SymbolRefs bool
+
+	// SCIPIndexPath is the path to a SCIP index of the repository. Symbols
+	// of the files it covers are taken from the index instead of ctags.
+	// With SymbolRefs, its references are used instead of lexical ones.
+	SCIPIndexPath string
+
 	// Write memory profiles to this file.
 	MemProfile string
 
@@ -109,6 +126,50 @@ type Options struct {
 	// DocumentRanksPath changes, we can reindex.
 	DocumentRanksVersion string
 
+	// Rankers compute the signals used to order documents within a shard. If
+	// nil, DefaultRankers is used. Rankers are ignored if DocumentRanksPath
+	// is set.
+	Rankers []Ranker
+
+	// DetectEncoding transcodes files which aren't UTF-8 to UTF-8 if their
+	// encoding is recognized, instead of skipping them as binary. The
+	// original encoding is returned in zoekt.FileMatch.Encoding.
+	DetectEncoding bool
+
+	// ContentExtractors are the names of the registered ContentExtractors
+	// which convert matching files to searchable text before they are
+	// indexed, see RegisterContentExtractor.
+	ContentExtractors []string
+
+	// Blame is set if gitindex stores the commit which last modified each
+	// line of the documents, see zoekt.Document.Blame. It is part of the
+	// hash of the options, so toggling it rebuilds the index.
+	Blame bool
+
+	// Gitignore is set if gitindex skips files matched by the .gitignore
+	// files of the repository, even though they are committed.
+	Gitignore bool
+
+	// GitAttributes is set if gitindex applies the linguist-language,
+	// linguist-generated and linguist-vendored attributes of the
+	// .gitattributes files of the repository to the language and the
+	// ranking of files, and skips files whose diff attribute is unset, eg.
+	// by the binary macro. Files found on several branches use the
+	// attributes of the first one.
+	GitAttributes bool
+
+	// History is set for the shards of commit messages and diffs built by
+	// gitindex, see gitindex.Options.IndexHistory. They are named apart
+	// from the shards of the files of the repository, so both can be
+	// updated independently.
+	History bool
+
+	// Snapshot, if set, builds a snapshot shard of the revision Snapshot,
+	// eg. a release tag, see zoekt.Repository.Snapshot. Snapshot shards are
+	// named by repository and revision, so they are retained when the
+	// branches of the repository are reindexed.
+	Snapshot string
+
 	// changedOrRemovedFiles is a list of file paths that have been changed or removed
 	// since the last indexing job for this repository. These files will be tombstoned
 	// in the older shards for this repository.
@@ -122,10 +183,22 @@ type HashOptions struct {
 	ctagsPath        string
 	cTagsMustSucceed bool
 	largeFiles       []string
+	nativeSymbols    bool
+	symbolRefs       bool
+	scipIndex        bool
+	extractors       string
+	detectEncoding   bool
+	blame            bool
+	gitignore        bool
+	gitAttributes    bool
 
 	// documentRankVersion is an experimental field which will change when the
 	// DocumentRanksPath content changes. If empty we ignore it.
 	documentRankVersion string
+
+	// rankers is the comma separated list of ranker names, empty if the
+	// default rankers are used.
+	rankers string
 }
 
 func (o *Options) HashOptions() HashOptions {
@@ -135,7 +208,16 @@ func (o *Options) HashOptions() HashOptions {
 		ctagsPath:           o.CTagsPath,
 		cTagsMustSucceed:    o.CTagsMustSucceed,
 		largeFiles:          o.LargeFiles,
+		nativeSymbols:       o.NativeSymbols,
+		symbolRefs:          o.SymbolRefs,
+		scipIndex:           o.SCIPIndexPath != "",
+		extractors:          strings.Join(o.ContentExtractors, ","),
+		detectEncoding:      o.DetectEncoding,
+		blame:               o.Blame,
+		gitignore:           o.Gitignore,
+		gitAttributes:       o.GitAttributes,
 		documentRankVersion: o.DocumentRanksVersion,
+		rankers:             rankerNames(o.Rankers),
 	}
 }
 
@@ -154,6 +236,44 @@ func (o *Options) GetHash() string {
 		io.WriteString(hasher, h.documentRankVersion)
 	}
 
+	if h.nativeSymbols {
+		hasher.Write([]byte{2})
+	}
+
+	if h.symbolRefs {
+		hasher.Write([]byte{3})
+	}
+
+	if h.scipIndex {
+		hasher.Write([]byte{4})
+	}
+
+	if h.extractors != "" {
+		hasher.Write([]byte{5})
+		io.WriteString(hasher, h.extractors)
+	}
+
+	if h.detectEncoding {
+		hasher.Write([]byte{6})
+	}
+
+	if h.blame {
+		hasher.Write([]byte{7})
+	}
+
+	if h.gitignore {
+		hasher.Write([]byte{8})
+	}
+
+	if h.gitAttributes {
+		hasher.Write([]byte{9})
+	}
+
+	if h.rankers != "" {
+		hasher.Write([]byte{1})
+		io.WriteString(hasher, h.rankers)
+	}
+
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
@@ -176,6 +296,48 @@ func (f largeFilesFlag) Set(value string) error {
 	return nil
 }
 
+type rankersFlag struct{ *Options }
+
+func (f rankersFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	return rankerSpecs(f.Rankers)
+}
+
+func (f rankersFlag) Set(value string) error {
+	rankers, err := ParseRankers(value)
+	if err != nil {
+		return err
+	}
+	f.Rankers = rankers
+	return nil
+}
+
+type extractorsFlag struct{ *Options }
+
+func (f extractorsFlag) String() string {
+	if f.Options == nil {
+		return ""
+	}
+	return strings.Join(f.ContentExtractors, ",")
+}
+
+func (f extractorsFlag) Set(value string) error {
+	var names []string
+	for _, n := range strings.Split(value, ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		if _, ok := lookupContentExtractor(n); !ok {
+			return fmt.Errorf("unknown content extractor %q, valid are %s", n, strings.Join(contentExtractorNames(), ", "))
+		}
+		names = append(names, n)
+	}
+	f.ContentExtractors = names
+	return nil
+}
+
 // Flags adds flags for build options to fs. It is the "inverse" of Args.
 func (o *Options) Flags(fs *flag.FlagSet) {
 	x := *o
@@ -187,10 +349,16 @@ func (o *Options) Flags(fs *flag.FlagSet) {
 	fs.StringVar(&o.IndexDir, "index", x.IndexDir, "directory for search indices")
 	fs.BoolVar(&o.CTagsMustSucceed, "require_ctags", x.CTagsMustSucceed, "If set, ctags calls must succeed.")
 	fs.Var(largeFilesFlag{o}, "large_file", "A glob pattern where matching files are to be index regardless of their size. You can add multiple patterns by setting this more than once.")
+	fs.BoolVar(&o.DetectEncoding, "detect_encoding", x.DetectEncoding, "If set, files in UTF-16, Shift_JIS, EUC-JP or Latin-1 are converted to UTF-8 rather than skipped.")
+	fs.Var(extractorsFlag{o}, "extract", "comma separated list of content extractors converting files to searchable text: "+strings.Join(contentExtractorNames(), ", ")+".")
+	fs.Var(rankersFlag{o}, "rankers", "comma separated list of rankers ordering documents within a shard: path, enry, imports, globs=FILE. Defaults to path.")
 	fs.StringVar(&o.MemProfile, "memprofile", "", "write memory profile(s) to `file.shardnum`. Note: sets parallelism to 1.")
 
 	// Sourcegraph specific
 	fs.BoolVar(&o.DisableCTags, "disable_ctags", x.DisableCTags, "If set, ctags will not be called.")
+	fs.BoolVar(&o.NativeSymbols, "native_symbols", x.NativeSymbols, "If set, symbols of Go, Java, Python, TypeScript and C++ files are extracted in-process instead of by ctags.")
+	fs.BoolVar(&o.SymbolRefs, "symbol_refs", x.SymbolRefs, "If set, references to symbols are indexed in addition to their definitions.")
+	fs.StringVar(&o.SCIPIndexPath, "scip_index", x.SCIPIndexPath, "path to a SCIP index of the repository, used for symbols instead of ctags.")
 }
 
 // Args generates command line arguments for o. It is the "inverse" of Flags.
@@ -225,11 +393,35 @@ func (o *Options) Args() []string {
 		args = append(args, "-large_file", a)
 	}
 
+	if o.DetectEncoding {
+		args = append(args, "-detect_encoding")
+	}
+
+	if len(o.ContentExtractors) > 0 {
+		args = append(args, "-extract", strings.Join(o.ContentExtractors, ","))
+	}
+
+	if o.Rankers != nil {
+		args = append(args, "-rankers", rankerSpecs(o.Rankers))
+	}
+
 	// Sourcegraph specific
 	if o.DisableCTags {
 		args = append(args, "-disable_ctags")
 	}
 
+	if o.NativeSymbols {
+		args = append(args, "-native_symbols")
+	}
+
+	if o.SymbolRefs {
+		args = append(args, "-symbol_refs")
+	}
+
+	if o.SCIPIndexPath != "" {
+		args = append(args, "-scip_index", o.SCIPIndexPath)
+	}
+
 	return args
 }
 
@@ -246,6 +438,25 @@ type Builder struct {
 
 	parser ctags.Parser
 
+	// nativeParser extracts the symbols of the languages it supports if
+	// Options.NativeSymbols is set.
+	nativeParser ctags.Parser
+
+	// scip holds the documents of the SCIP index by path, nil if
+	// Options.SCIPIndexPath is empty.
+	scip map[string]*scip.Document
+
+	extractors []ContentExtractor
+
+	// rankers orders the documents of each shard. It is Options.Rankers,
+	// with RepositoryRankers replaced by their signals once all documents
+	// were added.
+	rankers []Ranker
+
+	// held are the batches of documents held back until Finish because
+	// rankers contains a RepositoryRanker. Each batch becomes a shard.
+	held [][]*zoekt.Document
+
 	building sync.WaitGroup
 
 	errMu      sync.Mutex
@@ -310,6 +521,8 @@ func (o *Options) SetDefaults() {
 			o.RepositoryDescription.Name = filepath.Join(parsed.Host, parsed.Path)
 		}
 	}
+
+	o.RepositoryDescription.Snapshot = o.Snapshot
 }
 
 func hashString(s string) string {
@@ -318,6 +531,18 @@ func hashString(s string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+// hasCTags returns true if symbols are extracted, either by ctags or
+// natively.
+func (o *Options) hasCTags() bool {
+	return !o.DisableCTags && (o.CTagsPath != "" || o.NativeSymbols)
+}
+
+// hasSymbols returns true if symbols are extracted by ctags or imported from
+// a SCIP index.
+func (o *Options) hasSymbols() bool {
+	return o.hasCTags() || o.SCIPIndexPath != ""
+}
+
 // ShardName returns the name the given index shard.
 func (o *Options) shardName(n int) string {
 	return o.shardNameVersion(zoekt.IndexFormatVersion, n)
@@ -328,6 +553,14 @@ func (o *Options) shardNameVersion(version, n int) string {
 	if len(abs) > 200 {
 		abs = abs[:200] + hashString(abs)[:8]
 	}
+	if o.History {
+		// "@" is escaped in repository names, so this can't clash with
+		// the shards of another repository.
+		abs += "@history"
+	}
+	if o.Snapshot != "" {
+		abs += "@snapshot-" + url.QueryEscape(o.Snapshot)
+	}
 	return filepath.Join(o.IndexDir,
 		fmt.Sprintf("%s_v%d.%05d.zoekt", abs, version, n))
 }
@@ -455,6 +688,11 @@ func (o *Options) findShard() string {
 		}
 	}
 
+	if o.History || o.Snapshot != "" {
+		// Compound shards are searched for the files of the repository.
+		return ""
+	}
+
 	// Brute force finding the shard in compound shards. We should only hit this
 	// code path for repositories that are not already existing or are in
 	// compound shards.
@@ -526,10 +764,11 @@ func NewBuilder(opts Options) (*Builder, error) {
 	b := &Builder{
 		opts:           opts,
 		throttle:       make(chan int, opts.Parallelism),
+		rankers:        opts.Rankers,
 		finishedShards: map[string]string{},
 	}
 
-	if b.opts.CTagsPath == "" && b.opts.CTagsMustSucceed {
+	if b.opts.CTagsPath == "" && !b.opts.NativeSymbols && b.opts.CTagsMustSucceed {
 		return nil, fmt.Errorf("ctags binary not found, but CTagsMustSucceed set")
 	}
 
@@ -542,6 +781,26 @@ func NewBuilder(opts Options) (*Builder, error) {
 		b.parser = parser
 	}
 
+	if opts.NativeSymbols {
+		b.nativeParser = ctags.NewNativeParser(nil)
+	}
+
+	for _, name := range opts.ContentExtractors {
+		e, ok := lookupContentExtractor(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown content extractor %q", name)
+		}
+		b.extractors = append(b.extractors, e)
+	}
+
+	if opts.SCIPIndexPath != "" {
+		docs, err := loadSCIPIndex(opts.SCIPIndexPath)
+		if err != nil {
+			return nil, err
+		}
+		b.scip = docs
+	}
+
 	b.shardLogger = &lumberjack.Logger{
 		Filename:   filepath.Join(opts.IndexDir, "zoekt-builder-shard-log.tsv"),
 		MaxSize:    100, // Megabyte
@@ -580,6 +839,55 @@ func (b *Builder) Add(doc zoekt.Document) error {
 		return nil
 	}
 
+	if doc.SkipReason == "" {
+		for _, e := range b.extractors {
+			if e.Match(doc.Name) {
+				return b.addExtracted(e, doc)
+			}
+		}
+	}
+
+	return b.add(doc)
+}
+
+// addExtracted adds the documents extracted from doc by e. If extraction
+// fails, doc is added as is.
+func (b *Builder) addExtracted(e ContentExtractor, doc zoekt.Document) error {
+	files, err := e.Extract(doc.Name, doc.Content, b.opts.SizeMax)
+	if err != nil {
+		log.Printf("extracting %s: %v", doc.Name, err)
+		return b.add(doc)
+	}
+
+	for _, f := range files {
+		// Extracted documents keep the attributes of doc, but not those
+		// referring to its content.
+		d := doc
+		d.Name = f.Name
+		d.Content = f.Content
+		d.Encoding = ""
+		d.Blame = nil
+		d.Symbols = nil
+		d.SymbolsMetaData = nil
+		d.SymbolRefs = nil
+		if f.Name != doc.Name {
+			d.OriginalName = doc.Name
+
+			// Archives often hold binary files, eg. class files in
+			// jars. Rather than adding them as skipped documents, we
+			// leave them out.
+			if len(d.Content) <= b.opts.SizeMax && zoekt.CheckText(d.Content, b.opts.TrigramMax) != nil {
+				continue
+			}
+		}
+		if err := b.add(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) add(doc zoekt.Document) error {
 	allowLargeFile := b.opts.IgnoreSizeMax(doc.Name)
 
 	// Adjust trigramMax for allowed large files so we don't exclude them.
@@ -588,6 +896,12 @@ func (b *Builder) Add(doc zoekt.Document) error {
 		trigramMax = math.MaxInt64
 	}
 
+	if b.opts.DetectEncoding && doc.SkipReason == "" && (len(doc.Content) <= b.opts.SizeMax || allowLargeFile) {
+		if enc, text := detectEncoding(doc.Content); enc != "" {
+			doc.Content, doc.Encoding = text, enc
+		}
+	}
+
 	if len(doc.Content) > b.opts.SizeMax && !allowLargeFile {
 		// We could pass the document on to the shardbuilder, but if
 		// we pass through a part of the source tree with binary/large
@@ -798,6 +1112,72 @@ func (b *Builder) flush() error {
 	todo := b.todo
 	b.todo = nil
 	b.size = 0
+
+	if !b.ranksRepository() {
+		return b.buildBatch(todo)
+	}
+
+	if len(todo) > 0 {
+		b.held = append(b.held, todo)
+	}
+	if !b.finishCalled {
+		return nil
+	}
+
+	b.rankRepository()
+	batches := b.held
+	b.held = nil
+	if len(batches) == 0 {
+		// Build an empty shard, as buildBatch does without held batches.
+		batches = append(batches, nil)
+	}
+	for _, batch := range batches {
+		if err := b.buildBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ranksRepository returns true if the documents are ordered by a
+// RepositoryRanker.
+func (b *Builder) ranksRepository() bool {
+	if b.opts.DocumentRanksPath != "" {
+		return false
+	}
+	for _, r := range b.opts.Rankers {
+		if _, ok := r.(RepositoryRanker); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rankRepository ranks the held back documents with the RepositoryRankers
+// of b.rankers, and replaces them by their signals.
+func (b *Builder) rankRepository() {
+	var docs []*zoekt.Document
+	for _, batch := range b.held {
+		docs = append(docs, batch...)
+	}
+
+	rankers := make([]Ranker, len(b.rankers))
+	for i, r := range b.rankers {
+		rankers[i] = r
+		if _, ok := r.(RepositoryRanker); !ok {
+			continue
+		}
+		signals := make(map[*zoekt.Document][]float64, len(docs))
+		for j, s := range r.Rank(docs) {
+			signals[docs[j]] = s
+		}
+		rankers[i] = rankedRanker{Ranker: r, signals: signals}
+	}
+	b.rankers = rankers
+}
+
+// buildBatch builds a shard from todo.
+func (b *Builder) buildBatch(todo []*zoekt.Document) error {
 	b.errMu.Lock()
 	defer b.errMu.Unlock()
 	if b.buildError != nil {
@@ -892,54 +1272,47 @@ type rankedDoc struct {
 // before writing them to disk. The order of documents in the shard is important
 // at query time, because earlier documents receive a boost at query time and
 // have a higher chance of being searched before limits kick in.
-func rank(d *zoekt.Document, origIdx int) []float64 {
-	generated := 0.0
-	if strings.HasSuffix(d.Name, "min.js") || strings.HasSuffix(d.Name, "js.map") {
-		generated = 1.0
-	}
-
-	vendor := 0.0
-	if strings.Contains(d.Name, "vendor/") || strings.Contains(d.Name, "node_modules/") {
-		vendor = 1.0
-	}
-
-	test := 0.0
-	if testRe.MatchString(d.Name) {
-		test = 1.0
-	}
-
+//
+// The signals of rankers come first, see Ranker. The remaining signals break
+// ties.
+func rank(d *zoekt.Document, origIdx int, signals []float64) []float64 {
 	// Smaller is earlier (=better).
-	return []float64{
-		// Prefer docs that are not generated
-		generated,
-
-		// Prefer docs that are not vendored
-		vendor,
-
-		// Prefer docs that are not tests
-		test,
-
+	return append(signals,
 		// With short names
 		squashRange(len(d.Name)),
 
 		// With many symbols
-		1.0 - squashRange(len(d.Symbols)),
+		1.0-squashRange(len(d.Symbols)),
 
 		// With short content
 		squashRange(len(d.Content)),
 
 		// That is present is as many branches as possible
-		1.0 - squashRange(len(d.Branches)),
+		1.0-squashRange(len(d.Branches)),
 
 		// Preserve original ordering.
 		squashRange(origIdx),
-	}
+	)
 }
 
-func sortDocuments(todo []*zoekt.Document) {
+// sortDocuments sorts todo by rank. If rankers is nil, DefaultRankers is
+// used.
+func sortDocuments(todo []*zoekt.Document, rankers []Ranker) {
+	if rankers == nil {
+		rankers = DefaultRankers
+	}
+	rankerSignals := make([][][]float64, len(rankers))
+	for i, r := range rankers {
+		rankerSignals[i] = r.Rank(todo)
+	}
+
 	rs := make([]rankedDoc, 0, len(todo))
 	for i, t := range todo {
-		rd := rankedDoc{t, rank(t, i)}
+		var signals []float64
+		for _, rankerSignal := range rankerSignals {
+			signals = append(signals, rankerSignal[i]...)
+		}
+		rd := rankedDoc{t, rank(t, i, signals)}
 		rs = append(rs, rd)
 	}
 	sort.Slice(rs, func(i, j int) bool {
@@ -992,8 +1365,12 @@ func sortDocuments2(rs []*zoekt.Document) {
 }
 
 func (b *Builder) buildShard(todo []*zoekt.Document, nextShardNum int) (*finishedShard, error) {
-	if !b.opts.DisableCTags && b.opts.CTagsPath != "" {
-		err := ctagsAddSymbols(todo, b.parser, b.opts.CTagsPath)
+	// SCIP goes first, ctags skips documents which already have symbols.
+	if b.scip != nil {
+		scipAddSymbols(todo, b.scip, b.opts.SymbolRefs)
+	}
+	if b.opts.hasCTags() {
+		err := ctagsAddSymbols(todo, b.nativeParser, b.parser, b.opts.CTagsPath)
 		if b.opts.CTagsMustSucceed && err != nil {
 			return nil, err
 		}
@@ -1001,6 +1378,9 @@ func (b *Builder) buildShard(todo []*zoekt.Document, nextShardNum int) (*finishe
 			log.Printf("ignoring %s error: %v", b.opts.CTagsPath, err)
 		}
 	}
+	if b.opts.hasSymbols() && b.opts.SymbolRefs {
+		addSymbolRefs(todo)
+	}
 
 	name := b.opts.shardName(nextShardNum)
 
@@ -1012,7 +1392,7 @@ func (b *Builder) buildShard(todo []*zoekt.Document, nextShardNum int) (*finishe
 	if b.opts.DocumentRanksPath != "" {
 		sortDocuments2(todo)
 	} else {
-		sortDocuments(todo)
+		sortDocuments(todo, b.rankers)
 	}
 
 	for _, t := range todo {
@@ -1026,7 +1406,7 @@ func (b *Builder) buildShard(todo []*zoekt.Document, nextShardNum int) (*finishe
 
 func (b *Builder) newShardBuilder() (*zoekt.IndexBuilder, error) {
 	desc := b.opts.RepositoryDescription
-	desc.HasSymbols = !b.opts.DisableCTags && b.opts.CTagsPath != ""
+	desc.HasSymbols = b.opts.hasSymbols()
 	desc.SubRepoMap = b.opts.SubRepositories
 	desc.IndexOptions = b.opts.GetHash()
 
@@ -117,6 +117,36 @@ func TestFlags(t *testing.T) {
 		want: Options{
 			LargeFiles: []string{"*.md", "*.yaml"},
 		},
+	}, {
+		args: []string{"-native_symbols"},
+		want: Options{
+			NativeSymbols: true,
+		},
+	}, {
+		args: []string{"-symbol_refs"},
+		want: Options{
+			SymbolRefs: true,
+		},
+	}, {
+		args: []string{"-scip_index", "/tmp/index.scip"},
+		want: Options{
+			SCIPIndexPath: "/tmp/index.scip",
+		},
+	}, {
+		args: []string{"-extract", "ipynb,zip"},
+		want: Options{
+			ContentExtractors: []string{"ipynb", "zip"},
+		},
+	}, {
+		args: []string{"-detect_encoding"},
+		want: Options{
+			DetectEncoding: true,
+		},
+	}, {
+		args: []string{"-rankers", "enry,path"},
+		want: Options{
+			Rankers: []Ranker{EnryRanker{}, PathRanker{}},
+		},
 	}}
 
 	ignored := []cmp.Option{
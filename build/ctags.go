@@ -162,10 +162,32 @@ func ctagsAddSymbolsParser(todo []*zoekt.Document, parser ctags.Parser) error {
 	return nil
 }
 
-func ctagsAddSymbols(todo []*zoekt.Document, parser ctags.Parser, bin string) error {
+// ctagsAddSymbols adds the symbols of the documents in todo. native, if not
+// nil, parses the documents of the languages it supports. The other
+// documents are parsed by parser, or by running the ctags binary bin if
+// parser is nil.
+func ctagsAddSymbols(todo []*zoekt.Document, native, parser ctags.Parser, bin string) error {
+	if native != nil {
+		var nativeDocs, rest []*zoekt.Document
+		for _, doc := range todo {
+			if ctags.NativeSupports(doc.Name) {
+				nativeDocs = append(nativeDocs, doc)
+			} else {
+				rest = append(rest, doc)
+			}
+		}
+		if err := ctagsAddSymbolsParser(nativeDocs, native); err != nil {
+			return err
+		}
+		todo = rest
+	}
+
 	if parser != nil {
 		return ctagsAddSymbolsParser(todo, parser)
 	}
+	if bin == "" {
+		return nil
+	}
 
 	pathIndices := map[string]int{}
 	contents := map[string][]byte{}
@@ -229,3 +229,53 @@ func TestOverlaps(t *testing.T) {
 		})
 	}
 }
+
+func TestNativeSymbols(t *testing.T) {
+	todo := []*zoekt.Document{{
+		Name:    "foo.go",
+		Content: []byte("package foo\n\ntype T struct{}\n\nfunc (t T) Bar() {}\n"),
+	}}
+	if err := ctagsAddSymbols(todo, ctags.NewNativeParser(nil), nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*zoekt.Symbol{
+		{Sym: "foo", Kind: "package"},
+		{Sym: "T", Kind: "struct", Parent: "foo", ParentKind: "package"},
+		{Sym: "Bar", Kind: "func", Parent: "T", ParentKind: "struct"},
+	}
+	if !reflect.DeepEqual(todo[0].SymbolsMetaData, want) {
+		t.Errorf("got %+v, want %+v", todo[0].SymbolsMetaData, want)
+	}
+	if got := len(todo[0].Symbols); got != 3 {
+		t.Errorf("got %d symbol sections, want 3", got)
+	}
+}
+
+type recordingParser struct {
+	parsed []string
+}
+
+func (p *recordingParser) Parse(name string, content []byte) ([]*ctags.Entry, error) {
+	p.parsed = append(p.parsed, name)
+	return nil, nil
+}
+
+func (p *recordingParser) Close() {}
+
+func TestNativeSymbolsOtherLanguages(t *testing.T) {
+	todo := []*zoekt.Document{
+		{Name: "foo.go", Content: []byte("package foo\n")},
+		{Name: "foo.rb", Content: []byte("class Foo\nend\n")},
+	}
+	parser := &recordingParser{}
+	if err := ctagsAddSymbols(todo, ctags.NewNativeParser(nil), parser, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(todo[0].Symbols) != 1 {
+		t.Errorf("got %d symbols of foo.go, want 1", len(todo[0].Symbols))
+	}
+	if want := []string{"foo.rb"}; !reflect.DeepEqual(parser.parsed, want) {
+		t.Errorf("parser got %v, want %v", parser.parsed, want)
+	}
+}
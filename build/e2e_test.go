@@ -471,7 +471,7 @@ func testFileRankAspect(t *testing.T, c filerankCase) {
 
 	got := make([]*zoekt.Document, len(c.docs))
 	copy(got, c.docs)
-	sortDocuments(got)
+	sortDocuments(got, nil)
 
 	print := func(ds []*zoekt.Document) string {
 		r := ""
@@ -0,0 +1,131 @@
+package build
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	xunicode "golang.org/x/text/encoding/unicode"
+)
+
+// detectEncoding guesses the character encoding of content which isn't
+// UTF-8 and returns its name and the content transcoded to UTF-8. It returns
+// an empty name if content is UTF-8 or the encoding isn't recognized, eg.
+// because content is binary.
+//
+// We recognize UTF-16 (with a byte order mark, or mostly ASCII), Japanese
+// text in Shift_JIS or EUC-JP, and fall back to Windows-1252, a superset of
+// the printable characters of ISO-8859-1, for mostly ASCII text.
+func detectEncoding(content []byte) (string, []byte) {
+	if bytes.HasPrefix(content, []byte{0xff, 0xfe}) || bytes.HasPrefix(content, []byte{0xfe, 0xff}) {
+		// The BOM decoder picks the endianness and strips the BOM.
+		return decodeAs("UTF-16", xunicode.UTF16(xunicode.BigEndian, xunicode.ExpectBOM), content)
+	}
+
+	if utf8.Valid(content) {
+		return "", nil
+	}
+
+	if endian, ok := guessUTF16(content); ok {
+		name := "UTF-16BE"
+		if endian == xunicode.LittleEndian {
+			name = "UTF-16LE"
+		}
+		return decodeAs(name, xunicode.UTF16(endian, xunicode.IgnoreBOM), content)
+	}
+
+	if bytes.IndexByte(content, 0) >= 0 {
+		// binary
+		return "", nil
+	}
+
+	for _, c := range []struct {
+		name string
+		enc  encoding.Encoding
+	}{
+		{"Shift_JIS", japanese.ShiftJIS},
+		{"EUC-JP", japanese.EUCJP},
+	} {
+		if name, text := decodeAs(c.name, c.enc, content); name != "" && looksJapanese(text) {
+			return name, text
+		}
+	}
+
+	if nonASCII := countNonASCII(content); nonASCII*4 > len(content) {
+		// Not a Latin script; probably binary or an encoding we don't
+		// know about.
+		return "", nil
+	}
+	return decodeAs("windows-1252", charmap.Windows1252, content)
+}
+
+// decodeAs decodes content with enc. It returns an empty name if content
+// isn't valid in enc or decodes to control characters.
+func decodeAs(name string, enc encoding.Encoding, content []byte) (string, []byte) {
+	text, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return "", nil
+	}
+	for _, r := range string(text) {
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' && r != '\f') {
+			return "", nil
+		}
+	}
+	return name, text
+}
+
+// guessUTF16 recognizes UTF-16 without byte order mark if it is mostly
+// ASCII, ie. every other byte is zero.
+func guessUTF16(content []byte) (xunicode.Endianness, bool) {
+	if len(content) < 2 || len(content)%2 != 0 {
+		return xunicode.BigEndian, false
+	}
+
+	var zeros [2]int
+	for i, c := range content {
+		if c == 0 {
+			zeros[i%2]++
+		}
+	}
+	half := len(content) / 2
+	switch {
+	case zeros[1]*10 >= half*9 && zeros[0] == 0:
+		return xunicode.LittleEndian, true
+	case zeros[0]*10 >= half*9 && zeros[1] == 0:
+		return xunicode.BigEndian, true
+	}
+	return xunicode.BigEndian, false
+}
+
+// looksJapanese returns true if most non-ASCII characters of text are kana
+// or kanji, and there is some kana. Other CJK encodings may decode without
+// error, but rarely produce kana.
+func looksJapanese(text []byte) bool {
+	var kana, jp, other int
+	for _, r := range string(text) {
+		switch {
+		case r < utf8.RuneSelf:
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+			jp++
+		case unicode.Is(unicode.Han, r) || unicode.IsPunct(r) || unicode.IsSpace(r) || (r >= 0xff00 && r <= 0xffef):
+			jp++
+		default:
+			other++
+		}
+	}
+	return kana > 0 && jp >= 9*other
+}
+
+func countNonASCII(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	xunicode "golang.org/x/text/encoding/unicode"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func mustEncode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDetectEncoding(t *testing.T) {
+	const (
+		latinText    = "// Grüße aus Köln, café\n"
+		japaneseText = "// こんにちは、世界。日本語のコメント\nfunc main() {}\n"
+	)
+
+	cases := []struct {
+		name     string
+		content  []byte
+		wantEnc  string
+		wantText string
+	}{
+		{"utf8", []byte(japaneseText), "", ""},
+		{"utf16 bom", mustEncode(t, xunicode.UTF16(xunicode.LittleEndian, xunicode.UseBOM), latinText), "UTF-16", latinText},
+		{"utf16le", mustEncode(t, xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM), latinText), "UTF-16LE", latinText},
+		{"utf16be", mustEncode(t, xunicode.UTF16(xunicode.BigEndian, xunicode.IgnoreBOM), latinText), "UTF-16BE", latinText},
+		{"shift_jis", mustEncode(t, japanese.ShiftJIS, japaneseText), "Shift_JIS", japaneseText},
+		{"euc-jp", mustEncode(t, japanese.EUCJP, japaneseText), "EUC-JP", japaneseText},
+		{"latin1", mustEncode(t, charmap.ISO8859_1, latinText), "windows-1252", latinText},
+		{"binary", []byte("\x7fELF\x02\x01\x01\x00\x00\xff\xfe\x03"), "", ""},
+		{"binary without zeros", []byte("\xe4\x9f\xc3\xa8\xff\x81\x90\x9d\x8d\x8f\xfe\xfd"), "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			enc, text := detectEncoding(c.content)
+			if enc != c.wantEnc || string(text) != c.wantText {
+				t.Errorf("got %q, %q, want %q, %q", enc, text, c.wantEnc, c.wantText)
+			}
+		})
+	}
+}
+
+func TestDetectEncodingBuild(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := Options{
+		IndexDir: dir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+		DetectEncoding: true,
+	}
+	opts.SetDefaults()
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatalf("NewBuilder: %v", err)
+	}
+	if err := b.AddFile("sjis.txt", mustEncode(t, japanese.ShiftJIS, "こんにちは needle\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("utf8.txt", []byte("needle\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatalf("NewDirectorySearcher(%s): %v", dir, err)
+	}
+	defer ss.Close()
+
+	result, err := ss.Search(context.Background(), &query.Substring{Pattern: "こんにちは"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0].FileName != "sjis.txt" || result.Files[0].Encoding != "Shift_JIS" {
+		t.Fatalf("got %+v, want sjis.txt in Shift_JIS", result.Files)
+	}
+
+	result, err = ss.Search(context.Background(), &query.Substring{Pattern: "needle", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range result.Files {
+		if f.FileName == "utf8.txt" && f.Encoding != "" {
+			t.Errorf("got encoding %q for UTF-8 file", f.Encoding)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package build
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ContentExtractor converts files in a format which isn't searchable as is,
+// eg. because it is compressed, into searchable text.
+type ContentExtractor interface {
+	// Match returns true if the extractor handles the file name.
+	Match(name string) bool
+
+	// Extract returns the documents to index instead of the file. Contents
+	// larger than sizeMax should be truncated to sizeMax+1 bytes, so the
+	// builder can skip them without reading them completely.
+	Extract(name string, content []byte, sizeMax int) ([]ExtractedFile, error)
+}
+
+// ExtractedFile is a document produced by a ContentExtractor.
+type ExtractedFile struct {
+	// Name is the name of the document. If it differs from the name of the
+	// file it was extracted from, it should be a virtual path below it, see
+	// ArchiveMemberName.
+	Name    string
+	Content []byte
+}
+
+// ArchiveMemberName returns the virtual path of a member of an archive.
+func ArchiveMemberName(archive, member string) string {
+	return archive + "!/" + member
+}
+
+var (
+	extractorsMu sync.Mutex
+	extractors   = map[string]ContentExtractor{}
+)
+
+// RegisterContentExtractor makes an extractor available under name for
+// Options.ContentExtractors. It panics if name is already registered.
+func RegisterContentExtractor(name string, e ContentExtractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	if _, ok := extractors[name]; ok {
+		panic(fmt.Sprintf("build: content extractor %q registered twice", name))
+	}
+	extractors[name] = e
+}
+
+func lookupContentExtractor(name string) (ContentExtractor, bool) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	e, ok := extractors[name]
+	return e, ok
+}
+
+// contentExtractorNames returns the names of all registered extractors.
+func contentExtractorNames() []string {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	names := make([]string, 0, len(extractors))
+	for n := range extractors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterContentExtractor("ipynb", NotebookExtractor{})
+	RegisterContentExtractor("gzip", GzipExtractor{})
+	RegisterContentExtractor("zip", ZipExtractor{})
+}
+
+// NotebookExtractor indexes the cell sources of Jupyter notebooks rather
+// than their JSON, which also holds outputs such as base64 encoded images.
+// Cells are separated by an empty line.
+type NotebookExtractor struct{}
+
+func (NotebookExtractor) Match(name string) bool {
+	return path.Ext(name) == ".ipynb"
+}
+
+func (NotebookExtractor) Extract(name string, content []byte, sizeMax int) ([]ExtractedFile, error) {
+	var nb struct {
+		Cells []struct {
+			Source json.RawMessage `json:"source"`
+		} `json:"cells"`
+	}
+	if err := json.Unmarshal(content, &nb); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, c := range nb.Cells {
+		// Sources are either a string or a list of lines.
+		var lines []string
+		if err := json.Unmarshal(c.Source, &lines); err != nil {
+			var s string
+			if err := json.Unmarshal(c.Source, &s); err != nil {
+				return nil, err
+			}
+			lines = []string{s}
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		src := strings.Join(lines, "")
+		buf.WriteString(src)
+		if !strings.HasSuffix(src, "\n") {
+			buf.WriteString("\n")
+		}
+		if buf.Len() > sizeMax {
+			break
+		}
+	}
+	return []ExtractedFile{{Name: name, Content: buf.Bytes()}}, nil
+}
+
+// GzipExtractor indexes the decompressed content of gzip files, eg. rotated
+// logs, as a member named like the file without the .gz extension.
+type GzipExtractor struct{}
+
+func (GzipExtractor) Match(name string) bool {
+	return path.Ext(name) == ".gz"
+}
+
+func (GzipExtractor) Extract(name string, content []byte, sizeMax int) ([]ExtractedFile, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(sizeMax)+1))
+	if err != nil {
+		return nil, err
+	}
+	member := strings.TrimSuffix(path.Base(name), ".gz")
+	return []ExtractedFile{{Name: ArchiveMemberName(name, member), Content: data}}, nil
+}
+
+// ZipExtractor indexes the members of zip files, including jar and war
+// files, as documents below the archive. Archives larger than sizeMax are
+// indexed as is, so the builder skips them. Members whose declared size is
+// larger than sizeMax are left out, and so are the members following the
+// first MaxMembers members or MaxSize decompressed bytes.
+type ZipExtractor struct {
+	// MaxMembers is the maximum number of members extracted from an
+	// archive. If 0, 10000 is used.
+	MaxMembers int
+
+	// MaxSize is the maximum number of decompressed bytes extracted from an
+	// archive. If 0, 100 MiB are used.
+	MaxSize int64
+}
+
+func (ZipExtractor) Match(name string) bool {
+	switch path.Ext(name) {
+	case ".zip", ".jar", ".war":
+		return true
+	}
+	return false
+}
+
+func (e ZipExtractor) Extract(name string, content []byte, sizeMax int) ([]ExtractedFile, error) {
+	if len(content) > sizeMax {
+		return []ExtractedFile{{Name: name, Content: content}}, nil
+	}
+
+	maxMembers := e.MaxMembers
+	if maxMembers == 0 {
+		maxMembers = 10000
+	}
+	maxSize := e.MaxSize
+	if maxSize == 0 {
+		maxSize = 100 << 20
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []ExtractedFile
+	var size int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || f.UncompressedSize64 > uint64(sizeMax) {
+			continue
+		}
+		if len(files) == maxMembers || size+int64(f.UncompressedSize64) > maxSize {
+			break
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		// The declared size can't be trusted, so we limit reading anyway.
+		data, err := io.ReadAll(io.LimitReader(rc, int64(sizeMax)+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		size += int64(len(data))
+		if size > maxSize {
+			break
+		}
+		files = append(files, ExtractedFile{
+			Name:    ArchiveMemberName(name, path.Clean(f.Name)),
+			Content: data,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,236 @@
+package build
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipBytes(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		f, err := w.Create(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(files[n])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNotebookExtractor(t *testing.T) {
+	nb := `{"cells": [
+		{"cell_type": "markdown", "source": "# Title"},
+		{"cell_type": "code", "source": ["import os\n", "print(os.name)"], "outputs": [{"data": {"image/png": "iVBORw0KGgo="}}]}
+	]}`
+
+	got, err := NotebookExtractor{}.Extract("a.ipynb", []byte(nb), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExtractedFile{{Name: "a.ipynb", Content: []byte("# Title\n\nimport os\nprint(os.name)\n")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGzipExtractor(t *testing.T) {
+	got, err := GzipExtractor{}.Extract("logs/app.log.gz", gzipBytes(t, "hello world\n"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// truncated to sizeMax+1
+	want := []ExtractedFile{{Name: "logs/app.log.gz!/app.log", Content: []byte("hello ")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestZipExtractor(t *testing.T) {
+	content := zipBytes(t, map[string]string{
+		"META-INF/MANIFEST.MF": "Main-Class: Foo\n",
+		"dir/":                 "",
+	})
+	got, err := ZipExtractor{}.Extract("lib/foo.jar", content, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExtractedFile{{Name: "lib/foo.jar!/META-INF/MANIFEST.MF", Content: []byte("Main-Class: Foo\n")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestZipExtractorLimits(t *testing.T) {
+	content := zipBytes(t, map[string]string{
+		"a": "aaaa",
+		"b": strings.Repeat("b", 10000),
+		"c": "cccc",
+		"d": "dddd",
+	})
+	names := func(files []ExtractedFile) []string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		name    string
+		e       ZipExtractor
+		sizeMax int
+		want    []string
+	}{
+		{"defaults", ZipExtractor{}, 1 << 20, []string{"z.zip!/a", "z.zip!/b", "z.zip!/c", "z.zip!/d"}},
+		{"declared size", ZipExtractor{}, 1000, []string{"z.zip!/a", "z.zip!/c", "z.zip!/d"}},
+		{"members", ZipExtractor{MaxMembers: 2}, 1 << 20, []string{"z.zip!/a", "z.zip!/b"}},
+		{"total size", ZipExtractor{MaxSize: 10008}, 1 << 20, []string{"z.zip!/a", "z.zip!/b", "z.zip!/c"}},
+		{"archive size", ZipExtractor{}, len(content) - 1, []string{"z.zip"}},
+	} {
+		got, err := tc.e.Extract("z.zip", content, tc.sizeMax)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(names(got), tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, names(got), tc.want)
+		}
+	}
+}
+
+func TestContentExtractors(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := Options{
+		IndexDir: dir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+		ContentExtractors: []string{"gzip", "zip"},
+	}
+	opts.SetDefaults()
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatalf("NewBuilder: %v", err)
+	}
+	if err := b.AddFile("app.log.gz", gzipBytes(t, "needle in a log\n")); err != nil {
+		t.Fatal(err)
+	}
+	archive := zipBytes(t, map[string]string{
+		"README":    "needle in an archive\n",
+		"Foo.class": "\xca\xfe\xba\xbe\x00\x00needle",
+	})
+	if err := b.AddFile("a.zip", archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatalf("NewDirectorySearcher(%s): %v", dir, err)
+	}
+	defer ss.Close()
+
+	result, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, f := range result.Files {
+		got[f.FileName] = f.OriginalFileName
+	}
+	want := map[string]string{
+		"app.log.gz!/app.log": "app.log.gz",
+		"a.zip!/README":       "a.zip",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestContentExtractorsKeepAttributes(t *testing.T) {
+	dir := t.TempDir()
+
+	opts := Options{
+		IndexDir: dir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+		ContentExtractors: []string{"zip"},
+	}
+	opts.SetDefaults()
+
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatalf("NewBuilder: %v", err)
+	}
+	archive := zipBytes(t, map[string]string{"README": "needle\n"})
+	vendored := true
+	if err := b.Add(zoekt.Document{
+		Name:     "a.zip",
+		Content:  archive,
+		Language: "Text",
+		Vendored: &vendored,
+		// Symbols of the archive don't apply to its members.
+		Symbols:    []zoekt.DocumentSection{{Start: 50, End: 60}},
+		SymbolRefs: []zoekt.DocumentSection{{Start: 50, End: 60}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	ss, err := shards.NewDirectorySearcher(dir)
+	if err != nil {
+		t.Fatalf("NewDirectorySearcher(%s): %v", dir, err)
+	}
+	defer ss.Close()
+
+	result, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(result.Files))
+	}
+	if f := result.Files[0]; f.FileName != "a.zip!/README" || f.Language != "Text" {
+		t.Errorf("got %s in %q, want a.zip!/README in Text", f.FileName, f.Language)
+	}
+}
@@ -0,0 +1,390 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/go-enry/go-enry/v2"
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// Ranker computes signals used to order the documents of a shard at index
+// time. The signals of all rankers in Options.Rankers are concatenated and
+// compared lexicographically, smaller is earlier (=better). Ties are broken
+// by generic signals such as the length of the file name.
+type Ranker interface {
+	// Name identifies the ranker and its configuration. It is part of the
+	// index options hash, so changing rankers reindexes.
+	Name() string
+
+	// Rank returns a signal vector for each of docs. All vectors returned
+	// by a Ranker must have the same length.
+	Rank(docs []*zoekt.Document) [][]float64
+}
+
+// RepositoryRanker is a Ranker whose signals for a document depend on the
+// other documents of the repository, not only on those of its shard. If
+// Options.Rankers contains one, Builder holds back all documents until
+// Finish and calls Rank once with the documents of the whole repository.
+type RepositoryRanker interface {
+	Ranker
+
+	// RanksRepository marks the ranker as a RepositoryRanker.
+	RanksRepository()
+}
+
+// DefaultRankers is used if Options.Rankers is nil.
+var DefaultRankers = []Ranker{PathRanker{}}
+
+// ParseRankers parses a comma separated list of ranker names. Valid names
+// are "path", "enry", "imports" and "globs=FILE", see the corresponding
+// rankers.
+func ParseRankers(spec string) ([]Ranker, error) {
+	rankers := []Ranker{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "path":
+			rankers = append(rankers, PathRanker{})
+		case name == "enry":
+			rankers = append(rankers, EnryRanker{})
+		case name == "imports":
+			rankers = append(rankers, ImportGraphRanker{})
+		case strings.HasPrefix(name, "globs="):
+			r, err := NewGlobRanker(strings.TrimPrefix(name, "globs="))
+			if err != nil {
+				return nil, err
+			}
+			rankers = append(rankers, r)
+		default:
+			return nil, fmt.Errorf("unknown ranker %q", name)
+		}
+	}
+	return rankers, nil
+}
+
+func rankerNames(rankers []Ranker) string {
+	names := make([]string, 0, len(rankers))
+	for _, r := range rankers {
+		names = append(names, r.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// rankerSpecs returns the value of ParseRankers which recreates rankers.
+// It differs from rankerNames for GlobRanker, whose name includes a hash of
+// its config file.
+func rankerSpecs(rankers []Ranker) string {
+	specs := make([]string, 0, len(rankers))
+	for _, r := range rankers {
+		if g, ok := r.(*GlobRanker); ok {
+			specs = append(specs, "globs="+g.path)
+		} else {
+			specs = append(specs, r.Name())
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+// rankedRanker returns signals computed earlier by a RepositoryRanker.
+type rankedRanker struct {
+	Ranker
+	signals map[*zoekt.Document][]float64
+}
+
+func (r rankedRanker) Rank(docs []*zoekt.Document) [][]float64 {
+	signals := make([][]float64, len(docs))
+	for i, d := range docs {
+		signals[i] = r.signals[d]
+	}
+	return signals
+}
+
+func boolSignal(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// override returns *b if b is set, otherwise the result of heuristic.
+func override(b *bool, heuristic func() bool) bool {
+	if b != nil {
+		return *b
+	}
+	return heuristic()
+}
+
+// PathRanker demotes generated, vendored and test files based on simple
+// file name heuristics, unless Document.Generated or Document.Vendored are
+// set.
+type PathRanker struct{}
+
+func (PathRanker) Name() string { return "path" }
+
+func (PathRanker) Rank(docs []*zoekt.Document) [][]float64 {
+	signals := make([][]float64, len(docs))
+	for i, d := range docs {
+		generated := override(d.Generated, func() bool {
+			return strings.HasSuffix(d.Name, "min.js") || strings.HasSuffix(d.Name, "js.map")
+		})
+		vendor := override(d.Vendored, func() bool {
+			return strings.Contains(d.Name, "vendor/") || strings.Contains(d.Name, "node_modules/")
+		})
+
+		signals[i] = []float64{
+			// Prefer docs that are not generated
+			boolSignal(generated),
+
+			// Prefer docs that are not vendored
+			boolSignal(vendor),
+
+			// Prefer docs that are not tests
+			boolSignal(testRe.MatchString(d.Name)),
+		}
+	}
+	return signals
+}
+
+// EnryRanker demotes generated and vendored files as detected by go-enry,
+// which knows many more conventions than PathRanker and also inspects file
+// content for generated code markers.
+type EnryRanker struct{}
+
+func (EnryRanker) Name() string { return "enry" }
+
+func (EnryRanker) Rank(docs []*zoekt.Document) [][]float64 {
+	signals := make([][]float64, len(docs))
+	for i, d := range docs {
+		signals[i] = []float64{
+			boolSignal(override(d.Generated, func() bool { return enry.IsGenerated(d.Name, d.Content) })),
+			boolSignal(override(d.Vendored, func() bool { return enry.IsVendor(d.Name) })),
+		}
+	}
+	return signals
+}
+
+// GlobRanker orders documents by weights assigned to glob patterns in a
+// config file. Each non-empty line of the file holds a pattern and a weight
+// separated by whitespace, lines starting with # are ignored:
+//
+//	# Core packages first, generated protobuf code last.
+//	cmd/**       2
+//	internal/**  1
+//	**/*.pb.go   -10
+//
+// The pattern syntax is the one of Options.LargeFiles. The last matching
+// pattern determines the weight of a file, files matching no pattern have
+// weight 0. Files with higher weight come first. The name of the ranker
+// includes a hash of the file, so changing the file reindexes.
+type GlobRanker struct {
+	path  string
+	hash  string
+	rules []globRule
+}
+
+type globRule struct {
+	pattern string
+	weight  float64
+}
+
+// NewGlobRanker returns a GlobRanker reading its patterns from path.
+func NewGlobRanker(path string) (*GlobRanker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &GlobRanker{path: path, hash: fmt.Sprintf("%x", sha1.Sum(data))}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want pattern and weight, got %q", path, lineNum, line)
+		}
+		if _, err := doublestar.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		weight, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		r.rules = append(r.rules, globRule{pattern: fields[0], weight: weight})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *GlobRanker) Name() string { return "globs=" + r.path + "@" + r.hash }
+
+func (r *GlobRanker) Rank(docs []*zoekt.Document) [][]float64 {
+	signals := make([][]float64, len(docs))
+	for i, d := range docs {
+		weight := 0.0
+		for _, rule := range r.rules {
+			if m, _ := doublestar.PathMatch(rule.pattern, d.Name); m {
+				weight = rule.weight
+			}
+		}
+		signals[i] = []float64{-weight}
+	}
+	return signals
+}
+
+// ImportGraphRanker prefers files which are imported by many other files of
+// the repository. It recognizes imports of Go, Java, Kotlin, Scala, Python,
+// JavaScript, TypeScript, C and C++ with regular expressions, and resolves
+// them against the file names of the repository by their longest matching
+// path suffix. The centrality of a file is the number of distinct files
+// importing it.
+//
+// ImportGraphRanker is a RepositoryRanker, so Builder keeps all documents
+// of the repository in memory until Finish if it is used.
+type ImportGraphRanker struct{}
+
+func (ImportGraphRanker) Name() string { return "imports" }
+
+func (ImportGraphRanker) RanksRepository() {}
+
+var (
+	goImportRe      = regexp.MustCompile(`(?m)^import\s+(?:[\w.]+\s+)?"([^"]+)"`)
+	goImportBlockRe = regexp.MustCompile(`(?ms)^import\s*\((.*?)\)`)
+	quotedRe        = regexp.MustCompile(`"([^"]+)"`)
+	jsImportRe      = regexp.MustCompile(`(?:\bfrom|\bimport|\brequire\()\s*['"]([^'"]+)['"]`)
+	pyImportRe      = regexp.MustCompile(`(?m)^\s*(?:from\s+([\w.]+)\s+import|import\s+([\w.]+))`)
+	jvmImportRe     = regexp.MustCompile(`(?m)^\s*import\s+(?:static\s+)?([\w.]+)`)
+	includeRe       = regexp.MustCompile(`(?m)^\s*#\s*include\s*"([^"]+)"`)
+)
+
+// importSpecs returns the import paths of d in slash separated form.
+// Relative imports are resolved against the directory of d.
+func importSpecs(d *zoekt.Document) []string {
+	dir := path.Dir(d.Name)
+	submatches := func(re *regexp.Regexp, content []byte) []string {
+		var specs []string
+		for _, m := range re.FindAllSubmatch(content, -1) {
+			for _, g := range m[1:] {
+				if len(g) > 0 {
+					specs = append(specs, string(g))
+				}
+			}
+		}
+		return specs
+	}
+	dotted := func(specs []string) []string {
+		for i, s := range specs {
+			specs[i] = strings.ReplaceAll(strings.TrimLeft(s, "."), ".", "/")
+		}
+		return specs
+	}
+
+	switch path.Ext(d.Name) {
+	case ".go":
+		specs := submatches(goImportRe, d.Content)
+		for _, block := range goImportBlockRe.FindAllSubmatch(d.Content, -1) {
+			specs = append(specs, submatches(quotedRe, block[1])...)
+		}
+		return specs
+	case ".js", ".jsx", ".mjs", ".ts", ".tsx":
+		specs := submatches(jsImportRe, d.Content)
+		for i, s := range specs {
+			if strings.HasPrefix(s, ".") {
+				specs[i] = path.Join(dir, s)
+			}
+		}
+		return specs
+	case ".py":
+		return dotted(submatches(pyImportRe, d.Content))
+	case ".java", ".kt", ".scala":
+		return dotted(submatches(jvmImportRe, d.Content))
+	case ".c", ".cc", ".cpp", ".h", ".hh", ".hpp":
+		specs := submatches(includeRe, d.Content)
+		for i, s := range specs {
+			if strings.HasPrefix(s, ".") {
+				specs[i] = path.Join(dir, s)
+			}
+		}
+		return specs
+	}
+	return nil
+}
+
+// pathSuffixes returns all suffixes of p at path component boundaries, eg.
+// "a/b/c" yields "a/b/c", "b/c" and "c".
+func pathSuffixes(p string) []string {
+	suffixes := []string{p}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && i+1 < len(p) {
+			suffixes = append(suffixes, p[i+1:])
+		}
+	}
+	return suffixes
+}
+
+func (ImportGraphRanker) Rank(docs []*zoekt.Document) [][]float64 {
+	// Imports name either a file without its extension (most languages) or
+	// a directory (Go packages, Python packages, JavaScript index files).
+	targets := map[string][]int{}
+	for i, d := range docs {
+		ext := path.Ext(d.Name)
+		for _, s := range pathSuffixes(strings.TrimSuffix(d.Name, ext)) {
+			targets[s] = append(targets[s], i)
+		}
+		// C includes name the file including its extension.
+		for _, s := range pathSuffixes(d.Name) {
+			targets[s] = append(targets[s], i)
+		}
+		if dir := path.Dir(d.Name); dir != "." {
+			for _, s := range pathSuffixes(dir) {
+				targets[s] = append(targets[s], i)
+			}
+		}
+	}
+
+	importers := make([]map[int]struct{}, len(docs))
+	for i, d := range docs {
+		for _, spec := range importSpecs(d) {
+			// Take the longest suffix of the import path naming a file or
+			// directory of the repository.
+			for _, s := range pathSuffixes(path.Clean(spec)) {
+				ts, ok := targets[s]
+				if !ok {
+					continue
+				}
+				for _, t := range ts {
+					if t == i {
+						continue
+					}
+					if importers[t] == nil {
+						importers[t] = map[int]struct{}{}
+					}
+					importers[t][i] = struct{}{}
+				}
+				break
+			}
+		}
+	}
+
+	signals := make([][]float64, len(docs))
+	for i := range docs {
+		signals[i] = []float64{1.0 - squashRange(len(importers[i]))}
+	}
+	return signals
+}
@@ -0,0 +1,186 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func docNames(docs []*zoekt.Document) []string {
+	var names []string
+	for _, d := range docs {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestImportGraphRanker(t *testing.T) {
+	docs := []*zoekt.Document{
+		{Name: "cmd/a/main.go", Content: []byte("package main\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/org/repo/internal/util\"\n)\n")},
+		{Name: "cmd/b/main.go", Content: []byte("package main\n\nimport \"github.com/org/repo/internal/util\"\n")},
+		{Name: "internal/util/util.go", Content: []byte("package util\n")},
+		{Name: "web/app.ts", Content: []byte("import { x } from './lib/x';\nimport './lib/y';\n")},
+		{Name: "web/lib/x.ts", Content: []byte("export const x = 1;\n")},
+		{Name: "web/lib/y.ts", Content: []byte("import { x } from './x';\n")},
+	}
+
+	got := ImportGraphRanker{}.Rank(docs)
+	want := []float64{
+		1,
+		1,
+		1 - squashRange(2),
+		1,
+		1 - squashRange(2),
+		1 - squashRange(1),
+	}
+	for i := range docs {
+		if got[i][0] != want[i] {
+			t.Errorf("%s: got %v, want %v", docs[i].Name, got[i][0], want[i])
+		}
+	}
+
+	sortDocuments(docs, []Ranker{ImportGraphRanker{}})
+	if diff := cmp.Diff([]string{"web/lib/x.ts", "internal/util/util.go", "web/lib/y.ts"}, docNames(docs)[:3]); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestImportGraphRankerRepository(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		IndexDir:              dir,
+		RepositoryDescription: zoekt.Repository{Name: "repo"},
+		DisableCTags:          true,
+		Rankers:               []Ranker{ImportGraphRanker{}},
+		ShardMax:              100,
+		Parallelism:           1,
+	}
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The importer of util.go fills the first shard by itself, so util.go
+	// is only imported from another shard.
+	importer := "package main\n\nimport \"github.com/org/repo/lib/util\"\n\n// " + strings.Repeat("x", 100) + "\n"
+	for _, d := range []zoekt.Document{
+		{Name: "cmd/main.go", Content: []byte(importer)},
+		{Name: "b.go", Content: []byte("package b\n")},
+		{Name: "lib/util/util.go", Content: []byte("package util\n")},
+	} {
+		if err := b.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(opts.shardName(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	indexFile, err := zoekt.NewIndexFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searcher, err := zoekt.NewSearcher(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+
+	// Earlier documents of a shard score higher.
+	res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "package", Content: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	if diff := cmp.Diff([]string{"lib/util/util.go", "b.go"}, got); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGlobRanker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ranks")
+	if err := os.WriteFile(path, []byte("# comment\n\ncmd/** 2\n**/*.pb.go -10\ncmd/gen/** -1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rankers, err := ParseRankers("globs=" + path + ",path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rankerSpecs(rankers), "globs="+path+",path"; got != want {
+		t.Errorf("got specs %q, want %q", got, want)
+	}
+	name := rankers[0].Name()
+
+	docs := []*zoekt.Document{
+		{Name: "api/api.pb.go"},
+		{Name: "README.md"},
+		{Name: "cmd/gen/main.go"},
+		{Name: "cmd/tool/main.go"},
+	}
+	sortDocuments(docs, rankers)
+	if diff := cmp.Diff([]string{"cmd/tool/main.go", "README.md", "cmd/gen/main.go", "api/api.pb.go"}, docNames(docs)); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte("cmd/** 3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := NewGlobRanker(path); err != nil {
+		t.Fatal(err)
+	} else if r.Name() == name {
+		t.Errorf("name %q didn't change with the contents of %s", name, path)
+	}
+
+	if err := os.WriteFile(path, []byte("cmd/** high\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGlobRanker(path); err == nil {
+		t.Error("expected error for invalid weight")
+	}
+	if _, err := ParseRankers("pagerank"); err == nil {
+		t.Error("expected error for unknown ranker")
+	}
+}
+
+func TestEnryRanker(t *testing.T) {
+	docs := []*zoekt.Document{
+		{Name: "api.pb.go", Content: []byte("// Code generated by protoc-gen-go. DO NOT EDIT.\npackage api\n")},
+		{Name: "third_party/lib/lib.go", Content: []byte("package lib\n")},
+		{Name: "main.go", Content: []byte("package main\n")},
+	}
+	sortDocuments(docs, []Ranker{EnryRanker{}})
+	if diff := cmp.Diff([]string{"main.go", "third_party/lib/lib.go", "api.pb.go"}, docNames(docs)); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRankerOverrides(t *testing.T) {
+	yes, no := true, false
+	docs := []*zoekt.Document{
+		{Name: "vendor/lib.go", Vendored: &no},
+		{Name: "gen.go", Generated: &yes},
+		{Name: "app.min.js", Generated: &no},
+	}
+	for _, rankers := range [][]Ranker{{PathRanker{}}, {EnryRanker{}}} {
+		sortDocuments(docs, rankers)
+		if diff := cmp.Diff([]string{"gen.go"}, docNames(docs)[2:]); diff != "" {
+			t.Errorf("%s: order mismatch (-want +got):\n%s", rankers[0].Name(), diff)
+		}
+	}
+}
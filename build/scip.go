@@ -0,0 +1,227 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/scip"
+)
+
+// loadSCIPIndex reads the SCIP index at p and returns its documents by path.
+func loadSCIPIndex(p string) (map[string]*scip.Document, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := scip.Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	docs := make(map[string]*scip.Document, len(idx.Documents))
+	for _, d := range idx.Documents {
+		docs[path.Clean(d.RelativePath)] = d
+	}
+	return docs, nil
+}
+
+// scipKinds maps SCIP symbol kinds to the ctags kinds understood by
+// scoreKind. Kinds not listed here are derived from the symbol descriptor.
+var scipKinds = map[int32]string{
+	7:  "class",
+	8:  "constant",
+	9:  "method", // Constructor
+	11: "enum",
+	12: "enumerator",
+	15: "field",
+	17: "function",
+	21: "interface",
+	26: "method",
+	29: "module",
+	30: "namespace",
+	35: "package",
+	41: "field", // Property
+	49: "struct",
+	53: "interface", // Trait
+	54: "type",
+	55: "type", // TypeAlias
+	61: "variable",
+}
+
+var scipSuffixKinds = map[scip.Suffix]string{
+	scip.SuffixNamespace:     "namespace",
+	scip.SuffixType:          "type",
+	scip.SuffixTerm:          "variable",
+	scip.SuffixMeta:          "meta",
+	scip.SuffixMacro:         "macro",
+	scip.SuffixMethod:        "method",
+	scip.SuffixTypeParameter: "typeParameter",
+	scip.SuffixParameter:     "parameter",
+}
+
+// scipAddSymbols sets the symbols of the documents in todo which are covered
+// by the SCIP documents docs. Definitions replace the symbols found by ctags,
+// and other occurrences are stored as symbol references if refs is set.
+// Symbols local to a document are skipped.
+func scipAddSymbols(todo []*zoekt.Document, docs map[string]*scip.Document, refs bool) {
+	for _, doc := range todo {
+		sd, ok := docs[doc.Name]
+		if !ok {
+			continue
+		}
+
+		kinds := map[string]int32{}
+		for _, si := range sd.Symbols {
+			kinds[si.Symbol] = si.Kind
+		}
+
+		// Non-nil, so ctags doesn't parse the document if SCIP has no
+		// definitions for it.
+		doc.Symbols = []zoekt.DocumentSection{}
+		doc.SymbolsMetaData = []*zoekt.Symbol{}
+		var symRefs []zoekt.DocumentSection
+
+		lines := newLinesIndices(doc.Content)
+		for _, o := range sd.Occurrences {
+			if o.Symbol == "" || scip.IsLocal(o.Symbol) {
+				continue
+			}
+			sec, ok := scipRange(doc.Content, lines, o.Range, sd.PositionEncoding)
+			if !ok {
+				continue
+			}
+
+			if o.Roles&scip.SymbolRoleDefinition == 0 {
+				symRefs = append(symRefs, sec)
+				continue
+			}
+
+			ds, err := scip.ParseDescriptors(o.Symbol)
+			if err != nil || len(ds) == 0 {
+				continue
+			}
+			sym := &zoekt.Symbol{
+				Sym: ds[len(ds)-1].Name,
+				ID:  o.Symbol,
+			}
+			if sym.Kind = scipKinds[kinds[o.Symbol]]; sym.Kind == "" {
+				sym.Kind = scipSuffixKinds[ds[len(ds)-1].Suffix]
+			}
+			if len(ds) > 1 {
+				parent := ds[len(ds)-2]
+				sym.Parent = parent.Name
+				sym.ParentKind = scipSuffixKinds[parent.Suffix]
+			}
+			doc.Symbols = append(doc.Symbols, sec)
+			doc.SymbolsMetaData = append(doc.SymbolsMetaData, sym)
+		}
+
+		doc.Symbols, doc.SymbolsMetaData = dedupSymbols(doc.Symbols, doc.SymbolsMetaData)
+		if refs {
+			doc.SymbolRefs = scipRefs(symRefs, doc.Symbols)
+		}
+	}
+}
+
+// dedupSymbols sorts symbols and drops symbols overlapping an earlier one,
+// which the index doesn't support. Indexers emit overlapping definitions
+// eg. for a field and its implicit accessor.
+func dedupSymbols(secs []zoekt.DocumentSection, meta []*zoekt.Symbol) ([]zoekt.DocumentSection, []*zoekt.Symbol) {
+	idx := make([]int, len(secs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return secs[idx[i]].Start < secs[idx[j]].Start
+	})
+
+	outSecs := make([]zoekt.DocumentSection, 0, len(secs))
+	outMeta := make([]*zoekt.Symbol, 0, len(secs))
+	for _, i := range idx {
+		if n := len(outSecs); n > 0 && outSecs[n-1].End > secs[i].Start {
+			continue
+		}
+		outSecs = append(outSecs, secs[i])
+		outMeta = append(outMeta, meta[i])
+	}
+	return outSecs, outMeta
+}
+
+// scipRefs returns the sorted, non-overlapping references which don't
+// overlap a definition in defs. defs must be sorted.
+func scipRefs(refs, defs []zoekt.DocumentSection) []zoekt.DocumentSection {
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Start < refs[j].Start })
+
+	// Non-nil, so addSymbolRefs doesn't add lexical references.
+	out := []zoekt.DocumentSection{}
+	for _, r := range refs {
+		for len(defs) > 0 && defs[0].End <= r.Start {
+			defs = defs[1:]
+		}
+		if len(defs) > 0 && defs[0].Start < r.End {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].End > r.Start {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// scipRange converts a single line SCIP range to byte offsets into content.
+// lines holds the offsets of the newlines of content.
+func scipRange(content []byte, lines []uint32, r []int32, enc scip.PositionEncoding) (zoekt.DocumentSection, bool) {
+	if len(r) != 3 && !(len(r) == 4 && r[0] == r[2]) {
+		return zoekt.DocumentSection{}, false
+	}
+	line, startChar, endChar := int(r[0]), int(r[1]), int(r[len(r)-1])
+	if line < 0 || line > len(lines) || startChar < 0 || endChar <= startChar {
+		return zoekt.DocumentSection{}, false
+	}
+
+	lineStart := 0
+	if line > 0 {
+		lineStart = int(lines[line-1]) + 1
+	}
+	lineEnd := len(content)
+	if line < len(lines) {
+		lineEnd = int(lines[line])
+	}
+	text := content[lineStart:lineEnd]
+
+	start, ok := scipCharOffset(text, startChar, enc)
+	if !ok {
+		return zoekt.DocumentSection{}, false
+	}
+	end, ok := scipCharOffset(text, endChar, enc)
+	if !ok {
+		return zoekt.DocumentSection{}, false
+	}
+	return zoekt.DocumentSection{Start: uint32(lineStart + start), End: uint32(lineStart + end)}, true
+}
+
+// scipCharOffset returns the byte offset of character c of line.
+func scipCharOffset(line []byte, c int, enc scip.PositionEncoding) (int, bool) {
+	if enc != scip.PositionEncodingUTF16 && enc != scip.PositionEncodingUTF32 {
+		return c, c <= len(line)
+	}
+
+	units := 0
+	for off := 0; off < len(line); {
+		if units == c {
+			return off, true
+		}
+		r, size := utf8.DecodeRune(line[off:])
+		units++
+		if enc == scip.PositionEncodingUTF16 && r >= 0x10000 {
+			units++
+		}
+		off += size
+	}
+	return len(line), units == c
+}
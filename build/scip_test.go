@@ -0,0 +1,61 @@
+package build
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/scip"
+)
+
+func TestSCIPAddSymbols(t *testing.T) {
+	const (
+		typ    = "scip-go gomod example v1 `example/foo`/T#"
+		method = "scip-go gomod example v1 `example/foo`/T#Bar()."
+	)
+
+	todo := []*zoekt.Document{{
+		Name: "foo/foo.go",
+		// The "é" is 2 bytes but 1 UTF-16 code unit.
+		Content: []byte("package foo\n\ntype T struct{}\n\nfunc (t T) Bar() { /* é */ t.Bar() }\n"),
+	}, {
+		Name:    "other.go",
+		Content: []byte("package other\n"),
+	}}
+	docs := map[string]*scip.Document{
+		"foo/foo.go": {
+			RelativePath:     "foo/foo.go",
+			PositionEncoding: scip.PositionEncodingUTF16,
+			Occurrences: []scip.Occurrence{
+				{Range: []int32{2, 5, 6}, Symbol: typ, Roles: scip.SymbolRoleDefinition},
+				{Range: []int32{4, 11, 14}, Symbol: method, Roles: scip.SymbolRoleDefinition},
+				{Range: []int32{4, 29, 32}, Symbol: method},
+				{Range: []int32{4, 8, 9}, Symbol: typ},
+				{Range: []int32{4, 6, 7}, Symbol: "local 0", Roles: scip.SymbolRoleDefinition},
+			},
+			Symbols: []scip.SymbolInformation{{Symbol: typ, Kind: 49}},
+		},
+	}
+
+	scipAddSymbols(todo, docs, true)
+
+	wantSecs := []zoekt.DocumentSection{{Start: 18, End: 19}, {Start: 41, End: 44}}
+	if !reflect.DeepEqual(todo[0].Symbols, wantSecs) {
+		t.Errorf("got symbols %v, want %v", todo[0].Symbols, wantSecs)
+	}
+	wantMeta := []*zoekt.Symbol{
+		{Sym: "T", Kind: "struct", Parent: "example/foo", ParentKind: "namespace", ID: typ},
+		{Sym: "Bar", Kind: "method", Parent: "T", ParentKind: "type", ID: method},
+	}
+	if !reflect.DeepEqual(todo[0].SymbolsMetaData, wantMeta) {
+		t.Errorf("got metadata %+v, want %+v", todo[0].SymbolsMetaData, wantMeta)
+	}
+	wantRefs := []zoekt.DocumentSection{{Start: 38, End: 39}, {Start: 60, End: 63}}
+	if !reflect.DeepEqual(todo[0].SymbolRefs, wantRefs) {
+		t.Errorf("got refs %v, want %v", todo[0].SymbolRefs, wantRefs)
+	}
+
+	if todo[1].Symbols != nil {
+		t.Errorf("got symbols %v for document without SCIP data", todo[1].Symbols)
+	}
+}
@@ -0,0 +1,62 @@
+package build
+
+import (
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+var identifierRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// minSymbolRefLen is the minimum length of an identifier indexed as a symbol
+// reference. Shorter identifiers are mostly local variables.
+const minSymbolRefLen = 3
+
+// symbolRefStopWords are keywords of common languages which look like
+// identifiers but never refer to a symbol.
+var symbolRefStopWords = map[string]bool{
+	"and": true, "break": true, "case": true, "catch": true, "class": true,
+	"const": true, "continue": true, "def": true, "default": true,
+	"defer": true, "elif": true, "else": true, "enum": true, "except": true,
+	"export": true, "extends": true, "false": true, "final": true,
+	"finally": true, "for": true, "from": true, "func": true, "function": true,
+	"goto": true, "import": true, "interface": true, "let": true, "new": true,
+	"nil": true, "not": true, "null": true, "package": true, "pass": true,
+	"private": true, "public": true, "raise": true, "range": true,
+	"return": true, "select": true, "self": true, "static": true,
+	"struct": true, "super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "type": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true,
+}
+
+// addSymbolRefs sets SymbolRefs of the documents in todo which have symbols
+// and no references yet, eg. from a SCIP index. References are found
+// lexically: every identifier which isn't a keyword or a
+// definition is a reference. This over-approximates, eg. identifiers in
+// comments are references too, but needs no language support beyond what
+// symbol extraction already provides.
+func addSymbolRefs(todo []*zoekt.Document) {
+	for _, doc := range todo {
+		if len(doc.Symbols) == 0 || doc.SymbolRefs != nil {
+			continue
+		}
+
+		// Symbols are sorted and non-overlapping, see tagsToSections.
+		defs := doc.Symbols
+		var refs []zoekt.DocumentSection
+		for _, idx := range identifierRe.FindAllIndex(doc.Content, -1) {
+			start, end := uint32(idx[0]), uint32(idx[1])
+			if end-start < minSymbolRefLen || symbolRefStopWords[string(doc.Content[start:end])] {
+				continue
+			}
+			for len(defs) > 0 && defs[0].End <= start {
+				defs = defs[1:]
+			}
+			if len(defs) > 0 && defs[0].Start < end {
+				continue
+			}
+			refs = append(refs, zoekt.DocumentSection{Start: start, End: end})
+		}
+		doc.SymbolRefs = refs
+	}
+}
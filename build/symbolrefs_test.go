@@ -0,0 +1,30 @@
+package build
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+func TestAddSymbolRefs(t *testing.T) {
+	todo := []*zoekt.Document{{
+		Name:    "a.go",
+		Content: []byte("func bar() {}\nfunc foo() { return bar(x) }"),
+		// -------------01234567890123 456789012345678901234567890
+		Symbols: []zoekt.DocumentSection{{Start: 5, End: 8}, {Start: 19, End: 22}},
+	}, {
+		Name:    "README",
+		Content: []byte("see bar"),
+	}}
+
+	addSymbolRefs(todo)
+
+	want := []zoekt.DocumentSection{{Start: 34, End: 37}}
+	if !reflect.DeepEqual(todo[0].SymbolRefs, want) {
+		t.Errorf("got %v, want %v", todo[0].SymbolRefs, want)
+	}
+	if todo[1].SymbolRefs != nil {
+		t.Errorf("got %v for document without symbols, want nil", todo[1].SymbolRefs)
+	}
+}
@@ -44,6 +44,11 @@ func run() int {
 	deltaShardNumberFallbackThreshold := flag.Uint64("delta_threshold", 0, "upper limit on the number of preexisting shards that can exist before attempting a delta build (0 to disable fallback behavior)")
 	offlineRanking := flag.String("offline_ranking", "", "the name of the file that contains the ranking info.")
 	offlineRankingVersion := flag.String("offline_ranking_version", "", "a version string identifying the contents in offline_ranking.")
+	history := flag.Int("history", 0, "index the messages and diffs of this many recent commits per branch into history shards.")
+	blame := flag.Bool("blame", false, "index the commit which last modified each line of the indexed files.")
+	gitignore := flag.Bool("gitignore", false, "skip files matched by .gitignore files.")
+	gitattributes := flag.Bool("gitattributes", false, "apply linguist attributes and -diff of .gitattributes files.")
+	snapshotsStr := flag.String("snapshots", "", "comma separated list of revisions, eg. release tags, to index into snapshot shards, which are searched with at:.")
 	flag.Parse()
 
 	// Tune GOMAXPROCS to match Linux container CPU quota.
@@ -72,12 +77,20 @@ func run() int {
 	opts.IsDelta = *isDelta
 	opts.DocumentRanksPath = *offlineRanking
 	opts.DocumentRanksVersion = *offlineRankingVersion
+	opts.Blame = *blame
+	opts.Gitignore = *gitignore
+	opts.GitAttributes = *gitattributes
 
 	var branches []string
 	if *branchesStr != "" {
 		branches = strings.Split(*branchesStr, ",")
 	}
 
+	var snapshots []string
+	if *snapshotsStr != "" {
+		snapshots = strings.Split(*snapshotsStr, ",")
+	}
+
 	gitRepos := map[string]string{}
 	for _, repoDir := range flag.Args() {
 		repoDir, err := filepath.Abs(repoDir)
@@ -109,6 +122,8 @@ func run() int {
 			Branches:                          branches,
 			RepoDir:                           dir,
 			DeltaShardNumberFallbackThreshold: *deltaShardNumberFallbackThreshold,
+			IndexHistory:                      *history,
+			Snapshots:                         snapshots,
 		}
 
 		if err := gitindex.IndexGitRepo(gitOpts); err != nil {
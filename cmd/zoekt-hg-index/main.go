@@ -0,0 +1,64 @@
+// Command zoekt-hg-index indexes Mercurial repositories. It requires the hg
+// binary.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/automaxprocs/maxprocs"
+
+	"github.com/sourcegraph/zoekt/cmd"
+	"github.com/sourcegraph/zoekt/hgindex"
+)
+
+func run() int {
+	allowMissing := flag.Bool("allow_missing_branches", false, "allow missing branches.")
+	branchesStr := flag.String("branches", "default", "hg revisions to index.")
+	incremental := flag.Bool("incremental", true, "only index changed repositories")
+	isDelta := flag.Bool("delta", false, "whether we should use delta build")
+	deltaShardNumberFallbackThreshold := flag.Uint64("delta_threshold", 0, "upper limit on the number of preexisting shards that can exist before attempting a delta build (0 to disable fallback behavior)")
+	flag.Parse()
+
+	// Tune GOMAXPROCS to match Linux container CPU quota.
+	_, _ = maxprocs.Set()
+
+	opts := cmd.OptionsFromFlags()
+	opts.IsDelta = *isDelta
+
+	var branches []string
+	if *branchesStr != "" {
+		branches = strings.Split(*branchesStr, ",")
+	}
+
+	exitStatus := 0
+	for _, repoDir := range flag.Args() {
+		dir, err := filepath.Abs(repoDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir = filepath.Clean(dir)
+
+		opts.RepositoryDescription.Name = filepath.Base(dir)
+		hgOpts := hgindex.Options{
+			RepoDir:                           dir,
+			Incremental:                       *incremental,
+			AllowMissingBranch:                *allowMissing,
+			BuildOptions:                      *opts,
+			Branches:                          branches,
+			DeltaShardNumberFallbackThreshold: *deltaShardNumberFallbackThreshold,
+		}
+		if err := hgindex.IndexHgRepo(hgOpts); err != nil {
+			log.Printf("IndexHgRepo(%s, delta=%t): %v", dir, hgOpts.BuildOptions.IsDelta, err)
+			exitStatus = 1
+		}
+	}
+	return exitStatus
+}
+
+func main() {
+	os.Exit(run())
+}
@@ -16,51 +16,22 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime/pprof"
 	"strings"
 
-	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/build"
 	"github.com/sourcegraph/zoekt/cmd"
+	"github.com/sourcegraph/zoekt/dirindex"
 	"go.uber.org/automaxprocs/maxprocs"
 )
 
-type fileInfo struct {
-	name string
-	size int64
-}
-
-type fileAggregator struct {
-	ignoreDirs map[string]struct{}
-	sizeMax    int64
-	sink       chan fileInfo
-}
-
-func (a *fileAggregator) add(path string, info os.FileInfo, err error) error {
-	if err != nil {
-		return err
-	}
-
-	if info.IsDir() {
-		base := filepath.Base(path)
-		if _, ok := a.ignoreDirs[base]; ok {
-			return filepath.SkipDir
-		}
-	}
-
-	if info.Mode().IsRegular() {
-		a.sink <- fileInfo{path, info.Size()}
-	}
-	return nil
-}
-
 func main() {
 	cpuProfile := flag.String("cpu_profile", "", "write cpu profile to file")
 	ignoreDirs := flag.String("ignore_dirs", ".git,.hg,.svn", "comma separated list of directories to ignore.")
+	incremental := flag.Bool("incremental", false, "only index files changed since the last run. Requires shards built with -incremental.")
 	flag.Parse()
 
 	// Tune GOMAXPROCS to match Linux container CPU quota.
@@ -78,73 +49,33 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	ignoreDirMap := map[string]struct{}{}
+	var ignore []string
 	if *ignoreDirs != "" {
-		dirs := strings.Split(*ignoreDirs, ",")
-		for _, d := range dirs {
+		for _, d := range strings.Split(*ignoreDirs, ",") {
 			d = strings.TrimSpace(d)
 			if d != "" {
-				ignoreDirMap[d] = struct{}{}
+				ignore = append(ignore, d)
 			}
 		}
 	}
 	for _, arg := range flag.Args() {
-		opts.RepositoryDescription.Source = arg
-		if err := indexArg(arg, *opts, ignoreDirMap); err != nil {
+		if err := indexArg(arg, *opts, ignore, *incremental); err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-func indexArg(arg string, opts build.Options, ignore map[string]struct{}) error {
+func indexArg(arg string, opts build.Options, ignore []string, incremental bool) error {
 	dir, err := filepath.Abs(filepath.Clean(arg))
 	if err != nil {
 		return err
 	}
 
 	opts.RepositoryDescription.Name = filepath.Base(dir)
-	builder, err := build.NewBuilder(opts)
-	if err != nil {
-		return err
-	}
-	// we don't need to check error, since we either already have an error, or
-	// we returning the first call to builder.Finish.
-	defer builder.Finish() // nolint:errcheck
-
-	comm := make(chan fileInfo, 100)
-	agg := fileAggregator{
-		ignoreDirs: ignore,
-		sink:       comm,
-		sizeMax:    int64(opts.SizeMax),
-	}
-
-	go func() {
-		if err := filepath.Walk(dir, agg.add); err != nil {
-			log.Fatal(err)
-		}
-		close(comm)
-	}()
-
-	for f := range comm {
-		displayName := strings.TrimPrefix(f.name, dir+"/")
-		if f.size > int64(opts.SizeMax) && !opts.IgnoreSizeMax(displayName) {
-			if err := builder.Add(zoekt.Document{
-				Name:       displayName,
-				SkipReason: fmt.Sprintf("document size %d larger than limit %d", f.size, opts.SizeMax),
-			}); err != nil {
-				return err
-			}
-			continue
-		}
-		content, err := os.ReadFile(f.name)
-		if err != nil {
-			return err
-		}
-
-		if err := builder.AddFile(displayName, content); err != nil {
-			return err
-		}
-	}
-
-	return builder.Finish()
+	return dirindex.IndexDir(dirindex.Options{
+		Dir:          dir,
+		IgnoreDirs:   ignore,
+		Incremental:  incremental,
+		BuildOptions: opts,
+	})
 }
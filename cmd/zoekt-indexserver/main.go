@@ -35,6 +35,7 @@ import (
 
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/gitindex"
+	"github.com/sourcegraph/zoekt/placement"
 )
 
 const day = time.Hour * 24
@@ -64,6 +65,12 @@ type Options struct {
 	mirrorConfigFile string
 	maxLogAge        time.Duration
 	indexTimeout     time.Duration
+
+	placementFile     string
+	placementReplicas int
+	placementGrace    time.Duration
+	node              string
+	placement         *placement.Membership
 }
 
 func (o *Options) validate() {
@@ -78,6 +85,34 @@ func (o *Options) validate() {
 	if o.indexFlagsStr != "" {
 		o.indexFlags = strings.Split(o.indexFlagsStr, " ")
 	}
+	if o.placementFile != "" {
+		m, err := placement.LoadMembership(o.placementFile, o.placementReplicas)
+		if err != nil {
+			log.Fatalf("placement: %v", err)
+		}
+		if o.node == "" {
+			log.Fatal("must set --node with --placement_file")
+		}
+		o.placement = m
+	}
+}
+
+// owns returns true if this node indexes the repository called name.
+func (o *Options) owns(name string) bool {
+	if o.placement == nil {
+		return true
+	}
+	return o.placement.Placement().Owns(o.node, name)
+}
+
+// repoName returns the name of the repository cloned into dir by the
+// zoekt-mirror-* commands.
+func repoName(repoDir, dir string) string {
+	name, err := filepath.Rel(repoDir, dir)
+	if err != nil {
+		name = dir
+	}
+	return strings.TrimSuffix(filepath.ToSlash(name), ".git")
 }
 
 func (o *Options) defineFlags() {
@@ -91,6 +126,12 @@ func (o *Options) defineFlags() {
 	flag.Float64Var(&o.cpuFraction, "cpu_fraction", 0.25,
 		"use this fraction of the cores for indexing.")
 	flag.StringVar(&o.indexFlagsStr, "git_index_flags", "", "space separated list of flags passed through to zoekt-git-index (e.g. -git_index_flags='-symbols=false -submodules=false'")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&o.placementFile, "placement_file", "", "if set, only fetch and index the repositories placed on --node, out of the nodes listed in this file. Shards of other repositories are deleted once they have been placed on other nodes for --placement_grace. The file is reloaded every --fetch_interval.")
+	flag.IntVar(&o.placementReplicas, "placement_replicas", 1, "the number of nodes each repository is placed on.")
+	flag.DurationVar(&o.placementGrace, "placement_grace", 2*time.Hour, "keep serving the shards of repositories placed on other nodes this long, so their new owners can index them first. Should exceed --fetch_interval plus the time to index a repository.")
+	flag.StringVar(&o.node, "node", hostname, "the name of this node in --placement_file.")
 }
 
 // periodicFetch runs git-fetch every once in a while. Results are
@@ -109,8 +150,19 @@ func periodicFetch(repoDir, indexDir string, opts *Options, pendingRepos chan<-
 
 		// TODO: Randomize to make sure quota throttling hits everyone.
 
+		if opts.placement != nil {
+			if changed, err := opts.placement.Reload(); err != nil {
+				log.Printf("placement: %v", err)
+			} else if changed {
+				log.Printf("placement changed to %s", opts.placement.Placement())
+			}
+		}
+
 		later := map[string]struct{}{}
 		for _, dir := range repos {
+			if !opts.owns(repoName(repoDir, dir)) {
+				continue
+			}
 			if ok := fetchGitRepo(dir); !ok {
 				later[dir] = struct{}{}
 			} else {
@@ -150,6 +202,9 @@ func fetchGitRepo(dir string) bool {
 // indexes them, sequentially.
 func indexPendingRepos(indexDir, repoDir string, opts *Options, repos <-chan string) {
 	for dir := range repos {
+		if !opts.owns(repoName(repoDir, dir)) {
+			continue
+		}
 		indexPendingRepo(dir, indexDir, repoDir, opts)
 
 		// Failures (eg. timeout) will leave temp files
@@ -203,8 +258,39 @@ func deleteLogsLoop(logDir string, maxAge time.Duration) {
 	}
 }
 
-// Delete the shard if its corresponding git repo can't be found.
-func deleteIfOrphan(repoDir string, fn string) error {
+// disowned records since when the repositories with shards on this node
+// have been placed on other nodes.
+type disowned struct {
+	since map[string]time.Time
+
+	// seen holds the repositories placed on other nodes found since the
+	// last sweep.
+	seen map[string]time.Time
+}
+
+// expired returns true if name has been placed on other nodes for longer
+// than grace.
+func (d *disowned) expired(name string, now time.Time, grace time.Duration) bool {
+	since, ok := d.since[name]
+	if !ok {
+		since = now
+	}
+	if d.seen == nil {
+		d.seen = map[string]time.Time{}
+	}
+	d.seen[name] = since
+	return now.Sub(since) > grace
+}
+
+// sweep forgets the repositories which weren't passed to expired since the
+// last sweep, eg. because they are placed on this node again.
+func (d *disowned) sweep() {
+	d.since, d.seen = d.seen, nil
+}
+
+// Delete the shard if its corresponding git repo can't be found, or if the
+// repo has been placed on other nodes for longer than the grace period.
+func deleteIfOrphan(repoDir string, fn string, opts *Options, d *disowned, now time.Time) error {
 	f, err := os.Open(fn)
 	if err != nil {
 		return nil
@@ -228,6 +314,15 @@ func deleteIfOrphan(repoDir string, fn string) error {
 	}
 	repo := repos[0]
 
+	if !opts.owns(repo.Name) {
+		// The new owners may not have indexed the repo yet.
+		if !d.expired(repo.Name, now, opts.placementGrace) {
+			return nil
+		}
+		log.Printf("deleting shard %s; repo %q is placed on %v", fn, repo.Name, opts.placement.Placement().Owners(repo.Name))
+		return os.Remove(fn)
+	}
+
 	_, err = os.Stat(repo.Source)
 	if os.IsNotExist(err) {
 		log.Printf("deleting orphan shard %s; source %q not found", fn, repo.Source)
@@ -237,21 +332,24 @@ func deleteIfOrphan(repoDir string, fn string) error {
 	return err
 }
 
-func deleteOrphanIndexes(indexDir, repoDir string, watchInterval time.Duration) {
+func deleteOrphanIndexes(indexDir, repoDir string, watchInterval time.Duration, opts *Options) {
 	t := time.NewTicker(watchInterval)
 
 	expr := indexDir + "/*"
+	var d disowned
 	for {
 		fs, err := filepath.Glob(expr)
 		if err != nil {
 			log.Printf("Glob(%q): %v", expr, err)
 		}
 
+		now := time.Now()
 		for _, f := range fs {
-			if err := deleteIfOrphan(repoDir, f); err != nil {
+			if err := deleteIfOrphan(repoDir, f, opts, &d, now); err != nil {
 				log.Printf("deleteIfOrphan(%q): %v", f, err)
 			}
 		}
+		d.sweep()
 		<-t.C
 	}
 }
@@ -298,7 +396,7 @@ func main() {
 	pendingRepos := make(chan string, 10)
 	go periodicMirrorFile(repoDir, &opts, pendingRepos)
 	go deleteLogsLoop(logDir, opts.maxLogAge)
-	go deleteOrphanIndexes(*indexDir, repoDir, opts.fetchInterval)
+	go deleteOrphanIndexes(*indexDir, repoDir, opts.fetchInterval, &opts)
 	go indexPendingRepos(*indexDir, repoDir, &opts, pendingRepos)
 	periodicFetch(repoDir, *indexDir, &opts, pendingRepos)
 }
@@ -45,6 +45,7 @@ import (
 	"github.com/sourcegraph/zoekt/build"
 	"github.com/sourcegraph/zoekt/debugserver"
 	"github.com/sourcegraph/zoekt/internal/profiler"
+	"github.com/sourcegraph/zoekt/placement"
 )
 
 var (
@@ -1086,6 +1087,11 @@ type rootConfig struct {
 	cpuFraction      float64
 	blockProfileRate int
 
+	// config values related to placing repositories on indexservers
+	placementFile     string
+	placementReplicas int
+	placementGrace    time.Duration
+
 	// config values related to shard merging
 	vacuumInterval time.Duration
 	mergeInterval  time.Duration
@@ -1111,6 +1117,9 @@ func (rc *rootConfig) registerRootFlags(fs *flag.FlagSet) {
 	fs.Float64Var(&rc.cpuFraction, "cpu_fraction", 1.0, "use this fraction of the cores for indexing.")
 	fs.IntVar(&rc.blockProfileRate, "block_profile_rate", getEnvWithDefaultInt("BLOCK_PROFILE_RATE", -1), "Sampling rate of Go's block profiler in nanoseconds. Values <=0 disable the blocking profiler Var(default). A value of 1 includes every blocking event. See https://pkg.go.dev/runtime#SetBlockProfileRate")
 	fs.DurationVar(&rc.backoffDuration, "backoff_duration", getEnvWithDefaultDuration("BACKOFF_DURATION", 10*time.Minute), "for the given duration we backoff from enqueue operations for a repository that's failed its previous indexing attempt. Consecutive failures increase the duration of the delay linearly up to the maxBackoffDuration. A negative value disables indexing backoff.")
+	fs.StringVar(&rc.placementFile, "placement_file", getEnvWithDefaultString("PLACEMENT_FILE", ""), "if set, only index the repositories placed on --hostname, out of the nodes listed in this file, instead of all repositories Sourcegraph lists. The file is reloaded every --interval.")
+	fs.IntVar(&rc.placementReplicas, "placement_replicas", getEnvWithDefaultInt("PLACEMENT_REPLICAS", 1), "the number of indexservers each repository is placed on.")
+	fs.DurationVar(&rc.placementGrace, "placement_grace", getEnvWithDefaultDuration("PLACEMENT_GRACE", time.Hour), "keep indexing and serving repositories placed on other indexservers this long, so their new owners can index them first.")
 	fs.DurationVar(&rc.maxBackoffDuration, "max_backoff_duration", getEnvWithDefaultDuration("MAX_BACKOFF_DURATION", 120*time.Minute), "the maximum duration to backoff from enqueueing a repo for indexing.  A negative value disables indexing backoff.")
 }
 
@@ -1266,6 +1275,19 @@ func newServer(conf rootConfig) (*Server, error) {
 		}
 	}
 
+	if conf.placementFile != "" {
+		m, err := placement.LoadMembership(conf.placementFile, conf.placementReplicas)
+		if err != nil {
+			return nil, err
+		}
+		sg = &placementSourcegraph{
+			Sourcegraph: sg,
+			Membership:  m,
+			Node:        conf.hostname,
+			Grace:       conf.placementGrace,
+		}
+	}
+
 	if conf.indexConcurrency < 1 {
 		conf.indexConcurrency = 1
 	}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt/placement"
+)
+
+// placementSourcegraph restricts the repositories listed by Sourcegraph to
+// the ones placed on this indexserver. The other repositories are cleaned up
+// like repositories Sourcegraph stopped assigning to us, so membership
+// changes rebalance the repositories.
+type placementSourcegraph struct {
+	Sourcegraph
+
+	Membership *placement.Membership
+
+	// Node is the name of this indexserver in the membership file.
+	Node string
+
+	// Grace is how long indexed repositories placed on other indexservers
+	// are still listed, so they stay searchable until their new owners
+	// have indexed them.
+	Grace time.Duration
+
+	mu sync.Mutex
+	// disowned maps the repositories which were placed on other
+	// indexservers to when we noticed.
+	disowned map[uint32]time.Time
+}
+
+func (s *placementSourcegraph) List(ctx context.Context, indexed []uint32) (*SourcegraphListResult, error) {
+	if changed, err := s.Membership.Reload(); err != nil {
+		log.Printf("WARN: keeping placement: %v", err)
+	} else if changed {
+		log.Printf("placement changed to %s", s.Membership.Placement())
+	}
+
+	res, err := s.Sourcegraph.List(ctx, indexed)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isIndexed := make(map[uint32]bool, len(indexed))
+	for _, id := range indexed {
+		isIndexed[id] = true
+	}
+
+	now := time.Now()
+	p := s.Membership.Placement()
+	owned := map[uint32]bool{}
+	disowned := map[uint32]time.Time{}
+	ids := make([]uint32, 0, len(res.IDs))
+	for _, id := range res.IDs {
+		if !p.Owns(s.Node, placement.IDKey(id)) {
+			if !isIndexed[id] {
+				continue
+			}
+			since, ok := s.disowned[id]
+			if !ok {
+				since = now
+			}
+			if now.Sub(since) >= s.Grace {
+				continue
+			}
+			disowned[id] = since
+		}
+		owned[id] = true
+		ids = append(ids, id)
+	}
+	s.disowned = disowned
+
+	iterate := res.IterateIndexOptions
+	return &SourcegraphListResult{
+		IDs: ids,
+		IterateIndexOptions: func(f func(IndexOptions)) {
+			iterate(func(opts IndexOptions) {
+				if owned[opts.RepoID] {
+					f(opts)
+				}
+			})
+		},
+	}, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/sourcegraph/zoekt/placement"
+)
+
+type listSourcegraph struct {
+	Sourcegraph
+	ids []uint32
+}
+
+func (s listSourcegraph) List(ctx context.Context, indexed []uint32) (*SourcegraphListResult, error) {
+	return &SourcegraphListResult{
+		IDs: s.ids,
+		IterateIndexOptions: func(f func(IndexOptions)) {
+			for _, id := range s.ids {
+				f(IndexOptions{RepoID: id})
+			}
+		},
+	}, nil
+}
+
+func TestPlacementSourcegraph(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := placement.LoadMembership(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint32
+	for id := uint32(1); id <= 20; id++ {
+		ids = append(ids, id)
+	}
+	inner := listSourcegraph{ids: ids}
+
+	list := func(node string) []uint32 {
+		t.Helper()
+		res, err := (&placementSourcegraph{Sourcegraph: inner, Membership: m, Node: node}).List(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var iterated []uint32
+		res.IterateIndexOptions(func(o IndexOptions) {
+			iterated = append(iterated, o.RepoID)
+		})
+		if diff := cmp.Diff(res.IDs, iterated, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("IterateIndexOptions doesn't match IDs (-want +got):\n%s", diff)
+		}
+		return res.IDs
+	}
+
+	a, b := list("a"), list("b")
+	if len(a) == 0 || len(b) == 0 || len(a)+len(b) != len(ids) {
+		t.Fatalf("got a=%v b=%v, want a partition of %v", a, b, ids)
+	}
+
+	// Removing b from the membership moves its repositories to a.
+	if err := os.WriteFile(path, []byte("a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ids, list("a")); diff != "" {
+		t.Errorf("a doesn't own all repositories (-want +got):\n%s", diff)
+	}
+	if got := list("b"); len(got) != 0 {
+		t.Errorf("b still owns %v", got)
+	}
+}
+
+func TestPlacementSourcegraphGrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes")
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := placement.LoadMembership(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint32
+	for id := uint32(1); id <= 20; id++ {
+		ids = append(ids, id)
+	}
+	s := &placementSourcegraph{Sourcegraph: listSourcegraph{ids: ids}, Membership: m, Node: "b", Grace: time.Hour}
+	var indexed []uint32
+	list := func() []uint32 {
+		t.Helper()
+		res, err := s.List(context.Background(), indexed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.IDs
+	}
+
+	owned := list()
+	if len(owned) == 0 || len(owned) == len(ids) {
+		t.Fatalf("b owns %v, want a part of %v", owned, ids)
+	}
+
+	// b keeps the repositories it indexed during the grace period after
+	// it was removed from the membership.
+	indexed = owned
+	if err := os.WriteFile(path, []byte("a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(owned, list()); diff != "" {
+		t.Errorf("b dropped repositories during the grace period (-want +got):\n%s", diff)
+	}
+
+	for id := range s.disowned {
+		s.disowned[id] = time.Now().Add(-2 * time.Hour)
+	}
+	if got := list(); len(got) != 0 {
+		t.Errorf("b still owns %v after the grace period", got)
+	}
+}
@@ -20,6 +20,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
@@ -44,8 +45,13 @@ import (
 	"github.com/sourcegraph/zoekt"
 	"github.com/sourcegraph/zoekt/build"
 	"github.com/sourcegraph/zoekt/debugserver"
+	"github.com/sourcegraph/zoekt/distributed"
+	"github.com/sourcegraph/zoekt/internal/alert"
+	"github.com/sourcegraph/zoekt/internal/authz"
 	"github.com/sourcegraph/zoekt/internal/profiler"
+	"github.com/sourcegraph/zoekt/internal/quota"
 	"github.com/sourcegraph/zoekt/internal/tracer"
+	"github.com/sourcegraph/zoekt/placement"
 	"github.com/sourcegraph/zoekt/query"
 	"github.com/sourcegraph/zoekt/shards"
 	"github.com/sourcegraph/zoekt/stream"
@@ -130,13 +136,26 @@ func main() {
 
 	listen := flag.String("listen", ":6070", "listen on this address.")
 	index := flag.String("index", build.DefaultDir, "set index directory to use")
+	extraIndex := flag.String("extra_index", "", "if set, also search the shards in these comma separated directories, eg. a cold storage tier. Shards of a repository should only be in one directory.")
+	indexURLs := flag.String("index_urls", "", "if set, also search the shards listed by these comma separated URLs, eg. directory listings of a static file server. Shards are read with HTTP range requests.")
+	indexURLsInterval := flag.Duration("index_urls_interval", time.Minute, "how often to check --index_urls for changed shards.")
+	indexURLsTimeout := flag.Duration("index_urls_timeout", zoekt.DefaultHTTPTimeout, "timeout of the HTTP requests to --index_urls, including reads of shards.")
 	html := flag.Bool("html", true, "enable HTML interface")
 	enableRPC := flag.Bool("rpc", false, "enable go/net RPC")
 	enableIndexserverProxy := flag.Bool("indexserver_proxy", false, "proxy requests with URLs matching the path /indexserver/ to <index>/indexserver.sock")
+	adminListen := flag.String("admin_listen", "", "if set, serve /admin/reload, which indexers call to make the shards they wrote searchable immediately, on this address, eg. localhost:6071. It isn't served on --listen, because it is unauthenticated.")
 	print := flag.Bool("print", false, "enable local result URLs")
 	enablePprof := flag.Bool("pprof", false, "set to enable remote profiling.")
 	sslCert := flag.String("ssl_cert", "", "set path to SSL .pem holding certificate.")
 	sslKey := flag.String("ssl_key", "", "set path to SSL .pem holding key.")
+	sslClientCA := flag.String("ssl_client_ca", "", "set path to a .pem holding CA certificates used to verify TLS client certificates. The common name of a verified client certificate is used as the caller identity.")
+	aclFile := flag.String("acl_file", "", "if set, restrict the repositories each caller can search according to this JSON file mapping identities to repository names.")
+	identityHeader := flag.String("identity_header", "", "if set, read the caller identity from this request header. Only use behind a proxy which sets the header.")
+	rateLimit := flag.Float64("rate_limit", 0, "if non-zero, the sustained number of requests per second a single client (identity, see -acl_file, or IP) can make.")
+	rateLimitBurst := flag.Float64("rate_limit_burst", 0, "the number of requests a client can make at once. Defaults to --rate_limit.")
+	costLimit := flag.Float64("cost_limit", 0, "if non-zero, the sustained search cost per second a single client can spend. The cost of a search is the number of files loaded plus regexps evaluated plus MiB of content read.")
+	costLimitBurst := flag.Float64("cost_limit_burst", 0, "the cost budget of an idle client. Defaults to 60 times --cost_limit.")
+	costLimitReject := flag.Bool("cost_limit_reject", false, "reject searches of clients over their cost budget instead of running them in the batch queue.")
 	hostCustomization := flag.String(
 		"host_customization", "",
 		"specify host customization, as HOST1=QUERY,HOST2=QUERY")
@@ -144,6 +163,22 @@ func main() {
 	templateDir := flag.String("template_dir", "", "set directory from which to load custom .html.tpl template files")
 	dumpTemplates := flag.Bool("dump_templates", false, "dump templates into --template_dir and exit.")
 	version := flag.Bool("version", false, "Print version number")
+	alertsFile := flag.String("alerts_file", "", "if set, re-run the saved queries stored in this JSON file whenever shards are loaded and report new matches.")
+	alertsWebhook := flag.String("alerts_webhook", "", "if set, POST alerts for saved queries to this URL instead of logging them. Requires --alerts_file.")
+	backends := flag.String("backends", "", "if set, search the comma separated zoekt-webserver backends (host:port, serving --rpc) instead of --index.")
+	backendTimeout := flag.Duration("backend_timeout", 0, "if set, searches of --backends which take longer are abandoned and reported as crashes.")
+	placementFile := flag.String("placement_file", "", "if set, route queries restricted to repositories only to the --backends owning them, as placed on the nodes listed in this file. The nodes must be named like the --backends.")
+	placementReplicas := flag.Int("placement_replicas", 1, "the number of --backends each repository is placed on.")
+	resultCacheMB := flag.Int64("result_cache_mb", 0, "if positive, cache the results of searching each shard, up to this many MiB. Cached results of a shard are dropped when it is reloaded.")
+	placementByID := flag.Bool("placement_by_id", false, "if set, repositories are placed by ID, as done by zoekt-sourcegraph-indexserver, instead of by name.")
+	interactiveSearches := flag.Int64("interactive_searches", 0, "the number of interactive searches to run concurrently. Defaults to GOMAXPROCS.")
+	batchSearches := flag.Int64("batch_searches", 0, "the number of batch searches, and interactive searches running for long, to run concurrently. Defaults to a quarter of --interactive_searches.")
+	backgroundSearches := flag.Int64("background_searches", 0, "the number of background searches, eg. exports, to run concurrently. Defaults to 1.")
+	shardTimeout := flag.Duration("shard_timeout", 0, "if set, stop searching a single shard after this long and report it as timed out.")
+	exportMaxWallTime := flag.Duration("export_max_wall_time", time.Minute, "the maximum time a CSV or JSON Lines export searches. Requests may lower it with the timeout parameter.")
+	exportMaxMatchCount := flag.Int("export_max_match_count", 10_000_000, "the maximum number of matches of a CSV or JSON Lines export.")
+	quarantineThreshold := flag.Duration("quarantine_threshold", 0, "if set, quarantine shards whose p99 search latency exceeds this. Interactive searches skip quarantined shards, other searches search them last.")
+	quarantineDuration := flag.Duration("quarantine_duration", time.Minute, "how long a shard stays quarantined. See --quarantine_threshold.")
 
 	flag.Parse()
 
@@ -196,12 +231,86 @@ func main() {
 
 	prometheus.DefaultRegisterer.MustRegister(c)
 
+	var alertWatcher *alert.Watcher
+	if *alertsFile != "" {
+		store, err := alert.OpenStore(*alertsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var sink alert.Sink = &alert.LogSink{}
+		if *alertsWebhook != "" {
+			sink = &alert.WebhookSink{URL: *alertsWebhook}
+		}
+		alertWatcher = alert.NewWatcher(store, sink)
+	} else if *alertsWebhook != "" {
+		log.Fatal("--alerts_webhook requires --alerts_file")
+	}
+
 	// Do not block on loading shards so we can become partially available
 	// sooner. Otherwise on large instances zoekt can be unavailable on the
 	// order of minutes.
-	searcher, err := shards.NewDirectorySearcherFast(*index)
-	if err != nil {
-		log.Fatal(err)
+	searcherOpts := shards.DirectorySearcherOptions{
+		ResultCacheBytes: *resultCacheMB * 1024 * 1024,
+		Scheduler: shards.SchedulerOptions{
+			Interactive: *interactiveSearches,
+			Batch:       *batchSearches,
+			Background:  *backgroundSearches,
+		},
+		Quarantine: shards.QuarantineOptions{
+			ShardTimeout: *shardTimeout,
+			Threshold:    *quarantineThreshold,
+			Duration:     *quarantineDuration,
+		},
+	}
+	if alertWatcher != nil {
+		searcherOpts.OnLoad = alertWatcher.ReposLoaded
+	}
+	var (
+		searcher    zoekt.Streamer
+		reloader    shards.ShardReloader
+		quarantined shards.QuarantineLister
+	)
+	if *backends != "" {
+		var bs []distributed.Backend
+		for _, addr := range strings.Split(*backends, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				bs = append(bs, distributed.NewBackend(addr))
+			}
+		}
+		opts := distributed.Options{Timeout: *backendTimeout, PlacementByID: *placementByID}
+		if *placementFile != "" {
+			m, err := placement.LoadMembership(*placementFile, *placementReplicas)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go m.Watch(context.Background(), time.Minute)
+			opts.Placement = m
+		}
+		searcher = distributed.NewSearcher(bs, opts)
+	} else {
+		sources := []shards.ShardSource{shards.DirectorySource(*index)}
+		for _, dir := range strings.Split(*extraIndex, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				sources = append(sources, shards.DirectorySource(dir))
+			}
+		}
+		for _, u := range strings.Split(*indexURLs, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				sources = append(sources, shards.HTTPSource(u, &http.Client{Timeout: *indexURLsTimeout}, *indexURLsInterval))
+			}
+		}
+
+		var err error
+		if searcher, err = shards.NewSourceSearcher(sources, searcherOpts); err != nil {
+			log.Fatal(err)
+		}
+		reloader, _ = searcher.(shards.ShardReloader)
+		quarantined, _ = searcher.(shards.QuarantineLister)
+	}
+
+	if alertWatcher != nil {
+		go alertWatcher.Run(context.Background(), searcher)
 	}
 
 	searcher = &loggedSearcher{
@@ -210,9 +319,11 @@ func main() {
 	}
 
 	s := &web.Server{
-		Searcher: searcher,
-		Top:      web.Top,
-		Version:  zoekt.Version,
+		Searcher:            searcher,
+		Top:                 web.Top,
+		Version:             zoekt.Version,
+		ExportMaxWallTime:   *exportMaxWallTime,
+		ExportMaxMatchCount: *exportMaxMatchCount,
 	}
 
 	if *templateDir != "" {
@@ -221,6 +332,32 @@ func main() {
 		}
 	}
 
+	if *aclFile != "" {
+		provider, err := authz.NewFileProvider(*aclFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		identify := []authz.IdentityFunc{authz.ClientCertIdentity}
+		if *identityHeader != "" {
+			identify = append(identify, authz.HeaderIdentity(*identityHeader))
+		}
+		s.Authorizer = &authz.Authorizer{
+			Identify: authz.FirstIdentity(identify...),
+			Provider: provider,
+		}
+	}
+
+	if *rateLimit > 0 || *costLimit > 0 {
+		s.Limiter = quota.NewLimiter(quota.Config{
+			RequestsPerSecond: *rateLimit,
+			RequestBurst:      *rateLimitBurst,
+			CostPerSecond:     *costLimit,
+			CostBurst:         *costLimitBurst,
+			RejectOverBudget:  *costLimitReject,
+		})
+	}
+
 	s.Print = *print
 	s.HTML = *html
 	s.RPC = *enableRPC
@@ -245,7 +382,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	debugserver.AddHandlers(handler, *enablePprof)
+	var debugPages []debugserver.DebugPage
+	if quarantined != nil {
+		debugPages = append(debugPages, debugserver.DebugPage{Href: "debug/quarantine", Text: "Quarantined shards", Description: "shards skipped by interactive searches because they were slow, with the queries which tripped them"})
+		handler.Handle("/debug/quarantine", quarantineHandler(quarantined))
+	}
+	debugserver.AddHandlers(handler, *enablePprof, debugPages...)
+
+	// The admin endpoints are unauthenticated, so they are served on their
+	// own listener, which should only be reachable by the indexers.
+	if reloader != nil && *adminListen != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/admin/reload", reloadHandler(reloader))
+		go func() {
+			sglog.Scoped("server", "").Info("starting admin server", sglog.Stringp("address", adminListen))
+			log.Fatal(http.ListenAndServe(*adminListen, adminMux))
+		}()
+	}
 
 	if *enableIndexserverProxy {
 		socket := filepath.Join(*index, "indexserver.sock")
@@ -284,6 +437,23 @@ func main() {
 		Handler: handler,
 	}
 
+	if *sslClientCA != "" {
+		pem, err := os.ReadFile(*sslClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("no certificates found in %s", *sslClientCA)
+		}
+		// Client certificates are optional so that the watchdog and callers
+		// identified by other means can still connect.
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+
 	go func() {
 		sglog.Scoped("server", "").Info("starting server", sglog.Stringp("address", listen))
 		var err error
@@ -304,6 +474,59 @@ func main() {
 	}
 }
 
+// reloadHandler serves POST requests of indexers with the file names of the
+// shards they wrote or deleted in the shard parameter, so they are reloaded
+// immediately.
+func reloadHandler(r shards.ShardReloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		names := req.URL.Query()["shard"]
+		if len(names) == 0 {
+			http.Error(w, "missing shard parameter", http.StatusBadRequest)
+			return
+		}
+		for _, name := range names {
+			if err := r.ReloadShard(name); errors.Is(err, shards.ErrReloadThrottled) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+var quarantineTmpl = template.Must(template.New("quarantine").Parse(`
+<html>
+	<head>
+		<title>Quarantined shards</title>
+	</head>
+	<body>
+		{{if not .}}No shards are quarantined.{{end}}
+		{{range .}}
+		<h3>{{.Name}}</h3>
+		p99 {{.P99}}, quarantined {{.Since.Format "2006-01-02T15:04:05Z07:00"}} until {{.Until.Format "2006-01-02T15:04:05Z07:00"}}
+		<table>
+			<tr><th>Time</th><th>Duration</th><th>Query</th></tr>
+			{{range .Queries}}<tr><td>{{.Time.Format "2006-01-02T15:04:05Z07:00"}}</td><td>{{.Duration}}</td><td><code>{{.Query}}</code></td></tr>{{end}}
+		</table>
+		{{end}}
+	</body>
+</html>
+`))
+
+func quarantineHandler(l shards.QuarantineLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = quarantineTmpl.Execute(w, l.QuarantinedShards())
+	})
+}
+
 // addProxyHandler adds a handler to "mux" that proxies all requests with base
 // /indexserver to "socket".
 func addProxyHandler(mux *http.ServeMux, socket string) {
@@ -512,6 +735,7 @@ func (s *loggedSearcher) log(ctx context.Context, q query.Q, opts *zoekt.SearchO
 		sglog.Int("stat.ShardsScanned", st.ShardsScanned),
 		sglog.Int("stat.ShardsSkipped", st.ShardsSkipped),
 		sglog.Int("stat.ShardsSkippedFilter", st.ShardsSkippedFilter),
+		sglog.Int("stat.ShardsTimedOut", st.ShardsTimedOut),
 		sglog.Int("stat.MatchCount", st.MatchCount),
 		sglog.Int("stat.NgramMatches", st.NgramMatches),
 		sglog.Duration("stat.Wait", st.Wait),
@@ -40,6 +40,13 @@ type contentProvider struct {
 	_nlBuf   []uint32
 	_sects   []DocumentSection
 	_sectBuf []DocumentSection
+	_refs    []DocumentSection
+	_refsBuf []DocumentSection
+	_refsOK  bool
+	_ids     []string
+	_idsOK   bool
+	_blame   []BlameHunk
+	_blameOK bool
 	fileSize uint32
 }
 
@@ -52,6 +59,12 @@ func (p *contentProvider) setDocument(docID uint32) {
 
 	p._nl = nil
 	p._sects = nil
+	p._refs = nil
+	p._refsOK = false
+	p._ids = nil
+	p._idsOK = false
+	p._blame = nil
+	p._blameOK = false
 	p._data = nil
 }
 
@@ -65,6 +78,74 @@ func (p *contentProvider) docSections() []DocumentSection {
 	return p._sects
 }
 
+// symbolID returns the ID of the i-th symbol of the document, if any.
+func (p *contentProvider) symbolID(i uint32) string {
+	if !p._idsOK {
+		p._ids, p.err = p.id.readSymbolIDs(p.idx)
+		p._idsOK = true
+	}
+	if int(i) < len(p._ids) {
+		return p._ids[i]
+	}
+	return ""
+}
+
+// symbolRefs returns the byte ranges of symbol references in the document.
+func (p *contentProvider) symbolRefs() []DocumentSection {
+	if !p._refsOK {
+		var sz uint32
+		p._refs, sz, p.err = p.id.readSymbolRefs(p.idx, p._refsBuf)
+		p.stats.ContentBytesLoaded += int64(sz)
+		p._refsBuf = p._refs
+		p._refsOK = true
+	}
+	return p._refs
+}
+
+// blame returns the blame hunks of the document, if any.
+func (p *contentProvider) blame() []BlameHunk {
+	if !p._blameOK {
+		p._blame, p.err = p.id.readBlame(p.idx)
+		p._blameOK = true
+	}
+	return p._blame
+}
+
+// fillBlame sets the blame of the content line matches.
+func (p *contentProvider) fillBlame(ms []LineMatch) {
+	hunks := p.blame()
+	if len(hunks) == 0 {
+		return
+	}
+	for i := range ms {
+		if !ms[i].FileName {
+			ms[i].Blame = blameAt(hunks, ms[i].LineNumber)
+		}
+	}
+}
+
+// fillChunkBlame sets the blame of each line of the content chunk matches.
+func (p *contentProvider) fillChunkBlame(ms []ChunkMatch) {
+	hunks := p.blame()
+	if len(hunks) == 0 {
+		return
+	}
+	for i := range ms {
+		m := &ms[i]
+		if m.FileName {
+			continue
+		}
+		n := bytes.Count(m.Content, []byte{'\n'})
+		if len(m.Content) == 0 || m.Content[len(m.Content)-1] != '\n' {
+			n++
+		}
+		m.Blame = make([]*Blame, n)
+		for j := range m.Blame {
+			m.Blame[j] = blameAt(hunks, int(m.ContentStart.LineNumber)+j)
+		}
+	}
+}
+
 func (p *contentProvider) newlines() newlines {
 	if p._nl == nil {
 		var sz uint32
@@ -273,7 +354,10 @@ func (p *contentProvider) fillContentMatches(ms []*candidateMatch, numContextLin
 				if fragment.SymbolInfo != nil {
 					sec := p.docSections()[m.symbolIdx]
 					fragment.SymbolInfo.Sym = string(data[sec.Start:sec.End])
+					fragment.SymbolInfo.ID = p.symbolID(m.symbolIdx)
 				}
+			} else if m.symbolRef {
+				fragment.SymbolInfo = p.symbolRefInfo(data, m)
 			}
 
 			finalMatch.LineFragments = append(finalMatch.LineFragments, fragment)
@@ -319,8 +403,14 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 				if si != nil {
 					sec := p.docSections()[cm.symbolIdx]
 					si.Sym = string(data[sec.Start:sec.End])
+					si.ID = p.symbolID(cm.symbolIdx)
 				}
 				symbolInfo[i] = si
+			} else if cm.symbolRef {
+				if symbolInfo == nil {
+					symbolInfo = make([]*Symbol, len(chunk.candidates))
+				}
+				symbolInfo[i] = p.symbolRefInfo(data, cm)
 			}
 		}
 
@@ -345,6 +435,13 @@ func (p *contentProvider) fillContentChunkMatches(ms []*candidateMatch, numConte
 	return chunkMatches
 }
 
+// symbolRefInfo returns the SymbolInfo of a match on a symbol reference. Sym
+// is the whole referencing identifier, not only the matched part.
+func (p *contentProvider) symbolRefInfo(data []byte, m *candidateMatch) *Symbol {
+	sec := p.symbolRefs()[m.symbolIdx]
+	return &Symbol{Sym: string(data[sec.Start:sec.End]), Ref: true}
+}
+
 type candidateChunk struct {
 	firstLine  uint32 // 1-based, inclusive
 	lastLine   uint32 // 1-based, inclusive
@@ -0,0 +1,251 @@
+package ctags
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafana/regexp"
+)
+
+// nativeParser extracts symbols in-process for the languages it supports and
+// hands all other files to a fallback parser.
+type nativeParser struct {
+	fallback Parser
+}
+
+// NewNativeParser returns a Parser which extracts symbols of Go, Java,
+// Python, TypeScript and C++ files without running an external process,
+// including the parent scope of methods, fields and nested definitions. Go
+// files are parsed with go/parser, the other languages with tree-sitter
+// grammars. Without cgo, only Go and Python files are parsed. Other files
+// are parsed by fallback, which may be nil to skip them.
+func NewNativeParser(fallback Parser) Parser {
+	return &nativeParser{fallback: fallback}
+}
+
+// NativeSupports returns true if the parser returned by NewNativeParser
+// extracts the symbols of the file called name itself, instead of passing
+// it to its fallback.
+func NativeSupports(name string) bool {
+	return filepath.Ext(name) == ".go" || treeSitterSupports(name)
+}
+
+func (p *nativeParser) Parse(name string, content []byte) ([]*Entry, error) {
+	if filepath.Ext(name) == ".go" {
+		return parseGo(name, content), nil
+	}
+	if entries, ok, err := parseTreeSitter(name, content); ok {
+		return entries, err
+	}
+	if p.fallback == nil {
+		return nil, nil
+	}
+	return p.fallback.Parse(name, content)
+}
+
+func (p *nativeParser) Close() {
+	if p.fallback != nil {
+		p.fallback.Close()
+	}
+}
+
+// parseGo returns the symbols of a Go file. The kinds follow universal-ctags.
+// Files with syntax errors yield the symbols of the partial syntax tree.
+func parseGo(name string, content []byte) []*Entry {
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, name, content, parser.SkipObjectResolution)
+	if f == nil {
+		return nil
+	}
+
+	var entries []*Entry
+	add := func(id *ast.Ident, kind, parent, parentKind string) {
+		if id == nil || id.Name == "_" {
+			return
+		}
+		entries = append(entries, &Entry{
+			Name:       id.Name,
+			Path:       name,
+			Line:       fset.Position(id.Pos()).Line,
+			Kind:       kind,
+			Language:   "Go",
+			Parent:     parent,
+			ParentKind: parentKind,
+		})
+	}
+
+	add(f.Name, "package", "", "")
+	pkg := f.Name.Name
+
+	// Methods can be declared before their receiver type, so collect the
+	// kinds of all types first.
+	typeKinds := map[string]string{}
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.TYPE {
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				typeKinds[ts.Name.Name] = goTypeKind(ts.Type)
+			}
+		}
+	}
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add(d.Name, "func", pkg, "package")
+				continue
+			}
+			recv := goReceiverName(d.Recv.List[0].Type)
+			kind := typeKinds[recv]
+			if kind == "" {
+				kind = "type"
+			}
+			add(d.Name, "func", recv, kind)
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					kind := typeKinds[s.Name.Name]
+					add(s.Name, kind, pkg, "package")
+					goTypeMembers(s, kind, add)
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, id := range s.Names {
+						add(id, kind, pkg, "package")
+					}
+				}
+			}
+		}
+	}
+	return entries
+}
+
+func goTypeKind(e ast.Expr) string {
+	switch e.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	}
+	return "type"
+}
+
+func goReceiverName(e ast.Expr) string {
+	for {
+		switch t := e.(type) {
+		case *ast.StarExpr:
+			e = t.X
+		case *ast.IndexExpr:
+			e = t.X
+		case *ast.IndexListExpr:
+			e = t.X
+		case *ast.ParenExpr:
+			e = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func goTypeMembers(s *ast.TypeSpec, kind string, add func(id *ast.Ident, kind, parent, parentKind string)) {
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			for _, id := range field.Names {
+				add(id, "member", s.Name.Name, kind)
+			}
+		}
+	case *ast.InterfaceType:
+		for _, m := range t.Methods.List {
+			for _, id := range m.Names {
+				add(id, "methodSpec", s.Name.Name, kind)
+			}
+		}
+	}
+}
+
+var (
+	pyDefRe    = regexp.MustCompile(`^(?:async\s+)?def\s+(\w+)`)
+	pyClassRe  = regexp.MustCompile(`^class\s+(\w+)`)
+	pyAssignRe = regexp.MustCompile(`^(\w+)\s*(?::[^=]*)?=[^=]`)
+)
+
+// parsePython returns the classes, functions and module level variables of a
+// Python file. It is used if tree-sitter isn't available. Scopes are tracked
+// by indentation, so definitions inside multi-line strings are reported as
+// well. Lines longer than 1 MiB fail the
+// parse.
+func parsePython(name string, content []byte) ([]*Entry, error) {
+	type scope struct {
+		indent int
+		name   string
+		kind   string
+	}
+	var (
+		entries []*Entry
+		stack   []scope
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		for len(stack) > 0 && indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		var parent, parentKind string
+		if len(stack) > 0 {
+			parent, parentKind = stack[len(stack)-1].name, stack[len(stack)-1].kind
+		}
+
+		var symName, kind string
+		if m := pyClassRe.FindStringSubmatch(trimmed); m != nil {
+			symName, kind = m[1], "class"
+		} else if m := pyDefRe.FindStringSubmatch(trimmed); m != nil {
+			symName, kind = m[1], "function"
+			if parentKind == "class" {
+				kind = "member"
+			}
+		} else if m := pyAssignRe.FindStringSubmatch(trimmed); m != nil && len(stack) == 0 && indent == 0 {
+			symName, kind = m[1], "variable"
+		} else {
+			continue
+		}
+
+		entries = append(entries, &Entry{
+			Name:       symName,
+			Path:       name,
+			Line:       lineNum,
+			Kind:       kind,
+			Language:   "Python",
+			Parent:     parent,
+			ParentKind: parentKind,
+		})
+		if kind != "variable" {
+			stack = append(stack, scope{indent: indent, name: symName, kind: kind})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return entries, nil
+}
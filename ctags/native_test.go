@@ -0,0 +1,137 @@
+package ctags
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// summarize formats entries as "line kind name parentKind:parent".
+func summarize(entries []*Entry) []string {
+	var out []string
+	for _, e := range entries {
+		s := fmt.Sprintf("%d %s %s", e.Line, e.Kind, e.Name)
+		if e.Parent != "" {
+			s += fmt.Sprintf(" %s:%s", e.ParentKind, e.Parent)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestNativeParserGo(t *testing.T) {
+	src := `package store
+
+const maxSize = 10
+
+type (
+	Store struct {
+		mu, other int
+		Name      string
+	}
+	Getter interface {
+		Get(key string) string
+	}
+	ID int
+)
+
+func (s *Store) Get(key string) string { return "" }
+
+func (i ID) String() string { return "" }
+
+func New() *Store { return nil }
+`
+	entries, err := NewNativeParser(nil).Parse("store.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1 package store",
+		"3 const maxSize package:store",
+		"6 struct Store package:store",
+		"7 member mu struct:Store",
+		"7 member other struct:Store",
+		"8 member Name struct:Store",
+		"10 interface Getter package:store",
+		"11 methodSpec Get interface:Getter",
+		"13 type ID package:store",
+		"16 func Get struct:Store",
+		"18 func String type:ID",
+		"20 func New package:store",
+	}
+	if diff := cmp.Diff(want, summarize(entries)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParsePython(t *testing.T) {
+	src := `import os
+
+DEFAULT = 1
+
+class Shape:
+    sides = 0
+
+    def area(self):
+        def helper():
+            pass
+        return helper()
+
+    # comment at lower indent
+async def main():
+    pass
+`
+	entries, err := parsePython("shape.py", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"3 variable DEFAULT",
+		"5 class Shape",
+		"8 member area class:Shape",
+		"9 function helper member:area",
+		"14 function main",
+	}
+	if diff := cmp.Diff(want, summarize(entries)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParsePythonLongLine(t *testing.T) {
+	src := "def f():\n    x = '" + strings.Repeat("x", 2<<20) + "'\n\ndef g():\n    pass\n"
+	if _, err := parsePython("long.py", []byte(src)); err == nil {
+		t.Error("got no error for a line longer than the buffer")
+	}
+}
+
+type fakeParser struct {
+	parsed []string
+	closed bool
+}
+
+func (p *fakeParser) Parse(name string, content []byte) ([]*Entry, error) {
+	p.parsed = append(p.parsed, name)
+	return nil, nil
+}
+
+func (p *fakeParser) Close() { p.closed = true }
+
+func TestNativeParserFallback(t *testing.T) {
+	fallback := &fakeParser{}
+	p := NewNativeParser(fallback)
+	for _, name := range []string{"a.go", "b.rb", "c.py", "d.rs"} {
+		if _, err := p.Parse(name, []byte("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+
+	if diff := cmp.Diff([]string{"b.rb", "d.rs"}, fallback.parsed); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if !fallback.closed {
+		t.Error("fallback parser not closed")
+	}
+}
@@ -0,0 +1,412 @@
+//go:build cgo
+// +build cgo
+
+package ctags
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/cpp"
+	"github.com/smacker/go-tree-sitter/java"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+)
+
+// A treeSitterSymbol is a symbol defined by a node of a syntax tree.
+type treeSitterSymbol struct {
+	name string
+	// node is the node of the name, which determines the line.
+	node *sitter.Node
+	kind string
+
+	// scope makes the symbol the parent of the symbols nested in the node
+	// defining it.
+	scope bool
+
+	// parent and parentKind, if set, override the enclosing scope, eg. for
+	// C++ methods defined outside of their class.
+	parent, parentKind string
+}
+
+// A treeSitterLanguage extracts the symbols of a language from its
+// tree-sitter syntax tree. The kinds follow universal-ctags.
+type treeSitterLanguage struct {
+	name string
+	lang *sitter.Language
+
+	// symbols returns the symbols defined by n. parentKind is the kind of
+	// the enclosing scope, and kinds maps the names of the scopes found so
+	// far to their kinds.
+	symbols func(n *sitter.Node, content []byte, parentKind string, kinds map[string]string) []treeSitterSymbol
+}
+
+var (
+	treeSitterJava       = &treeSitterLanguage{name: "Java", lang: java.GetLanguage(), symbols: javaSymbols}
+	treeSitterPython     = &treeSitterLanguage{name: "Python", lang: python.GetLanguage(), symbols: pythonSymbols}
+	treeSitterTypeScript = &treeSitterLanguage{name: "TypeScript", lang: typescript.GetLanguage(), symbols: typeScriptSymbols}
+	treeSitterTSX        = &treeSitterLanguage{name: "TypeScript", lang: tsx.GetLanguage(), symbols: typeScriptSymbols}
+	treeSitterCPP        = &treeSitterLanguage{name: "C++", lang: cpp.GetLanguage(), symbols: cppSymbols}
+)
+
+// treeSitterLanguages maps file extensions to their language. Like
+// universal-ctags, header files are parsed as C++.
+var treeSitterLanguages = map[string]*treeSitterLanguage{
+	".java": treeSitterJava,
+	".py":   treeSitterPython,
+	".pyi":  treeSitterPython,
+	".ts":   treeSitterTypeScript,
+	".mts":  treeSitterTypeScript,
+	".cts":  treeSitterTypeScript,
+	".tsx":  treeSitterTSX,
+	".cc":   treeSitterCPP,
+	".cpp":  treeSitterCPP,
+	".cxx":  treeSitterCPP,
+	".c++":  treeSitterCPP,
+	".h":    treeSitterCPP,
+	".hh":   treeSitterCPP,
+	".hpp":  treeSitterCPP,
+	".hxx":  treeSitterCPP,
+	".h++":  treeSitterCPP,
+}
+
+func treeSitterSupports(name string) bool {
+	_, ok := treeSitterLanguages[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// parseTreeSitter returns the symbols of Java, Python, TypeScript and C++
+// files. It returns false for other files.
+func parseTreeSitter(name string, content []byte) ([]*Entry, bool, error) {
+	l, ok := treeSitterLanguages[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return nil, false, nil
+	}
+	entries, err := l.parse(name, content)
+	return entries, true, err
+}
+
+// parse returns the symbols of a file. Files with syntax errors yield the
+// symbols of the nodes tree-sitter could recover.
+func (l *treeSitterLanguage) parse(name string, content []byte) ([]*Entry, error) {
+	parser := sitter.NewParser()
+	defer parser.Close()
+	parser.SetLanguage(l.lang)
+
+	tree, err := parser.ParseCtx(context.Background(), nil, content)
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Close()
+
+	var (
+		entries []*Entry
+		kinds   = map[string]string{}
+	)
+	var walk func(n *sitter.Node, parent, parentKind string)
+	walk = func(n *sitter.Node, parent, parentKind string) {
+		childParent, childParentKind := parent, parentKind
+		for _, s := range l.symbols(n, content, parentKind, kinds) {
+			if s.name == "" {
+				continue
+			}
+			e := &Entry{
+				Name:       s.name,
+				Path:       name,
+				Line:       int(s.node.StartPoint().Row) + 1,
+				Kind:       s.kind,
+				Language:   l.name,
+				Parent:     parent,
+				ParentKind: parentKind,
+			}
+			if s.parent != "" {
+				e.Parent, e.ParentKind = s.parent, s.parentKind
+			}
+			entries = append(entries, e)
+			if s.scope {
+				childParent, childParentKind = s.name, s.kind
+				kinds[s.name] = s.kind
+			}
+		}
+
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			walk(n.NamedChild(i), childParent, childParentKind)
+		}
+	}
+	walk(tree.RootNode(), "", "")
+	return entries, nil
+}
+
+// fieldChildren returns the children of n in field. Unlike
+// n.ChildByFieldName, it returns all of them, eg. each declarator of
+// "int a, b;".
+func fieldChildren(n *sitter.Node, field string) []*sitter.Node {
+	c := sitter.NewTreeCursor(n)
+	defer c.Close()
+
+	var children []*sitter.Node
+	for ok := c.GoToFirstChild(); ok; ok = c.GoToNextSibling() {
+		if c.CurrentFieldName() == field {
+			children = append(children, c.CurrentNode())
+		}
+	}
+	return children
+}
+
+// named returns the symbol named by the name field of n.
+func named(n *sitter.Node, content []byte, kind string, scope bool) []treeSitterSymbol {
+	name := n.ChildByFieldName("name")
+	if name == nil {
+		return nil
+	}
+	return []treeSitterSymbol{{name: name.Content(content), node: name, kind: kind, scope: scope}}
+}
+
+func javaSymbols(n *sitter.Node, content []byte, parentKind string, kinds map[string]string) []treeSitterSymbol {
+	switch n.Type() {
+	case "package_declaration":
+		if n.NamedChildCount() == 0 {
+			return nil
+		}
+		name := n.NamedChild(0)
+		return []treeSitterSymbol{{name: name.Content(content), node: name, kind: "package"}}
+	case "class_declaration", "record_declaration":
+		return named(n, content, "class", true)
+	case "interface_declaration":
+		return named(n, content, "interface", true)
+	case "enum_declaration":
+		return named(n, content, "enum", true)
+	case "annotation_type_declaration":
+		return named(n, content, "annotation", true)
+	case "method_declaration", "constructor_declaration":
+		return named(n, content, "method", true)
+	case "enum_constant":
+		return named(n, content, "enumConstant", false)
+	case "field_declaration", "constant_declaration":
+		var syms []treeSitterSymbol
+		for _, d := range fieldChildren(n, "declarator") {
+			syms = append(syms, named(d, content, "field", false)...)
+		}
+		return syms
+	}
+	return nil
+}
+
+func pythonSymbols(n *sitter.Node, content []byte, parentKind string, kinds map[string]string) []treeSitterSymbol {
+	switch n.Type() {
+	case "class_definition":
+		return named(n, content, "class", true)
+	case "function_definition":
+		if parentKind == "class" {
+			return named(n, content, "member", true)
+		}
+		return named(n, content, "function", true)
+	case "expression_statement":
+		// Variables of modules and classes. Assignments in functions
+		// are local variables, which aren't reported.
+		if parentKind != "" && parentKind != "class" {
+			return nil
+		}
+		var syms []treeSitterSymbol
+		for a := n.NamedChild(0); a != nil && a.Type() == "assignment"; a = a.ChildByFieldName("right") {
+			left := a.ChildByFieldName("left")
+			if left == nil {
+				break
+			}
+			ids := []*sitter.Node{left}
+			if t := left.Type(); t == "pattern_list" || t == "tuple_pattern" {
+				ids = nil
+				for i := 0; i < int(left.NamedChildCount()); i++ {
+					ids = append(ids, left.NamedChild(i))
+				}
+			}
+			for _, id := range ids {
+				if id.Type() == "identifier" {
+					syms = append(syms, treeSitterSymbol{name: id.Content(content), node: id, kind: "variable"})
+				}
+			}
+		}
+		return syms
+	}
+	return nil
+}
+
+func typeScriptSymbols(n *sitter.Node, content []byte, parentKind string, kinds map[string]string) []treeSitterSymbol {
+	switch n.Type() {
+	case "class_declaration", "abstract_class_declaration":
+		return named(n, content, "class", true)
+	case "interface_declaration":
+		return named(n, content, "interface", true)
+	case "enum_declaration":
+		return named(n, content, "enum", true)
+	case "enum_body":
+		var syms []treeSitterSymbol
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			c := n.NamedChild(i)
+			if c.Type() == "enum_assignment" {
+				c = c.ChildByFieldName("name")
+			}
+			if c != nil && c.Type() == "property_identifier" {
+				syms = append(syms, treeSitterSymbol{name: c.Content(content), node: c, kind: "enumerator"})
+			}
+		}
+		return syms
+	case "function_declaration", "generator_function_declaration", "function_signature":
+		return named(n, content, "function", true)
+	case "method_definition", "method_signature", "abstract_method_signature":
+		return named(n, content, "method", true)
+	case "public_field_definition", "property_signature":
+		return named(n, content, "property", false)
+	case "type_alias_declaration":
+		return named(n, content, "alias", false)
+	case "internal_module", "module":
+		syms := named(n, content, "namespace", true)
+		for i := range syms {
+			syms[i].name = strings.Trim(syms[i].name, "\"'`")
+		}
+		return syms
+	case "lexical_declaration", "variable_declaration":
+		// Variables of modules and namespaces. Variables of functions are
+		// local variables, which aren't reported.
+		if parentKind != "" && parentKind != "namespace" {
+			return nil
+		}
+		kind := "variable"
+		if n.ChildCount() > 0 && n.Child(0).Type() == "const" {
+			kind = "constant"
+		}
+		var syms []treeSitterSymbol
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			d := n.NamedChild(i)
+			name := d.ChildByFieldName("name")
+			if d.Type() != "variable_declarator" || name == nil || name.Type() != "identifier" {
+				continue
+			}
+			s := treeSitterSymbol{name: name.Content(content), node: name, kind: kind}
+			if v := d.ChildByFieldName("value"); v != nil && (v.Type() == "arrow_function" || v.Type() == "function") {
+				s.kind, s.scope = "function", true
+			}
+			syms = append(syms, s)
+		}
+		return syms
+	}
+	return nil
+}
+
+func cppSymbols(n *sitter.Node, content []byte, parentKind string, kinds map[string]string) []treeSitterSymbol {
+	switch n.Type() {
+	case "namespace_definition":
+		return named(n, content, "namespace", true)
+	case "class_specifier", "struct_specifier", "union_specifier", "enum_specifier":
+		// Without a body, eg. "struct S *p;", the type is only referred to.
+		if n.ChildByFieldName("body") == nil {
+			return nil
+		}
+		return named(n, content, strings.TrimSuffix(n.Type(), "_specifier"), true)
+	case "enumerator":
+		return named(n, content, "enumerator", false)
+	case "function_definition":
+		d, _ := cppDeclaratorName(n.ChildByFieldName("declarator"))
+		if s, ok := cppSymbol(d, content, "function", kinds); ok {
+			s.scope = true
+			return []treeSitterSymbol{s}
+		}
+	case "field_declaration", "declaration":
+		// Declarations of functions are prototypes. Other declarations
+		// are reported if they declare members or global variables.
+		kind := "member"
+		if n.Type() == "declaration" {
+			if parentKind != "" && parentKind != "namespace" && !cppIsType(parentKind) {
+				return nil
+			}
+			kind = "variable"
+		}
+		var syms []treeSitterSymbol
+		for _, d := range fieldChildren(n, "declarator") {
+			name, isFunc := cppDeclaratorName(d)
+			k := kind
+			if isFunc {
+				k = "prototype"
+			}
+			if s, ok := cppSymbol(name, content, k, kinds); ok {
+				syms = append(syms, s)
+			}
+		}
+		return syms
+	case "type_definition":
+		var syms []treeSitterSymbol
+		for _, d := range fieldChildren(n, "declarator") {
+			name, _ := cppDeclaratorName(d)
+			if s, ok := cppSymbol(name, content, "typedef", kinds); ok {
+				syms = append(syms, s)
+			}
+		}
+		return syms
+	case "alias_declaration":
+		return named(n, content, "typedef", false)
+	}
+	return nil
+}
+
+// cppDeclaratorName returns the name declared by the declarator d, and
+// whether it declares a function.
+func cppDeclaratorName(d *sitter.Node) (*sitter.Node, bool) {
+	isFunc := false
+	for d != nil {
+		switch d.Type() {
+		case "function_declarator":
+			isFunc = true
+			d = d.ChildByFieldName("declarator")
+		case "pointer_declarator", "reference_declarator", "array_declarator", "init_declarator", "parenthesized_declarator", "attributed_declarator":
+			next := d.ChildByFieldName("declarator")
+			if next == nil && d.NamedChildCount() > 0 {
+				next = d.NamedChild(int(d.NamedChildCount()) - 1)
+			}
+			d = next
+		default:
+			return d, isFunc
+		}
+	}
+	return nil, isFunc
+}
+
+// cppSymbol returns the symbol called by the name n. The parent of
+// qualified names, eg. "Shape::area", is their scope.
+func cppSymbol(n *sitter.Node, content []byte, kind string, kinds map[string]string) (treeSitterSymbol, bool) {
+	if n == nil {
+		return treeSitterSymbol{}, false
+	}
+	var scope string
+	for {
+		switch n.Type() {
+		case "qualified_identifier":
+			if s := n.ChildByFieldName("scope"); s != nil {
+				scope = s.Content(content)
+			}
+			n = n.ChildByFieldName("name")
+		case "template_function", "template_type":
+			n = n.ChildByFieldName("name")
+		case "identifier", "field_identifier", "type_identifier", "destructor_name", "operator_name":
+			s := treeSitterSymbol{name: n.Content(content), node: n, kind: kind}
+			if scope != "" {
+				s.parent, s.parentKind = scope, "namespace"
+				if k, ok := kinds[scope[strings.LastIndex(scope, ":")+1:]]; ok {
+					s.parentKind = k
+				}
+			}
+			return s, true
+		default:
+			return treeSitterSymbol{}, false
+		}
+		if n == nil {
+			return treeSitterSymbol{}, false
+		}
+	}
+}
+
+func cppIsType(kind string) bool {
+	return kind == "class" || kind == "struct" || kind == "union"
+}
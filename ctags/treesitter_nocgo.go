@@ -0,0 +1,20 @@
+//go:build !cgo
+// +build !cgo
+
+package ctags
+
+import "path/filepath"
+
+func treeSitterSupports(name string) bool {
+	return filepath.Ext(name) == ".py"
+}
+
+// parseTreeSitter returns false for all files but Python files, since the
+// tree-sitter grammars need cgo. Python files are parsed line by line.
+func parseTreeSitter(name string, content []byte) ([]*Entry, bool, error) {
+	if !treeSitterSupports(name) {
+		return nil, false, nil
+	}
+	entries, err := parsePython(name, content)
+	return entries, true, err
+}
@@ -0,0 +1,161 @@
+//go:build cgo
+// +build cgo
+
+package ctags
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNativeParserTreeSitter(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want []string
+	}{{
+		name: "Shape.java",
+		src: `package com.example.shapes;
+
+public class Shape extends Base {
+  private int sides, corners;
+  public Shape(int s) {}
+  double area() { return 0; }
+  enum Color { RED, GREEN }
+  interface Visitor { void visit(Shape s); }
+}
+`,
+		want: []string{
+			"1 package com.example.shapes",
+			"3 class Shape",
+			"4 field sides class:Shape",
+			"4 field corners class:Shape",
+			"5 method Shape class:Shape",
+			"6 method area class:Shape",
+			"7 enum Color class:Shape",
+			"7 enumConstant RED enum:Color",
+			"7 enumConstant GREEN enum:Color",
+			"8 interface Visitor class:Shape",
+			"8 method visit interface:Visitor",
+		},
+	}, {
+		name: "shape.py",
+		src: `import os
+
+DEFAULT: int = 1
+A = B = 2
+
+class Shape:
+    sides = 0
+
+    @property
+    def area(self):
+        local = 1
+        def helper():
+            pass
+        return helper()
+
+async def main():
+    pass
+`,
+		want: []string{
+			"3 variable DEFAULT",
+			"4 variable A",
+			"4 variable B",
+			"6 class Shape",
+			"7 variable sides class:Shape",
+			"10 member area class:Shape",
+			"12 function helper member:area",
+			"16 function main",
+		},
+	}, {
+		name: "shape.ts",
+		src: `export const MAX = 1;
+let count = 0;
+
+export class Shape {
+  sides: number = 0;
+  area(): number {
+    const local = 1;
+    return local;
+  }
+}
+
+interface Visitor {
+  visit(s: Shape): void;
+}
+
+enum Color { Red, Green = 2 }
+type Name = string;
+const make = () => new Shape();
+
+namespace NS {
+  export function f() {}
+}
+`,
+		want: []string{
+			"1 constant MAX",
+			"2 variable count",
+			"4 class Shape",
+			"5 property sides class:Shape",
+			"6 method area class:Shape",
+			"12 interface Visitor",
+			"13 method visit interface:Visitor",
+			"16 enum Color",
+			"16 enumerator Red enum:Color",
+			"16 enumerator Green enum:Color",
+			"17 alias Name",
+			"18 function make",
+			"20 namespace NS",
+			"21 function f namespace:NS",
+		},
+	}, {
+		name: "shape.cc",
+		src: `namespace geo {
+
+class Shape {
+ public:
+  Shape();
+  virtual double area() const;
+  int sides;
+};
+
+double Shape::area() const {
+  int local = 0;
+  return local;
+}
+
+enum Color { RED };
+typedef int size;
+int count = 0;
+
+}  // namespace geo
+`,
+		want: []string{
+			"1 namespace geo",
+			"3 class Shape namespace:geo",
+			"5 prototype Shape class:Shape",
+			"6 prototype area class:Shape",
+			"7 member sides class:Shape",
+			"10 function area class:Shape",
+			"15 enum Color namespace:geo",
+			"15 enumerator RED enum:Color",
+			"16 typedef size namespace:geo",
+			"17 variable count namespace:geo",
+		},
+	}}
+
+	p := NewNativeParser(nil)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := p.Parse(tc.name, []byte(tc.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, summarize(entries)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Package dirindex indexes plain directories. Incremental runs record the
+// size, modification time and hash of each file in the shard metadata, and
+// later runs only index the files which changed since as a delta build.
+package dirindex
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+)
+
+// Options controls how a directory is indexed.
+type Options struct {
+	// Dir is the directory to index.
+	Dir string
+
+	// IgnoreDirs holds the names of directories which are skipped, eg.
+	// ".git".
+	IgnoreDirs []string
+
+	// Incremental only indexes the files which were added, changed or
+	// removed since the last run, as a delta build on top of the existing
+	// shards. All files are indexed if the existing shards have no file
+	// states or were built with other options.
+	Incremental bool
+
+	// DeltaShardNumberFallbackThreshold is the number of existing shards
+	// above which an incremental run indexes all files again, to compact
+	// the delta shards. If 0, there is no limit.
+	DeltaShardNumberFallbackThreshold uint64
+
+	// Indexing options.
+	BuildOptions build.Options
+}
+
+// file is a regular file found in the directory.
+type file struct {
+	// name is the slash separated path relative to the directory.
+	name  string
+	path  string
+	state zoekt.FileState
+}
+
+// IndexDir indexes the directory as specified by the options.
+func IndexDir(opts Options) error {
+	opts.BuildOptions.SetDefaults()
+	if opts.Dir == "" {
+		return fmt.Errorf("dirindex: must set Dir")
+	}
+	dir, err := filepath.Abs(filepath.Clean(opts.Dir))
+	if err != nil {
+		return err
+	}
+
+	bo := opts.BuildOptions
+	bo.RepositoryDescription.Source = dir
+
+	files, err := walk(dir, opts.IgnoreDirs)
+	if err != nil {
+		return err
+	}
+
+	var old map[string]zoekt.FileState
+	bo.IsDelta = false
+	if opts.Incremental {
+		if old, err = deltaBase(opts, &bo); err != nil {
+			log.Printf("dirindex: indexing all files of %s: %s", dir, err)
+		} else {
+			bo.IsDelta = true
+		}
+	}
+
+	var builder *build.Builder
+	getBuilder := func() (*build.Builder, error) {
+		if builder == nil {
+			builder, err = build.NewBuilder(bo)
+			if err != nil {
+				return nil, fmt.Errorf("build.NewBuilder: %w", err)
+			}
+		}
+		return builder, nil
+	}
+	defer func() {
+		// we don't need to check error, since we either already have an
+		// error, or we returning the first call to builder.Finish.
+		if builder != nil {
+			builder.Finish() // nolint:errcheck
+		}
+	}()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Incremental runs assume that files whose size and modification time
+	// are unchanged are unchanged. The others are hashed, to skip files
+	// which were only touched.
+	var states map[string]zoekt.FileState
+	if opts.Incremental {
+		states = make(map[string]zoekt.FileState, len(files))
+	}
+	for _, name := range names {
+		f := files[name]
+		prev, existed := old[name]
+		if existed && prev.Size == f.state.Size && prev.ModTime.Equal(f.state.ModTime) {
+			states[name] = prev
+			continue
+		}
+
+		var content []byte
+		tooLarge := f.state.Size > int64(bo.SizeMax) && !bo.IgnoreSizeMax(name)
+		if !tooLarge {
+			if content, err = os.ReadFile(f.path); err != nil {
+				return err
+			}
+			if opts.Incremental {
+				f.state.Hash = fmt.Sprintf("%x", sha1.Sum(content))
+			}
+		}
+		if opts.Incremental {
+			states[name] = f.state
+		}
+		if existed && prev.Hash != "" && prev.Hash == f.state.Hash {
+			continue
+		}
+
+		b, err := getBuilder()
+		if err != nil {
+			return err
+		}
+		if existed {
+			b.MarkFileAsChangedOrRemoved(name)
+		}
+		if tooLarge {
+			err = b.Add(zoekt.Document{
+				Name:       name,
+				SkipReason: fmt.Sprintf("document size %d larger than limit %d", f.state.Size, bo.SizeMax),
+			})
+		} else {
+			err = b.AddFile(name, content)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range old {
+		if _, ok := files[name]; ok {
+			continue
+		}
+		b, err := getBuilder()
+		if err != nil {
+			return err
+		}
+		b.MarkFileAsChangedOrRemoved(name)
+	}
+
+	if builder == nil {
+		if bo.IsDelta {
+			return writeFileStates(&bo, states)
+		}
+		// A full build of an empty directory still replaces the shards.
+		if _, err := getBuilder(); err != nil {
+			return err
+		}
+	}
+
+	if err := builder.Finish(); err != nil {
+		return err
+	}
+	if !opts.Incremental {
+		return nil
+	}
+	return writeFileStates(&bo, states)
+}
+
+// walk returns the regular files below dir by name.
+func walk(dir string, ignoreDirs []string) (map[string]file, error) {
+	ignore := map[string]bool{}
+	for _, d := range ignoreDirs {
+		ignore[d] = true
+	}
+
+	files := map[string]file{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && ignore[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		files[name] = file{
+			name: name,
+			path: path,
+			state: zoekt.FileState{
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			},
+		}
+		return nil
+	})
+	return files, err
+}
+
+// deltaBase returns the file states of the existing shards, or an error if a
+// delta build isn't possible.
+func deltaBase(opts Options, bo *build.Options) (map[string]zoekt.FileState, error) {
+	repo, ok, err := bo.FindRepositoryMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("no existing shards found")
+	}
+	if repo.FileStates == nil {
+		return nil, errors.New("existing shards have no file states")
+	}
+	if repo.IndexOptions != bo.GetHash() {
+		return nil, fmt.Errorf("index options changed, new index options: %+v", bo.HashOptions())
+	}
+	if !build.BranchNamesEqual(repo.Branches, bo.RepositoryDescription.Branches) {
+		return nil, errors.New("branches changed")
+	}
+	if t := opts.DeltaShardNumberFallbackThreshold; t > 0 {
+		if n := len(bo.FindAllShards()); uint64(n) > t {
+			return nil, fmt.Errorf("number of existing shards (%d) > requested shard threshold (%d)", n, t)
+		}
+	}
+	return repo.FileStates, nil
+}
+
+// writeFileStates stores states in the metadata of the first shard, which
+// is where FindRepositoryMetadata reads it from.
+func writeFileStates(bo *build.Options, states map[string]zoekt.FileState) error {
+	shards := bo.FindAllShards()
+	if len(shards) == 0 {
+		return nil
+	}
+	repos, _, err := zoekt.ReadMetadataPathAlive(shards[0])
+	if err != nil {
+		return err
+	}
+	if len(repos) != 1 {
+		return fmt.Errorf("shard %q has %d repositories, want 1", shards[0], len(repos))
+	}
+
+	repo := repos[0]
+	repo.FileStates = states
+	tmp, final, err := zoekt.JsonMarshalRepoMetaTemp(shards[0], repo)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, final)
+}
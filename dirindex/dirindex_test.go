@@ -0,0 +1,196 @@
+package dirindex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func search(t *testing.T, indexDir, q string) []string {
+	t.Helper()
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	parsed, err := query.Parse(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := searcher.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range res.Files {
+		names = append(names, f.FileName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestIndexDirIncremental(t *testing.T) {
+	dir := t.TempDir()
+	indexDir := t.TempDir()
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeFile(t, filepath.Join(dir, "keep.go"), "package keep // needle", t0)
+	writeFile(t, filepath.Join(dir, "change.go"), "package change // needle", t0)
+	writeFile(t, filepath.Join(dir, "touch.go"), "package touch // needle", t0)
+	writeFile(t, filepath.Join(dir, "sub", "remove.go"), "package remove // needle", t0)
+	writeFile(t, filepath.Join(dir, ".git", "config"), "needle", t0)
+
+	opts := Options{
+		Dir:         dir,
+		IgnoreDirs:  []string{".git"},
+		Incremental: true,
+		BuildOptions: build.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "repo",
+			},
+		},
+	}
+	if err := IndexDir(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	bo := opts.BuildOptions
+	bo.SetDefaults()
+	if shards := bo.FindAllShards(); len(shards) != 1 {
+		t.Fatalf("got shards %v, want 1", shards)
+	}
+	repo, _, err := bo.FindRepositoryMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.FileStates) != 4 || repo.FileStates["sub/remove.go"].Hash == "" {
+		t.Fatalf("got file states %v", repo.FileStates)
+	}
+
+	// Nothing changed.
+	if err := IndexDir(opts); err != nil {
+		t.Fatal(err)
+	}
+	if shards := bo.FindAllShards(); len(shards) != 1 {
+		t.Fatalf("got shards %v, want no delta shard", shards)
+	}
+
+	t1 := t0.Add(time.Hour)
+	writeFile(t, filepath.Join(dir, "change.go"), "package change // haystack", t1)
+	writeFile(t, filepath.Join(dir, "touch.go"), "package touch // needle", t1)
+	writeFile(t, filepath.Join(dir, "add.go"), "package add // needle", t1)
+	if err := os.Remove(filepath.Join(dir, "sub", "remove.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := IndexDir(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := bo.FindAllShards()
+	if len(shards) != 2 {
+		t.Fatalf("got shards %v, want a delta shard", shards)
+	}
+	// The changed and removed files are tombstoned in the old shard.
+	repos, _, err := zoekt.ReadMetadataPathAlive(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := repos[0].FileTombstones; len(got) != 2 {
+		t.Errorf("got tombstones %v, want change.go and sub/remove.go", got)
+	}
+
+	if got, want := search(t, indexDir, "needle"), []string{"add.go", "keep.go", "touch.go"}; !equal(got, want) {
+		t.Errorf("needle: got %v, want %v", got, want)
+	}
+	if got, want := search(t, indexDir, "haystack"), []string{"change.go"}; !equal(got, want) {
+		t.Errorf("haystack: got %v, want %v", got, want)
+	}
+
+	repo, _, err = bo.FindRepositoryMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := repo.FileStates["touch.go"]; !st.ModTime.Equal(t1) {
+		t.Errorf("got touch.go state %v, want mtime %v", st, t1)
+	}
+	if _, ok := repo.FileStates["sub/remove.go"]; ok || len(repo.FileStates) != 4 {
+		t.Errorf("got file states %v", repo.FileStates)
+	}
+}
+
+func TestIndexDirFallback(t *testing.T) {
+	dir := t.TempDir()
+	indexDir := t.TempDir()
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.go"), "package a", t0)
+
+	opts := Options{
+		Dir: dir,
+		BuildOptions: build.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "repo",
+			},
+		},
+	}
+	if err := IndexDir(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Runs which aren't incremental don't record file states.
+	if metas, _ := filepath.Glob(filepath.Join(indexDir, "*.meta")); len(metas) != 0 {
+		t.Errorf("got metadata files %v, want none", metas)
+	}
+
+	// Changing the index options requires a full build.
+	opts.Incremental = true
+	opts.BuildOptions.LargeFiles = []string{"*.go"}
+	writeFile(t, filepath.Join(dir, "b.go"), "package b", t0)
+	if err := IndexDir(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	bo := opts.BuildOptions
+	bo.SetDefaults()
+	if shards := bo.FindAllShards(); len(shards) != 1 {
+		t.Fatalf("got shards %v, want 1", shards)
+	}
+	if got, want := search(t, indexDir, "package"), []string{"a.go", "b.go"}; !equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
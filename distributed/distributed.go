@@ -0,0 +1,443 @@
+// Package distributed provides a zoekt.Streamer which fans queries out to
+// several zoekt-webserver backends, each holding a part of the corpus, and
+// merges their results.
+//
+// Backends which fail or exceed their timeout don't fail the search as long
+// as one backend succeeds. Each failed backend is reported as a crash in
+// zoekt.Stats.Crashes and zoekt.RepoList.Crashes. Repositories found on
+// several backends, eg. replicas, are only returned from one of them.
+//
+// If the repositories are placed on the backends with package placement,
+// queries restricted to a set of repositories are only sent to the first
+// owner of each repository. If that backend fails, the repositories it was
+// searched for are searched on their next owners.
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/placement"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/rpc"
+	"github.com/sourcegraph/zoekt/stream"
+)
+
+// Backend is a searcher the queries are fanned out to.
+type Backend struct {
+	// Name identifies the backend in errors and logs.
+	Name string
+
+	zoekt.Streamer
+}
+
+// NewBackend returns a backend for the zoekt-webserver at address
+// (host:port), which must serve both the RPC and the streaming API. It uses
+// RPC for List and streaming for Search and StreamSearch.
+func NewBackend(address string) Backend {
+	return Backend{
+		Name: address,
+		Streamer: &webserver{
+			Searcher: rpc.Client(address),
+			Client:   stream.NewClient("http://"+address, nil),
+		},
+	}
+}
+
+type webserver struct {
+	zoekt.Searcher
+	*stream.Client
+}
+
+func (w *webserver) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return collect(ctx, q, opts, w)
+}
+
+// Options controls the fan out.
+type Options struct {
+	// Timeout bounds the duration of the search of each backend. If 0,
+	// backends are only bound by the context of the search.
+	Timeout time.Duration
+
+	// Placement, if set, places the repositories on the backends, which
+	// are identified by their names.
+	Placement *placement.Membership
+
+	// PlacementByID routes queries by the repository IDs of
+	// query.BranchesRepos, as placed by zoekt-sourcegraph-indexserver.
+	// Otherwise queries are routed by the repository names of
+	// query.RepoSet, as placed by zoekt-indexserver.
+	PlacementByID bool
+}
+
+// NewSearcher returns a searcher over backends.
+func NewSearcher(backends []Backend, opts Options) zoekt.Streamer {
+	return &searcher{backends: backends, opts: opts}
+}
+
+type searcher struct {
+	backends []Backend
+	opts     Options
+}
+
+func (s *searcher) String() string {
+	names := make([]string, 0, len(s.backends))
+	for _, b := range s.backends {
+		names = append(names, b.Name)
+	}
+	return fmt.Sprintf("distributed(%s)", strings.Join(names, ","))
+}
+
+func (s *searcher) Close() {
+	for _, b := range s.backends {
+		b.Close()
+	}
+}
+
+func (s *searcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return collect(ctx, q, opts, s)
+}
+
+func (s *searcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	routes := s.route(q)
+	m := newMerger(len(routes), sender)
+	errs := s.fanOut(ctx, routes, "StreamSearch", func(ctx context.Context, i int, b Backend) error {
+		return b.StreamSearch(ctx, q, opts, stream.SenderFunc(func(r *zoekt.SearchResult) {
+			m.send(i, r)
+		}))
+	}, m.retry, m.done)
+
+	failed, err := s.failures(ctx, "StreamSearch", errs)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		sender.Send(&zoekt.SearchResult{
+			Stats: zoekt.Stats{Crashes: failed},
+			Progress: zoekt.Progress{
+				Priority:           math.Inf(-1),
+				MaxPendingPriority: math.Inf(-1),
+			},
+		})
+	}
+	return nil
+}
+
+func (s *searcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	// Minimal entries have no stats, so the stats of replicated
+	// repositories could only be summed once per replica. Instead we list
+	// full entries, sum the stats of the deduplicated ones and convert them
+	// to minimal entries afterwards.
+	minimal := opts != nil && opts.Minimal
+	if minimal {
+		full := *opts
+		full.Minimal = false
+		opts = &full
+	}
+
+	routes := s.route(q)
+	// lists holds the lists of the backends of each route, which are
+	// called one after the other.
+	lists := make([][]*zoekt.RepoList, len(routes))
+	errs := s.fanOut(ctx, routes, "List", func(ctx context.Context, i int, b Backend) error {
+		rl, err := b.List(ctx, q, opts)
+		if err != nil {
+			return err
+		}
+		lists[i] = append(lists[i], rl)
+		return nil
+	}, nil, nil)
+
+	failed, err := s.failures(ctx, "List", errs)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := &zoekt.RepoList{
+		Crashes: failed,
+		Minimal: map[uint32]*zoekt.MinimalRepoListEntry{},
+	}
+	seen := map[string]bool{}
+	for _, rl := range flatten(lists) {
+		agg.Crashes += rl.Crashes
+		for _, r := range rl.Repos {
+			if seen[r.Repository.Name] {
+				continue
+			}
+			seen[r.Repository.Name] = true
+			agg.Stats.Add(&r.Stats)
+			agg.Stats.Repos++
+
+			if id := r.Repository.ID; id != 0 && minimal {
+				agg.Minimal[id] = &zoekt.MinimalRepoListEntry{
+					HasSymbols: r.Repository.HasSymbols,
+					Branches:   r.Repository.Branches,
+				}
+			} else {
+				agg.Repos = append(agg.Repos, r)
+			}
+		}
+	}
+	return agg, nil
+}
+
+// A route is a backend and the placement keys of the repositories it is
+// searched for. The keys are nil if the backend is searched for all of its
+// repositories.
+type route struct {
+	backend Backend
+	keys    []string
+}
+
+// route returns the backends q is sent to.
+func (s *searcher) route(q query.Q) []route {
+	all := make([]route, len(s.backends))
+	for i, b := range s.backends {
+		all[i] = route{backend: b}
+	}
+	if s.opts.Placement == nil {
+		return all
+	}
+	keys, ok := repoKeys(q, s.opts.PlacementByID)
+	if !ok {
+		return all
+	}
+
+	routes, ok := s.routeKeys(keys, nil)
+	if !ok {
+		// The owners aren't backends of this searcher, eg. while the
+		// membership file and the backends flag disagree.
+		return all
+	}
+	return routes
+}
+
+// routeKeys groups keys by their first owner which is a backend not in
+// failed. It returns false if a key has no such owner.
+func (s *searcher) routeKeys(keys []string, failed map[string]bool) ([]route, bool) {
+	byName := make(map[string]int, len(s.backends))
+	for i, b := range s.backends {
+		byName[b.Name] = i
+	}
+	p := s.opts.Placement.Placement()
+	selected := make([][]string, len(s.backends))
+	for _, key := range keys {
+		found := false
+		for _, owner := range p.Owners(key) {
+			if i, ok := byName[owner]; ok && !failed[owner] {
+				selected[i] = append(selected[i], key)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	var routes []route
+	for i, keys := range selected {
+		if len(keys) > 0 {
+			routes = append(routes, route{backend: s.backends[i], keys: keys})
+		}
+	}
+	return routes, true
+}
+
+func flatten(lists [][]*zoekt.RepoList) []*zoekt.RepoList {
+	var flat []*zoekt.RepoList
+	for _, l := range lists {
+		flat = append(flat, l...)
+	}
+	return flat
+}
+
+// repoKeys returns the placement keys of the repositories q is restricted
+// to. It returns false if q may match any repository.
+func repoKeys(q query.Q, byID bool) ([]string, bool) {
+	switch q := q.(type) {
+	case *query.GobCache:
+		return repoKeys(q.Q, byID)
+	case *query.RepoSet:
+		if byID {
+			return nil, false
+		}
+		keys := make([]string, 0, len(q.Set))
+		for name := range q.Set {
+			keys = append(keys, name)
+		}
+		return keys, true
+	case *query.BranchesRepos:
+		if !byID {
+			return nil, false
+		}
+		var keys []string
+		seen := map[uint32]bool{}
+		for _, br := range q.List {
+			it := br.Repos.Iterator()
+			for it.HasNext() {
+				id := it.Next()
+				if !seen[id] {
+					seen[id] = true
+					keys = append(keys, placement.IDKey(id))
+				}
+			}
+		}
+		return keys, true
+	case *query.And:
+		for _, c := range q.Children {
+			if keys, ok := repoKeys(c, byID); ok {
+				return keys, true
+			}
+		}
+	case *query.Or:
+		var keys []string
+		for _, c := range q.Children {
+			k, ok := repoKeys(c, byID)
+			if !ok {
+				return nil, false
+			}
+			keys = append(keys, k...)
+		}
+		return keys, len(q.Children) > 0
+	}
+	return nil, false
+}
+
+// fanOut calls f for the backend of each of routes concurrently, with the
+// timeout of the backends applied to ctx. If a backend searched for a set of
+// repositories fails, f is called again for their next owners, one after
+// the other, after retry is called. retry and done, if not nil, are called
+// with the index of the route. done is called once f returned for the route
+// and its fallbacks. It returns the errors of the routes.
+func (s *searcher) fanOut(ctx context.Context, routes []route, method string, f func(ctx context.Context, i int, b Backend) error, retry, done func(i int)) []error {
+	errs := make([]error, len(routes))
+	var wg sync.WaitGroup
+	for i, r := range routes {
+		wg.Add(1)
+		go func(i int, r route) {
+			defer wg.Done()
+			errs[i] = s.call(ctx, method, i, r, f, retry)
+			if done != nil {
+				done(i)
+			}
+		}(i, r)
+	}
+	wg.Wait()
+	return errs
+}
+
+// call calls f for the backend of r, and for the next owners of the keys of
+// r while backends fail.
+func (s *searcher) call(ctx context.Context, method string, i int, r route, f func(ctx context.Context, i int, b Backend) error, retry func(i int)) error {
+	var (
+		failed  map[string]bool
+		lastErr error
+	)
+	pending := []route{r}
+	for len(pending) > 0 {
+		r := pending[0]
+		pending = pending[1:]
+
+		err := s.callBackend(ctx, i, r.backend, f)
+		if err == nil {
+			continue
+		}
+		if len(r.keys) == 0 || ctx.Err() != nil {
+			lastErr = fmt.Errorf("backend %s: %w", r.backend.Name, err)
+			continue
+		}
+
+		if failed == nil {
+			failed = map[string]bool{}
+		}
+		failed[r.backend.Name] = true
+		next, ok := s.routeKeys(r.keys, failed)
+		if !ok {
+			lastErr = fmt.Errorf("backend %s: %w", r.backend.Name, err)
+			continue
+		}
+		log.Printf("distributed: %s of backend %s failed, retrying on %d other backend(s): %v", method, r.backend.Name, len(next), err)
+		if retry != nil {
+			retry(i)
+		}
+		pending = append(pending, next...)
+	}
+	return lastErr
+}
+
+func (s *searcher) callBackend(ctx context.Context, i int, b Backend, f func(ctx context.Context, i int, b Backend) error) error {
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+	return f(ctx, i, b)
+}
+
+// failures returns the number of failed routes. It returns an error if ctx
+// is done or all routes failed.
+func (s *searcher) failures(ctx context.Context, method string, errs []error) (int, error) {
+	failed := 0
+	var firstErr error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() == nil {
+			log.Printf("distributed: %s failed: %v", method, err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return failed, err
+	}
+	if failed > 0 && failed == len(errs) {
+		return failed, firstErr
+	}
+	return failed, nil
+}
+
+// collect implements Search with StreamSearch. The files are ranked and
+// truncated to opts.MaxDocDisplayCount and opts.TopK.
+func collect(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, s zoekt.Streamer) (*zoekt.SearchResult, error) {
+	agg := &zoekt.SearchResult{
+		RepoURLs:      map[string]string{},
+		LineFragments: map[string]string{},
+	}
+	var mu sync.Mutex
+	err := s.StreamSearch(ctx, q, opts, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		agg.Stats.Add(r.Stats)
+		agg.Files = append(agg.Files, r.Files...)
+		for k, v := range r.RepoURLs {
+			agg.RepoURLs[k] = v
+		}
+		for k, v := range r.LineFragments {
+			agg.LineFragments[k] = v
+		}
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	zoekt.SortFiles(agg.Files, opts)
+	if max := opts.MaxDocDisplayCount; max > 0 && len(agg.Files) > max {
+		agg.Files = agg.Files[:max]
+	}
+	if k := opts.TopK; k > 0 && len(agg.Files) > k {
+		agg.Files = agg.Files[:k]
+	}
+	return agg, nil
+}
@@ -0,0 +1,340 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/placement"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/stream"
+)
+
+// fakeBackend streams results and then returns err.
+type fakeBackend struct {
+	results []*zoekt.SearchResult
+	repos   []*zoekt.RepoListEntry
+	err     error
+
+	// block makes StreamSearch and List wait for the context.
+	block bool
+}
+
+func (b *fakeBackend) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	for _, r := range b.results {
+		cp := *r
+		cp.Files = append([]zoekt.FileMatch(nil), r.Files...)
+		sender.Send(&cp)
+	}
+	if b.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return b.err
+}
+
+func (b *fakeBackend) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return collect(ctx, q, opts, b)
+}
+
+func (b *fakeBackend) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	if b.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	if opts == nil || !opts.Minimal {
+		return &zoekt.RepoList{Repos: b.repos}, nil
+	}
+	rl := &zoekt.RepoList{Minimal: map[uint32]*zoekt.MinimalRepoListEntry{}}
+	for _, r := range b.repos {
+		rl.Stats.Add(&r.Stats)
+		rl.Stats.Repos++
+		rl.Minimal[r.Repository.ID] = &zoekt.MinimalRepoListEntry{Branches: r.Repository.Branches}
+	}
+	return rl, nil
+}
+
+func (b *fakeBackend) Close()         {}
+func (b *fakeBackend) String() string { return "fake" }
+
+func result(priority, maxPending float64, repos ...string) *zoekt.SearchResult {
+	r := &zoekt.SearchResult{
+		Progress: zoekt.Progress{
+			Priority:           priority,
+			MaxPendingPriority: maxPending,
+		},
+	}
+	for _, repo := range repos {
+		r.Files = append(r.Files, zoekt.FileMatch{
+			Repository: repo,
+			FileName:   "f",
+			// Shards count the ranges of chunk matches.
+			ChunkMatches: []zoekt.ChunkMatch{{Ranges: make([]zoekt.Range, 2)}},
+		})
+		r.Stats.FileCount++
+		r.Stats.MatchCount += 2
+	}
+	return r
+}
+
+func streamSearch(t *testing.T, s zoekt.Streamer) ([]*zoekt.SearchResult, error) {
+	t.Helper()
+	var got []*zoekt.SearchResult
+	err := s.StreamSearch(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		got = append(got, r)
+	}))
+	return got, err
+}
+
+func TestStreamSearchMerge(t *testing.T) {
+	s := NewSearcher([]Backend{
+		{Name: "a", Streamer: &fakeBackend{results: []*zoekt.SearchResult{
+			result(0, 5),
+			result(5, 3, "a5"),
+			result(3, 1, "a3", "shared"),
+			result(1, math.Inf(-1), "a1"),
+		}}},
+		{Name: "b", Streamer: &fakeBackend{results: []*zoekt.SearchResult{
+			result(0, 4),
+			result(4, 2, "b4", "shared"),
+			result(2, math.Inf(-1), "b2"),
+		}}},
+	}, Options{})
+
+	got, err := streamSearch(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var repos []string
+	var stats zoekt.Stats
+	lastPending := math.Inf(1)
+	for _, r := range got {
+		for _, f := range r.Files {
+			repos = append(repos, f.Repository)
+		}
+		stats.Add(r.Stats)
+		if r.MaxPendingPriority > lastPending {
+			t.Errorf("MaxPendingPriority increased from %v to %v", lastPending, r.MaxPendingPriority)
+		}
+		lastPending = r.MaxPendingPriority
+	}
+
+	// Results are in order of priority, and "shared" is only returned by
+	// one backend.
+	var shared int
+	for _, r := range repos {
+		if r == "shared" {
+			shared++
+		}
+	}
+	if shared != 1 {
+		t.Errorf("got shared %d times, want 1: %v", shared, repos)
+	}
+	want := []string{"a5", "b4", "a3", "b2", "a1"}
+	var withoutShared []string
+	for _, r := range repos {
+		if r != "shared" {
+			withoutShared = append(withoutShared, r)
+		}
+	}
+	if diff := cmp.Diff(want, withoutShared); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+	if stats.FileCount != 6 || stats.MatchCount != 12 {
+		t.Errorf("got FileCount %d, MatchCount %d, want 6, 12", stats.FileCount, stats.MatchCount)
+	}
+}
+
+func TestStreamSearchPartialFailure(t *testing.T) {
+	s := NewSearcher([]Backend{
+		{Name: "ok", Streamer: &fakeBackend{results: []*zoekt.SearchResult{result(1, math.Inf(-1), "ok")}}},
+		{Name: "err", Streamer: &fakeBackend{err: errors.New("boom")}},
+		{Name: "slow", Streamer: &fakeBackend{results: []*zoekt.SearchResult{result(2, 1, "slow")}, block: true}},
+	}, Options{Timeout: 10 * time.Millisecond})
+
+	res, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Crashes != 2 {
+		t.Errorf("got Crashes %d, want 2", res.Stats.Crashes)
+	}
+	// Results received before the timeout are kept.
+	if len(res.Files) != 2 {
+		t.Errorf("got %v, want files of ok and slow", res.Files)
+	}
+
+	s = NewSearcher([]Backend{
+		{Name: "err", Streamer: &fakeBackend{err: errors.New("boom")}},
+	}, Options{})
+	if _, err := streamSearch(t, s); err == nil {
+		t.Error("got no error when all backends failed")
+	}
+}
+
+func TestList(t *testing.T) {
+	repo := func(id uint32, name string, docs int) *zoekt.RepoListEntry {
+		return &zoekt.RepoListEntry{
+			Repository: zoekt.Repository{ID: id, Name: name},
+			Stats:      zoekt.RepoStats{Documents: docs},
+		}
+	}
+	s := NewSearcher([]Backend{
+		{Name: "a", Streamer: &fakeBackend{repos: []*zoekt.RepoListEntry{repo(1, "a", 1), repo(3, "shared", 10)}}},
+		{Name: "b", Streamer: &fakeBackend{repos: []*zoekt.RepoListEntry{repo(3, "shared", 10), repo(2, "b", 2)}}},
+		{Name: "c", Streamer: &fakeBackend{err: errors.New("boom")}},
+	}, Options{})
+
+	rl, err := s.List(context.Background(), &query.Const{Value: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range rl.Repos {
+		names = append(names, r.Repository.Name)
+	}
+	if diff := cmp.Diff([]string{"a", "shared", "b"}, names); diff != "" {
+		t.Errorf("repos mismatch (-want +got):\n%s", diff)
+	}
+	if rl.Stats.Repos != 3 || rl.Stats.Documents != 13 {
+		t.Errorf("got stats %+v, want 3 repos and 13 documents", rl.Stats)
+	}
+	if rl.Crashes != 1 {
+		t.Errorf("got Crashes %d, want 1", rl.Crashes)
+	}
+
+	// The replicated repository is counted once in minimal lists, too.
+	rl, err = s.List(context.Background(), &query.Const{Value: true}, &zoekt.ListOptions{Minimal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl.Repos) != 0 || len(rl.Minimal) != 3 {
+		t.Errorf("got %d repos and %d minimal entries, want 0 and 3", len(rl.Repos), len(rl.Minimal))
+	}
+	if rl.Stats.Repos != 3 || rl.Stats.Documents != 13 {
+		t.Errorf("got minimal stats %+v, want 3 repos and 13 documents", rl.Stats)
+	}
+}
+
+func TestRoutePlacement(t *testing.T) {
+	nodes := filepath.Join(t.TempDir(), "nodes")
+	if err := os.WriteFile(nodes, []byte("a\nb\nc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := placement.LoadMembership(nodes, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var backends []Backend
+	for _, name := range []string{"a", "b", "c"} {
+		backends = append(backends, Backend{Name: name, Streamer: &fakeBackend{
+			results: []*zoekt.SearchResult{result(1, math.Inf(-1), name)},
+		}})
+	}
+
+	search := func(s zoekt.Streamer, q query.Q) []string {
+		t.Helper()
+		res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.Repository)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	s := NewSearcher(backends, Options{Placement: m})
+	owner := m.Placement().Owners("repo")[0]
+	q := query.NewAnd(&query.Substring{Pattern: "foo"}, &query.RepoSet{Set: map[string]bool{"repo": true}})
+	if diff := cmp.Diff([]string{owner}, search(s, q)); diff != "" {
+		t.Errorf("RepoSet routed to wrong backends (-want +got):\n%s", diff)
+	}
+
+	// Unrestricted queries and queries of repositories placed by ID are
+	// sent to all backends.
+	all := []string{"a", "b", "c"}
+	if diff := cmp.Diff(all, search(s, &query.Substring{Pattern: "foo"})); diff != "" {
+		t.Errorf("unrestricted query routed to wrong backends (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(all, search(s, query.NewSingleBranchesRepos("HEAD", 1))); diff != "" {
+		t.Errorf("BranchesRepos routed by name (-want +got):\n%s", diff)
+	}
+
+	s = NewSearcher(backends, Options{Placement: m, PlacementByID: true})
+	owner = m.Placement().Owners(placement.IDKey(42))[0]
+	if diff := cmp.Diff([]string{owner}, search(s, query.NewSingleBranchesRepos("HEAD", 42))); diff != "" {
+		t.Errorf("BranchesRepos routed to wrong backends (-want +got):\n%s", diff)
+	}
+}
+
+func TestRoutePlacementFailover(t *testing.T) {
+	nodes := filepath.Join(t.TempDir(), "nodes")
+	if err := os.WriteFile(nodes, []byte("a\nb\nc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := placement.LoadMembership(nodes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := m.Placement().Owners("repo")
+
+	files := func(names ...string) *zoekt.SearchResult {
+		r := result(1, math.Inf(-1))
+		for _, name := range names {
+			r.Files = append(r.Files, zoekt.FileMatch{Repository: "repo", FileName: name})
+			r.Stats.FileCount++
+		}
+		return r
+	}
+	var backends []Backend
+	for _, name := range []string{"a", "b", "c"} {
+		b := &fakeBackend{results: []*zoekt.SearchResult{files("f1", "f2")}}
+		if name == owners[0] {
+			// The first owner fails after sending a part of its results.
+			b = &fakeBackend{results: []*zoekt.SearchResult{files("f1")}, err: errors.New("boom")}
+		}
+		backends = append(backends, Backend{Name: name, Streamer: b})
+	}
+
+	s := NewSearcher(backends, Options{Placement: m})
+	q := query.NewAnd(&query.Substring{Pattern: "foo"}, &query.RepoSet{Set: map[string]bool{"repo": true}})
+	res, err := s.Search(context.Background(), q, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName)
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"f1", "f2"}, got); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	if res.Stats.Crashes != 0 || res.Stats.FileCount != 2 {
+		t.Errorf("got stats %+v, want no crashes and 2 files", res.Stats)
+	}
+
+	// If all owners fail, the search fails.
+	for _, b := range backends {
+		b.Streamer.(*fakeBackend).err = errors.New("boom")
+	}
+	if _, err := s.Search(context.Background(), q, &zoekt.SearchOptions{}); err == nil {
+		t.Error("got no error when all owners failed")
+	}
+}
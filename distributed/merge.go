@@ -0,0 +1,160 @@
+package distributed
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// merger merges the results streamed by several backends. Results are held
+// back until no backend can send a result of higher priority, which is the
+// case once their priority is at least the MaxPendingPriority of every
+// backend. Backends which haven't sent a result yet block all results.
+type merger struct {
+	mu     sync.Mutex
+	sender zoekt.Sender
+
+	// pending holds the latest MaxPendingPriority of each backend.
+	pending []float64
+
+	queue resultQueue
+
+	// owners maps repositories to the backend whose results are returned
+	// for them.
+	owners map[repoKey]int
+
+	// sent holds the files sent, so the files of repositories released by
+	// a retried backend aren't sent twice.
+	sent map[fileKey]bool
+
+	// released holds the repositories of retried backends.
+	released map[repoKey]bool
+}
+
+// repoKey identifies a repository across backends. The ID is 0 for
+// repositories without ID, so the name is needed too.
+type repoKey struct {
+	id   uint32
+	name string
+}
+
+type fileKey struct {
+	repo repoKey
+	name string
+}
+
+func newMerger(backends int, sender zoekt.Sender) *merger {
+	pending := make([]float64, backends)
+	for i := range pending {
+		pending[i] = math.Inf(1)
+	}
+	return &merger{
+		sender:   sender,
+		pending:  pending,
+		owners:   map[repoKey]int{},
+		sent:     map[fileKey]bool{},
+		released: map[repoKey]bool{},
+	}
+}
+
+// send handles a result of backend i.
+func (m *merger) send(i int, r *zoekt.SearchResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dedup(i, r)
+	m.pending[i] = r.MaxPendingPriority
+	heap.Push(&m.queue, r)
+	m.flush()
+}
+
+// done marks backend i as finished.
+func (m *merger) done(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending[i] = math.Inf(-1)
+	m.flush()
+}
+
+// retry marks backend i as failed, and its repositories as being searched
+// again by the next results of i. The files i sent already are dropped from
+// them.
+func (m *merger) retry(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, owner := range m.owners {
+		if owner == i {
+			delete(m.owners, key)
+			m.released[key] = true
+		}
+	}
+	m.pending[i] = math.Inf(1)
+}
+
+// dedup removes the files of repositories owned by other backends from r,
+// and makes backend i the owner of the other repositories of r.
+func (m *merger) dedup(i int, r *zoekt.SearchResult) {
+	files := r.Files[:0]
+	for _, f := range r.Files {
+		key := repoKey{id: f.RepositoryID, name: f.Repository}
+		fk := fileKey{repo: key, name: f.FileName}
+		owner, ok := m.owners[key]
+		if !ok {
+			m.owners[key] = i
+		}
+		if (ok && owner != i) || (m.released[key] && m.sent[fk]) {
+			r.Stats.FileCount--
+			r.Stats.MatchCount -= matchCount(&f)
+			continue
+		}
+		m.sent[fk] = true
+		files = append(files, f)
+	}
+	r.Files = files
+}
+
+// matchCount returns the number of matches of f, as counted in
+// Stats.MatchCount by the shards.
+func matchCount(f *zoekt.FileMatch) int {
+	n := len(f.LineMatches)
+	for _, cm := range f.ChunkMatches {
+		n += len(cm.Ranges)
+	}
+	return n
+}
+
+// flush sends the queued results which are stable.
+func (m *merger) flush() {
+	maxPending := math.Inf(-1)
+	for _, p := range m.pending {
+		maxPending = math.Max(maxPending, p)
+	}
+
+	for m.queue.Len() > 0 && m.queue[0].Priority >= maxPending {
+		r := heap.Pop(&m.queue).(*zoekt.SearchResult)
+		r.MaxPendingPriority = maxPending
+		if m.queue.Len() > 0 {
+			r.MaxPendingPriority = math.Max(maxPending, m.queue[0].Priority)
+		}
+		m.sender.Send(r)
+	}
+}
+
+// resultQueue is a max-heap of results by priority.
+type resultQueue []*zoekt.SearchResult
+
+func (q resultQueue) Len() int            { return len(q) }
+func (q resultQueue) Less(i, j int) bool  { return q[i].Priority > q[j].Priority }
+func (q resultQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *resultQueue) Push(x interface{}) { *q = append(*q, x.(*zoekt.SearchResult)) }
+
+func (q *resultQueue) Pop() interface{} {
+	old := *q
+	r := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return r
+}
@@ -59,6 +59,27 @@ func (d *indexData) simplifyMultiRepo(q query.Q, predicate func(*Repository) boo
 	return &query.Const{Value: false}
 }
 
+// snapshotAt returns true if repo is a snapshot matched by q.
+func snapshotAt(repo *Repository, q *query.At) bool {
+	switch {
+	case repo.Snapshot == "":
+		return false
+	case q.Revision != "":
+		if repo.Snapshot == q.Revision {
+			return true
+		}
+		for _, b := range repo.Branches {
+			if b.Version == q.Revision {
+				return true
+			}
+		}
+		return false
+	case !q.Time.IsZero():
+		return !repo.LatestCommitDate.After(q.Time)
+	}
+	return true
+}
+
 func (d *indexData) simplify(in query.Q) query.Q {
 	eval := query.Map(in, func(q query.Q) query.Q {
 		switch r := q.(type) {
@@ -83,6 +104,14 @@ func (d *indexData) simplify(in query.Q) query.Q {
 			return d.simplifyMultiRepo(q, func(repo *Repository) bool {
 				return r.Set[repo.Name]
 			})
+		case *query.HistoryType, *query.Author, *query.CommitDate:
+			if d.commits == nil {
+				return &query.Const{Value: false}
+			}
+		case *query.At:
+			return d.simplifyMultiRepo(q, func(repo *Repository) bool {
+				return snapshotAt(repo, r)
+			})
 		case *query.Language:
 			_, has := d.metaData.LanguageMap[r.Language]
 			if !has && d.metaData.IndexFeatureVersion < 12 {
@@ -152,6 +181,16 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 	default:
 	}
 
+	// Commit messages and diffs are only searched if asked for.
+	if d.commits != nil && !query.IsHistory(q) {
+		q = query.NewAnd(q, &query.Not{Child: &query.HistoryType{}})
+	}
+
+	// So are snapshots.
+	if d.hasSnapshots && !query.IsSnapshot(q) {
+		q = query.NewAnd(q, &query.Not{Child: &query.At{}})
+	}
+
 	q = d.simplify(q)
 	if c, ok := q.(*query.Const); ok && !c.Value {
 		return &res, nil
@@ -197,6 +236,15 @@ func (d *indexData) Search(ctx context.Context, q query.Q, opts *SearchOptions)
 		repoMatchCount int
 	)
 
+	// topK holds the best files so far if we only return the top K.
+	// topKEvaluated counts the matches of all documents evaluated for it,
+	// which ShardMaxMatchCount limits instead of the matches returned.
+	var topK *topKFiles
+	topKEvaluated := 0
+	if opts.TopK > 0 && !opts.UseDocumentRanks {
+		topK = newTopKFiles(opts.TopK)
+	}
+
 	docCount := uint32(len(d.fileBranchMasks))
 	lastDoc := int(-1)
 
@@ -228,6 +276,12 @@ nextFileMatch:
 				if _, tombstoned := repoMetadata.FileTombstones[string(d.fileName(nextDoc))]; tombstoned {
 					continue
 				}
+				// Extracted documents are tombstoned with their file.
+				if orig, _ := d.readDocString(&d.originalNames, nextDoc); orig != "" {
+					if _, tombstoned := repoMetadata.FileTombstones[orig]; tombstoned {
+						continue
+					}
+				}
 			}
 
 			// Skip documents over ShardRepoMaxMatchCount if specified.
@@ -253,7 +307,11 @@ nextFileMatch:
 			repoMatchCount = 0
 		}
 
-		if canceled || (res.Stats.MatchCount >= opts.ShardMaxMatchCount && opts.ShardMaxMatchCount > 0) {
+		matchCount := res.Stats.MatchCount
+		if topK != nil {
+			matchCount = topKEvaluated
+		}
+		if canceled || (matchCount >= opts.ShardMaxMatchCount && opts.ShardMaxMatchCount > 0) {
 			res.Stats.FilesSkipped += int(docCount - nextDoc)
 			break
 		}
@@ -279,6 +337,45 @@ nextFileMatch:
 			}
 		}
 
+		atomMatchCount := 0
+		visitMatches(mt, known, func(mt matchTree) {
+			atomMatchCount++
+		})
+		shouldMergeMatches := !opts.ChunkMatches
+		finalCands := gatherMatches(mt, known, shouldMergeMatches)
+		topKEvaluated += len(finalCands)
+
+		if len(finalCands) == 0 {
+			nm := d.fileName(nextDoc)
+			finalCands = append(finalCands,
+				&candidateMatch{
+					caseSensitive: false,
+					fileName:      true,
+					substrBytes:   nm,
+					substrLowered: nm,
+					file:          nextDoc,
+					runeOffset:    0,
+					byteOffset:    0,
+					byteMatchSz:   uint32(len(nm)),
+				})
+		}
+
+		atomScore := float64(atomMatchCount) / float64(totalAtomCount) * scoreFactorAtomMatch
+		docOrderScore := scoreFileOrderFactor * (1.0 - float64(nextDoc)/float64(len(d.boundaries)))
+		shardOrderScore := scoreShardRankFactor * float64(md.Rank) / maxUInt16
+
+		// Skip documents which can't make it into the top K before filling
+		// in their matches, which needs their content.
+		if topK != nil {
+			if threshold, ok := topK.threshold(); ok {
+				bound := d.fragmentScoreBound(nextDoc, finalCands, opts.ChunkMatches) + atomScore + docOrderScore + shardOrderScore
+				if bound <= threshold {
+					res.Stats.FilesSkipped++
+					continue
+				}
+			}
+		}
+
 		fileMatch := FileMatch{
 			Repository:         md.Name,
 			RepositoryID:       md.ID,
@@ -306,32 +403,27 @@ nextFileMatch:
 			}
 		}
 
-		atomMatchCount := 0
-		visitMatches(mt, known, func(mt matchTree) {
-			atomMatchCount++
-		})
-		shouldMergeMatches := !opts.ChunkMatches
-		finalCands := gatherMatches(mt, known, shouldMergeMatches)
-
-		if len(finalCands) == 0 {
-			nm := d.fileName(nextDoc)
-			finalCands = append(finalCands,
-				&candidateMatch{
-					caseSensitive: false,
-					fileName:      true,
-					substrBytes:   nm,
-					substrLowered: nm,
-					file:          nextDoc,
-					runeOffset:    0,
-					byteOffset:    0,
-					byteMatchSz:   uint32(len(nm)),
-				})
+		if fileMatch.OriginalFileName, err = d.readDocString(&d.originalNames, nextDoc); err != nil {
+			return nil, err
+		}
+		if fileMatch.Encoding, err = d.readDocString(&d.encodings, nextDoc); err != nil {
+			return nil, err
+		}
+		if c := d.commit(nextDoc); c != nil {
+			commit := *c
+			fileMatch.Commit = &commit
 		}
 
 		if opts.ChunkMatches {
 			fileMatch.ChunkMatches = cp.fillChunkMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts.DebugScore)
+			if opts.IncludeBlame {
+				cp.fillChunkBlame(fileMatch.ChunkMatches)
+			}
 		} else {
 			fileMatch.LineMatches = cp.fillMatches(finalCands, opts.NumContextLines, fileMatch.Language, opts.DebugScore)
+			if opts.IncludeBlame {
+				cp.fillBlame(fileMatch.LineMatches)
+			}
 		}
 
 		maxFileScore := 0.0
@@ -365,16 +457,16 @@ nextFileMatch:
 		// Prefer docs with several top-scored matches.
 		fileMatch.addScore("repetition-boost", scoreRepetitionFactor*float64(repetitions), opts.DebugScore)
 
-		fileMatch.addScore("atom", float64(atomMatchCount)/float64(totalAtomCount)*scoreFactorAtomMatch, opts.DebugScore)
+		fileMatch.addScore("atom", atomScore, opts.DebugScore)
 
 		// Prefer earlier docs.
-		fileMatch.addScore("doc-order", scoreFileOrderFactor*(1.0-float64(nextDoc)/float64(len(d.boundaries))), opts.DebugScore)
+		fileMatch.addScore("doc-order", docOrderScore, opts.DebugScore)
 
 		if opts.UseDocumentRanks && len(d.ranks) > int(nextDoc) {
 			fileMatch.Ranks = d.ranks[nextDoc]
 		}
 
-		fileMatch.addScore("shard-order", scoreShardRankFactor*float64(md.Rank)/maxUInt16, opts.DebugScore)
+		fileMatch.addScore("shard-order", shardOrderScore, opts.DebugScore)
 
 		if opts.DebugScore && opts.UseDocumentRanks {
 			fileMatch.Debug += fmt.Sprintf("ranks: %v, ", fileMatch.Ranks)
@@ -399,12 +491,27 @@ nextFileMatch:
 			fileMatch.Debug = fmt.Sprintf("score:%.2f <- %s", fileMatch.Score, fileMatch.Debug)
 		}
 
+		if topK != nil {
+			if topK.add(nextDoc, fileMatch) {
+				res.Stats.FilesSkipped++
+			}
+			continue
+		}
+
 		res.Files = append(res.Files, fileMatch)
 		res.Stats.MatchCount += len(fileMatch.LineMatches)
 		res.Stats.MatchCount += matchedChunkRanges
 		res.Stats.FileCount++
 	}
 
+	if topK != nil {
+		res.Files = topK.files()
+		for i := range res.Files {
+			res.Stats.MatchCount += matchCount(&res.Files[i])
+		}
+		res.Stats.FileCount = len(res.Files)
+	}
+
 	// We do not sort Files here, instead we rely on the shards pkg to do file
 	// ranking. If we sorted now, we would break the assumption that results
 	// from the same repo in a shard appear next to each other.
@@ -473,6 +580,9 @@ func gatherMatches(mt matchTree, known map[matchTree]bool, merge bool) []*candid
 		if smt, ok := mt.(*symbolRegexpMatchTree); ok {
 			cands = append(cands, smt.found...)
 		}
+		if smt, ok := mt.(*symbolRefMatchTree); ok {
+			cands = append(cands, smt.found...)
+		}
 	})
 
 	foundContentMatch := false
@@ -578,6 +688,13 @@ func (d *indexData) gatherBranches(docID uint32, mt matchTree, known map[matchTr
 }
 
 func (d *indexData) List(ctx context.Context, q query.Q, opts *ListOptions) (rl *RepoList, err error) {
+	// Commit messages, diffs and snapshots are only listed if asked for, as
+	// in Search.
+	if d.commits != nil && !query.IsHistory(q) {
+		return &RepoList{}, nil
+	}
+	listSnapshots := query.IsSnapshot(q)
+
 	var include func(rle *RepoListEntry) bool
 
 	q = d.simplify(q)
@@ -621,12 +738,17 @@ func (d *indexData) List(ctx context.Context, q query.Q, opts *ListOptions) (rl
 			continue
 		}
 		rle := &d.repoListEntry[i]
+		if rle.Repository.Snapshot != "" && !listSnapshots {
+			continue
+		}
 		if !include(rle) {
 			continue
 		}
 
 		l.Stats.Add(&rle.Stats)
-		if id := rle.Repository.ID; id != 0 && minimal {
+		// Minimal entries are keyed by ID, so they would be mixed up with
+		// the snapshots and the history of the repository.
+		if id := rle.Repository.ID; id != 0 && minimal && rle.Repository.Snapshot == "" && d.commits == nil {
 			l.Minimal[id] = &MinimalRepoListEntry{
 				HasSymbols: rle.Repository.HasSymbols,
 				Branches:   rle.Repository.Branches,
@@ -0,0 +1,93 @@
+package gitindex
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// blamer computes the blame of files of the branches of a repository.
+type blamer struct {
+	repo *git.Repository
+
+	// versions holds the commit of each branch.
+	versions map[string]plumbing.Hash
+
+	// commits caches the commits of the branches.
+	commits map[plumbing.Hash]*object.Commit
+
+	// authors caches the formatted author of commits, since go-git only
+	// reports the email of the author of a line.
+	authors map[plumbing.Hash]string
+}
+
+func newBlamer(repo *git.Repository, versions map[string]plumbing.Hash) *blamer {
+	return &blamer{
+		repo:     repo,
+		versions: versions,
+		commits:  map[plumbing.Hash]*object.Commit{},
+		authors:  map[plumbing.Hash]string{},
+	}
+}
+
+// blame returns the blame of the file at path on branch as hunks of lines
+// last modified by the same commit.
+func (b *blamer) blame(branch, path string) ([]zoekt.BlameHunk, error) {
+	commit, err := b.commit(b.versions[branch])
+	if err != nil {
+		return nil, err
+	}
+	res, err := git.Blame(commit, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hunks []zoekt.BlameHunk
+	var last plumbing.Hash
+	for i, l := range res.Lines {
+		if i > 0 && l.Hash == last {
+			continue
+		}
+		last = l.Hash
+
+		author, ok := b.authors[l.Hash]
+		if !ok {
+			c, err := b.repo.CommitObject(l.Hash)
+			if err != nil {
+				return nil, err
+			}
+			author = formatAuthor(c.Author)
+			b.authors[l.Hash] = author
+		}
+		hunks = append(hunks, zoekt.BlameHunk{
+			Line: i + 1,
+			Blame: zoekt.Blame{
+				Hash:   l.Hash.String(),
+				Author: author,
+				Date:   l.Date,
+			},
+		})
+	}
+	return hunks, nil
+}
+
+func (b *blamer) commit(h plumbing.Hash) (*object.Commit, error) {
+	if c, ok := b.commits[h]; ok {
+		return c, nil
+	}
+	c, err := b.repo.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	b.commits[h] = c
+	return c, nil
+}
+
+// formatAuthor formats a signature like zoekt.Commit.Author.
+func formatAuthor(sig object.Signature) string {
+	return fmt.Sprintf("%s <%s>", sig.Name, sig.Email)
+}
@@ -0,0 +1,113 @@
+package gitindex
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func TestIndexBlame(t *testing.T) {
+	dir := t.TempDir()
+	createHistoryRepo(t, dir)
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+		Blame: true,
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	for pattern, want := range map[string]string{
+		"package main":         "Alice <alice@example.com>",
+		"func frobnicate() {}": "Bob <bob@example.com>",
+	} {
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: pattern, Content: true}, &zoekt.SearchOptions{IncludeBlame: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 {
+			t.Fatalf("%q: got %v, want 1 line of main.go", pattern, res.Files)
+		}
+		if b := res.Files[0].LineMatches[0].Blame; b == nil || b.Author != want || len(b.Hash) != 40 {
+			t.Errorf("%q: got blame %+v, want author %q", pattern, b, want)
+		}
+	}
+}
+
+func TestIndexBlameToggleRebuilds(t *testing.T) {
+	dir := t.TempDir()
+	createHistoryRepo(t, dir)
+	indexDir := t.TempDir()
+
+	index := func(blame bool) {
+		t.Helper()
+		buildOpts := build.Options{
+			IndexDir:              indexDir,
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			Blame:                 blame,
+		}
+		buildOpts.SetDefaults()
+		if err := IndexGitRepo(Options{
+			RepoDir:      filepath.Join(dir, "repo"),
+			BuildOptions: buildOpts,
+			BranchPrefix: "refs/heads/",
+			Branches:     []string{"master"},
+			Incremental:  true,
+		}); err != nil {
+			t.Fatalf("IndexGitRepo: %v", err)
+		}
+	}
+	hasBlame := func() bool {
+		t.Helper()
+		searcher, err := shards.NewDirectorySearcher(indexDir)
+		if err != nil {
+			t.Fatal("NewDirectorySearcher", err)
+		}
+		defer searcher.Close()
+		res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "package main", Content: true}, &zoekt.SearchOptions{IncludeBlame: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 {
+			t.Fatalf("got %v, want 1 line of main.go", res.Files)
+		}
+		return res.Files[0].LineMatches[0].Blame != nil
+	}
+
+	index(false)
+	if hasBlame() {
+		t.Fatal("got blame without Blame")
+	}
+	index(true)
+	if !hasBlame() {
+		t.Error("enabling Blame didn't rebuild the index")
+	}
+	index(false)
+	if hasBlame() {
+		t.Error("disabling Blame didn't rebuild the index")
+	}
+}
@@ -0,0 +1,160 @@
+package gitindex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/ignore"
+)
+
+const (
+	gitignoreFile     = ".gitignore"
+	gitattributesFile = ".gitattributes"
+)
+
+// treeRules holds the .gitignore and .gitattributes files of a tree. Files
+// of submodules aren't considered.
+type treeRules struct {
+	ignore *ignore.GitIgnore
+	attrs  *ignore.Attributes
+}
+
+// loadTreeRules parses the .gitignore files of tree if withIgnore is set,
+// and its .gitattributes files if withAttrs is set.
+func loadTreeRules(repo *git.Repository, tree *object.Tree, withIgnore, withAttrs bool) (*treeRules, error) {
+	rules := &treeRules{}
+	if withIgnore {
+		rules.ignore = &ignore.GitIgnore{}
+	}
+	if withAttrs {
+		rules.attrs = &ignore.Attributes{}
+	}
+
+	w := object.NewTreeWalker(tree, true, nil)
+	defer w.Close()
+	for {
+		name, entry, err := w.Next()
+		if errors.Is(err, io.EOF) {
+			return rules, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !entry.Mode.IsFile() || entry.Mode == filemode.Symlink {
+			continue
+		}
+
+		var parse func(string, io.Reader) error
+		switch {
+		case entry.Name == gitignoreFile && withIgnore:
+			parse = rules.ignore.Parse
+		case entry.Name == gitattributesFile && withAttrs:
+			parse = rules.attrs.Parse
+		default:
+			continue
+		}
+
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		r, err := blob.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		err = parse(dir, r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// applyAttributes sets the language of doc and its generated and vendored
+// flags from the linguist attributes of its file. Files whose diff
+// attribute is unset, eg. by the binary macro, are skipped.
+func (r *treeRules) applyAttributes(doc *zoekt.Document, path string) {
+	attrs := r.attrs.Lookup(path)
+	if len(attrs) == 0 {
+		return
+	}
+	if lang, ok := attrs["linguist-language"]; ok && lang != ignore.AttrSet && lang != ignore.AttrUnset {
+		doc.Language = lang
+	}
+	doc.Generated = attrBool(attrs, "linguist-generated")
+	doc.Vendored = attrBool(attrs, "linguist-vendored")
+	if attrs["diff"] == ignore.AttrUnset {
+		doc.SkipReason = fmt.Sprintf("diff attribute unset in %s", gitattributesFile)
+		doc.Content = nil
+	}
+}
+
+// attrBool returns nil if the attribute is unspecified.
+func attrBool(attrs map[string]string, name string) *bool {
+	v, ok := attrs[name]
+	if !ok {
+		return nil
+	}
+	b := v != ignore.AttrUnset && !strings.EqualFold(v, "false")
+	return &b
+}
+
+// isRulesFile returns true if changes of the file at p affect other files.
+func isRulesFile(opts Options, p string) bool {
+	base := path.Base(p)
+	return (opts.BuildOptions.Gitignore && base == gitignoreFile) || (opts.BuildOptions.GitAttributes && base == gitattributesFile)
+}
+
+// loadBranchRules returns the rules of the tree of each branch of
+// opts.BuildOptions.RepositoryDescription.
+func loadBranchRules(opts Options, repo *git.Repository) (map[string]*treeRules, error) {
+	rules := map[string]*treeRules{}
+	for _, br := range opts.BuildOptions.RepositoryDescription.Branches {
+		commit, err := repo.CommitObject(plumbing.NewHash(br.Version))
+		if err != nil {
+			return nil, fmt.Errorf("branch %s: %w", br.Name, err)
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("branch %s: %w", br.Name, err)
+		}
+		if rules[br.Name], err = loadTreeRules(repo, tree, opts.BuildOptions.Gitignore, opts.BuildOptions.GitAttributes); err != nil {
+			return nil, fmt.Errorf("branch %s: %w", br.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+// removeIgnored removes the branches from branchMap on which a file is
+// ignored by .gitignore files, and the files ignored on all branches.
+func removeIgnored(repos map[fileKey]BlobLocation, branchMap map[fileKey][]string, rules map[string]*treeRules) {
+	for key, brs := range branchMap {
+		if key.SubRepoPath != "" {
+			continue
+		}
+		kept := brs[:0]
+		for _, b := range brs {
+			if r := rules[b]; r == nil || !r.ignore.Match(key.Path, false) {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) == 0 {
+			delete(repos, key)
+			delete(branchMap, key)
+		} else {
+			branchMap[key] = kept
+		}
+	}
+}
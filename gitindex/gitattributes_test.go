@@ -0,0 +1,140 @@
+package gitindex
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func createGitignoreRepo(t *testing.T, dir string) {
+	t.Helper()
+	script := `mkdir repo
+cd repo
+git init -b master
+git config user.email "you@example.com"
+git config user.name "Your Name"
+printf 'build/\n*.log\n!keep.log\n' > .gitignore
+printf '*.tpl linguist-language=Go\n*.dat binary\n' > .gitattributes
+mkdir build sub
+echo "needle" > build/out.txt
+echo "needle" > debug.log
+echo "needle" > keep.log
+echo "needle" > main.tpl
+echo "needle" > blob.dat
+echo "needle" > sub/a.txt
+printf '/a.txt\n' > sub/.gitignore
+git add -f .
+git commit -m "initial"
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+}
+
+// searchNeedle returns the file names and languages of the matches of
+// "needle" in indexDir.
+func searchNeedle(t *testing.T, indexDir string) []string {
+	t.Helper()
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	res, err := searcher.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].FileName < res.Files[j].FileName })
+
+	var got []string
+	for _, f := range res.Files {
+		got = append(got, f.FileName+":"+f.Language)
+	}
+	return got
+}
+
+func TestGitignoreAndAttributes(t *testing.T) {
+	dir := t.TempDir()
+	createGitignoreRepo(t, dir)
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+	buildOpts.Gitignore = true
+	buildOpts.GitAttributes = true
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	got := searchNeedle(t, indexDir)
+	want := []string{"keep.log:", "main.tpl:Go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGitignoreToggleRebuilds(t *testing.T) {
+	dir := t.TempDir()
+	createGitignoreRepo(t, dir)
+	indexDir := t.TempDir()
+
+	index := func(gitignore, gitattributes bool) {
+		t.Helper()
+		buildOpts := build.Options{
+			IndexDir:              indexDir,
+			RepositoryDescription: zoekt.Repository{Name: "repo"},
+			Gitignore:             gitignore,
+			GitAttributes:         gitattributes,
+		}
+		buildOpts.SetDefaults()
+		if err := IndexGitRepo(Options{
+			RepoDir:      filepath.Join(dir, "repo"),
+			BuildOptions: buildOpts,
+			BranchPrefix: "refs/heads/",
+			Branches:     []string{"master"},
+			Incremental:  true,
+		}); err != nil {
+			t.Fatalf("IndexGitRepo: %v", err)
+		}
+	}
+
+	all := []string{"blob.dat:", "build/out.txt:Text", "debug.log:", "keep.log:", "main.tpl:Smarty", "sub/a.txt:Text"}
+	for _, tc := range []struct {
+		gitignore, gitattributes bool
+		want                     []string
+	}{
+		{false, false, all},
+		{true, false, []string{"blob.dat:", "keep.log:", "main.tpl:Smarty"}},
+		{true, true, []string{"keep.log:", "main.tpl:Go"}},
+		{false, true, []string{"build/out.txt:Text", "debug.log:", "keep.log:", "main.tpl:Go", "sub/a.txt:Text"}},
+		{false, false, all},
+	} {
+		index(tc.gitignore, tc.gitattributes)
+		if got := searchNeedle(t, indexDir); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("gitignore=%t gitattributes=%t: got %v, want %v", tc.gitignore, tc.gitattributes, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gitindex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+)
+
+// indexHistory builds the history shards of repo, which hold the messages
+// and the diffs of the last opts.IndexHistory commits of each branch of
+// opts.BuildOptions.RepositoryDescription.
+func indexHistory(opts Options, repo *git.Repository) error {
+	bo := opts.BuildOptions
+	bo.History = true
+	bo.IsDelta = false
+	// Symbols and extracted content are meaningless for diffs.
+	bo.DisableCTags = true
+	bo.NativeSymbols = false
+	bo.SymbolRefs = false
+	bo.SCIPIndexPath = ""
+	bo.ContentExtractors = nil
+	bo.DocumentRanksPath = ""
+	bo.SubRepositories = nil
+
+	if opts.Incremental && bo.IncrementalSkipIndexing() {
+		return nil
+	}
+
+	commits, branches, err := historyCommits(repo, bo.RepositoryDescription.Branches, opts.IndexHistory)
+	if err != nil {
+		return err
+	}
+
+	builder, err := build.NewBuilder(bo)
+	if err != nil {
+		return fmt.Errorf("build.NewBuilder: %w", err)
+	}
+	defer builder.Finish() // nolint:errcheck
+
+	for _, c := range commits {
+		docs, err := commitDocuments(c)
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", c.Hash, err)
+		}
+		for _, doc := range docs {
+			doc.Branches = branches[c.Hash]
+			if err := builder.Add(doc); err != nil {
+				return fmt.Errorf("error adding history of commit %s: %w", c.Hash, err)
+			}
+		}
+	}
+	return builder.Finish()
+}
+
+// historyCommits returns the last n commits of each branch, newest first,
+// and the branches each commit was found on.
+func historyCommits(repo *git.Repository, branches []zoekt.RepositoryBranch, n int) ([]*object.Commit, map[plumbing.Hash][]string, error) {
+	var commits []*object.Commit
+	commitBranches := map[plumbing.Hash][]string{}
+	for _, br := range branches {
+		iter, err := repo.Log(&git.LogOptions{
+			From:  plumbing.NewHash(br.Version),
+			Order: git.LogOrderCommitterTime,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("log of branch %s: %w", br.Name, err)
+		}
+		for i := 0; i < n; i++ {
+			c, err := iter.Next()
+			if err != nil {
+				// io.EOF at the root of the history.
+				break
+			}
+			if _, ok := commitBranches[c.Hash]; !ok {
+				commits = append(commits, c)
+			}
+			commitBranches[c.Hash] = append(commitBranches[c.Hash], br.Name)
+		}
+		iter.Close()
+	}
+	return commits, commitBranches, nil
+}
+
+// commitDocuments returns a document for the message of c and one for the
+// diff of each file c changed. Merge commits are diffed against their first
+// parent.
+func commitDocuments(c *object.Commit) ([]zoekt.Document, error) {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	meta := zoekt.Commit{
+		Hash:    c.Hash.String(),
+		Author:  formatAuthor(c.Author),
+		Date:    c.Author.When,
+		Subject: subject,
+	}
+
+	msg := meta
+	msg.Kind = zoekt.CommitKindMessage
+	docs := []zoekt.Document{{
+		Name:     meta.Hash,
+		Content:  []byte(c.Message),
+		Language: "Text",
+		Commit:   &msg,
+	}}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range changes {
+		if ch.From.TreeEntry.Mode == filemode.Submodule || ch.To.TreeEntry.Mode == filemode.Submodule {
+			continue
+		}
+		patch, err := ch.Patch()
+		if err != nil {
+			return nil, err
+		}
+		for _, fp := range patch.FilePatches() {
+			if fp.IsBinary() {
+				continue
+			}
+			from, to := fp.Files()
+			name := ""
+			if to != nil {
+				name = to.Path()
+			} else if from != nil {
+				name = from.Path()
+			}
+
+			var buf bytes.Buffer
+			if err := diff.NewUnifiedEncoder(&buf, diff.DefaultContextLines).Encode(filePatch{fp}); err != nil {
+				return nil, err
+			}
+
+			d := meta
+			d.Kind = zoekt.CommitKindDiff
+			docs = append(docs, zoekt.Document{
+				Name:     name,
+				Content:  buf.Bytes(),
+				Language: "Diff",
+				Commit:   &d,
+			})
+		}
+	}
+	return docs, nil
+}
+
+// filePatch is a diff.Patch of a single file, so each file is encoded
+// separately.
+type filePatch struct {
+	fp diff.FilePatch
+}
+
+func (p filePatch) FilePatches() []diff.FilePatch { return []diff.FilePatch{p.fp} }
+func (p filePatch) Message() string               { return "" }
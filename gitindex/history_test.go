@@ -0,0 +1,124 @@
+package gitindex
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func createHistoryRepo(t *testing.T, dir string) {
+	script := `mkdir repo
+cd repo
+git init -b master
+git config user.email "alice@example.com"
+git config user.name "Alice"
+echo "package main" > main.go
+git add main.go
+GIT_AUTHOR_DATE="2021-10-01T10:00:00Z" git commit -m "initial import"
+
+echo "func cleanup() {}" >> main.go
+GIT_AUTHOR_DATE="2021-10-02T10:00:00Z" git commit -am "add cleanup"
+
+echo "func frobnicate() {}" >> main.go
+echo "notes" > README
+git add README
+GIT_AUTHOR_DATE="2021-10-03T10:00:00Z" git commit -am "add frobnicate
+
+It frobs." --author "Bob <bob@example.com>"
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+}
+
+func TestIndexHistory(t *testing.T) {
+	dir := t.TempDir()
+	createHistoryRepo(t, dir)
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+		IndexHistory: 2,
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	historyOpts := buildOpts
+	historyOpts.History = true
+	if shards := historyOpts.FindAllShards(); len(shards) != 1 {
+		t.Fatalf("got history shards %v, want 1", shards)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	search := func(q string) []zoekt.FileMatch {
+		t.Helper()
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].FileName < res.Files[j].FileName })
+		return res.Files
+	}
+
+	// Only the file itself without history atoms.
+	if got := search("frobnicate"); len(got) != 1 || got[0].Commit != nil {
+		t.Errorf("got %v, want main.go without commit", got)
+	}
+
+	got := search("type:commit frob")
+	if len(got) != 1 {
+		t.Fatalf("got %v, want 1 commit", got)
+	}
+	if c := got[0].Commit; c == nil || c.Kind != zoekt.CommitKindMessage || c.Author != "Bob <bob@example.com>" || c.Subject != "add frobnicate" || got[0].FileName != c.Hash {
+		t.Errorf("got %+v with commit %+v", got[0], got[0].Commit)
+	}
+
+	got = search("type:diff cleanup author:alice")
+	if len(got) != 1 || got[0].FileName != "main.go" || got[0].Commit.Kind != zoekt.CommitKindDiff || got[0].Commit.Subject != "add cleanup" {
+		t.Errorf("got %v, want diff of main.go by add cleanup", got)
+	}
+
+	got = search("type:diff author:bob")
+	if len(got) != 2 || got[0].FileName != "README" || got[1].FileName != "main.go" {
+		t.Errorf("got %v, want diffs of README and main.go", got)
+	}
+
+	if got := search("type:commit before:2021-10-03"); len(got) != 1 || got[0].Commit.Subject != "add cleanup" {
+		t.Errorf("got %v, want add cleanup", got)
+	}
+
+	// Beyond IndexHistory.
+	if got := search("type:commit initial"); len(got) != 0 {
+		t.Errorf("got %v, want no match for the initial commit", got)
+	}
+}
@@ -320,6 +320,18 @@ type Options struct {
 	// If DeltaShardNumberFallbackThreshold is 0, then this fallback behavior is disabled:
 	// a delta build will always be performed regardless of the number of preexisting shards.
 	DeltaShardNumberFallbackThreshold uint64
+
+	// IndexHistory is the number of most recent commits of each branch
+	// whose messages and diffs are indexed into separate history shards.
+	// They are searched with type:commit, type:diff, author:, before: and
+	// after:. If 0, no history is indexed.
+	IndexHistory int
+
+	// Snapshots are revisions, eg. release tags, whose files are indexed
+	// into snapshot shards next to the shards of Branches, see
+	// build.Options.Snapshot. Snapshots are only rebuilt if their revision
+	// moved, and they are kept when they are removed from Snapshots.
+	Snapshots []string
 }
 
 func expandBranches(repo *git.Repository, bs []string, prefix string) ([]string, error) {
@@ -371,6 +383,19 @@ func expandBranches(repo *git.Repository, bs []string, prefix string) ([]string,
 	return result, nil
 }
 
+// indexSnapshot builds the snapshot shards of the revision rev.
+func indexSnapshot(opts Options, config gitIndexConfig, rev string) error {
+	opts.Branches = []string{rev}
+	opts.Snapshots = nil
+	opts.IndexHistory = 0
+	opts.AllowMissingBranch = false
+	// The files of a revision don't change, so snapshots are only built once.
+	opts.Incremental = true
+	opts.BuildOptions.IsDelta = false
+	opts.BuildOptions.Snapshot = rev
+	return indexGitRepo(opts, config)
+}
+
 // IndexGitRepo indexes the git repository as specified by the options.
 func IndexGitRepo(opts Options) error {
 	return indexGitRepo(opts, gitIndexConfig{})
@@ -404,6 +429,12 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 		log.Printf("setTemplatesFromConfig(%s): %s", opts.RepoDir, err)
 	}
 
+	for _, rev := range opts.Snapshots {
+		if err := indexSnapshot(opts, config, rev); err != nil {
+			return fmt.Errorf("indexSnapshot(%s): %w", rev, err)
+		}
+	}
+
 	branches, err := expandBranches(repo, opts.Branches, opts.BranchPrefix)
 	if err != nil {
 		return fmt.Errorf("expandBranches: %w", err)
@@ -428,6 +459,12 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 		}
 	}
 
+	if opts.IndexHistory > 0 {
+		if err := indexHistory(opts, repo); err != nil {
+			return fmt.Errorf("indexHistory: %w", err)
+		}
+	}
+
 	if opts.Incremental && opts.BuildOptions.IncrementalSkipIndexing() {
 		return nil
 	}
@@ -489,6 +526,16 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 		}
 	}
 
+	var rules map[string]*treeRules
+	if opts.BuildOptions.Gitignore || opts.BuildOptions.GitAttributes {
+		if rules, err = loadBranchRules(opts, repo); err != nil {
+			return fmt.Errorf("loadBranchRules: %w", err)
+		}
+		if opts.BuildOptions.Gitignore {
+			removeIgnored(repos, branchMap, rules)
+		}
+	}
+
 	builder, err := build.NewBuilder(opts.BuildOptions)
 	if err != nil {
 		return fmt.Errorf("build.NewBuilder: %w", err)
@@ -526,6 +573,18 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 	sort.Strings(names)
 	names = uniq(names)
 
+	var blamer *blamer
+	// The commit which last modified each line is returned with
+	// zoekt.SearchOptions.IncludeBlame. Files found on several branches are
+	// blamed on the first one. Files of submodules aren't blamed.
+	if opts.BuildOptions.Blame {
+		versions := map[string]plumbing.Hash{}
+		for _, br := range opts.BuildOptions.RepositoryDescription.Branches {
+			versions[br.Name] = plumbing.NewHash(br.Version)
+		}
+		blamer = newBlamer(repo, versions)
+	}
+
 	for _, name := range names {
 		keys := fileKeys[name]
 
@@ -554,13 +613,27 @@ func indexGitRepo(opts Options, config gitIndexConfig) error {
 			if err != nil {
 				return err
 			}
-			if err := builder.Add(zoekt.Document{
+
+			var blame []zoekt.BlameHunk
+			if blamer != nil && key.SubRepoPath == "" && len(brs) > 0 {
+				if blame, err = blamer.blame(brs[0], key.Path); err != nil {
+					log.Printf("blame %s: %v", keyFullPath, err)
+				}
+			}
+
+			doc := zoekt.Document{
 				SubRepositoryPath: key.SubRepoPath,
 				Name:              keyFullPath,
 				Content:           contents,
 				Branches:          brs,
 				Ranks:             ranks[keyFullPath],
-			}); err != nil {
+				Blame:             blame,
+			}
+			if opts.BuildOptions.GitAttributes && key.SubRepoPath == "" && len(brs) > 0 {
+				rules[brs[0]].applyAttributes(&doc, key.Path)
+			}
+
+			if err := builder.Add(doc); err != nil {
 				return fmt.Errorf("error adding document with name %s: %w", keyFullPath, err)
 			}
 		}
@@ -732,6 +805,9 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 				if newFileRelativeRootPath == ignore.IgnoreFile {
 					return nil, nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
 				}
+				if isRulesFile(options, newFileRelativeRootPath) {
+					return nil, nil, nil, nil, fmt.Errorf("changes of %q aren't supported in delta builds", newFileRelativeRootPath)
+				}
 
 				// either file is added or renamed, so we need to add the new version to the build
 				file := fileKey{Path: newFileRelativeRootPath, ID: newFile.Hash}
@@ -751,6 +827,9 @@ func prepareDeltaBuild(options Options, repository *git.Repository) (repos map[f
 			if oldFileRelativeRootPath == ignore.IgnoreFile {
 				return nil, nil, nil, nil, fmt.Errorf("%q file is not yet supported in delta builds", ignore.IgnoreFile)
 			}
+			if isRulesFile(options, oldFileRelativeRootPath) {
+				return nil, nil, nil, nil, fmt.Errorf("changes of %q aren't supported in delta builds", oldFileRelativeRootPath)
+			}
 
 			// The file is either modified or deleted. So, we need to add ALL versions
 			// of the old file (across all branches) to the build.
@@ -0,0 +1,116 @@
+package gitindex
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func TestIndexSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	script := `mkdir repo
+cd repo
+git init -b master
+git config user.email "alice@example.com"
+git config user.name "Alice"
+echo "package main" > main.go
+git add main.go
+GIT_COMMITTER_DATE="2021-10-01T10:00:00Z" git commit -m "initial import"
+git tag v1
+
+echo "func cleanup() {}" >> main.go
+GIT_COMMITTER_DATE="2021-10-02T10:00:00Z" git commit -am "add cleanup"
+git tag -a v2 -m "release v2"
+
+echo "func frobnicate() {}" >> main.go
+GIT_COMMITTER_DATE="2021-10-03T10:00:00Z" git commit -am "add frobnicate"
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	buildOpts := build.Options{
+		IndexDir: indexDir,
+		RepositoryDescription: zoekt.Repository{
+			Name: "repo",
+		},
+	}
+	buildOpts.SetDefaults()
+
+	opts := Options{
+		RepoDir:      filepath.Join(dir, "repo"),
+		BuildOptions: buildOpts,
+		BranchPrefix: "refs/heads/",
+		Branches:     []string{"master"},
+		Snapshots:    []string{"v1", "v2"},
+	}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+
+	// Snapshots dropped from the options are retained.
+	opts.Snapshots = []string{"v2"}
+	if err := IndexGitRepo(opts); err != nil {
+		t.Fatalf("IndexGitRepo: %v", err)
+	}
+	v1Opts := buildOpts
+	v1Opts.Snapshot = "v1"
+	v1Shards := v1Opts.FindAllShards()
+	if len(v1Shards) != 1 {
+		t.Fatalf("got snapshot shards %v, want 1", v1Shards)
+	}
+	if _, err := os.Stat(v1Shards[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	branches := func(q string) []string {
+		t.Helper()
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := searcher.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var branches []string
+		for _, f := range res.Files {
+			branches = append(branches, f.Branches...)
+		}
+		return branches
+	}
+
+	cases := []struct {
+		q    string
+		want []string
+	}{
+		// Snapshots don't pollute default results.
+		{"package", []string{"master"}},
+		{"cleanup at:v1", nil},
+		{"cleanup at:v2", []string{"v2"}},
+		{"package at:2021-10-02T12:00:00Z", []string{"v2"}},
+		{"package at:2021-10-01T12:00:00Z", []string{"v1"}},
+		{"package at:2021-09-01", nil},
+	}
+	for _, tc := range cases {
+		if got := branches(tc.q); len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("%s: got branches %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
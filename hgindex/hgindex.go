@@ -0,0 +1,281 @@
+// Package hgindex provides functions for indexing Mercurial repositories.
+// It runs the hg binary, which must be on the PATH.
+package hgindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+)
+
+// Options controls details of the indexing process.
+type Options struct {
+	// The repository to be indexed.
+	RepoDir string
+
+	// If set, skip indexing if the existing index shard is newer
+	// than the revisions in the repository.
+	Incremental bool
+
+	// Don't error out if some branch is missing
+	AllowMissingBranch bool
+
+	// Indexing options. If BuildOptions.IsDelta is set, only the files
+	// changed since the revisions of the existing shards are indexed.
+	BuildOptions build.Options
+
+	// List of revisions to index, e.g. []string{"default", "stable"}. Any
+	// revision understood by hg can be used.
+	Branches []string
+
+	// DeltaShardNumberFallbackThreshold is the number of preexisting shards
+	// above which a normal build is done instead of a delta build. If 0, a
+	// delta build is always attempted.
+	DeltaShardNumberFallbackThreshold uint64
+}
+
+// errUnknownRevision is returned by resolve for revisions which don't exist.
+var errUnknownRevision = errors.New("unknown revision")
+
+// archivePrefix is the directory in which hg archive puts the files.
+const archivePrefix = "zoekt/"
+
+type fileKey struct {
+	path string
+	hash [sha1.Size]byte
+}
+
+// IndexHgRepo indexes the Mercurial repository as specified by the options.
+func IndexHgRepo(opts Options) error {
+	// Set max thresholds, since we use them in this function.
+	opts.BuildOptions.SetDefaults()
+	if opts.RepoDir == "" {
+		return fmt.Errorf("hgindex: must set RepoDir")
+	}
+	opts.BuildOptions.RepositoryDescription.Source = opts.RepoDir
+
+	for _, b := range opts.Branches {
+		node, date, err := resolve(opts.RepoDir, b)
+		if err != nil {
+			if opts.AllowMissingBranch && errors.Is(err, errUnknownRevision) {
+				continue
+			}
+			return fmt.Errorf("resolve %s: %w", b, err)
+		}
+
+		opts.BuildOptions.RepositoryDescription.Branches = append(opts.BuildOptions.RepositoryDescription.Branches, zoekt.RepositoryBranch{
+			Name:    b,
+			Version: node,
+		})
+		if date.After(opts.BuildOptions.RepositoryDescription.LatestCommitDate) {
+			opts.BuildOptions.RepositoryDescription.LatestCommitDate = date
+		}
+	}
+
+	if opts.Incremental && opts.BuildOptions.IncrementalSkipIndexing() {
+		return nil
+	}
+
+	var changed map[string]bool
+	if opts.BuildOptions.IsDelta {
+		var err error
+		if changed, err = changedFiles(opts); err != nil {
+			log.Printf("delta build: falling back to normal build since delta build failed, repository=%q, err=%s", opts.BuildOptions.RepositoryDescription.Name, err)
+			opts.BuildOptions.IsDelta = false
+		}
+	}
+
+	contents := map[fileKey][]byte{}
+	branchMap := map[fileKey][]string{}
+	var keys []fileKey
+	for _, br := range opts.BuildOptions.RepositoryDescription.Branches {
+		err := archive(opts.RepoDir, br.Version, func(name string, content []byte) {
+			if changed != nil && !changed[name] {
+				return
+			}
+			key := fileKey{path: name, hash: sha1.Sum(content)}
+			if _, ok := branchMap[key]; !ok {
+				contents[key] = content
+				keys = append(keys, key)
+			}
+			branchMap[key] = append(branchMap[key], br.Name)
+		})
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", br.Name, err)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].path < keys[j].path })
+
+	builder, err := build.NewBuilder(opts.BuildOptions)
+	if err != nil {
+		return fmt.Errorf("build.NewBuilder: %w", err)
+	}
+	// we don't need to check error, since we either already have an error, or
+	// we returning the first call to builder.Finish.
+	defer builder.Finish() // nolint:errcheck
+
+	changedNames := make([]string, 0, len(changed))
+	for name := range changed {
+		changedNames = append(changedNames, name)
+	}
+	sort.Strings(changedNames)
+	for _, name := range changedNames {
+		builder.MarkFileAsChangedOrRemoved(name)
+	}
+
+	for _, key := range keys {
+		content := contents[key]
+		if len(content) > opts.BuildOptions.SizeMax && !opts.BuildOptions.IgnoreSizeMax(key.path) {
+			if err := builder.Add(zoekt.Document{
+				SkipReason: fmt.Sprintf("file size %d exceeds maximum size %d", len(content), opts.BuildOptions.SizeMax),
+				Name:       key.path,
+				Branches:   branchMap[key],
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := builder.Add(zoekt.Document{
+			Name:     key.path,
+			Content:  content,
+			Branches: branchMap[key],
+		}); err != nil {
+			return fmt.Errorf("error adding document with name %s: %w", key.path, err)
+		}
+	}
+	return builder.Finish()
+}
+
+// changedFiles returns the files which were added, modified or removed on
+// any branch since the revisions of the existing shards.
+func changedFiles(opts Options) (map[string]bool, error) {
+	repo, ok, err := opts.BuildOptions.FindRepositoryMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("no existing shards found")
+	}
+	if !build.BranchNamesEqual(repo.Branches, opts.BuildOptions.RepositoryDescription.Branches) {
+		return nil, errors.New("branches changed")
+	}
+	if repo.IndexOptions != opts.BuildOptions.GetHash() {
+		return nil, fmt.Errorf("index options changed, new index options: %+v", opts.BuildOptions.HashOptions())
+	}
+	if t := opts.DeltaShardNumberFallbackThreshold; t > 0 {
+		if n := len(opts.BuildOptions.FindAllShards()); uint64(n) > t {
+			return nil, fmt.Errorf("number of existing shards (%d) > requested shard threshold (%d)", n, t)
+		}
+	}
+
+	old := map[string]string{}
+	for _, br := range repo.Branches {
+		old[br.Name] = br.Version
+	}
+
+	changed := map[string]bool{}
+	for _, br := range opts.BuildOptions.RepositoryDescription.Branches {
+		out, err := hg(opts.RepoDir, "status", "--rev", old[br.Name], "--rev", br.Version, "--modified", "--added", "--removed", "--no-status", "--print0")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Split(string(out), "\x00") {
+			if name != "" {
+				changed[name] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// resolve returns the node and the commit date of rev.
+func resolve(repoDir, rev string) (string, time.Time, error) {
+	out, err := hg(repoDir, "log", "--rev", rev, "--limit", "1", "--template", "{node} {date|hgdate}")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return parseLog(string(out))
+}
+
+// parseLog parses the "{node} {date|hgdate}" template, where the date is
+// the unix time and the offset of the timezone in seconds west of UTC.
+func parseLog(out string) (string, time.Time, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return "", time.Time{}, fmt.Errorf("unexpected hg log output %q", out)
+	}
+	sec, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unexpected hg log output %q: %w", out, err)
+	}
+	offset, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unexpected hg log output %q: %w", out, err)
+	}
+	return fields[0], time.Unix(sec, 0).In(time.FixedZone("", -offset)), nil
+}
+
+// archive calls f with the name and the content of each regular file of
+// the repository at rev.
+func archive(repoDir, rev string, f func(name string, content []byte)) error {
+	out, err := hg(repoDir, "archive", "--config", "ui.archivemeta=false", "--rev", rev, "--type", "tar", "--prefix", archivePrefix, "-")
+	if err != nil {
+		return err
+	}
+	return readTar(bytes.NewReader(out), f)
+}
+
+func readTar(r io.Reader, f func(name string, content []byte)) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), archivePrefix)
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		f(name, content)
+	}
+}
+
+// hg runs hg in repoDir and returns its output.
+func hg(repoDir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("hg", args...)
+	cmd.Dir = repoDir
+	// Don't let the user configuration change the output.
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "unknown revision") {
+			return nil, fmt.Errorf("hg %s: %w: %s", args[0], errUnknownRevision, msg)
+		}
+		return nil, fmt.Errorf("hg %s: %w: %s", args[0], err, msg)
+	}
+	return out, nil
+}
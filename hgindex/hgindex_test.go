@@ -0,0 +1,141 @@
+package hgindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/build"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+func TestParseLog(t *testing.T) {
+	node, date, err := parseLog("0123456789abcdef0123456789abcdef01234567 1633082400 -7200\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("got node %q", node)
+	}
+	if want := time.Date(2021, 10, 1, 10, 0, 0, 0, time.UTC); !date.Equal(want) {
+		t.Errorf("got date %v, want %v", date, want)
+	}
+	if _, offset := date.Zone(); offset != 7200 {
+		t.Errorf("got zone offset %d, want 7200", offset)
+	}
+
+	for _, out := range []string{"", "abc 123", "abc x 0", "abc 123 y"} {
+		if _, _, err := parseLog(out); err == nil {
+			t.Errorf("parseLog(%q): got no error", out)
+		}
+	}
+}
+
+func TestReadTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: archivePrefix, Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: archivePrefix + "dir/a.go", Typeflag: tar.TypeReg, Mode: 0o644, Size: 9},
+		{Name: archivePrefix + "link", Typeflag: tar.TypeSymlink, Linkname: "dir/a.go"},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("package a"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	if err := readTar(&buf, func(name string, content []byte) { got[name] = string(content) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["dir/a.go"] != "package a" {
+		t.Errorf("got %v, want dir/a.go only", got)
+	}
+}
+
+func TestIndexHgRepo(t *testing.T) {
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg not found")
+	}
+
+	dir := t.TempDir()
+	script := `hg init repo
+cd repo
+echo "package main // needle" > main.go
+echo "notes" > README
+hg add main.go README
+hg commit -u "Alice <alice@example.com>" -d "2021-10-01 10:00 +0000" -m "initial import"
+hg branch stable
+echo "package main // haystack" > main.go
+hg commit -u "Alice <alice@example.com>" -d "2021-10-02 10:00 +0000" -m "stable"
+`
+	cmd := exec.Command("/bin/sh", "-euxc", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("execution error: %v, output %s", err, out)
+	}
+
+	indexDir := t.TempDir()
+	opts := Options{
+		RepoDir: filepath.Join(dir, "repo"),
+		BuildOptions: build.Options{
+			IndexDir: indexDir,
+			RepositoryDescription: zoekt.Repository{
+				Name: "repo",
+			},
+		},
+		Branches:           []string{"default", "stable", "missing"},
+		AllowMissingBranch: true,
+	}
+	if err := IndexHgRepo(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	searcher, err := shards.NewDirectorySearcher(indexDir)
+	if err != nil {
+		t.Fatal("NewDirectorySearcher", err)
+	}
+	defer searcher.Close()
+
+	for q, want := range map[string]string{
+		"needle branch:default":  "default",
+		"haystack branch:stable": "stable",
+		"notes":                  "default,stable",
+	} {
+		res, err := searcher.Search(context.Background(), mustParse(t, q), &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != 1 {
+			t.Errorf("%s: got %v, want 1 file", q, res.Files)
+			continue
+		}
+		if got := strings.Join(res.Files[0].Branches, ","); got != want {
+			t.Errorf("%s: got branches %q, want %q", q, got, want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, q string) query.Q {
+	t.Helper()
+	parsed, err := query.Parse(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
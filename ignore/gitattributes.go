@@ -0,0 +1,107 @@
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Values of attributes returned by Attributes.Lookup for attributes which
+// are set or unset rather than set to a value.
+const (
+	AttrSet   = "true"
+	AttrUnset = "false"
+)
+
+// macros holds the attribute macros built into git.
+var macros = map[string][]attr{
+	"binary": {{"diff", AttrUnset}, {"merge", AttrUnset}, {"text", AttrUnset}},
+}
+
+// attr is an attribute of a .gitattributes line. An empty value means the
+// attribute is unspecified, ie. reset to the default.
+type attr struct {
+	name  string
+	value string
+}
+
+type attrRule struct {
+	pattern *gitPattern
+	attrs   []attr
+}
+
+// Attributes holds the patterns and attributes of .gitattributes files.
+// Patterns follow the rules of GitIgnore, except that they can't be negated
+// and patterns ending with a slash match nothing.
+type Attributes struct {
+	rules []attrRule
+}
+
+// Parse adds the rules of the .gitattributes file in dir, which is relative
+// to the root of the repository and empty for the root itself.
+func (a *Attributes) Parse(dir string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+
+		p, err := parseGitPattern(dir, fields[0])
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
+
+		rule := attrRule{pattern: p}
+		for _, f := range fields[1:] {
+			switch {
+			case strings.HasPrefix(f, "-"):
+				rule.attrs = append(rule.attrs, attr{f[1:], AttrUnset})
+			case strings.HasPrefix(f, "!"):
+				rule.attrs = append(rule.attrs, attr{f[1:], ""})
+			case strings.Contains(f, "="):
+				name, value, _ := strings.Cut(f, "=")
+				rule.attrs = append(rule.attrs, attr{name, value})
+			case macros[f] != nil:
+				rule.attrs = append(rule.attrs, macros[f]...)
+			default:
+				rule.attrs = append(rule.attrs, attr{f, AttrSet})
+			}
+		}
+		a.rules = append(a.rules, rule)
+	}
+	sort.SliceStable(a.rules, func(i, j int) bool {
+		return depth(a.rules[i].pattern.dir) < depth(a.rules[j].pattern.dir)
+	})
+	return scanner.Err()
+}
+
+// Lookup returns the attributes of the file at path. Attributes which are
+// set or unset have the value AttrSet or AttrUnset. Unspecified attributes
+// are missing.
+func (a *Attributes) Lookup(path string) map[string]string {
+	if a == nil {
+		return nil
+	}
+	var attrs map[string]string
+	for _, r := range a.rules {
+		if !r.pattern.match(path, false) {
+			continue
+		}
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		for _, at := range r.attrs {
+			if at.value == "" {
+				delete(attrs, at.name)
+			} else {
+				attrs[at.name] = at.value
+			}
+		}
+	}
+	return attrs
+}
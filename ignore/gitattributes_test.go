@@ -0,0 +1,48 @@
+package ignore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+	var a Attributes
+	root := `
+# comment
+*.pb.go     linguist-generated
+vendor/**   linguist-vendored -diff
+*.tpl       linguist-language=Go
+*.png       binary
+dir/        linguist-vendored
+`
+	if err := a.Parse("", strings.NewReader(root)); err != nil {
+		t.Fatal(err)
+	}
+	sub := `
+*.pb.go     -linguist-generated
+*.tpl       !linguist-language
+`
+	if err := a.Parse("sub", strings.NewReader(sub)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"a.go", nil},
+		{"x/a.pb.go", map[string]string{"linguist-generated": AttrSet}},
+		{"vendor/x/a.go", map[string]string{"linguist-vendored": AttrSet, "diff": AttrUnset}},
+		{"a.tpl", map[string]string{"linguist-language": "Go"}},
+		{"img/a.png", map[string]string{"diff": AttrUnset, "merge": AttrUnset, "text": AttrUnset}},
+		{"dir/a.go", nil},
+		{"sub/a.pb.go", map[string]string{"linguist-generated": AttrUnset}},
+		{"sub/a.tpl", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := a.Lookup(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/grafana/regexp"
+)
+
+// gitPattern is a pattern of a .gitignore or .gitattributes file.
+type gitPattern struct {
+	// dir is the directory of the file holding the pattern, relative to
+	// the root of the repository. It is empty for the root directory.
+	dir string
+
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// parseGitPattern parses a pattern of a file in dir. It returns nil for
+// blank lines and comments.
+func parseGitPattern(dir, line string) (*gitPattern, error) {
+	line = trimTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	p := &gitPattern{dir: dir}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+
+	// A pattern with a slash at the beginning or in the middle is relative
+	// to dir, otherwise it matches names at any level below dir.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	expr += globToRegexp(line) + "$"
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	p.re = re
+	return p, nil
+}
+
+// trimTrailingSpace removes trailing spaces unless they are escaped with a
+// backslash.
+func trimTrailingSpace(line string) string {
+	line = strings.TrimRight(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// globToRegexp translates the gitignore glob syntax: * and ? don't match
+// slashes, a ** path component matches any number of directories, and
+// brackets denote character classes.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') {
+				if i+2 == len(glob) {
+					sb.WriteString(".*")
+					return sb.String()
+				}
+				if glob[i+2] == '/' {
+					sb.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := classEnd(glob, i)
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : end]
+			sb.WriteByte('[')
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				sb.WriteByte('^')
+				class = class[1:]
+			}
+			for j := 0; j < len(class); j++ {
+				if class[j] == '\\' && j+1 < len(class) {
+					j++
+				}
+				if strings.IndexByte(`\[]^`, class[j]) >= 0 {
+					sb.WriteByte('\\')
+				}
+				sb.WriteByte(class[j])
+			}
+			sb.WriteByte(']')
+			i = end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String()
+}
+
+// classEnd returns the index of the bracket closing the character class
+// starting at glob[start], or -1.
+func classEnd(glob string, start int) int {
+	i := start + 1
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		i++
+	}
+	// A leading ] is part of the class.
+	if i < len(glob) && glob[i] == ']' {
+		i++
+	}
+	for ; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+		case ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// match reports whether the pattern matches path, which is relative to the
+// root of the repository.
+func (p *gitPattern) match(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.dir != "" {
+		if !strings.HasPrefix(path, p.dir+"/") {
+			return false
+		}
+		path = path[len(p.dir)+1:]
+	}
+	return p.re.MatchString(path)
+}
+
+// depth returns the number of path components of dir.
+func depth(dir string) int {
+	if dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// GitIgnore matches paths against the patterns of .gitignore files,
+// following the semantics of git: patterns may be negated with !, apply to
+// directories only if they end with a slash, and are relative to the
+// directory of their file if they contain a slash. Patterns of files in
+// deeper directories take precedence.
+type GitIgnore struct {
+	patterns []*gitPattern
+}
+
+// Parse adds the patterns of the .gitignore file in dir, which is relative
+// to the root of the repository and empty for the root itself.
+func (g *GitIgnore) Parse(dir string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p, err := parseGitPattern(dir, scanner.Text())
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			g.patterns = append(g.patterns, p)
+		}
+	}
+	sort.SliceStable(g.patterns, func(i, j int) bool {
+		return depth(g.patterns[i].dir) < depth(g.patterns[j].dir)
+	})
+	return scanner.Err()
+}
+
+// Match returns true if path is ignored. A file is ignored if any of its
+// parent directories is, since git can't re-include files of ignored
+// directories.
+func (g *GitIgnore) Match(path string, isDir bool) bool {
+	if g == nil || len(g.patterns) == 0 {
+		return false
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && g.match(path[:i], true) {
+			return true
+		}
+	}
+	return g.match(path, isDir)
+}
+
+// match applies the last pattern matching path.
+func (g *GitIgnore) match(path string, isDir bool) bool {
+	for i := len(g.patterns) - 1; i >= 0; i-- {
+		if p := g.patterns[i]; p.match(path, isDir) {
+			return !p.negate
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGitIgnore(t *testing.T) {
+	var g GitIgnore
+	root := `
+# comment
+*.log
+!keep.log
+build/
+/root-only.txt
+docs/**/*.pdf
+**/tmp
+\#hash
+trailing\ 
+file[0-9].txt
+`
+	if err := g.Parse("", strings.NewReader(root)); err != nil {
+		t.Fatal(err)
+	}
+	sub := `
+!*.log
+/local
+`
+	if err := g.Parse("sub", strings.NewReader(sub)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"a.log", false, true},
+		{"dir/a.log", false, true},
+		{"keep.log", false, false},
+		{"dir/keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"build/out.go", false, true},
+		{"src/build/out.go", false, true},
+		{"root-only.txt", false, true},
+		{"dir/root-only.txt", false, false},
+		{"docs/a.pdf", false, true},
+		{"docs/x/y/a.pdf", false, true},
+		{"other/a.pdf", false, false},
+		{"tmp", true, true},
+		{"a/b/tmp/c.go", false, true},
+		{"#hash", false, true},
+		{"comment", false, false},
+		{"trailing ", false, true},
+		{"trailing", false, false},
+		{"file1.txt", false, true},
+		{"filex.txt", false, false},
+		// Deeper files take precedence.
+		{"sub/a.log", false, false},
+		{"sub/local", false, true},
+		{"local", false, false},
+		{"sub/x/local", false, false},
+		// Files of ignored directories can't be re-included.
+		{"build/keep.log", false, true},
+	}
+	for _, tt := range tests {
+		if got := g.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, %t) = %t, want %t", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	var empty *GitIgnore
+	if empty.Match("a.log", false) {
+		t.Error("nil GitIgnore matched")
+	}
+}
@@ -1,4 +1,5 @@
-// package ignore provides helpers to support ignore-files similar to .gitignore
+// package ignore provides helpers to support ignore-files similar to .gitignore,
+// as well as .gitignore and .gitattributes files themselves.
 package ignore
 
 import (
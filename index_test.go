@@ -21,8 +21,10 @@ import (
 	"os"
 	"reflect"
 	"regexp/syntax"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -1775,7 +1777,7 @@ func TestListRepos(t *testing.T) {
 			Stats: RepoStats{
 				Shards:                     1,
 				Documents:                  4,
-				IndexBytes:                 300,
+				IndexBytes:                 332,
 				ContentBytes:               68,
 				NewLinesCount:              4,
 				DefaultBranchNewLinesCount: 2,
@@ -1784,7 +1786,10 @@ func TestListRepos(t *testing.T) {
 		}
 
 		if os.Getenv("ZOEKT_ENABLE_NGRAM_BS") != "" {
-			want.Stats.IndexBytes = 228
+			want.Stats.IndexBytes = 260
+		}
+		if os.Getenv("ZOEKT_DISABLE_NGRAM_FILTER") != "" {
+			want.Stats.IndexBytes -= 32
 		}
 
 		if diff := cmp.Diff(want, res); diff != "" {
@@ -2881,6 +2886,333 @@ func TestSymbolRegexpAll(t *testing.T) {
 	})
 }
 
+func TestSymbolID(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{
+			Name:            "f1",
+			Content:         []byte("func foo() {}\nfunc bar() {}"),
+			Symbols:         []DocumentSection{{19, 22}, {5, 8}},
+			SymbolsMetaData: []*Symbol{{Kind: "func", ID: "go . . . bar()."}, {Kind: "func"}},
+		},
+		Document{
+			Name:            "f2",
+			Content:         []byte("func bar() {}"),
+			Symbols:         []DocumentSection{{5, 8}},
+			SymbolsMetaData: []*Symbol{{Kind: "func"}},
+		},
+	)
+
+	q := &query.Symbol{Expr: &query.Substring{Pattern: "bar"}}
+	res := searchForTest(t, b, q, chunkOpts)
+	if len(res.Files) != 2 {
+		t.Fatalf("got %v, want 2 files", res.Files)
+	}
+	got := map[string]string{}
+	for _, f := range res.Files {
+		for _, si := range f.ChunkMatches[0].SymbolInfo {
+			got[f.FileName] = si.ID
+		}
+	}
+	want := map[string]string{"f1": "go . . . bar().", "f2": ""}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSymbolRef(t *testing.T) {
+	content := []byte("func bar() {}\nfunc foo() { bar() }")
+	// ----------------01234567890123 45678901234567890123
+
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{
+			Name:       "f1",
+			Content:    content,
+			Symbols:    []DocumentSection{{5, 8}, {19, 22}},
+			SymbolRefs: []DocumentSection{{27, 30}},
+		},
+		Document{
+			Name:    "f2",
+			Content: []byte("bar"),
+		},
+	)
+
+	for _, q := range []query.Q{
+		&query.SymbolRef{Expr: &query.Substring{Pattern: "bar"}},
+		&query.SymbolRef{Expr: &query.Regexp{Regexp: mustParseRE("^ba")}},
+	} {
+		t.Run(q.String(), func(t *testing.T) {
+			res := searchForTest(t, b, q, chunkOpts)
+			if len(res.Files) != 1 || len(res.Files[0].ChunkMatches) != 1 {
+				t.Fatalf("got %v, want 1 chunk in 1 file", res.Files)
+			}
+			m := res.Files[0].ChunkMatches[0]
+			if got := m.Ranges[0].Start.ByteOffset; got != 27 {
+				t.Errorf("got offset %d, want 27", got)
+			}
+			want := []*Symbol{{Sym: "bar", Ref: true}}
+			if diff := cmp.Diff(want, m.SymbolInfo); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("LineMatches", func(t *testing.T) {
+		q := &query.SymbolRef{Expr: &query.Substring{Pattern: "bar"}}
+		res := searchForTest(t, b, q)
+		if len(res.Files) != 1 || len(res.Files[0].LineMatches) != 1 {
+			t.Fatalf("got %v, want 1 line in 1 file", res.Files)
+		}
+		f := res.Files[0].LineMatches[0].LineFragments[0]
+		if f.Offset != 27 || f.SymbolInfo == nil || !f.SymbolInfo.Ref {
+			t.Errorf("got %+v, want reference at offset 27", f)
+		}
+	})
+
+	t.Run("NoRefs", func(t *testing.T) {
+		b := testIndexBuilder(t, &Repository{Name: "reponame"},
+			Document{Name: "f1", Content: content, Symbols: []DocumentSection{{5, 8}}})
+		res := searchForTest(t, b, &query.SymbolRef{Expr: &query.Substring{Pattern: "bar"}})
+		if len(res.Files) != 0 {
+			t.Errorf("got %v, want no matches", res.Files)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{Name: "main.go", Content: []byte("fix the bug")},
+		Document{
+			Name:    "0123abcd",
+			Content: []byte("fix the bug\n"),
+			Commit:  &Commit{Kind: CommitKindMessage, Hash: "0123abcd", Author: "Alice <alice@example.com>", Date: day(2)},
+		},
+		Document{
+			Name:    "main.go",
+			Content: []byte("+fix the bug\n"),
+			Commit:  &Commit{Kind: CommitKindDiff, Hash: "0123abcd", Author: "Alice <alice@example.com>", Date: day(2)},
+		},
+		Document{
+			Name:    "4567cdef",
+			Content: []byte("fix the bug again\n"),
+			Commit:  &Commit{Kind: CommitKindMessage, Hash: "4567cdef", Author: "Bob <bob@example.com>", Date: day(5)},
+		},
+	)
+
+	fix := &query.Substring{Pattern: "fix"}
+	for _, tc := range []struct {
+		q    query.Q
+		want []string
+	}{
+		{fix, []string{"main.go"}},
+		{query.NewAnd(fix, &query.HistoryType{Kind: "commit"}), []string{"0123abcd", "4567cdef"}},
+		{query.NewAnd(fix, &query.HistoryType{Kind: "diff"}), []string{"main.go"}},
+		{query.NewAnd(fix, &query.Author{Pattern: "BOB"}), []string{"4567cdef"}},
+		{query.NewAnd(fix, &query.CommitDate{Time: day(3), Before: true}), []string{"0123abcd", "main.go"}},
+		{query.NewAnd(fix, &query.CommitDate{Time: day(3)}), []string{"4567cdef"}},
+	} {
+		t.Run(tc.q.String(), func(t *testing.T) {
+			res := searchForTest(t, b, tc.q)
+			var got []string
+			for _, f := range res.Files {
+				got = append(got, f.FileName)
+				if (f.Commit != nil) != query.IsHistory(tc.q) {
+					t.Errorf("%s: got commit %v", f.FileName, f.Commit)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	res := searchForTest(t, b, &query.Author{Pattern: "bob"})
+	want := &Commit{Kind: CommitKindMessage, Hash: "4567cdef", Author: "Bob <bob@example.com>", Date: day(5)}
+	if len(res.Files) != 1 || !reflect.DeepEqual(res.Files[0].Commit, want) {
+		t.Errorf("got %v, want a match with commit %v", res.Files, want)
+	}
+}
+
+func TestHistoryList(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "reponame", ID: 1},
+		Document{
+			Name:    "0123abcd",
+			Content: []byte("fix the bug\n"),
+			Commit:  &Commit{Kind: CommitKindMessage, Hash: "0123abcd", Author: "Alice <alice@example.com>"},
+		},
+	)
+	searcher := searcherForTest(t, b)
+	defer searcher.Close()
+
+	for _, tc := range []struct {
+		q    query.Q
+		want int
+	}{
+		{&query.Const{Value: true}, 0},
+		{&query.Repo{Regexp: regexp.MustCompile("reponame")}, 0},
+		{&query.HistoryType{}, 1},
+	} {
+		rl, err := searcher.List(context.Background(), tc.q, &ListOptions{Minimal: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Minimal entries are keyed by ID, so history shards are never
+		// minimal.
+		if len(rl.Minimal) != 0 || len(rl.Repos) != tc.want {
+			t.Errorf("List(%s): got %d repos and %d minimal entries, want %d and 0", tc.q, len(rl.Repos), len(rl.Minimal), tc.want)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	b := testIndexBuilderCompound(t,
+		[]*Repository{
+			{Name: "live", ID: 1},
+			{
+				Name:             "snap",
+				ID:               2,
+				Snapshot:         "v1",
+				Branches:         []RepositoryBranch{{Name: "v1", Version: "0123abcd"}},
+				LatestCommitDate: day(2),
+			},
+		},
+		[][]Document{
+			{{Name: "main.go", Content: []byte("fix the bug")}},
+			{{Name: "main.go", Content: []byte("fix the bug")}},
+		},
+	)
+
+	fix := &query.Substring{Pattern: "fix"}
+	for _, tc := range []struct {
+		q    query.Q
+		want []string
+	}{
+		{fix, []string{"live"}},
+		{query.NewAnd(fix, &query.At{}), []string{"snap"}},
+		{query.NewAnd(fix, &query.At{Revision: "v1"}), []string{"snap"}},
+		{query.NewAnd(fix, &query.At{Revision: "0123abcd"}), []string{"snap"}},
+		{query.NewAnd(fix, &query.At{Revision: "v2"}), nil},
+		{query.NewAnd(fix, &query.At{Time: day(3)}), []string{"snap"}},
+		{query.NewAnd(fix, &query.At{Time: day(1)}), nil},
+		{query.NewOr(fix, &query.At{Revision: "v1"}), []string{"live", "snap"}},
+	} {
+		t.Run(tc.q.String(), func(t *testing.T) {
+			res := searchForTest(t, b, tc.q)
+			var got []string
+			for _, f := range res.Files {
+				got = append(got, f.Repository)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotList(t *testing.T) {
+	b := testIndexBuilderCompound(t,
+		[]*Repository{
+			{Name: "repo", ID: 1, Branches: []RepositoryBranch{{Name: "main", Version: "live"}}},
+			{Name: "repo", ID: 1, Snapshot: "v1", Branches: []RepositoryBranch{{Name: "v1", Version: "0123abcd"}}},
+		},
+		[][]Document{
+			{{Name: "main.go", Content: []byte("fix the bug")}},
+			{{Name: "main.go", Content: []byte("fix the bug")}},
+		},
+	)
+	searcher := searcherForTest(t, b)
+	defer searcher.Close()
+
+	for _, tc := range []struct {
+		q    query.Q
+		want []string
+	}{
+		{&query.Const{Value: true}, []string{""}},
+		{&query.Repo{Regexp: regexp.MustCompile("repo")}, []string{""}},
+		{&query.At{}, []string{"", "v1"}},
+	} {
+		rl, err := searcher.List(context.Background(), tc.q, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range rl.Repos {
+			got = append(got, r.Repository.Snapshot)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("List(%s): got snapshots %q, want %q", tc.q, got, tc.want)
+		}
+	}
+
+	// Minimal entries are keyed by ID, so snapshots are never minimal.
+	rl, err := searcher.List(context.Background(), &query.At{}, &ListOptions{Minimal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rl.Minimal[1]; got == nil || !reflect.DeepEqual(got.Branches, []RepositoryBranch{{Name: "main", Version: "live"}}) {
+		t.Errorf("got minimal entry %+v, want the live repository", got)
+	}
+	if len(rl.Repos) != 1 || rl.Repos[0].Repository.Snapshot != "v1" {
+		t.Errorf("got repos %v, want the snapshot", rl.Repos)
+	}
+}
+
+func TestBlame(t *testing.T) {
+	alice := Blame{Hash: strings.Repeat("a1", 20), Author: "Alice <alice@example.com>", Date: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}
+	bob := Blame{Hash: strings.Repeat("b2", 20), Author: "Bob <bob@example.com>", Date: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)}
+	b := testIndexBuilder(t, &Repository{Name: "reponame"},
+		Document{
+			Name:    "f1",
+			Content: []byte("one\ntwo\nthree\n"),
+			Blame:   []BlameHunk{{Line: 1, Blame: alice}, {Line: 2, Blame: bob}},
+		},
+		Document{Name: "f2", Content: []byte("two")},
+	)
+	q := &query.Substring{Pattern: "two", Content: true}
+
+	t.Run("LineMatches", func(t *testing.T) {
+		res := searchForTest(t, b, q, SearchOptions{IncludeBlame: true})
+		if len(res.Files) != 2 {
+			t.Fatalf("got %v, want 2 files", res.Files)
+		}
+		for _, f := range res.Files {
+			got := f.LineMatches[0].Blame
+			if f.FileName == "f1" && !reflect.DeepEqual(got, &bob) {
+				t.Errorf("f1: got %v, want %v", got, bob)
+			} else if f.FileName == "f2" && got != nil {
+				t.Errorf("f2: got %v, want no blame", got)
+			}
+		}
+
+		res = searchForTest(t, b, q)
+		for _, f := range res.Files {
+			if f.LineMatches[0].Blame != nil {
+				t.Errorf("%s: got blame without IncludeBlame", f.FileName)
+			}
+		}
+	})
+
+	t.Run("ChunkMatches", func(t *testing.T) {
+		res := searchForTest(t, b, q, SearchOptions{IncludeBlame: true, ChunkMatches: true, NumContextLines: 1})
+		if len(res.Files) != 2 {
+			t.Fatalf("got %v, want 2 files", res.Files)
+		}
+		for _, f := range res.Files {
+			var want []*Blame
+			if f.FileName == "f1" {
+				want = []*Blame{&alice, &bob, &bob}
+			}
+			if got := f.ChunkMatches[0].Blame; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got %v, want %v", f.FileName, got, want)
+			}
+		}
+	})
+}
+
 func TestHitIterTerminate(t *testing.T) {
 	// contrived input: trigram frequencies forces selecting abc +
 	// def for the distance iteration. There is no match, so this
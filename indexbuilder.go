@@ -17,6 +17,7 @@ package zoekt
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"hash/crc64"
 	"html/template"
@@ -159,6 +160,12 @@ type IndexBuilder struct {
 	nameStrings     []*searchableString
 	docSections     [][]DocumentSection
 	runeDocSections []DocumentSection
+	symbolRefs      [][]DocumentSection
+	symbolIDs       [][]string
+	originalNames   []string
+	encodings       []string
+	commits         []string
+	blames          []string
 
 	symID        uint32
 	symIndex     map[string]uint32
@@ -274,6 +281,14 @@ type DocumentSection struct {
 	Start, End uint32
 }
 
+// BlameHunk attributes the lines from Line up to the next hunk to the
+// commit which last modified them.
+type BlameHunk struct {
+	// Line is the 1-based number of the first line of the hunk.
+	Line int
+	Blame
+}
+
 // Document holds a document (file) to index.
 type Document struct {
 	Name              string
@@ -282,6 +297,22 @@ type Document struct {
 	SubRepositoryPath string
 	Language          string
 
+	// OriginalName is set if Name is a virtual path of text extracted from
+	// the file OriginalName, eg. a member of an archive.
+	OriginalName string
+
+	// Encoding is the character encoding of the file if Content was
+	// transcoded to UTF-8, eg. "Shift_JIS".
+	Encoding string
+
+	// Commit is set for documents of history shards, which hold commit
+	// messages and diffs rather than files.
+	Commit *Commit
+
+	// Blame attributes the lines of Content to the commits which last
+	// modified them. It is optional.
+	Blame []BlameHunk
+
 	// If set, something is wrong with the file contents, and this
 	// is the reason it wasn't indexed.
 	SkipReason string
@@ -290,6 +321,11 @@ type Document struct {
 	Symbols         []DocumentSection
 	SymbolsMetaData []*Symbol
 
+	// SymbolRefs are the byte ranges of identifiers referring to symbols,
+	// as opposed to Symbols which are definitions. They are searched with
+	// query.SymbolRef.
+	SymbolRefs []DocumentSection
+
 	// Ranks is a vector of ranks for a document as provided by a DocumentRanksFile
 	// file in the git repo.
 	//
@@ -298,6 +334,12 @@ type Document struct {
 	//
 	// This field is experimental and may change at any time without warning.
 	Ranks []float64
+
+	// Generated and Vendored override the heuristics of rankers demoting
+	// generated and vendored files, eg. as set by linguist attributes in
+	// .gitattributes. If nil, the heuristics apply.
+	Generated *bool
+	Vendored  *bool
 }
 
 type symbolSlice struct {
@@ -316,6 +358,21 @@ func (s symbolSlice) Less(i, j int) bool {
 	return s.symbols[i].Start < s.symbols[j].Start
 }
 
+// symbolIDs returns the IDs of symbols, or nil if none of them has an ID.
+func symbolIDs(symbols []*Symbol) []string {
+	var ids []string
+	for i, sym := range symbols {
+		if sym == nil || sym.ID == "" {
+			continue
+		}
+		if ids == nil {
+			ids = make([]string, len(symbols))
+		}
+		ids[i] = sym.ID
+	}
+	return ids
+}
+
 // AddFile is a convenience wrapper for Add
 func (b *IndexBuilder) AddFile(name string, content []byte) error {
 	return b.Add(Document{Name: name, Content: content})
@@ -436,6 +493,7 @@ func (b *IndexBuilder) Add(doc Document) error {
 		doc.Content = []byte(notIndexedMarker + doc.SkipReason)
 		doc.Symbols = nil
 		doc.SymbolsMetaData = nil
+		doc.SymbolRefs = nil
 		if doc.Language == "" {
 			doc.Language = "skipped"
 		}
@@ -464,12 +522,35 @@ func (b *IndexBuilder) Add(doc Document) error {
 		return fmt.Errorf("section goes past end of content")
 	}
 
+	sort.Slice(doc.SymbolRefs, func(i, j int) bool {
+		return doc.SymbolRefs[i].Start < doc.SymbolRefs[j].Start
+	})
+	for i, s := range doc.SymbolRefs {
+		if s.End > uint32(len(doc.Content)) || (i > 0 && doc.SymbolRefs[i-1].End > s.Start) {
+			return fmt.Errorf("symbol references overlap or go past end of content")
+		}
+	}
+
 	if doc.SubRepositoryPath != "" {
 		rel, err := filepath.Rel(doc.SubRepositoryPath, doc.Name)
 		if err != nil || rel == doc.Name {
 			return fmt.Errorf("path %q must start subrepo path %q", doc.Name, doc.SubRepositoryPath)
 		}
 	}
+
+	var commitString string
+	if doc.Commit != nil {
+		blob, err := json.Marshal(doc.Commit)
+		if err != nil {
+			return err
+		}
+		commitString = string(blob)
+	}
+
+	blame, err := marshalBlame(doc.Blame)
+	if err != nil {
+		return err
+	}
 	docStr, runeSecs, err := b.contentPostings.newSearchableString(doc.Content, doc.Symbols)
 	if err != nil {
 		return err
@@ -513,6 +594,12 @@ func (b *IndexBuilder) Add(doc Document) error {
 
 	b.nameStrings = append(b.nameStrings, nameStr)
 	b.docSections = append(b.docSections, doc.Symbols)
+	b.symbolRefs = append(b.symbolRefs, doc.SymbolRefs)
+	b.symbolIDs = append(b.symbolIDs, symbolIDs(doc.SymbolsMetaData))
+	b.originalNames = append(b.originalNames, doc.OriginalName)
+	b.encodings = append(b.encodings, doc.Encoding)
+	b.commits = append(b.commits, commitString)
+	b.blames = append(b.blames, string(blame))
 	b.fileEndSymbol = append(b.fileEndSymbol, uint32(len(b.runeDocSections)))
 	b.branchMasks = append(b.branchMasks, mask)
 	b.checksums = append(b.checksums, hasher.Sum(nil)...)
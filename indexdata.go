@@ -35,12 +35,36 @@ type indexData struct {
 
 	ngrams ngramMap
 
+	// ngramFilter is nil if disabled with ZOEKT_DISABLE_NGRAM_FILTER.
+	ngramFilter *NgramFilter
+
 	newlinesStart uint32
 	newlinesIndex []uint32
 
 	docSectionsStart uint32
 	docSectionsIndex []uint32
 
+	// symbolRefsIndex is empty if the shard has no symbol references.
+	symbolRefsStart uint32
+	symbolRefsIndex []uint32
+
+	// symbolIDsIndex is empty if no symbol of the shard has an ID.
+	symbolIDsStart uint32
+	symbolIDsIndex []uint32
+
+	originalNames docStrings
+	encodings     docStrings
+
+	blames docStrings
+
+	// commits holds the commit of each document of a history shard. It is
+	// nil for other shards.
+	commits []*Commit
+
+	// hasSnapshots is set if a repository of the shard is a snapshot, see
+	// Repository.Snapshot.
+	hasSnapshots bool
+
 	runeDocSections    []DocumentSection
 	runeDocSectionsRaw []byte
 
@@ -102,6 +126,13 @@ type indexData struct {
 	rawConfigMasks []uint8
 }
 
+// docStrings locates an optional section holding a string per document.
+type docStrings struct {
+	start uint32
+	// index is empty if the section is absent.
+	index []uint32
+}
+
 type symbolData struct {
 	// symContent stores Symbol.Sym and Symbol.Parent.
 	// TODO we don't need to store Symbol.Sym.
@@ -288,15 +319,29 @@ func (d *indexData) calculateNewLinesStats(start, end uint32) (count, defaultCou
 	return
 }
 
+// commit returns the commit of a document of a history shard, or nil.
+func (d *indexData) commit(docID uint32) *Commit {
+	if d.commits == nil {
+		return nil
+	}
+	return d.commits[docID]
+}
+
 func (d *indexData) String() string {
 	return fmt.Sprintf("shard(%s)", d.file.Name())
 }
 
+// NgramFilter returns the ngram filter of the shard, which is nil if
+// disabled.
+func (d *indexData) NgramFilter() *NgramFilter {
+	return d.ngramFilter
+}
+
 // calculates an approximate size of indexData in memory in bytes.
 func (d *indexData) memoryUse() int {
 	sz := 0
 	for _, a := range [][]uint32{
-		d.newlinesIndex, d.docSectionsIndex,
+		d.newlinesIndex, d.docSectionsIndex, d.symbolRefsIndex, d.symbolIDsIndex, d.originalNames.index, d.encodings.index, d.blames.index,
 		d.boundaries, d.fileNameIndex,
 		d.fileEndRunes, d.fileNameEndRunes,
 		d.fileEndSymbol, d.symbols.symKindIndex,
@@ -316,6 +361,7 @@ func (d *indexData) memoryUse() int {
 	sz += 8 * len(d.fileBranchMasks)
 	sz += d.ngrams.SizeBytes()
 	sz += 12 * len(d.fileNameNgrams) // these slices reference mmap-ed memory
+	sz += d.ngramFilter.SizeBytes()
 	return sz
 }
 
@@ -0,0 +1,202 @@
+package zoekt
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// httpIndexFileBlockSize is the unit in which small reads are fetched
+	// and cached. Reads of posting lists and documents are mostly much
+	// smaller.
+	httpIndexFileBlockSize = 64 << 10
+
+	// httpIndexFileMaxBlocks limits the cache of a file to 4 MiB.
+	httpIndexFileMaxBlocks = 64
+
+	// DefaultHTTPTimeout is the timeout of the client used for HTTP
+	// requests of NewHTTPIndexFile if it is passed no client.
+	DefaultHTTPTimeout = time.Minute
+)
+
+var defaultHTTPClient = &http.Client{Timeout: DefaultHTTPTimeout}
+
+// httpIndexFile reads a shard with HTTP range requests. Small reads are
+// served from a cache of the least recently read blocks of the file, larger
+// reads are fetched directly.
+type httpIndexFile struct {
+	client *http.Client
+	url    string
+	size   uint32
+
+	// version is the strong ETag or the Last-Modified date of the file
+	// when it was opened. It is sent with every range request, so reads
+	// fail instead of mixing bytes of different versions of the file.
+	version string
+
+	mu sync.Mutex
+	// lru holds *httpIndexFileBlock, most recently used first.
+	lru    *list.List
+	blocks map[uint32]*list.Element
+}
+
+type httpIndexFileBlock struct {
+	off  uint32
+	data []byte
+}
+
+// NewHTTPIndexFile returns an IndexFile which reads the shard at url with
+// HTTP range requests, so shards served by an object store or static file
+// server can be searched without copying them first. client may be nil to
+// use a client with DefaultHTTPTimeout. Reads have no context, so the
+// timeout of client is all that bounds them. The server must send a strong
+// ETag or a Last-Modified date, and reads fail once the file changes, so the
+// shard has to be reopened.
+func NewHTTPIndexFile(client *http.Client, url string) (IndexFile, error) {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	resp, err := client.Head(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD %s: %s", url, resp.Status)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, fmt.Errorf("%s doesn't support range requests", url)
+	}
+	if resp.ContentLength < 0 || resp.ContentLength >= maxUInt32 {
+		return nil, fmt.Errorf("file %s has unsupported size: %d", url, resp.ContentLength)
+	}
+
+	// Weak ETags can't be used in If-Range.
+	version := resp.Header.Get("ETag")
+	if version == "" || strings.HasPrefix(version, "W/") {
+		version = resp.Header.Get("Last-Modified")
+	}
+	if version == "" {
+		return nil, fmt.Errorf("%s has neither a strong ETag nor a Last-Modified date", url)
+	}
+
+	return &httpIndexFile{
+		client:  client,
+		url:     url,
+		size:    uint32(resp.ContentLength),
+		version: version,
+		lru:     list.New(),
+		blocks:  map[uint32]*list.Element{},
+	}, nil
+}
+
+func (f *httpIndexFile) Read(off, sz uint32) ([]byte, error) {
+	if off > off+sz || off+sz > f.size {
+		return nil, fmt.Errorf("out of bounds: %d, len %d, name %s", off+sz, f.size, f.url)
+	}
+	if sz == 0 {
+		return []byte{}, nil
+	}
+	if sz > httpIndexFileBlockSize {
+		return f.fetch(off, sz)
+	}
+
+	first := off / httpIndexFileBlockSize * httpIndexFileBlockSize
+	last := (off + sz - 1) / httpIndexFileBlockSize * httpIndexFileBlockSize
+	b, err := f.block(first)
+	if err != nil {
+		return nil, err
+	}
+	if first == last {
+		// Blocks are never modified, so we can return a part of one.
+		return b[off-first : off-first+sz], nil
+	}
+
+	next, err := f.block(last)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, sz)
+	data = append(data, b[off-first:]...)
+	return append(data, next[:off+sz-last]...), nil
+}
+
+// block returns the block starting at off.
+func (f *httpIndexFile) block(off uint32) ([]byte, error) {
+	f.mu.Lock()
+	if e, ok := f.blocks[off]; ok {
+		f.lru.MoveToFront(e)
+		f.mu.Unlock()
+		return e.Value.(*httpIndexFileBlock).data, nil
+	}
+	f.mu.Unlock()
+
+	sz := uint32(httpIndexFileBlockSize)
+	if off+sz > f.size {
+		sz = f.size - off
+	}
+	data, err := f.fetch(off, sz)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blocks[off]; !ok {
+		f.blocks[off] = f.lru.PushFront(&httpIndexFileBlock{off: off, data: data})
+		if f.lru.Len() > httpIndexFileMaxBlocks {
+			evicted := f.lru.Remove(f.lru.Back()).(*httpIndexFileBlock)
+			delete(f.blocks, evicted.off)
+		}
+	}
+	return data, nil
+}
+
+func (f *httpIndexFile) fetch(off, sz uint32) ([]byte, error) {
+	req, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+sz-1))
+	// If the file changed, servers send all of it (200) for If-Range and
+	// refuse (412) for If-Match.
+	req.Header.Set("If-Range", f.version)
+	if strings.HasPrefix(f.version, `"`) {
+		req.Header.Set("If-Match", f.version)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK, http.StatusPreconditionFailed:
+		return nil, fmt.Errorf("GET %s range %d+%d: file changed since it was opened: %s", f.url, off, sz, resp.Status)
+	default:
+		return nil, fmt.Errorf("GET %s range %d+%d: %s", f.url, off, sz, resp.Status)
+	}
+
+	data := make([]byte, sz)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("GET %s range %d+%d: %w", f.url, off, sz, err)
+	}
+	return data, nil
+}
+
+func (f *httpIndexFile) Name() string {
+	return f.url
+}
+
+func (f *httpIndexFile) Size() (uint32, error) {
+	return f.size, nil
+}
+
+func (f *httpIndexFile) Close() {}
@@ -0,0 +1,196 @@
+package zoekt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func serveBytes(t *testing.T, data []byte) (*httptest.Server, *int64) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "shard.zoekt", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestHTTPIndexFileRead(t *testing.T) {
+	data := make([]byte, 3*httpIndexFileBlockSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	srv, requests := serveBytes(t, data)
+
+	f, err := NewHTTPIndexFile(srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sz, _ := f.Size(); sz != uint32(len(data)) {
+		t.Fatalf("got size %d, want %d", sz, len(data))
+	}
+
+	cases := []struct{ off, sz uint32 }{
+		{0, 10},
+		{httpIndexFileBlockSize - 5, 10},
+		{100, 2*httpIndexFileBlockSize + 1},
+		{uint32(len(data)) - 50, 50},
+		{5, 0},
+	}
+	for _, c := range cases {
+		got, err := f.Read(c.off, c.sz)
+		if err != nil {
+			t.Fatalf("Read(%d, %d): %v", c.off, c.sz, err)
+		}
+		if !bytes.Equal(got, data[c.off:c.off+c.sz]) {
+			t.Errorf("Read(%d, %d) returned the wrong bytes", c.off, c.sz)
+		}
+	}
+
+	// The first block is cached.
+	before := atomic.LoadInt64(requests)
+	if _, err := f.Read(20, 30); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(requests) - before; n != 0 {
+		t.Errorf("cached read made %d requests", n)
+	}
+
+	if _, err := f.Read(uint32(len(data))-5, 10); err == nil {
+		t.Error("out of bounds read succeeded")
+	}
+}
+
+func TestHTTPIndexFileSearch(t *testing.T) {
+	b := testIndexBuilder(t, &Repository{Name: "repo"},
+		Document{Name: "f1", Content: []byte("needle in a haystack")},
+		Document{Name: "f2", Content: []byte("just hay")})
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := serveBytes(t, buf.Bytes())
+
+	f, err := NewHTTPIndexFile(srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSearcher(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	res, err := s.Search(context.Background(), &query.Substring{Pattern: "needle"}, &SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].FileName != "f1" {
+		t.Errorf("got %v, want f1", res.Files)
+	}
+}
+
+func TestHTTPIndexFileTimeout(t *testing.T) {
+	data := make([]byte, 100)
+	stall := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			<-stall
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "shard.zoekt", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(stall) })
+
+	client := srv.Client()
+	client.Timeout = 10 * time.Millisecond
+	f, err := NewHTTPIndexFile(client, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(0, 10); err == nil {
+		t.Error("Read of a stalled server succeeded")
+	}
+
+	f, err = NewHTTPIndexFile(nil, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.(*httpIndexFile).client.Timeout; got != DefaultHTTPTimeout {
+		t.Errorf("got default timeout %v, want %v", got, DefaultHTTPTimeout)
+	}
+}
+
+func TestHTTPIndexFileChanged(t *testing.T) {
+	old := bytes.Repeat([]byte("a"), 100)
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		serve func(w http.ResponseWriter, r *http.Request, data []byte, version int)
+	}{{
+		name: "etag",
+		serve: func(w http.ResponseWriter, r *http.Request, data []byte, version int) {
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+			http.ServeContent(w, r, "shard.zoekt", time.Time{}, bytes.NewReader(data))
+		},
+	}, {
+		name: "last-modified",
+		serve: func(w http.ResponseWriter, r *http.Request, data []byte, version int) {
+			http.ServeContent(w, r, "shard.zoekt", modified.Add(time.Duration(version)*time.Hour), bytes.NewReader(data))
+		},
+	}, {
+		name: "if-match",
+		serve: func(w http.ResponseWriter, r *http.Request, data []byte, version int) {
+			// Servers which ignore If-Range.
+			r.Header.Del("If-Range")
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+			http.ServeContent(w, r, "shard.zoekt", time.Time{}, bytes.NewReader(data))
+		},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var version int64 = 1
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				v := atomic.LoadInt64(&version)
+				data := old
+				if v > 1 {
+					data = bytes.Repeat([]byte("b"), 100)
+				}
+				c.serve(w, r, data, int(v))
+			}))
+			t.Cleanup(srv.Close)
+
+			f, err := NewHTTPIndexFile(srv.Client(), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := f.Read(0, 10); err != nil || !bytes.Equal(got, old[:10]) {
+				t.Fatalf("got %q, %v, want %q", got, err, old[:10])
+			}
+
+			// Read would be served from the cache.
+			atomic.StoreInt64(&version, 2)
+			if _, err := f.(*httpIndexFile).fetch(0, 10); err == nil {
+				t.Error("read of a changed file succeeded")
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `W/"v1"`)
+		http.ServeContent(w, r, "shard.zoekt", time.Time{}, bytes.NewReader(old))
+	}))
+	t.Cleanup(srv.Close)
+	if _, err := NewHTTPIndexFile(srv.Client(), srv.URL); err == nil {
+		t.Error("opened a file without a strong validator")
+	}
+}
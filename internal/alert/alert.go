@@ -0,0 +1,196 @@
+// Package alert re-runs saved queries whenever new shards are loaded and
+// reports matches which were not present in the previous run.
+//
+// The first time a saved query is run against a repository the results are
+// only recorded as a baseline, so adding a query does not alert on all
+// existing matches.
+package alert
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Watcher runs saved queries for repositories whose shards have been
+// (re)loaded. Use ReposLoaded as shards.DirectorySearcherOptions.OnLoad and
+// call Run once the searcher is available.
+type Watcher struct {
+	Store *Store
+	Sink  Sink
+
+	// MaxWallTime bounds the time spent running a single saved query. Defaults
+	// to one minute.
+	MaxWallTime time.Duration
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	signal  chan struct{}
+}
+
+// NewWatcher returns a Watcher which records results in store and sends
+// alerts to sink.
+func NewWatcher(store *Store, sink Sink) *Watcher {
+	return &Watcher{
+		Store:   store,
+		Sink:    sink,
+		pending: map[string]struct{}{},
+		signal:  make(chan struct{}, 1),
+	}
+}
+
+// ReposLoaded marks repos as needing a check. It does not block.
+func (w *Watcher) ReposLoaded(repos []*zoekt.Repository) {
+	w.mu.Lock()
+	for _, r := range repos {
+		w.pending[r.Name] = struct{}{}
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks pending repositories using searcher until ctx is done.
+func (w *Watcher) Run(ctx context.Context, searcher zoekt.Searcher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.signal:
+		}
+
+		w.mu.Lock()
+		repos := make([]string, 0, len(w.pending))
+		for r := range w.pending {
+			repos = append(repos, r)
+		}
+		w.pending = map[string]struct{}{}
+		w.mu.Unlock()
+
+		if len(repos) == 0 {
+			continue
+		}
+
+		if err := w.Check(ctx, searcher, repos); err != nil {
+			log.Printf("alert: %v", err)
+		}
+	}
+}
+
+// Check runs every saved query against repos, sends an alert for each query
+// with new matches and persists the results.
+func (w *Watcher) Check(ctx context.Context, searcher zoekt.Searcher, repos []string) error {
+	sort.Strings(repos)
+
+	var firstErr error
+	for _, sq := range w.Store.Queries() {
+		if err := w.checkQuery(ctx, searcher, sq, repos); err != nil {
+			log.Printf("alert: query %q: %v", sq.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if err := w.Store.Save(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+func (w *Watcher) checkQuery(ctx context.Context, searcher zoekt.Searcher, sq SavedQuery, repos []string) error {
+	q, err := query.Parse(sq.Query)
+	if err != nil {
+		return err
+	}
+	q = query.NewAnd(q, query.NewRepoSet(repos...))
+
+	maxWallTime := w.MaxWallTime
+	if maxWallTime == 0 {
+		maxWallTime = time.Minute
+	}
+
+	sr, err := searcher.Search(ctx, q, &zoekt.SearchOptions{MaxWallTime: maxWallTime})
+	if err != nil {
+		return err
+	}
+	if sr.Stats.Crashes > 0 {
+		// Partial results would make matches look new once the crashing
+		// shards recover.
+		return nil
+	}
+
+	current := make(map[string]map[string]bool, len(repos))
+	matches := make(map[string]map[string]Match, len(repos))
+	for _, repo := range repos {
+		current[repo] = map[string]bool{}
+		matches[repo] = map[string]Match{}
+	}
+
+	for _, fm := range sr.Files {
+		if current[fm.Repository] == nil {
+			continue
+		}
+		for _, lm := range fm.LineMatches {
+			key := matchKey(&fm, &lm)
+			current[fm.Repository][key] = true
+			matches[fm.Repository][key] = Match{
+				Repository: fm.Repository,
+				Branches:   fm.Branches,
+				FileName:   fm.FileName,
+				LineNumber: lm.LineNumber,
+				Line:       string(lm.Line),
+			}
+		}
+	}
+
+	a := &Alert{Name: sq.Name, Query: sq.Query}
+	for _, repo := range repos {
+		if previous, ok := w.Store.seen(sq.Name, repo); ok {
+			for key := range current[repo] {
+				if !previous[key] {
+					a.Matches = append(a.Matches, matches[repo][key])
+				}
+			}
+		}
+	}
+
+	if len(a.Matches) > 0 {
+		sort.Slice(a.Matches, func(i, j int) bool {
+			mi, mj := a.Matches[i], a.Matches[j]
+			if mi.Repository != mj.Repository {
+				return mi.Repository < mj.Repository
+			}
+			if mi.FileName != mj.FileName {
+				return mi.FileName < mj.FileName
+			}
+			return mi.LineNumber < mj.LineNumber
+		})
+		if err := w.Sink.Notify(ctx, a); err != nil {
+			// Don't record the results so we alert again on the next run.
+			return err
+		}
+	}
+
+	for _, repo := range repos {
+		w.Store.setSeen(sq.Name, repo, current[repo])
+	}
+	return nil
+}
+
+// matchKey identifies a match independently of its line number, so that
+// unrelated edits moving a match around do not trigger an alert.
+func matchKey(fm *zoekt.FileMatch, lm *zoekt.LineMatch) string {
+	if lm.FileName {
+		return fm.FileName + "\x00"
+	}
+	return fm.FileName + "\x00" + string(lm.Line)
+}
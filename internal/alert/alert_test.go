@@ -0,0 +1,170 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sourcegraph/zoekt"
+)
+
+type memSeeker struct {
+	data []byte
+}
+
+func (s *memSeeker) Close() {}
+func (s *memSeeker) Read(off, sz uint32) ([]byte, error) {
+	return s.data[off : off+sz], nil
+}
+
+func (s *memSeeker) Size() (uint32, error) {
+	return uint32(len(s.data)), nil
+}
+
+func (s *memSeeker) Name() string {
+	return "memSeeker"
+}
+
+func searcherForTest(t *testing.T, repo string, docs ...zoekt.Document) zoekt.Searcher {
+	t.Helper()
+
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{Name: repo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range docs {
+		if err := b.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := zoekt.NewSearcher(&memSeeker{buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWatcherWebhook(t *testing.T) {
+	var got []*Alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got = append(got, &a)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddQuery(SavedQuery{Name: "aws", Query: "AKIA[0-9A-Z]{4}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher(store, &WebhookSink{URL: ts.URL})
+	ctx := context.Background()
+
+	// The first run only records a baseline.
+	s := searcherForTest(t, "repo",
+		zoekt.Document{Name: "old.txt", Content: []byte("key = AKIAOLD1\n")})
+	if err := w.Check(ctx, s, []string{"repo"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got alerts for baseline: %v", got)
+	}
+
+	// Only the match which was not present before is reported, even though
+	// the old match moved to a different line.
+	s = searcherForTest(t, "repo",
+		zoekt.Document{Name: "old.txt", Content: []byte("\nkey = AKIAOLD1\n")},
+		zoekt.Document{Name: "new.txt", Content: []byte("secret AKIANEW2\n")})
+
+	// Reopen the store to check the baseline was persisted.
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Store = store
+
+	if err := w.Check(ctx, s, []string{"repo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Alert{{
+		Name:  "aws",
+		Query: "AKIA[0-9A-Z]{4}",
+		Matches: []Match{{
+			Repository: "repo",
+			Branches:   nil,
+			FileName:   "new.txt",
+			LineNumber: 1,
+			Line:       "secret AKIANEW2",
+		}},
+	}}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", d)
+	}
+
+	// Running again without changes does not alert.
+	if err := w.Check(ctx, s, []string{"repo"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(got))
+	}
+}
+
+func TestWatcherWebhookFailureRetries(t *testing.T) {
+	fail := true
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "alerts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddQuery(SavedQuery{Name: "todo", Query: "TODO"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher(store, &WebhookSink{URL: ts.URL})
+	ctx := context.Background()
+
+	if err := w.Check(ctx, searcherForTest(t, "repo"), []string{"repo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := searcherForTest(t, "repo", zoekt.Document{Name: "a.go", Content: []byte("// TODO\n")})
+	if err := w.Check(ctx, s, []string{"repo"}); err == nil {
+		t.Fatal("expected error from failing webhook")
+	}
+
+	fail = false
+	if err := w.Check(ctx, s, []string{"repo"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("got %d webhook calls, want 2", calls)
+	}
+}
@@ -0,0 +1,88 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Match is a single line which newly matched a saved query.
+type Match struct {
+	Repository string
+	Branches   []string
+	FileName   string
+	LineNumber int
+	Line       string
+}
+
+// Alert is emitted for a saved query once it has new matches.
+type Alert struct {
+	Name    string
+	Query   string
+	Matches []Match
+}
+
+// Sink receives alerts.
+type Sink interface {
+	Notify(ctx context.Context, a *Alert) error
+}
+
+// LogSink writes alerts to a logger. If Logger is nil the standard logger is
+// used.
+type LogSink struct {
+	Logger *log.Logger
+}
+
+func (s *LogSink) Notify(_ context.Context, a *Alert) error {
+	logf := log.Printf
+	if s.Logger != nil {
+		logf = s.Logger.Printf
+	}
+
+	for _, m := range a.Matches {
+		logf("alert %q: %s:%s:%d: %s", a.Name, m.Repository, m.FileName, m.LineNumber, m.Line)
+	}
+	return nil
+}
+
+// WebhookSink POSTs alerts as JSON to URL.
+type WebhookSink struct {
+	URL string
+
+	// Client is used to send requests. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+func (s *WebhookSink) Notify(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: status %s", s.URL, resp.Status)
+	}
+	return nil
+}
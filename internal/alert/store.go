@@ -0,0 +1,162 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// SavedQuery is a named query which is re-run whenever shards for the
+// repositories it could match are reloaded.
+type SavedQuery struct {
+	// Name identifies the query in alerts. It must be unique.
+	Name string
+
+	// Query is parsed with query.Parse.
+	Query string
+}
+
+// storeData is the on-disk representation of a Store.
+type storeData struct {
+	Queries []SavedQuery
+
+	// Seen maps a query name to the repositories we have searched, which in
+	// turn map to the keys of matches we found in the last run. A repository
+	// which has been searched without results has an empty, non-nil list.
+	Seen map[string]map[string][]string `json:",omitempty"`
+}
+
+// Store persists saved queries together with the results of their last run
+// as a JSON file. The file can be edited by hand to add or remove queries
+// while the webserver is stopped.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data storeData
+}
+
+// OpenStore reads the store at path. A missing file is treated as an empty
+// store and is created on the first call to Save.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, fmt.Errorf("alert store %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for _, q := range s.data.Queries {
+		if q.Name == "" {
+			return nil, fmt.Errorf("alert store %s: query %q has no name", path, q.Query)
+		}
+		if names[q.Name] {
+			return nil, fmt.Errorf("alert store %s: duplicate query name %q", path, q.Name)
+		}
+		names[q.Name] = true
+	}
+
+	return s, nil
+}
+
+// Queries returns a copy of the saved queries.
+func (s *Store) Queries() []SavedQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SavedQuery(nil), s.data.Queries...)
+}
+
+// AddQuery adds q to the store, replacing an existing query of the same
+// name. Replacing a query forgets its previous results.
+func (s *Store) AddQuery(q SavedQuery) error {
+	if q.Name == "" {
+		return fmt.Errorf("query %q has no name", q.Query)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.data.Queries {
+		if s.data.Queries[i].Name == q.Name {
+			s.data.Queries[i] = q
+			delete(s.data.Seen, q.Name)
+			return nil
+		}
+	}
+	s.data.Queries = append(s.data.Queries, q)
+	return nil
+}
+
+// seen returns the match keys recorded for name in repo. ok is false if repo
+// has never been searched for name.
+func (s *Store) seen(name, repo string) (keys map[string]bool, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.data.Seen[name][repo]
+	if !ok {
+		return nil, false
+	}
+
+	keys = make(map[string]bool, len(list))
+	for _, k := range list {
+		keys[k] = true
+	}
+	return keys, true
+}
+
+// setSeen replaces the match keys recorded for name in repo.
+func (s *Store) setSeen(name, repo string, keys map[string]bool) {
+	list := make([]string, 0, len(keys))
+	for k := range keys {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Seen == nil {
+		s.data.Seen = map[string]map[string][]string{}
+	}
+	if s.data.Seen[name] == nil {
+		s.data.Seen[name] = map[string][]string{}
+	}
+	s.data.Seen[name][repo] = list
+}
+
+// Save atomically writes the store to disk.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	b, err := json.MarshalIndent(&s.data, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,94 @@
+// Package authz restricts the repositories a caller of zoekt-webserver can
+// search.
+//
+// The identity of a caller is resolved once per HTTP request and stored in
+// the request context. The Searcher returned by Authorizer.Searcher reads the
+// identity from the context of each call and ANDs a query.RepoSet of the
+// permitted repositories to the query before passing it on.
+package authz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Provider returns the repositories an identity is permitted to search.
+type Provider interface {
+	// Repos returns the names of the repositories identity may search. If all
+	// is true, identity may search every repository and repos is ignored.
+	Repos(ctx context.Context, identity string) (repos map[string]bool, all bool, err error)
+}
+
+// IdentityFunc resolves the identity of the caller of r. An empty identity
+// denotes an anonymous caller.
+type IdentityFunc func(r *http.Request) string
+
+// HeaderIdentity returns an IdentityFunc which reads the identity from the
+// request header name. It should only be used behind a proxy which sets the
+// header.
+func HeaderIdentity(name string) IdentityFunc {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// ClientCertIdentity is an IdentityFunc which uses the subject common name of
+// a verified TLS client certificate as identity.
+func ClientCertIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// FirstIdentity returns an IdentityFunc which returns the first non-empty
+// identity resolved by fns.
+func FirstIdentity(fns ...IdentityFunc) IdentityFunc {
+	return func(r *http.Request) string {
+		for _, f := range fns {
+			if id := f(r); id != "" {
+				return id
+			}
+		}
+		return ""
+	}
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying identity.
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored in ctx by WithIdentity. It
+// returns the anonymous identity if there is none.
+func IdentityFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(identityKey{}).(string)
+	return id
+}
+
+// Authorizer ties together how callers are identified and what they may
+// search.
+type Authorizer struct {
+	Identify IdentityFunc
+	Provider Provider
+}
+
+// Identity resolves the identity of the caller of r.
+func (a *Authorizer) Identity(r *http.Request) string {
+	if a.Identify == nil {
+		return ""
+	}
+	return a.Identify(r)
+}
+
+// Middleware stores the identity of the caller in the context of every
+// request before calling next.
+func (a *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithIdentity(r.Context(), a.Identity(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
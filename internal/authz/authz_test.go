@@ -0,0 +1,134 @@
+package authz
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type recordingSearcher struct {
+	zoekt.Streamer
+	got query.Q
+}
+
+func (s *recordingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.got = q
+	return &zoekt.SearchResult{}, nil
+}
+
+func (s *recordingSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	s.got = q
+	return &zoekt.RepoList{}, nil
+}
+
+func (s *recordingSearcher) String() string { return "recordingSearcher" }
+
+func writeACL(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearcherRestrictsQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, path, `{"alice": ["a", "b"], "root": ["*"]}`, time.Now())
+
+	p, err := NewFileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recordingSearcher{}
+	s := (&Authorizer{Provider: p}).Searcher(rec)
+	q := &query.Substring{Pattern: "needle"}
+
+	cases := []struct {
+		identity string
+		want     string
+	}{
+		{"alice", `(and (reposet a b) substr:"needle")`},
+		{"root", `substr:"needle"`},
+		{"mallory", `(and (reposet ) substr:"needle")`},
+		{"", `(and (reposet ) substr:"needle")`},
+	}
+
+	for _, tc := range cases {
+		ctx := WithIdentity(context.Background(), tc.identity)
+
+		if _, err := s.Search(ctx, q, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.got.String(); got != tc.want {
+			t.Errorf("Search(%q): got %s, want %s", tc.identity, got, tc.want)
+		}
+
+		if _, err := s.List(ctx, q, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.got.String(); got != tc.want {
+			t.Errorf("List(%q): got %s, want %s", tc.identity, got, tc.want)
+		}
+	}
+
+	// Bound searchers ignore the identity in the context.
+	bound := BindIdentity(s, "alice")
+	if _, err := bound.Search(WithIdentity(context.Background(), "root"), q, &zoekt.SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.got.String(), `(and (reposet a b) substr:"needle")`; got != want {
+		t.Errorf("bound: got %s, want %s", got, want)
+	}
+}
+
+func TestFileProviderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	start := time.Now().Add(-time.Hour)
+	writeACL(t, path, `{"alice": ["a"]}`, start)
+
+	p, err := NewFileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos, all, err := p.Repos(context.Background(), "alice")
+	if err != nil || all || !repos["a"] || len(repos) != 1 {
+		t.Fatalf("got %v %v %v", repos, all, err)
+	}
+
+	writeACL(t, path, `{"alice": ["*"]}`, start.Add(time.Minute))
+
+	_, all, err = p.Repos(context.Background(), "alice")
+	if err != nil || !all {
+		t.Fatalf("expected reload to grant all repos, got %v %v", all, err)
+	}
+
+	// A broken file denies access rather than using a stale ACL.
+	writeACL(t, path, `{`, start.Add(2*time.Minute))
+	if _, _, err := p.Repos(context.Background(), "alice"); err == nil {
+		t.Fatal("expected error for invalid ACL file")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	r := httptest.NewRequest("GET", "/search", nil)
+	r.Header.Set("X-Zoekt-User", " alice ")
+
+	identify := FirstIdentity(ClientCertIdentity, HeaderIdentity("X-Zoekt-User"))
+	if got := identify(r); got != "alice" {
+		t.Fatalf("got identity %q, want alice", got)
+	}
+
+	if got := identify(httptest.NewRequest("GET", "/search", nil)); got != "" {
+		t.Fatalf("got identity %q, want anonymous", got)
+	}
+}
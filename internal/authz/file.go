@@ -0,0 +1,93 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// allRepos in an ACL grants access to every repository.
+const allRepos = "*"
+
+// FileProvider is a Provider backed by a JSON file mapping identities to the
+// repository names they may search, for example:
+//
+//	{
+//	  "alice": ["github.com/org/frontend", "github.com/org/backend"],
+//	  "ci-bot": ["*"],
+//	  "": ["github.com/org/public"]
+//	}
+//
+// The entry "" applies to anonymous callers. Identities without an entry
+// can't search any repository. The file is reloaded when its modification
+// time changes.
+type FileProvider struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	acl     map[string]map[string]bool
+}
+
+// NewFileProvider returns a FileProvider reading path. It fails if path can't
+// be read or parsed.
+func NewFileProvider(path string) (*FileProvider, error) {
+	p := &FileProvider{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reload re-reads the ACL file if it changed. Must be called with mu held or
+// before p is shared.
+func (p *FileProvider) reload() error {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	if p.acl != nil && fi.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string][]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("parsing ACL file %s: %w", p.path, err)
+	}
+
+	acl := make(map[string]map[string]bool, len(raw))
+	for identity, repos := range raw {
+		set := make(map[string]bool, len(repos))
+		for _, r := range repos {
+			set[r] = true
+		}
+		acl[identity] = set
+	}
+
+	p.acl = acl
+	p.modTime = fi.ModTime()
+	return nil
+}
+
+func (p *FileProvider) Repos(_ context.Context, identity string) (map[string]bool, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.reload(); err != nil {
+		return nil, false, err
+	}
+
+	repos := p.acl[identity]
+	if repos[allRepos] {
+		return nil, true, nil
+	}
+	return repos, false, nil
+}
@@ -0,0 +1,100 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// Searcher returns a zoekt.Streamer which only searches the repositories the
+// identity stored in the context of each call may access.
+func (a *Authorizer) Searcher(s zoekt.Streamer) zoekt.Streamer {
+	return &authzSearcher{Streamer: s, provider: a.Provider}
+}
+
+type authzSearcher struct {
+	zoekt.Streamer
+	provider Provider
+}
+
+// restrict ANDs q with the set of repositories the caller may search.
+func (s *authzSearcher) restrict(ctx context.Context, q query.Q) (query.Q, error) {
+	identity := IdentityFromContext(ctx)
+	repos, all, err := s.provider.Repos(ctx, identity)
+	if err != nil {
+		return nil, fmt.Errorf("authz: resolving repositories for %q: %w", identity, err)
+	}
+	if all {
+		return q, nil
+	}
+
+	set := make(map[string]bool, len(repos))
+	for r, ok := range repos {
+		if ok {
+			set[r] = true
+		}
+	}
+
+	// The RepoSet comes first so that shards.selectRepoSet can use it to
+	// skip shards of repositories the caller can't search.
+	return query.NewAnd(&query.RepoSet{Set: set}, q), nil
+}
+
+func (s *authzSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	q, err := s.restrict(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *authzSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	q, err := s.restrict(ctx, q)
+	if err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s *authzSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	q, err := s.restrict(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.List(ctx, q, opts)
+}
+
+func (s *authzSearcher) String() string {
+	return fmt.Sprintf("authz(%s)", s.Streamer.String())
+}
+
+// BindIdentity returns a zoekt.Streamer which calls s with identity stored in
+// the context of every call. It is used for transports such as net/rpc where
+// calls don't inherit the context of the HTTP request which opened the
+// connection.
+func BindIdentity(s zoekt.Streamer, identity string) zoekt.Streamer {
+	return &boundSearcher{Streamer: s, identity: identity}
+}
+
+type boundSearcher struct {
+	zoekt.Streamer
+	identity string
+}
+
+func (s *boundSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	return s.Streamer.Search(WithIdentity(ctx, s.identity), q, opts)
+}
+
+func (s *boundSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	return s.Streamer.StreamSearch(WithIdentity(ctx, s.identity), q, opts, sender)
+}
+
+func (s *boundSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return s.Streamer.List(WithIdentity(ctx, s.identity), q, opts)
+}
+
+// Close is a no-op since a bound searcher only lives as long as a single
+// connection, while the underlying searcher is shared.
+func (s *boundSearcher) Close() {}
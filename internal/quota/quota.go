@@ -0,0 +1,267 @@
+// Package quota limits how much of zoekt-webserver a single client can use.
+//
+// Every client has two token buckets. The request bucket limits the number
+// of search requests per second. The cost bucket is debited with the cost of
+// every search once it finished, see Cost. A client whose cost bucket is in
+// debt is over quota: its searches are either rejected or demoted to the
+// batch queue of the shards scheduler until the bucket refilled.
+package quota
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/authz"
+	"github.com/sourcegraph/zoekt/shards"
+)
+
+var (
+	metricRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoekt_quota_rejected_total",
+		Help: "The total number of requests rejected because a client exceeded its quota.",
+	}, []string{"reason"})
+	metricDemotedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_quota_demoted_total",
+		Help: "The total number of searches demoted to the batch queue because a client exceeded its cost budget.",
+	})
+	metricCostTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_quota_cost_total",
+		Help: "The total cost charged to clients, see quota.Cost.",
+	})
+	metricClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_quota_clients",
+		Help: "The number of clients currently tracked by the quota limiter.",
+	})
+)
+
+// ErrOverQuota is returned by searches of clients which exceeded their cost
+// budget if Config.RejectOverBudget is set.
+var ErrOverQuota = errors.New("quota: cost budget exceeded, try again later")
+
+// ErrTooManyRequests is returned by calls of searchers returned by
+// BindClient if the client exceeded its request rate.
+var ErrTooManyRequests = errors.New("quota: too many requests, try again later")
+
+// Config configures a Limiter. A zero rate disables the corresponding limit.
+type Config struct {
+	// RequestsPerSecond is the sustained number of requests per second a
+	// client can make. RequestBurst is the number of requests a client can
+	// make at once. It defaults to RequestsPerSecond.
+	RequestsPerSecond float64
+	RequestBurst      float64
+
+	// CostPerSecond is the sustained search cost per second, as computed by
+	// Cost, a client can spend. CostBurst is the cost budget of an idle
+	// client. It defaults to 60 seconds worth of CostPerSecond.
+	CostPerSecond float64
+	CostBurst     float64
+
+	// RejectOverBudget rejects searches of clients over their cost budget
+	// instead of demoting them to the batch queue.
+	RejectOverBudget bool
+}
+
+// Cost returns the cost of a search from its stats. Each file whose content
+// was loaded, each regular expression evaluation and each MiB of content read
+// cost 1.
+func Cost(st *zoekt.Stats) float64 {
+	return float64(st.FilesLoaded) +
+		float64(st.RegexpsConsidered) +
+		float64(st.ContentBytesLoaded)/(1<<20)
+}
+
+// gcInterval is how often we forget the clients whose buckets refilled. We
+// also do so whenever the number of clients doubled, so clients which only
+// make a few requests can't exhaust memory.
+const gcInterval = time.Minute
+
+// Limiter enforces per client quotas.
+type Limiter struct {
+	cfg Config
+
+	// now and demote are overridden in tests.
+	now    func() time.Time
+	demote func(context.Context) context.Context
+
+	mu      sync.Mutex
+	clients map[string]*client
+	lastGC  time.Time
+	// gcSize is the number of clients after the last GC.
+	gcSize int
+}
+
+// NewLimiter returns a Limiter enforcing cfg.
+func NewLimiter(cfg Config) *Limiter {
+	if cfg.RequestBurst == 0 {
+		cfg.RequestBurst = cfg.RequestsPerSecond
+	}
+	if cfg.CostBurst == 0 {
+		cfg.CostBurst = 60 * cfg.CostPerSecond
+	}
+	return &Limiter{
+		cfg:     cfg,
+		now:     time.Now,
+		demote:  shards.WithBatchPriority,
+		clients: map[string]*client{},
+	}
+}
+
+type client struct {
+	requests bucket
+	cost     bucket
+}
+
+// bucket is a token bucket whose balance can become negative.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// full returns true if b refilled by now. A bucket without rate is never
+// used, so it is always full.
+func (b *bucket) full(now time.Time, rate, burst float64) bool {
+	return rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*rate >= burst
+}
+
+func (b *bucket) refill(now time.Time, rate, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// get returns the state for key. Must be called with mu held.
+func (l *Limiter) get(key string, now time.Time) *client {
+	if now.Sub(l.lastGC) > gcInterval || len(l.clients) > 2*l.gcSize+1024 {
+		l.gc(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{
+			requests: bucket{tokens: l.cfg.RequestBurst, last: now},
+			cost:     bucket{tokens: l.cfg.CostBurst, last: now},
+		}
+		l.clients[key] = c
+	}
+	metricClients.Set(float64(len(l.clients)))
+	return c
+}
+
+// gc forgets the clients whose buckets refilled. Their state is the same as
+// that of a new client, so forgetting them doesn't reset their quota early.
+// Must be called with mu held.
+func (l *Limiter) gc(now time.Time) {
+	for k, c := range l.clients {
+		if c.requests.full(now, l.cfg.RequestsPerSecond, l.cfg.RequestBurst) && c.cost.full(now, l.cfg.CostPerSecond, l.cfg.CostBurst) {
+			delete(l.clients, k)
+		}
+	}
+	l.lastGC = now
+	l.gcSize = len(l.clients)
+}
+
+// allowRequest takes a token from the request bucket of key. It returns false
+// if there is none left.
+func (l *Limiter) allowRequest(key string) bool {
+	if l.cfg.RequestsPerSecond <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	c := l.get(key, now)
+	c.requests.refill(now, l.cfg.RequestsPerSecond, l.cfg.RequestBurst)
+	if c.requests.tokens < 1 {
+		return false
+	}
+	c.requests.tokens--
+	return true
+}
+
+// overBudget returns true if key spent more than its cost budget.
+func (l *Limiter) overBudget(key string) bool {
+	if l.cfg.CostPerSecond <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	c := l.get(key, now)
+	c.cost.refill(now, l.cfg.CostPerSecond, l.cfg.CostBurst)
+	return c.cost.tokens < 0
+}
+
+// charge debits cost from the cost budget of key.
+func (l *Limiter) charge(key string, cost float64) {
+	metricCostTotal.Add(cost)
+	if l.cfg.CostPerSecond <= 0 || cost == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	c := l.get(key, now)
+	c.cost.refill(now, l.cfg.CostPerSecond, l.cfg.CostBurst)
+	c.cost.tokens -= cost
+}
+
+// ClientKey identifies the client of r by the identity stored in the request
+// context by authz.Authorizer.Middleware. Anonymous clients are identified by
+// their IP address.
+func ClientKey(r *http.Request) string {
+	if id := authz.IdentityFromContext(r.Context()); id != "" {
+		return "identity:" + id
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx carrying the client key used to charge
+// searches.
+func WithClient(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, clientKey{}, key)
+}
+
+func clientFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(clientKey{}).(string)
+	return key, ok
+}
+
+// Middleware rejects requests of clients which exceeded their request rate
+// with http.StatusTooManyRequests. Otherwise it stores the client key in the
+// request context so searches can be charged to the client. It must run after
+// authz.Authorizer.Middleware, see ClientKey.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ClientKey(r)
+		if !l.allowRequest(key) {
+			metricRejectedTotal.WithLabelValues("rate").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), key)))
+	})
+}
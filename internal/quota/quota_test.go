@@ -0,0 +1,207 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/authz"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// costSearcher returns results costing cost and records the context of the
+// last search.
+type costSearcher struct {
+	zoekt.Streamer
+	cost    int
+	lastCtx context.Context
+}
+
+func (s *costSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.lastCtx = ctx
+	return &zoekt.SearchResult{Stats: zoekt.Stats{FilesLoaded: s.cost}}, nil
+}
+
+func (s *costSearcher) String() string { return "costSearcher" }
+
+func TestMiddlewareRequestRate(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewLimiter(Config{RequestsPerSecond: 1, RequestBurst: 2})
+	l.now = clock.now
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := clientFromContext(r.Context()); !ok {
+			t.Error("missing client in context")
+		}
+	}))
+
+	do := func(remoteAddr string) int {
+		r := httptest.NewRequest("GET", "/search?q=foo", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i, want := range []int{200, 200, 429} {
+		if got := do("10.0.0.1:1234"); got != want {
+			t.Fatalf("request %d: got status %d, want %d", i, got, want)
+		}
+	}
+
+	// Other clients have their own bucket.
+	if got := do("10.0.0.2:1234"); got != 200 {
+		t.Fatalf("other client: got status %d, want 200", got)
+	}
+
+	clock.advance(time.Second)
+	if got := do("10.0.0.1:4321"); got != 200 {
+		t.Fatalf("after refill: got status %d, want 200", got)
+	}
+}
+
+func TestSearcherCostBudget(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		clock := &fakeClock{t: time.Now()}
+		l := NewLimiter(Config{CostPerSecond: 10, CostBurst: 100, RejectOverBudget: reject})
+		l.now = clock.now
+		l.demote = demoteForTest
+
+		inner := &costSearcher{cost: 150}
+		s := l.Searcher(inner)
+		ctx := WithClient(context.Background(), "ip:10.0.0.1")
+
+		// Within budget: runs at normal priority and puts the client in debt.
+		if _, err := s.Search(ctx, &query.Const{Value: true}, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if isBatch(inner.lastCtx) {
+			t.Fatal("search within budget was demoted")
+		}
+
+		_, err := s.Search(ctx, &query.Const{Value: true}, &zoekt.SearchOptions{})
+		if reject {
+			if !errors.Is(err, ErrOverQuota) {
+				t.Fatalf("got err %v, want ErrOverQuota", err)
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isBatch(inner.lastCtx) {
+				t.Fatal("search over budget was not demoted")
+			}
+		}
+
+		// Searches without a client are never limited.
+		inner.lastCtx = nil
+		if _, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Once the debt is paid back the client is within budget again.
+		clock.advance(time.Minute)
+		inner.cost = 0
+		if _, err := s.Search(ctx, &query.Const{Value: true}, &zoekt.SearchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if isBatch(inner.lastCtx) {
+			t.Fatal("search was demoted after budget refilled")
+		}
+	}
+}
+
+type demotedKey struct{}
+
+func demoteForTest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, demotedKey{}, true)
+}
+
+func isBatch(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(demotedKey{}) != nil
+}
+
+func TestCost(t *testing.T) {
+	got := Cost(&zoekt.Stats{FilesLoaded: 2, RegexpsConsidered: 3, ContentBytesLoaded: 2 << 20})
+	if got != 7 {
+		t.Fatalf("got cost %v, want 7", got)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/search?q=foo", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Authorization", "Bearer made-up")
+	if got, want := ClientKey(r), "ip:10.0.0.1"; got != want {
+		t.Errorf("unverified token: got %q, want %q", got, want)
+	}
+
+	r = r.WithContext(authz.WithIdentity(r.Context(), "alice"))
+	if got, want := ClientKey(r), "identity:alice"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLimiterForgetsRefilledClients(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewLimiter(Config{RequestsPerSecond: 10, RequestBurst: 10, CostPerSecond: 1, CostBurst: 10})
+	l.now = clock.now
+
+	l.allowRequest("a")
+	l.charge("b", 5)
+
+	// a refilled after 100ms, b after 5s.
+	clock.advance(time.Second)
+	l.mu.Lock()
+	l.gc(clock.now())
+	_, hasA := l.clients["a"]
+	_, hasB := l.clients["b"]
+	l.mu.Unlock()
+	if hasA || !hasB {
+		t.Errorf("got a=%v b=%v, want only b to be kept", hasA, hasB)
+	}
+
+	// Many new clients trigger a GC before gcInterval passed.
+	for i := 0; i < 3000; i++ {
+		clock.advance(time.Second)
+		l.allowRequest(fmt.Sprintf("ip:10.0.%d.%d", i/256, i%256))
+	}
+	if n := len(l.clients); n > 2048 {
+		t.Errorf("got %d clients, want them to be collected", n)
+	}
+}
+
+func TestBindClientRequestRate(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewLimiter(Config{RequestsPerSecond: 1, RequestBurst: 2})
+	l.now = clock.now
+
+	inner := &costSearcher{}
+	s := l.BindClient(inner, "ip:10.0.0.1")
+	for i, want := range []error{nil, nil, ErrTooManyRequests} {
+		if _, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}); !errors.Is(err, want) {
+			t.Fatalf("call %d: got err %v, want %v", i, err, want)
+		}
+	}
+	if key, _ := clientFromContext(inner.lastCtx); key != "ip:10.0.0.1" {
+		t.Errorf("got client %q, want ip:10.0.0.1", key)
+	}
+
+	clock.advance(time.Second)
+	if _, err := s.Search(context.Background(), &query.Const{Value: true}, &zoekt.SearchOptions{}); err != nil {
+		t.Fatalf("after refill: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/stream"
+)
+
+// Searcher returns a zoekt.Streamer which charges the cost of each search to
+// the client stored in the call context. Searches of clients over their cost
+// budget are rejected or demoted depending on Config.RejectOverBudget. Calls
+// without a client are not limited.
+func (l *Limiter) Searcher(s zoekt.Streamer) zoekt.Streamer {
+	return &quotaSearcher{Streamer: s, limiter: l}
+}
+
+type quotaSearcher struct {
+	zoekt.Streamer
+	limiter *Limiter
+}
+
+// admit checks the cost budget of the client of ctx and returns the context
+// to search with.
+func (s *quotaSearcher) admit(ctx context.Context) (context.Context, error) {
+	key, ok := clientFromContext(ctx)
+	if !ok || !s.limiter.overBudget(key) {
+		return ctx, nil
+	}
+
+	if s.limiter.cfg.RejectOverBudget {
+		metricRejectedTotal.WithLabelValues("cost").Inc()
+		return nil, ErrOverQuota
+	}
+
+	metricDemotedTotal.Inc()
+	return s.limiter.demote(ctx), nil
+}
+
+func (s *quotaSearcher) charge(ctx context.Context, st *zoekt.Stats) {
+	if key, ok := clientFromContext(ctx); ok {
+		s.limiter.charge(key, Cost(st))
+	}
+}
+
+func (s *quotaSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	ctx, err := s.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sr, err := s.Streamer.Search(ctx, q, opts)
+	if sr != nil {
+		s.charge(ctx, &sr.Stats)
+	}
+	return sr, err
+}
+
+func (s *quotaSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	ctx, err := s.admit(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stats zoekt.Stats
+	err = s.Streamer.StreamSearch(ctx, q, opts, stream.SenderFunc(func(event *zoekt.SearchResult) {
+		stats.Add(event.Stats)
+		sender.Send(event)
+	}))
+	s.charge(ctx, &stats)
+	return err
+}
+
+func (s *quotaSearcher) String() string {
+	return fmt.Sprintf("quota(%s)", s.Streamer.String())
+}
+
+// BindClient returns a zoekt.Streamer which calls s with key stored in the
+// context of every call. Each call is charged to the request rate of key,
+// like a request passing Middleware, and fails with ErrTooManyRequests if
+// the rate is exceeded. It is used for transports such as net/rpc where
+// calls don't inherit the context of the HTTP request which opened the
+// connection.
+func (l *Limiter) BindClient(s zoekt.Streamer, key string) zoekt.Streamer {
+	return &boundSearcher{Streamer: s, limiter: l, key: key}
+}
+
+type boundSearcher struct {
+	zoekt.Streamer
+	limiter *Limiter
+	key     string
+}
+
+// bind returns the context to call the underlying searcher with.
+func (s *boundSearcher) bind(ctx context.Context) (context.Context, error) {
+	if !s.limiter.allowRequest(s.key) {
+		metricRejectedTotal.WithLabelValues("rate").Inc()
+		return nil, ErrTooManyRequests
+	}
+	return WithClient(ctx, s.key), nil
+}
+
+func (s *boundSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	ctx, err := s.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.Search(ctx, q, opts)
+}
+
+func (s *boundSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zoekt.SearchOptions, sender zoekt.Sender) error {
+	ctx, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Streamer.StreamSearch(ctx, q, opts, sender)
+}
+
+func (s *boundSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	ctx, err := s.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Streamer.List(ctx, q, opts)
+}
+
+// Close is a no-op since a bound searcher only lives as long as a single
+// connection, while the underlying searcher is shared.
+func (s *boundSearcher) Close() {}
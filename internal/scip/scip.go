@@ -0,0 +1,311 @@
+// Package scip decodes the parts of SCIP code intelligence indexes needed to
+// index precise symbols. See https://github.com/sourcegraph/scip for the
+// format. The index is decoded from the protobuf wire format directly, so we
+// don't depend on the generated bindings.
+package scip
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// PositionEncoding is the unit of the character offsets of a Document.
+type PositionEncoding int32
+
+const (
+	// PositionEncodingUnspecified is treated like PositionEncodingUTF8.
+	PositionEncodingUnspecified PositionEncoding = 0
+	PositionEncodingUTF8        PositionEncoding = 1
+	PositionEncodingUTF16       PositionEncoding = 2
+	PositionEncodingUTF32       PositionEncoding = 3
+)
+
+// SymbolRole is a bitset of the roles of an Occurrence.
+type SymbolRole int32
+
+const (
+	SymbolRoleDefinition SymbolRole = 0x1
+	SymbolRoleImport     SymbolRole = 0x2
+)
+
+// Index is a decoded SCIP index.
+type Index struct {
+	Documents []*Document
+}
+
+// Document holds the occurrences and symbols of a single file.
+type Document struct {
+	// RelativePath is the slash separated path of the file relative to the
+	// project root.
+	RelativePath     string
+	PositionEncoding PositionEncoding
+	Occurrences      []Occurrence
+	Symbols          []SymbolInformation
+}
+
+// Occurrence is a definition of or a reference to a symbol.
+type Occurrence struct {
+	// Range is [startLine, startChar, endChar] or [startLine, startChar,
+	// endLine, endChar]. Lines and characters are 0-based.
+	Range  []int32
+	Symbol string
+	Roles  SymbolRole
+}
+
+// SymbolInformation holds metadata about a symbol defined in a Document.
+type SymbolInformation struct {
+	Symbol      string
+	Kind        int32
+	DisplayName string
+}
+
+// Parse decodes a SCIP index.
+func Parse(b []byte) (*Index, error) {
+	idx := &Index{}
+	err := decodeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num != 2 || typ != protowire.BytesType {
+			return nil
+		}
+		d, err := parseDocument(v)
+		if err != nil {
+			return err
+		}
+		idx.Documents = append(idx.Documents, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scip: %w", err)
+	}
+	return idx, nil
+}
+
+func parseDocument(b []byte) (*Document, error) {
+	d := &Document{}
+	err := decodeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			d.RelativePath = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			o, err := parseOccurrence(v)
+			if err != nil {
+				return err
+			}
+			d.Occurrences = append(d.Occurrences, o)
+		case num == 3 && typ == protowire.BytesType:
+			s, err := parseSymbolInformation(v)
+			if err != nil {
+				return err
+			}
+			d.Symbols = append(d.Symbols, s)
+		case num == 6 && typ == protowire.VarintType:
+			d.PositionEncoding = PositionEncoding(n)
+		}
+		return nil
+	})
+	return d, err
+}
+
+func parseOccurrence(b []byte) (Occurrence, error) {
+	var o Occurrence
+	err := decodeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			o.Range = append(o.Range, int32(n))
+		case num == 1 && typ == protowire.BytesType:
+			// packed
+			for len(v) > 0 {
+				x, l := protowire.ConsumeVarint(v)
+				if l < 0 {
+					return protowire.ParseError(l)
+				}
+				o.Range = append(o.Range, int32(x))
+				v = v[l:]
+			}
+		case num == 2 && typ == protowire.BytesType:
+			o.Symbol = string(v)
+		case num == 3 && typ == protowire.VarintType:
+			o.Roles = SymbolRole(n)
+		}
+		return nil
+	})
+	return o, err
+}
+
+func parseSymbolInformation(b []byte) (SymbolInformation, error) {
+	var s SymbolInformation
+	err := decodeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s.Symbol = string(v)
+		case num == 5 && typ == protowire.VarintType:
+			s.Kind = int32(n)
+		case num == 6 && typ == protowire.BytesType:
+			s.DisplayName = string(v)
+		}
+		return nil
+	})
+	return s, err
+}
+
+// decodeFields calls f for each field of the message b. v is set for
+// length-delimited fields, n for varint fields. Other fields are skipped.
+func decodeFields(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+
+		var (
+			v []byte
+			n uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+
+		if err := f(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Suffix is the kind of a symbol descriptor.
+type Suffix byte
+
+const (
+	SuffixNamespace     Suffix = '/'
+	SuffixType          Suffix = '#'
+	SuffixTerm          Suffix = '.'
+	SuffixMeta          Suffix = ':'
+	SuffixMacro         Suffix = '!'
+	SuffixMethod        Suffix = '('
+	SuffixTypeParameter Suffix = '['
+	SuffixParameter     Suffix = ')'
+)
+
+// Descriptor is one component of the path of a symbol, eg. the "Bar" of
+// "pkg/Foo#Bar().".
+type Descriptor struct {
+	Name   string
+	Suffix Suffix
+}
+
+// IsLocal returns true if symbol is local to its document.
+func IsLocal(symbol string) bool {
+	return strings.HasPrefix(symbol, "local ")
+}
+
+var errBadSymbol = errors.New("malformed symbol")
+
+// ParseDescriptors returns the descriptors of a global symbol, which has the
+// form "<scheme> <manager> <package> <version> <descriptors>".
+func ParseDescriptors(symbol string) ([]Descriptor, error) {
+	s := symbol
+	// Skip the scheme and package fields. Spaces in fields are escaped
+	// by doubling them.
+	for field := 0; field < 4; field++ {
+		i := 0
+		for {
+			j := strings.IndexByte(s[i:], ' ')
+			if j < 0 {
+				return nil, fmt.Errorf("%w: %q", errBadSymbol, symbol)
+			}
+			i += j
+			if i+1 < len(s) && s[i+1] == ' ' {
+				i += 2
+				continue
+			}
+			break
+		}
+		s = s[i+1:]
+	}
+
+	var ds []Descriptor
+	for len(s) > 0 {
+		switch s[0] {
+		case '[', '(':
+			closing := byte(']')
+			suffix := SuffixTypeParameter
+			if s[0] == '(' {
+				closing, suffix = ')', SuffixParameter
+			}
+			name, rest, err := parseName(s[1:])
+			if err != nil || len(rest) == 0 || rest[0] != closing {
+				return nil, fmt.Errorf("%w: %q", errBadSymbol, symbol)
+			}
+			ds = append(ds, Descriptor{Name: name, Suffix: suffix})
+			s = rest[1:]
+			continue
+		}
+
+		name, rest, err := parseName(s)
+		if err != nil || len(rest) == 0 {
+			return nil, fmt.Errorf("%w: %q", errBadSymbol, symbol)
+		}
+		switch c := Suffix(rest[0]); c {
+		case SuffixNamespace, SuffixType, SuffixTerm, SuffixMeta, SuffixMacro:
+			ds = append(ds, Descriptor{Name: name, Suffix: c})
+			s = rest[1:]
+		case SuffixMethod:
+			// method: name(disambiguator).
+			end := strings.Index(rest, ").")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: %q", errBadSymbol, symbol)
+			}
+			ds = append(ds, Descriptor{Name: name, Suffix: SuffixMethod})
+			s = rest[end+2:]
+		default:
+			return nil, fmt.Errorf("%w: %q", errBadSymbol, symbol)
+		}
+	}
+	return ds, nil
+}
+
+// parseName parses a simple or backtick escaped identifier at the start of s.
+func parseName(s string) (name, rest string, err error) {
+	if strings.HasPrefix(s, "`") {
+		var sb strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '`' {
+				sb.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '`' {
+				sb.WriteByte('`')
+				i++
+				continue
+			}
+			return sb.String(), s[i+1:], nil
+		}
+		return "", "", errBadSymbol
+	}
+
+	i := 0
+	for i < len(s) && isIdentifierChar(s[i]) {
+		i++
+	}
+	if i == 0 {
+		return "", "", errBadSymbol
+	}
+	return s[:i], s[i:], nil
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '+' || c == '-' || c == '$' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
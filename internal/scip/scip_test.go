@@ -0,0 +1,127 @@
+package scip
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func TestParse(t *testing.T) {
+	var packed []byte
+	for _, v := range []uint64{2, 5, 8} {
+		packed = protowire.AppendVarint(packed, v)
+	}
+
+	var occ []byte
+	occ = appendMessage(occ, 1, packed)
+	occ = appendString(occ, 2, "go gomod example v1 Foo#")
+	occ = appendVarint(occ, 3, uint64(SymbolRoleDefinition))
+	// unknown field
+	occ = appendVarint(occ, 5, 42)
+
+	var si []byte
+	si = appendString(si, 1, "go gomod example v1 Foo#")
+	si = appendVarint(si, 5, 49)
+
+	var doc []byte
+	doc = appendString(doc, 1, "foo/foo.go")
+	doc = appendMessage(doc, 2, occ)
+	doc = appendMessage(doc, 3, si)
+	doc = appendVarint(doc, 6, uint64(PositionEncodingUTF16))
+
+	var metadata []byte
+	metadata = appendString(metadata, 3, "file:///src")
+
+	var index []byte
+	index = appendMessage(index, 1, metadata)
+	index = appendMessage(index, 2, doc)
+
+	got, err := Parse(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Index{Documents: []*Document{{
+		RelativePath:     "foo/foo.go",
+		PositionEncoding: PositionEncodingUTF16,
+		Occurrences: []Occurrence{{
+			Range:  []int32{2, 5, 8},
+			Symbol: "go gomod example v1 Foo#",
+			Roles:  SymbolRoleDefinition,
+		}},
+		Symbols: []SymbolInformation{{
+			Symbol: "go gomod example v1 Foo#",
+			Kind:   49,
+		}},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := Parse(index[:len(index)-3]); err == nil {
+		t.Error("want error for truncated index")
+	}
+}
+
+func TestParseDescriptors(t *testing.T) {
+	cases := []struct {
+		symbol string
+		want   []Descriptor
+	}{{
+		symbol: "scip-go gomod github.com/foo/bar v1.0.0 `github.com/foo/bar/baz`/Server#Search().",
+		want: []Descriptor{
+			{Name: "github.com/foo/bar/baz", Suffix: SuffixNamespace},
+			{Name: "Server", Suffix: SuffixType},
+			{Name: "Search", Suffix: SuffixMethod},
+		},
+	}, {
+		symbol: "scip-typescript npm my  pkg 1.0 src/`index.ts`/foo().(x)",
+		want: []Descriptor{
+			{Name: "src", Suffix: SuffixNamespace},
+			{Name: "index.ts", Suffix: SuffixNamespace},
+			{Name: "foo", Suffix: SuffixMethod},
+			{Name: "x", Suffix: SuffixParameter},
+		},
+	}, {
+		symbol: "rust-analyzer cargo std . vec/Vec#[T]len().",
+		want: []Descriptor{
+			{Name: "vec", Suffix: SuffixNamespace},
+			{Name: "Vec", Suffix: SuffixType},
+			{Name: "T", Suffix: SuffixTypeParameter},
+			{Name: "len", Suffix: SuffixMethod},
+		},
+	}}
+
+	for _, c := range cases {
+		got, err := ParseDescriptors(c.symbol)
+		if err != nil {
+			t.Errorf("ParseDescriptors(%q): %v", c.symbol, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseDescriptors(%q): got %+v, want %+v", c.symbol, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"local 1", "go gomod example v1 Foo", "go gomod example v1 Foo(x"} {
+		if _, err := ParseDescriptors(bad); err == nil {
+			t.Errorf("ParseDescriptors(%q): want error", bad)
+		}
+	}
+}
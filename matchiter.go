@@ -24,6 +24,7 @@ type candidateMatch struct {
 	caseSensitive bool
 	fileName      bool
 	symbol        bool
+	symbolRef     bool // symbolIdx is an index into the symbol references
 	symbolIdx     uint32
 
 	substrBytes   []byte
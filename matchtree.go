@@ -215,6 +215,53 @@ func (t *symbolRegexpMatchTree) matches(cp *contentProvider, cost int, known map
 	return len(t.found) > 0, true
 }
 
+// symbolRefMatchTree matches the regexp against the symbol references of a
+// document. The child only selects candidate documents.
+type symbolRefMatchTree struct {
+	matchTree
+	regexp *regexp.Regexp
+
+	reEvaluated bool
+	found       []*candidateMatch
+}
+
+func (t *symbolRefMatchTree) prepare(doc uint32) {
+	t.matchTree.prepare(doc)
+	t.reEvaluated = false
+}
+
+func (t *symbolRefMatchTree) matches(cp *contentProvider, cost int, known map[matchTree]bool) (bool, bool) {
+	if t.reEvaluated {
+		return len(t.found) > 0, true
+	}
+
+	if cost < costRegexp {
+		return false, false
+	}
+
+	refs := cp.symbolRefs()
+	content := cp.data(false)
+
+	found := t.found[:0]
+	for i, sec := range refs {
+		idx := t.regexp.FindIndex(content[sec.Start:sec.End])
+		if idx == nil {
+			continue
+		}
+
+		found = append(found, &candidateMatch{
+			byteOffset:  sec.Start + uint32(idx[0]),
+			byteMatchSz: uint32(idx[1] - idx[0]),
+			symbolRef:   true,
+			symbolIdx:   uint32(i),
+		})
+	}
+	t.found = found
+	t.reEvaluated = true
+
+	return len(t.found) > 0, true
+}
+
 type symbolSubstrMatchTree struct {
 	*substrMatchTree
 
@@ -456,6 +503,10 @@ func (t *symbolRegexpMatchTree) String() string {
 	return fmt.Sprintf("symbol(%v)", t.matchTree)
 }
 
+func (t *symbolRefMatchTree) String() string {
+	return fmt.Sprintf("symref(%v)", t.matchTree)
+}
+
 // visitMatches visits all atoms in matchTree. Note: This visits
 // noVisitMatchTree. For collecting matches use visitMatches.
 func visitMatchTree(t matchTree, f func(matchTree)) {
@@ -480,6 +531,8 @@ func visitMatchTree(t matchTree, f func(matchTree)) {
 		visitMatchTree(s.substrMatchTree, f)
 	case *symbolRegexpMatchTree:
 		visitMatchTree(s.matchTree, f)
+	case *symbolRefMatchTree:
+		visitMatchTree(s.matchTree, f)
 	default:
 		f(t)
 	}
@@ -881,6 +934,37 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			},
 		}, nil
 
+	case *query.HistoryType:
+		return &docMatchTree{
+			reason:  "history",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				c := d.commit(docID)
+				return c != nil && (s.Kind == "" || c.Kind == s.Kind)
+			},
+		}, nil
+
+	case *query.Author:
+		pattern := strings.ToLower(s.Pattern)
+		return &docMatchTree{
+			reason:  "author",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				c := d.commit(docID)
+				return c != nil && strings.Contains(strings.ToLower(c.Author), pattern)
+			},
+		}, nil
+
+	case *query.CommitDate:
+		return &docMatchTree{
+			reason:  "commit date",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				c := d.commit(docID)
+				return c != nil && c.Date.Before(s.Time) == s.Before
+			},
+		}, nil
+
 	case *query.Symbol:
 		subMT, err := d.newMatchTree(s.Expr)
 		if err != nil {
@@ -920,6 +1004,37 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			matchTree: subMT,
 		}, nil
 
+	case *query.SymbolRef:
+		var expr string
+		switch e := s.Expr.(type) {
+		case *query.Substring:
+			expr = regexp.QuoteMeta(e.Pattern)
+			if !e.CaseSensitive {
+				expr = "(?i)" + expr
+			}
+		case *query.Regexp:
+			expr = e.Regexp.String()
+			if !e.CaseSensitive {
+				expr = "(?i)" + expr
+			}
+		default:
+			return nil, fmt.Errorf("found %T inside query.SymbolRef", s.Expr)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		subMT, err := d.newMatchTree(s.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &symbolRefMatchTree{
+			matchTree: subMT,
+			regexp:    re,
+		}, nil
+
 	case *query.FileNameSet:
 		return &docMatchTree{
 			reason:  "FileNameSet",
@@ -950,6 +1065,19 @@ func (d *indexData) newMatchTree(q query.Q) (matchTree, error) {
 			},
 		}, nil
 
+	case *query.At:
+		reposWant := make([]bool, len(d.repoMetaData))
+		for repoIdx := range d.repoMetaData {
+			reposWant[repoIdx] = snapshotAt(&d.repoMetaData[repoIdx], s)
+		}
+		return &docMatchTree{
+			reason:  "at",
+			numDocs: d.numDocs(),
+			predicate: func(docID uint32) bool {
+				return reposWant[d.repos[docID]]
+			},
+		}, nil
+
 	case *query.RepoSet:
 		reposWant := make([]bool, len(d.repoMetaData))
 		for repoIdx, r := range d.repoMetaData {
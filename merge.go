@@ -228,9 +228,35 @@ func addDocument(d *indexData, ib *IndexBuilder, repoID int, docID uint32) error
 		return err
 	}
 
+	if doc.SymbolRefs, _, err = d.readSymbolRefs(docID, nil); err != nil {
+		return err
+	}
+
+	if doc.OriginalName, err = d.readDocString(&d.originalNames, docID); err != nil {
+		return err
+	}
+
+	if doc.Encoding, err = d.readDocString(&d.encodings, docID); err != nil {
+		return err
+	}
+
+	doc.Commit = d.commit(docID)
+
+	if doc.Blame, err = d.readBlame(docID); err != nil {
+		return err
+	}
+
+	ids, err := d.readSymbolIDs(docID)
+	if err != nil {
+		return err
+	}
+
 	doc.SymbolsMetaData = make([]*Symbol, len(doc.Symbols))
 	for i := range doc.SymbolsMetaData {
 		doc.SymbolsMetaData[i] = d.symbols.data(d.fileEndSymbol[docID] + uint32(i))
+		if i < len(ids) && doc.SymbolsMetaData[i] != nil {
+			doc.SymbolsMetaData[i].ID = ids[i]
+		}
 	}
 
 	// calculate branches
@@ -0,0 +1,272 @@
+package zoekt
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"regexp/syntax"
+	"unicode"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// ngramFilterBitsPerNgram is the size of an NgramFilter per ngram. Since a
+// query only passes the filter if all its ngrams do, the false positive rate
+// of about 2% per ngram is plenty for skipping shards.
+const ngramFilterBitsPerNgram = 10
+
+// NgramFilter is a blocked Bloom filter of the case folded ngrams of the
+// contents and file names of a shard. It is built when the shard is loaded
+// and is much smaller than the ngram index, so it can be checked for every
+// shard before searching it.
+//
+// All bits set for an ngram are in the same 64-bit block, so testing an
+// ngram costs a single cache miss.
+type NgramFilter struct {
+	// blocks has a power of 2 length.
+	blocks []uint64
+}
+
+func newNgramFilter(ngrams int) *NgramFilter {
+	n := (ngrams*ngramFilterBitsPerNgram + 63) / 64
+	if n < 1 {
+		n = 1
+	}
+	return &NgramFilter{blocks: make([]uint64, 1<<bits.Len(uint(n-1)))}
+}
+
+func (f *NgramFilter) add(ng ngram) {
+	h := ngramFilterHash(ng)
+	f.blocks[h&uint64(len(f.blocks)-1)] |= ngramFilterMask(h)
+}
+
+func (f *NgramFilter) contains(h uint64) bool {
+	m := ngramFilterMask(h)
+	return f.blocks[h&uint64(len(f.blocks)-1)]&m == m
+}
+
+// SizeBytes returns the memory used by f.
+func (f *NgramFilter) SizeBytes() int {
+	if f == nil {
+		return 0
+	}
+	return 8 * len(f.blocks)
+}
+
+// MayMatch returns false if a shard can't match q, because it lacks ngrams
+// q requires. A nil filter or query may match.
+func (f *NgramFilter) MayMatch(q *NgramFilterQuery) bool {
+	if f == nil || q == nil {
+		return true
+	}
+	switch {
+	case q.never:
+		return false
+	case q.or != nil:
+		for _, c := range q.or {
+			if f.MayMatch(c) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, h := range q.ngrams {
+		if !f.contains(h) {
+			return false
+		}
+	}
+	for _, c := range q.and {
+		if !f.MayMatch(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// ngramFilterHash returns the hash of the case folded ng.
+func ngramFilterHash(ng ngram) uint64 {
+	rs := ngramToRunes(ng)
+	for i, r := range rs {
+		rs[i] = foldRune(r)
+	}
+
+	// splitmix64 finalizer
+	x := uint64(runesToNGram(rs))
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ngramFilterMask returns the bits set in a block for hash h. The low bits of
+// h select the block, the high bits select 4 bits in the block.
+func ngramFilterMask(h uint64) uint64 {
+	return 1<<(h>>40&63) | 1<<(h>>46&63) | 1<<(h>>52&63) | 1<<(h>>58)
+}
+
+// foldRune returns the smallest rune which is equal to r under simple case
+// folding, so that all case variants of an ngram have the same hash.
+func foldRune(r rune) rune {
+	if r < 0x80 {
+		if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}
+	min := r
+	for c := unicode.SimpleFold(r); c != r; c = unicode.SimpleFold(c) {
+		if c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// buildNgramFilter returns the filter of the content ngrams in ngramText,
+// encoded as in the ngramText section, and of the file name ngrams.
+func buildNgramFilter(ngramText []byte, nameNgrams map[ngram][]byte) *NgramFilter {
+	f := newNgramFilter(len(ngramText)/ngramEncoding + len(nameNgrams))
+	for i := 0; i+ngramEncoding <= len(ngramText); i += ngramEncoding {
+		f.add(ngram(binary.BigEndian.Uint64(ngramText[i : i+ngramEncoding])))
+	}
+	for ng := range nameNgrams {
+		f.add(ng)
+	}
+	return f
+}
+
+// NgramFilterQuery holds the ngrams required by a query, to test it against
+// the NgramFilters of many shards.
+type NgramFilterQuery struct {
+	// never is set if the query matches nothing.
+	never bool
+
+	// ngrams holds the hashes of ngrams which are all required.
+	ngrams []uint64
+
+	// and holds subqueries which must all match.
+	and []*NgramFilterQuery
+
+	// or, if not nil, holds subqueries of which one must match. ngrams and
+	// and are empty then.
+	or []*NgramFilterQuery
+}
+
+// NewNgramFilterQuery returns the ngrams required by q. It returns nil if q
+// may match without any ngram, eg. if it only restricts repositories or
+// contains negations.
+func NewNgramFilterQuery(q query.Q) *NgramFilterQuery {
+	switch q := q.(type) {
+	case *query.Const:
+		if !q.Value {
+			return &NgramFilterQuery{never: true}
+		}
+	case *query.Substring:
+		return substringFilterQuery(q.Pattern)
+	case *query.Regexp:
+		return regexpFilterQuery(q.Regexp)
+	case *query.Symbol:
+		return NewNgramFilterQuery(q.Expr)
+	case *query.Type:
+		return NewNgramFilterQuery(q.Child)
+	case *query.And:
+		children := make([]*NgramFilterQuery, 0, len(q.Children))
+		for _, c := range q.Children {
+			children = append(children, NewNgramFilterQuery(c))
+		}
+		return andFilterQuery(children)
+	case *query.Or:
+		children := make([]*NgramFilterQuery, 0, len(q.Children))
+		for _, c := range q.Children {
+			children = append(children, NewNgramFilterQuery(c))
+		}
+		return orFilterQuery(children)
+	}
+	return nil
+}
+
+func substringFilterQuery(pattern string) *NgramFilterQuery {
+	var hashes []uint64
+	seen := map[ngram]bool{}
+	for _, o := range splitNGrams([]byte(pattern)) {
+		if !seen[o.ngram] {
+			seen[o.ngram] = true
+			hashes = append(hashes, ngramFilterHash(o.ngram))
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	return &NgramFilterQuery{ngrams: hashes}
+}
+
+// regexpFilterQuery follows indexData.regexpToMatchTreeRecursive, which
+// decides which ngrams a regexp search looks up.
+func regexpFilterQuery(r *syntax.Regexp) *NgramFilterQuery {
+	switch r.Op {
+	case syntax.OpLiteral:
+		return substringFilterQuery(string(r.Rune))
+	case syntax.OpCapture, syntax.OpPlus:
+		return regexpFilterQuery(r.Sub[0])
+	case syntax.OpRepeat:
+		if r.Min >= 1 {
+			return regexpFilterQuery(r.Sub[0])
+		}
+	case syntax.OpConcat:
+		children := make([]*NgramFilterQuery, 0, len(r.Sub))
+		for _, sr := range r.Sub {
+			children = append(children, regexpFilterQuery(sr))
+		}
+		return andFilterQuery(children)
+	case syntax.OpAlternate:
+		children := make([]*NgramFilterQuery, 0, len(r.Sub))
+		for _, sr := range r.Sub {
+			children = append(children, regexpFilterQuery(sr))
+		}
+		return orFilterQuery(children)
+	}
+	return nil
+}
+
+// andFilterQuery ignores the children which don't require ngrams.
+func andFilterQuery(children []*NgramFilterQuery) *NgramFilterQuery {
+	and := &NgramFilterQuery{}
+	for _, c := range children {
+		switch {
+		case c == nil:
+		case c.never:
+			return c
+		case c.or != nil:
+			and.and = append(and.and, c)
+		default:
+			and.ngrams = append(and.ngrams, c.ngrams...)
+			and.and = append(and.and, c.and...)
+		}
+	}
+	if len(and.ngrams) == 0 && len(and.and) == 0 {
+		return nil
+	}
+	return and
+}
+
+// orFilterQuery requires ngrams only if all children do.
+func orFilterQuery(children []*NgramFilterQuery) *NgramFilterQuery {
+	or := &NgramFilterQuery{or: []*NgramFilterQuery{}}
+	for _, c := range children {
+		switch {
+		case c == nil:
+			return nil
+		case c.never:
+		default:
+			or.or = append(or.or, c)
+		}
+	}
+	if len(or.or) == 0 {
+		return &NgramFilterQuery{never: true}
+	}
+	if len(or.or) == 1 {
+		return or.or[0]
+	}
+	return or
+}
@@ -0,0 +1,65 @@
+package zoekt
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestNgramFilter(t *testing.T) {
+	b := testIndexBuilder(t, nil,
+		Document{Name: "Makefile", Content: []byte("build: ÄRGER\n\tgo build ./...\n")},
+		Document{Name: "main.go", Content: []byte("package main\n\nfunc helloWorld() {}\n")},
+	)
+	f := searcherForTest(t, b).(*indexData).NgramFilter()
+	if f == nil {
+		t.Fatal("shard has no ngram filter")
+	}
+
+	cases := []struct {
+		q    query.Q
+		want bool
+	}{
+		{&query.Substring{Pattern: "helloWorld"}, true},
+		{&query.Substring{Pattern: "HELLOWORLD"}, true},
+		{&query.Substring{Pattern: "ärger", CaseSensitive: true}, true},
+		{&query.Substring{Pattern: "makefile", FileName: true}, true},
+		{&query.Substring{Pattern: "goodbyeWorld"}, false},
+		{&query.Substring{Pattern: "go"}, true},
+		{&query.Regexp{Regexp: mustParseRE("hello.*World")}, true},
+		{&query.Regexp{Regexp: mustParseRE("goodbye.*World")}, false},
+		{&query.Regexp{Regexp: mustParseRE("(goodbye|hello)World")}, true},
+		{&query.Regexp{Regexp: mustParseRE("(goodbye|farewell)World")}, false},
+		{&query.Regexp{Regexp: mustParseRE("(goodbye|x)World")}, true},
+		{&query.Symbol{Expr: &query.Substring{Pattern: "goodbye"}}, false},
+		{query.NewAnd(&query.Substring{Pattern: "hello"}, &query.Substring{Pattern: "goodbye"}), false},
+		{query.NewAnd(&query.Substring{Pattern: "hello"}, &query.Branch{Pattern: "main"}), true},
+		{query.NewOr(&query.Substring{Pattern: "hello"}, &query.Substring{Pattern: "goodbye"}), true},
+		{query.NewOr(&query.Substring{Pattern: "farewell"}, &query.Substring{Pattern: "goodbye"}), false},
+		{query.NewOr(&query.Substring{Pattern: "farewell"}, &query.Not{Child: &query.Substring{Pattern: "goodbye"}}), true},
+		{&query.Const{Value: false}, false},
+	}
+	for _, tc := range cases {
+		if got := f.MayMatch(NewNgramFilterQuery(tc.q)); got != tc.want {
+			t.Errorf("MayMatch(%s) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestNgramFilterFalsePositives(t *testing.T) {
+	f := newNgramFilter(10000)
+	for i := 0; i < 10000; i++ {
+		f.add(runesToNGram([ngramSize]rune{'a', rune(0x4e00 + i), 'b'}))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		ng := runesToNGram([ngramSize]rune{'x', rune(0x4e00 + i), 'y'})
+		if f.contains(ngramFilterHash(ng)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("got %d false positives of 10000, want < 3%%", falsePositives)
+	}
+}
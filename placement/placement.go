@@ -0,0 +1,225 @@
+// Package placement assigns the repositories of a zoekt cluster to its nodes
+// by rendezvous hashing: each repository is owned by the nodes with the
+// highest hashes of the node and the repository. Adding or removing a node
+// only moves the repositories it gains or loses.
+//
+// Repositories are identified by keys. zoekt-indexserver uses repository
+// names, zoekt-sourcegraph-indexserver uses IDKey of the repository IDs.
+package placement
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Placement assigns keys to nodes.
+type Placement struct {
+	nodes    []string
+	replicas int
+}
+
+// New returns a placement of each key on replicas of nodes. replicas is
+// clamped to the number of nodes, and is at least 1.
+func New(nodes []string, replicas int) *Placement {
+	uniq := map[string]bool{}
+	var sorted []string
+	for _, n := range nodes {
+		if !uniq[n] {
+			uniq[n] = true
+			sorted = append(sorted, n)
+		}
+	}
+	sort.Strings(sorted)
+
+	if replicas > len(sorted) {
+		replicas = len(sorted)
+	}
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &Placement{nodes: sorted, replicas: replicas}
+}
+
+// IDKey returns the key of a repository placed by ID.
+func IDKey(id uint32) string {
+	return "id:" + strconv.FormatUint(uint64(id), 10)
+}
+
+// Nodes returns the nodes in sorted order.
+func (p *Placement) Nodes() []string {
+	return p.nodes
+}
+
+// Replicas returns the number of nodes owning each key.
+func (p *Placement) Replicas() int {
+	return p.replicas
+}
+
+// Owners returns the nodes owning key, best first. Searches of key should
+// prefer the first owner, so that the caches of the other replicas stay
+// cold only for failover.
+func (p *Placement) Owners(key string) []string {
+	if len(p.nodes) == 0 {
+		return nil
+	}
+
+	type scored struct {
+		node  string
+		score uint64
+	}
+	scores := make([]scored, len(p.nodes))
+	for i, n := range p.nodes {
+		scores[i] = scored{node: n, score: score(n, key)}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].score != scores[j].score {
+			return scores[i].score > scores[j].score
+		}
+		return scores[i].node < scores[j].node
+	})
+
+	owners := make([]string, p.replicas)
+	for i := range owners {
+		owners[i] = scores[i].node
+	}
+	return owners
+}
+
+// Owns returns true if node is one of the owners of key.
+func (p *Placement) Owns(node, key string) bool {
+	for _, o := range p.Owners(key) {
+		if o == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal returns true if p and o place all keys on the same nodes.
+func (p *Placement) Equal(o *Placement) bool {
+	if p.replicas != o.replicas || len(p.nodes) != len(o.nodes) {
+		return false
+	}
+	for i := range p.nodes {
+		if p.nodes[i] != o.nodes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Placement) String() string {
+	return fmt.Sprintf("placement(replicas=%d, nodes=%s)", p.replicas, strings.Join(p.nodes, ","))
+}
+
+// score hashes node and key. FNV alone doesn't mix the high bits well
+// enough for similar inputs, so it is followed by the splitmix64 finalizer.
+func score(node, key string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, node)
+	h.Write([]byte{0})
+	io.WriteString(h, key)
+
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ParseNodes parses a membership file, which holds a node name per line.
+// Blank lines and lines starting with # are ignored.
+func ParseNodes(r io.Reader) ([]string, error) {
+	var nodes []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		nodes = append(nodes, line)
+	}
+	return nodes, scanner.Err()
+}
+
+// Membership is the placement of the nodes listed in a static membership
+// file, which can be reloaded when the file changes.
+type Membership struct {
+	path     string
+	replicas int
+
+	// current holds a *Placement.
+	current atomic.Value
+}
+
+// LoadMembership reads the membership file at path.
+func LoadMembership(path string, replicas int) (*Membership, error) {
+	m := &Membership{path: path, replicas: replicas}
+	if _, err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Placement returns the current placement.
+func (m *Membership) Placement() *Placement {
+	return m.current.Load().(*Placement)
+}
+
+// Reload reads the membership file again. It returns true if the placement
+// changed. On error the current placement is kept.
+func (m *Membership) Reload() (changed bool, err error) {
+	f, err := os.Open(m.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	nodes, err := ParseNodes(f)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", m.path, err)
+	}
+	if len(nodes) == 0 {
+		return false, fmt.Errorf("%s: no nodes", m.path)
+	}
+
+	p := New(nodes, m.replicas)
+	if old, ok := m.current.Load().(*Placement); ok && old.Equal(p) {
+		return false, nil
+	}
+	m.current.Store(p)
+	return true, nil
+}
+
+// Watch reloads the membership file every interval until ctx is done.
+// Indexservers pick up the new placement when they next list the
+// repositories to index, which indexes the repositories gained and deletes
+// the ones lost.
+func (m *Membership) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if changed, err := m.Reload(); err != nil {
+			log.Printf("placement: reloading %s: %v", m.path, err)
+		} else if changed {
+			log.Printf("placement: membership changed to %s", m.Placement())
+		}
+	}
+}
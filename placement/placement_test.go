@@ -0,0 +1,116 @@
+package placement
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOwners(t *testing.T) {
+	p := New([]string{"c", "a", "b", "a", "d"}, 2)
+	if diff := cmp.Diff([]string{"a", "b", "c", "d"}, p.Nodes()); diff != "" {
+		t.Errorf("nodes mismatch (-want +got):\n%s", diff)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("repo%d", i)
+		owners := p.Owners(key)
+		if len(owners) != 2 || owners[0] == owners[1] {
+			t.Fatalf("got owners %v of %s, want 2 distinct nodes", owners, key)
+		}
+		if diff := cmp.Diff(owners, New([]string{"d", "c", "b", "a"}, 2).Owners(key)); diff != "" {
+			t.Fatalf("owners of %s depend on node order (-want +got):\n%s", key, diff)
+		}
+		for _, o := range owners {
+			if !p.Owns(o, key) {
+				t.Errorf("%s doesn't own %s", o, key)
+			}
+			counts[o]++
+		}
+	}
+
+	// Each node owns about half of the keys.
+	for n, c := range counts {
+		if c < 400 || c > 600 {
+			t.Errorf("node %s owns %d of 2000 replicas, want about 500", n, c)
+		}
+	}
+}
+
+func TestReplicasClamped(t *testing.T) {
+	if got := New([]string{"a", "b"}, 5).Replicas(); got != 2 {
+		t.Errorf("got %d replicas, want 2", got)
+	}
+	if got := New([]string{"a", "b"}, 0).Replicas(); got != 1 {
+		t.Errorf("got %d replicas, want 1", got)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	before := New([]string{"a", "b", "c"}, 1)
+	after := New([]string{"a", "b", "c", "d"}, 1)
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := IDKey(uint32(i))
+		o, n := before.Owners(key)[0], after.Owners(key)[0]
+		if o != n {
+			// Keys only move to the new node.
+			if n != "d" {
+				t.Fatalf("%s moved from %s to %s", key, o, n)
+			}
+			moved++
+		}
+	}
+	if moved < 150 || moved > 350 {
+		t.Errorf("%d of 1000 keys moved, want about 250", moved)
+	}
+}
+
+func TestParseNodes(t *testing.T) {
+	nodes, err := ParseNodes(strings.NewReader("# cluster\nzoekt-0:6070\n\n  zoekt-1:6070  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"zoekt-0:6070", "zoekt-1:6070"}, nodes); diff != "" {
+		t.Errorf("nodes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMembershipReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("a\nb\n")
+	m, err := LoadMembership(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("b\na\n")
+	if changed, err := m.Reload(); err != nil || changed {
+		t.Errorf("got changed=%v, err=%v for reordered nodes", changed, err)
+	}
+
+	write("a\nb\nc\n")
+	if changed, err := m.Reload(); err != nil || !changed {
+		t.Errorf("got changed=%v, err=%v for added node", changed, err)
+	}
+
+	write("")
+	if _, err := m.Reload(); err == nil {
+		t.Error("got no error for empty membership")
+	}
+	if got := len(m.Placement().Nodes()); got != 3 {
+		t.Errorf("got %d nodes after failed reload, want 3", got)
+	}
+}
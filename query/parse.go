@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"log"
 	"regexp/syntax"
+	"time"
 
 	"github.com/go-enry/go-enry/v2"
 	"github.com/grafana/regexp"
@@ -174,6 +175,17 @@ func parseExpr(in []byte) (Q, int, error) {
 		}
 
 		expr = &Symbol{q}
+	case tokSymRef:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the symref: atom must have an argument")
+		}
+
+		q, err := RegexpQuery(text, false, false)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		expr = &SymbolRef{q}
 	case tokParenClose:
 		// Caller must consume paren.
 		expr = nil
@@ -210,6 +222,13 @@ func parseExpr(in []byte) (Q, int, error) {
 		expr = &Not{subQ}
 
 	case tokType:
+		if text == "commit" || text == "diff" {
+			// Restricts the documents searched rather than the result
+			// type.
+			expr = &HistoryType{Kind: text}
+			break
+		}
+
 		var t uint8
 		switch text {
 		case "filematch":
@@ -219,15 +238,52 @@ func parseExpr(in []byte) (Q, int, error) {
 		case "repo":
 			t = TypeRepo
 		default:
-			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo}", text)
+			return nil, 0, fmt.Errorf("query: unknown type argument %q, want {filematch,filename,repo,commit,diff}", text)
 		}
 		// Later we will lift this into a root, like we do for caseQ
 		expr = &Type{Type: t, Child: nil}
+	case tokAuthor:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the author: atom must have an argument")
+		}
+		expr = &Author{Pattern: text}
+	case tokBefore, tokAfter:
+		t, err := parseDate(text)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr = &CommitDate{Time: t, Before: tok.Type == tokBefore}
+	case tokAt:
+		if text == "" {
+			return nil, 0, fmt.Errorf("the at: atom must have an argument")
+		}
+		if t, err := parseDate(text); err == nil {
+			expr = &At{Time: t}
+		} else {
+			expr = &At{Revision: text}
+		}
 	}
 
 	return expr, len(in) - len(b), nil
 }
 
+// dateLayouts are the layouts accepted by before:, after: and at:. Dates
+// without a time zone are in UTC.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+func parseDate(text string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("query: invalid date %q, want YYYY-MM-DD or RFC 3339", text)
+}
+
 const regexpFlags syntax.Flags = syntax.ClassNL | syntax.PerlX | syntax.UnicodeGroups
 
 // RegexpQuery parses an atom into either a regular expression, or a
@@ -374,9 +430,18 @@ const (
 	tokSym        = 13
 	tokType       = 14
 	tokArchived   = 15
+	tokSymRef     = 16
+	tokAuthor     = 17
+	tokBefore     = 18
+	tokAfter      = 19
+	tokAt         = 20
 )
 
 var tokNames = map[int]string{
+	tokAfter:      "After",
+	tokAt:         "At",
+	tokAuthor:     "Author",
+	tokBefore:     "Before",
 	tokArchived:   "Archived",
 	tokBranch:     "Branch",
 	tokCase:       "Case",
@@ -391,10 +456,15 @@ var tokNames = map[int]string{
 	tokText:       "Text",
 	tokLang:       "Language",
 	tokSym:        "Symbol",
+	tokSymRef:     "SymbolRef",
 	tokType:       "Type",
 }
 
 var prefixes = map[string]int{
+	"after:":    tokAfter,
+	"at:":       tokAt,
+	"author:":   tokAuthor,
+	"before:":   tokBefore,
 	"archived:": tokArchived,
 	"b:":        tokBranch,
 	"branch:":   tokBranch,
@@ -408,6 +478,7 @@ var prefixes = map[string]int{
 	"repo:":     tokRepo,
 	"lang:":     tokLang,
 	"sym:":      tokSym,
+	"symref:":   tokSymRef,
 	"t:":        tokType,
 	"type:":     tokType,
 }
@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"regexp/syntax"
 	"testing"
+	"time"
 
 	"github.com/grafana/regexp"
 )
@@ -94,6 +95,19 @@ func TestParseQuery(t *testing.T) {
 		{"sym:Pqr", &Symbol{&Substring{Pattern: "Pqr", CaseSensitive: true}}},
 		{"sym:.*", &Symbol{&Regexp{Regexp: mustParseRE(".*")}}},
 		{"sym:a(b|d)e", &Symbol{&Regexp{Regexp: mustParseRE("a[bd]e")}}},
+		{"symref:pqr", &SymbolRef{&Substring{Pattern: "pqr"}}},
+		{"symref:Pqr", &SymbolRef{&Substring{Pattern: "Pqr", CaseSensitive: true}}},
+
+		// history
+		{"type:commit abc", NewAnd(&HistoryType{Kind: "commit"}, &Substring{Pattern: "abc"})},
+		{"type:diff", &HistoryType{Kind: "diff"}},
+		{"author:Alice", &Author{Pattern: "Alice"}},
+		{"before:2023-01-02", &CommitDate{Time: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), Before: true}},
+		{"after:2023-01-02T03:04:05Z", &CommitDate{Time: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}},
+
+		// snapshots
+		{"at:v3.0 abc", NewAnd(&At{Revision: "v3.0"}, &Substring{Pattern: "abc"})},
+		{"at:2023-01-02", &At{Time: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}},
 
 		// case
 		{"abc case:yes", &Substring{Pattern: "abc", CaseSensitive: true}},
@@ -117,6 +131,9 @@ func TestParseQuery(t *testing.T) {
 		{"case:foo", nil},
 
 		{"sym:", nil},
+		{"symref:", nil},
+		{"author:", nil},
+		{"before:yesterday", nil},
 		{"abc or", nil},
 		{"or abc", nil},
 		{"def or or abc", nil},
@@ -26,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/RoaringBitmap/roaring"
 	"github.com/grafana/regexp"
@@ -141,6 +142,17 @@ func (s *Symbol) String() string {
 	return fmt.Sprintf("sym:%s", s.Expr)
 }
 
+// SymbolRef finds a string that is a reference to a symbol, such as a call
+// or a use of a type. Shards only contain references if they were built with
+// symbol references enabled.
+type SymbolRef struct {
+	Expr Q
+}
+
+func (s *SymbolRef) String() string {
+	return fmt.Sprintf("symref:%s", s.Expr)
+}
+
 type caseQ struct {
 	Flavor string
 }
@@ -157,6 +169,93 @@ func (l *Language) String() string {
 	return "lang:" + l.Language
 }
 
+// HistoryType matches documents of history shards of the given kind,
+// "commit" for commit messages and "diff" for the changes of a commit to a
+// file. An empty Kind matches both. Other documents are only searched if a
+// query has no history atoms, see IsHistory.
+type HistoryType struct {
+	Kind string
+}
+
+func (q *HistoryType) String() string {
+	if q.Kind == "" {
+		return "type:history"
+	}
+	return "type:" + q.Kind
+}
+
+// Author matches documents of history shards whose commit author, formatted
+// as "Name <email>", contains Pattern, ignoring case.
+type Author struct {
+	Pattern string
+}
+
+func (q *Author) String() string {
+	return fmt.Sprintf("author:%q", q.Pattern)
+}
+
+// CommitDate matches documents of history shards whose commit was authored
+// before Time, or at or after Time if Before is false.
+type CommitDate struct {
+	Time   time.Time
+	Before bool
+}
+
+func (q *CommitDate) String() string {
+	if q.Before {
+		return "before:" + q.Time.Format(time.RFC3339)
+	}
+	return "after:" + q.Time.Format(time.RFC3339)
+}
+
+// IsHistory returns true if q has an atom which only matches documents of
+// history shards.
+func IsHistory(q Q) bool {
+	found := false
+	VisitAtoms(q, func(q Q) {
+		switch q.(type) {
+		case *HistoryType, *Author, *CommitDate:
+			found = true
+		}
+	})
+	return found
+}
+
+// At matches documents of snapshot shards, see zoekt.Repository.Snapshot:
+// of the snapshot of Revision, a tag or commit, or else of the snapshots
+// whose commit is not later than Time. An empty At matches all snapshots.
+// Snapshot shards are only searched if a query has an At atom, see
+// IsSnapshot.
+//
+// Sharded searchers only search the latest snapshot of each repository
+// matching Time.
+type At struct {
+	Revision string
+	Time     time.Time
+}
+
+func (q *At) String() string {
+	switch {
+	case q.Revision != "":
+		return "at:" + q.Revision
+	case !q.Time.IsZero():
+		return "at:" + q.Time.Format(time.RFC3339)
+	}
+	return "at:*"
+}
+
+// IsSnapshot returns true if q has an atom which only matches documents of
+// snapshot shards.
+func IsSnapshot(q Q) bool {
+	found := false
+	VisitAtoms(q, func(q Q) {
+		if _, ok := q.(*At); ok {
+			found = true
+		}
+	})
+	return found
+}
+
 type Const struct {
 	Value bool
 }
@@ -400,6 +499,12 @@ func (q *Symbol) setCase(k string) {
 	}
 }
 
+func (q *SymbolRef) setCase(k string) {
+	if sc, ok := q.Expr.(setCaser); ok {
+		sc.setCase(k)
+	}
+}
+
 func (q *Regexp) setCase(k string) {
 	switch k {
 	case "yes":
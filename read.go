@@ -22,6 +22,7 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/rs/xid"
 )
@@ -242,7 +243,12 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 	d.metaData = *md
 	d.repoMetaData = make([]Repository, 0, len(repos))
 	for _, r := range repos {
+		// FileStates is only used by indexers.
+		r.FileStates = nil
 		d.repoMetaData = append(d.repoMetaData, *r)
+		if r.Snapshot != "" {
+			d.hasSnapshots = true
+		}
 	}
 
 	if d.metaData.IndexFeatureVersion < ReadMinFeatureVersion {
@@ -259,6 +265,16 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 	d.newlinesIndex = toc.newlines.relativeIndex()
 	d.docSectionsStart = toc.fileSections.data.off
 	d.docSectionsIndex = toc.fileSections.relativeIndex()
+	d.symbolRefsStart = toc.symbolRefs.data.off
+	d.symbolRefsIndex = toc.symbolRefs.relativeIndex()
+	d.symbolIDsStart = toc.symbolIDs.data.off
+	d.symbolIDsIndex = toc.symbolIDs.relativeIndex()
+	d.originalNames = docStrings{start: toc.originalNames.data.off, index: toc.originalNames.relativeIndex()}
+	d.encodings = docStrings{start: toc.encodings.data.off, index: toc.encodings.relativeIndex()}
+	d.blames = docStrings{start: toc.blames.data.off, index: toc.blames.relativeIndex()}
+	if err := d.readCommits(toc); err != nil {
+		return nil, err
+	}
 
 	d.symbols.symKindIndex = toc.symbolKindMap.relativeIndex()
 	d.fileEndSymbol, err = readSectionU32(d.file, toc.fileEndSymbol)
@@ -320,6 +336,14 @@ func (r *reader) readIndexData(toc *indexTOC) (*indexData, error) {
 		return nil, err
 	}
 
+	if os.Getenv("ZOEKT_DISABLE_NGRAM_FILTER") == "" {
+		ngramText, err := d.readSectionBlob(toc.ngramText)
+		if err != nil {
+			return nil, err
+		}
+		d.ngramFilter = buildNgramFilter(ngramText, d.fileNameNgrams)
+	}
+
 	for _, md := range d.repoMetaData {
 		repoBranchIDs := make(map[string]uint, len(md.Branches))
 		repoBranchNames := make(map[uint]string, len(md.Branches))
@@ -580,6 +604,98 @@ func (d *indexData) readDocSections(i uint32, buf []DocumentSection) ([]Document
 	return unmarshalDocSections(blob, buf), sec.sz, nil
 }
 
+// readSymbolRefs returns the symbol references of document i. It returns nil
+// for shards without symbol references.
+func (d *indexData) readSymbolRefs(i uint32, buf []DocumentSection) ([]DocumentSection, uint32, error) {
+	if len(d.symbolRefsIndex) == 0 {
+		return nil, 0, nil
+	}
+	sec := simpleSection{
+		off: d.symbolRefsStart + d.symbolRefsIndex[i],
+		sz:  d.symbolRefsIndex[i+1] - d.symbolRefsIndex[i],
+	}
+	blob, err := d.readSectionBlob(sec)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return unmarshalDocSections(blob, buf), sec.sz, nil
+}
+
+// readSymbolIDs returns the IDs of the symbols of document i, in the order of
+// its symbol sections. Symbols without ID have an empty ID, and the result may
+// be shorter than the list of symbols.
+func (d *indexData) readSymbolIDs(i uint32) ([]string, error) {
+	if len(d.symbolIDsIndex) == 0 {
+		return nil, nil
+	}
+	sec := simpleSection{
+		off: d.symbolIDsStart + d.symbolIDsIndex[i],
+		sz:  d.symbolIDsIndex[i+1] - d.symbolIDsIndex[i],
+	}
+	if sec.sz == 0 {
+		return nil, nil
+	}
+	blob, err := d.readSectionBlob(sec)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(blob), "\n"), nil
+}
+
+// readDocString returns the string of document i in s. It is empty if the
+// shard doesn't have the section.
+func (d *indexData) readDocString(s *docStrings, i uint32) (string, error) {
+	if len(s.index) == 0 {
+		return "", nil
+	}
+	sec := simpleSection{
+		off: s.start + s.index[i],
+		sz:  s.index[i+1] - s.index[i],
+	}
+	if sec.sz == 0 {
+		return "", nil
+	}
+	blob, err := d.readSectionBlob(sec)
+	return string(blob), err
+}
+
+// readBlame returns the blame hunks of document i. It returns nil if the
+// document has no blame information.
+func (d *indexData) readBlame(i uint32) ([]BlameHunk, error) {
+	s, err := d.readDocString(&d.blames, i)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalBlame([]byte(s))
+}
+
+// readCommits decodes the commits of the documents of a history shard.
+func (d *indexData) readCommits(toc *indexTOC) error {
+	s := docStrings{start: toc.commits.data.off, index: toc.commits.relativeIndex()}
+	if len(s.index) == 0 {
+		return nil
+	}
+
+	d.commits = make([]*Commit, len(s.index)-1)
+	for i := range d.commits {
+		v, err := d.readDocString(&s, uint32(i))
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			continue
+		}
+		var c Commit
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			return fmt.Errorf("commit of document %d: %w", i, err)
+		}
+		d.commits[i] = &c
+	}
+	return nil
+}
+
 func (d *indexData) readRanks(toc *indexTOC) error {
 	blob, err := d.readSectionBlob(toc.ranks)
 	if err != nil {
@@ -131,12 +131,16 @@ var once sync.Once
 func RegisterGob() {
 	once.Do(func() {
 		gobRegister(&query.And{})
+		gobRegister(&query.At{})
+		gobRegister(&query.Author{})
 		gobRegister(&query.BranchRepos{})
 		gobRegister(&query.BranchesRepos{})
 		gobRegister(&query.Branch{})
+		gobRegister(&query.CommitDate{})
 		gobRegister(&query.Const{})
 		gobRegister(&query.FileNameSet{})
 		gobRegister(&query.GobCache{})
+		gobRegister(&query.HistoryType{})
 		gobRegister(&query.Language{})
 		gobRegister(&query.Not{})
 		gobRegister(&query.Or{})
@@ -146,6 +150,7 @@ func RegisterGob() {
 		gobRegister(&query.Repo{})
 		gobRegister(&query.Substring{})
 		gobRegister(&query.Symbol{})
+		gobRegister(&query.SymbolRef{})
 		gobRegister(&query.Type{})
 		gobRegister(query.RawConfig(41))
 	})
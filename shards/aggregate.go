@@ -47,6 +47,13 @@ func (c *collectSender) Send(r *zoekt.SearchResult) {
 	if len(r.Files) > 0 {
 		c.aggregate.Files = append(c.aggregate.Files, r.Files...)
 
+		// Only the top K files are returned, so we don't need to hold on to
+		// the files of every shard.
+		if k := c.opts.TopK; k > 0 && len(c.aggregate.Files) > 2*k {
+			zoekt.SortFiles(c.aggregate.Files, c.opts)
+			c.aggregate.Files = c.aggregate.Files[:k]
+		}
+
 		for k, v := range r.RepoURLs {
 			c.aggregate.RepoURLs[k] = v
 		}
@@ -81,6 +88,9 @@ func (c *collectSender) Done() (_ *zoekt.SearchResult, ok bool) {
 	if max := c.opts.MaxDocDisplayCount; max > 0 && len(agg.Files) > max {
 		agg.Files = agg.Files[:max]
 	}
+	if k := c.opts.TopK; k > 0 && len(agg.Files) > k {
+		agg.Files = agg.Files[:k]
+	}
 
 	return agg, true
 }
@@ -0,0 +1,275 @@
+package shards
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+var (
+	metricResultCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_result_cache_hits_total",
+		Help: "The total number of shard searches answered from the result cache",
+	})
+	metricResultCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_result_cache_misses_total",
+		Help: "The total number of shard searches not found in the result cache",
+	})
+	metricResultCacheEvictionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_result_cache_evictions_total",
+		Help: "The total number of results evicted from the result cache to stay within its size limit",
+	})
+	metricResultCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zoekt_result_cache_bytes",
+		Help: "The estimated size of the results in the result cache",
+	})
+)
+
+// resultCacheKey identifies a query and the search options which affect the
+// results of a shard.
+type resultCacheKey [sha256.Size]byte
+
+// resultCache caches the results of searching single shards. Entries belong
+// to a loaded shard, and are dropped when the shard is replaced, so repeated
+// queries only search the shards which changed since. The least recently
+// used results are evicted once the size of the results, as estimated by
+// zoekt.SearchResult.SizeBytes, exceeds the limit.
+//
+// A nil *resultCache is valid and caches nothing.
+type resultCache struct {
+	maxBytes uint64
+
+	mu    sync.Mutex
+	bytes uint64
+
+	// lru holds *resultCacheEntry, most recently used first.
+	lru *list.List
+
+	// shards maps shard IDs to the entries of the shard.
+	shards map[uint64]map[resultCacheKey]*list.Element
+}
+
+type resultCacheEntry struct {
+	shard  uint64
+	key    resultCacheKey
+	result *zoekt.SearchResult
+	size   uint64
+}
+
+func newResultCache(maxBytes uint64) *resultCache {
+	return &resultCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		shards:   map[uint64]map[resultCacheKey]*list.Element{},
+	}
+}
+
+// search searches s, or returns the cached result of searching it for key.
+// Incomplete results, ie. of crashed, canceled or timed out searches, aren't
+// cached.
+func (c *resultCache) search(ctx context.Context, key resultCacheKey, s *rankedShard, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	if c == nil {
+		return searchOneShard(ctx, s, q, opts)
+	}
+
+	if sr, ok := c.get(s.id, key); ok {
+		metricResultCacheHitsTotal.Inc()
+		return sr, nil
+	}
+	metricResultCacheMissesTotal.Inc()
+
+	sr, err := searchOneShard(ctx, s, q, opts)
+	if err == nil && sr != nil && sr.Stats.Crashes == 0 && sr.Stats.ShardsTimedOut == 0 && ctx.Err() == nil {
+		c.put(s.id, key, sr)
+	}
+	return sr, err
+}
+
+func (c *resultCache) get(shard uint64, key resultCacheKey) (*zoekt.SearchResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.shards[shard][key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return cloneResult(e.Value.(*resultCacheEntry).result), true
+}
+
+func (c *resultCache) put(shard uint64, key resultCacheKey, sr *zoekt.SearchResult) {
+	// The result references the mmapped shard, which may be unmapped while
+	// the result is cached.
+	cached := cloneResult(sr)
+	copyFiles(cached)
+
+	size := cached.SizeBytes()
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, ok := c.shards[shard]
+	if !ok {
+		entries = map[resultCacheKey]*list.Element{}
+		c.shards[shard] = entries
+	}
+	if e, ok := entries[key]; ok {
+		c.remove(e)
+	}
+	entries[key] = c.lru.PushFront(&resultCacheEntry{shard: shard, key: key, result: cached, size: size})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		metricResultCacheEvictionsTotal.Inc()
+	}
+	metricResultCacheBytes.Set(float64(c.bytes))
+}
+
+// invalidate drops the results of shard.
+func (c *resultCache) invalidate(shard uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.shards[shard] {
+		c.remove(e)
+	}
+	metricResultCacheBytes.Set(float64(c.bytes))
+}
+
+// remove must be called with c.mu held.
+func (c *resultCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*resultCacheEntry)
+	c.bytes -= entry.size
+
+	entries := c.shards[entry.shard]
+	delete(entries, entry.key)
+	if len(entries) == 0 {
+		delete(c.shards, entry.shard)
+	}
+}
+
+// cloneResult copies the parts of sr which the senders downstream of
+// streamSearch modify, ie. everything but the byte slices.
+func cloneResult(sr *zoekt.SearchResult) *zoekt.SearchResult {
+	cp := *sr
+	cp.Files = make([]zoekt.FileMatch, len(sr.Files))
+	for i, f := range sr.Files {
+		f.LineMatches = append([]zoekt.LineMatch(nil), f.LineMatches...)
+		f.ChunkMatches = append([]zoekt.ChunkMatch(nil), f.ChunkMatches...)
+		cp.Files[i] = f
+	}
+	cp.RepoURLs = make(map[string]string, len(sr.RepoURLs))
+	for k, v := range sr.RepoURLs {
+		cp.RepoURLs[k] = v
+	}
+	cp.LineFragments = make(map[string]string, len(sr.LineFragments))
+	for k, v := range sr.LineFragments {
+		cp.LineFragments[k] = v
+	}
+	return &cp
+}
+
+// newResultCacheKey returns the key of searching shards for q with opts.
+// Options which don't affect the results of a shard are ignored.
+func newResultCacheKey(q query.Q, opts *zoekt.SearchOptions) resultCacheKey {
+	h := sha256.New()
+	writeQuery(h, q)
+
+	o := *opts
+	o.MaxWallTime = 0
+	o.FlushWallTime = 0
+	o.Trace = false
+	o.SpanContext = nil
+	o.Class = zoekt.SearchClassInteractive
+	fmt.Fprintf(h, "\x00%+v", o)
+
+	var key resultCacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+// writeQuery writes a canonical form of q to w. The String methods of some
+// queries summarize large sets of repositories and files, so they can't
+// identify queries.
+func writeQuery(w io.Writer, q query.Q) {
+	switch q := q.(type) {
+	case *query.And:
+		io.WriteString(w, "(and")
+		for _, c := range q.Children {
+			io.WriteString(w, " ")
+			writeQuery(w, c)
+		}
+		io.WriteString(w, ")")
+	case *query.Or:
+		io.WriteString(w, "(or")
+		for _, c := range q.Children {
+			io.WriteString(w, " ")
+			writeQuery(w, c)
+		}
+		io.WriteString(w, ")")
+	case *query.Not:
+		io.WriteString(w, "(not ")
+		writeQuery(w, q.Child)
+		io.WriteString(w, ")")
+	case *query.Type:
+		fmt.Fprintf(w, "(type:%d ", q.Type)
+		writeQuery(w, q.Child)
+		io.WriteString(w, ")")
+	case *query.GobCache:
+		writeQuery(w, q.Q)
+	case *query.RepoSet:
+		names := make([]string, 0, len(q.Set))
+		for name := range q.Set {
+			names = append(names, name)
+		}
+		writeSet(w, "reposet", names)
+	case *query.FileNameSet:
+		names := make([]string, 0, len(q.Set))
+		for name := range q.Set {
+			names = append(names, name)
+		}
+		writeSet(w, "filenameset", names)
+	case *query.BranchesRepos:
+		io.WriteString(w, "(branchesrepos")
+		var buf [binary.MaxVarintLen64]byte
+		for _, br := range q.List {
+			fmt.Fprintf(w, " %q:%d:", br.Branch, br.Repos.GetCardinality())
+			it := br.Repos.Iterator()
+			for it.HasNext() {
+				n := binary.PutUvarint(buf[:], uint64(it.Next()))
+				w.Write(buf[:n])
+			}
+		}
+		io.WriteString(w, ")")
+	default:
+		io.WriteString(w, q.String())
+	}
+}
+
+func writeSet(w io.Writer, name string, values []string) {
+	sort.Strings(values)
+	fmt.Fprintf(w, "(%s", name)
+	for _, v := range values {
+		fmt.Fprintf(w, " %q", v)
+	}
+	io.WriteString(w, ")")
+}
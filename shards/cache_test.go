@@ -0,0 +1,138 @@
+package shards
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.uber.org/atomic"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// countingSearcher returns a file named after the shard and counts its
+// searches.
+type countingSearcher struct {
+	name     string
+	searches atomic.Int64
+}
+
+func (s *countingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	s.searches.Inc()
+	return &zoekt.SearchResult{
+		Files: []zoekt.FileMatch{{
+			FileName:    s.name,
+			Repository:  s.name,
+			LineMatches: []zoekt.LineMatch{{Line: []byte("line")}},
+		}},
+		Stats: zoekt.Stats{FileCount: 1, MatchCount: 1},
+	}, nil
+}
+
+func (s *countingSearcher) List(ctx context.Context, q query.Q, opts *zoekt.ListOptions) (*zoekt.RepoList, error) {
+	return &zoekt.RepoList{Repos: []*zoekt.RepoListEntry{{Repository: zoekt.Repository{Name: s.name}}}}, nil
+}
+
+func (s *countingSearcher) Close()         {}
+func (s *countingSearcher) String() string { return s.name }
+
+func TestResultCache(t *testing.T) {
+	ss := newShardedSearcher(1)
+	ss.cache = newResultCache(1 << 20)
+
+	a, b := &countingSearcher{name: "a"}, &countingSearcher{name: "b"}
+	ss.replace(map[string]zoekt.Searcher{"a": a, "b": b})
+
+	search := func(q query.Q, opts *zoekt.SearchOptions) {
+		t.Helper()
+		res, err := ss.Search(context.Background(), q, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Files) != 2 || string(res.Files[0].LineMatches[0].Line) != "line" {
+			t.Fatalf("got %+v, want a file per shard", res.Files)
+		}
+	}
+	wantSearches := func(wantA, wantB int64) {
+		t.Helper()
+		if got := a.searches.Load(); got != wantA {
+			t.Errorf("a searched %d times, want %d", got, wantA)
+		}
+		if got := b.searches.Load(); got != wantB {
+			t.Errorf("b searched %d times, want %d", got, wantB)
+		}
+	}
+
+	q := &query.Substring{Pattern: "foo"}
+	search(q, &zoekt.SearchOptions{})
+	search(q, &zoekt.SearchOptions{MaxWallTime: 1e9})
+	wantSearches(1, 1)
+
+	// Options affecting results and other queries miss.
+	search(q, &zoekt.SearchOptions{ChunkMatches: true})
+	search(&query.Substring{Pattern: "bar"}, &zoekt.SearchOptions{})
+	wantSearches(3, 3)
+
+	// Replacing a shard only invalidates its results.
+	a2 := &countingSearcher{name: "a"}
+	ss.replace(map[string]zoekt.Searcher{"a": a2})
+	search(q, &zoekt.SearchOptions{})
+	wantSearches(3, 3)
+	if got := a2.searches.Load(); got != 1 {
+		t.Errorf("replaced a searched %d times, want 1", got)
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	sr := &zoekt.SearchResult{Files: []zoekt.FileMatch{{FileName: "f", Content: make([]byte, 100)}}}
+	size := cloneResult(sr).SizeBytes()
+
+	c := newResultCache(2 * size)
+	keys := make([]resultCacheKey, 3)
+	for i := range keys {
+		keys[i] = newResultCacheKey(&query.Substring{Pattern: fmt.Sprint(i)}, &zoekt.SearchOptions{})
+		c.put(1, keys[i], sr)
+	}
+	if _, ok := c.get(1, keys[0]); ok {
+		t.Error("least recently used result wasn't evicted")
+	}
+	for _, key := range keys[1:] {
+		if _, ok := c.get(1, key); !ok {
+			t.Error("recent result was evicted")
+		}
+	}
+	if c.bytes != 2*size {
+		t.Errorf("got %d bytes, want %d", c.bytes, 2*size)
+	}
+
+	c.invalidate(1)
+	if c.bytes != 0 || c.lru.Len() != 0 || len(c.shards) != 0 {
+		t.Errorf("invalidate left %d bytes, %d entries", c.bytes, c.lru.Len())
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	set := func(names ...string) query.Q {
+		s := map[string]bool{}
+		for _, n := range names {
+			s[n] = true
+		}
+		return &query.RepoSet{Set: s}
+	}
+
+	// The String of large sets only has their size.
+	a := set("1", "2", "3", "4", "5", "6")
+	b := set("1", "2", "3", "4", "5", "7")
+	if newResultCacheKey(a, &zoekt.SearchOptions{}) == newResultCacheKey(b, &zoekt.SearchOptions{}) {
+		t.Error("different repo sets have the same key")
+	}
+	if newResultCacheKey(a, &zoekt.SearchOptions{}) != newResultCacheKey(set("6", "5", "4", "3", "2", "1"), &zoekt.SearchOptions{}) {
+		t.Error("equal repo sets have different keys")
+	}
+
+	br := func(ids ...uint32) query.Q { return query.NewSingleBranchesRepos("HEAD", ids...) }
+	if newResultCacheKey(br(1, 2), &zoekt.SearchOptions{}) == newResultCacheKey(br(1, 3), &zoekt.SearchOptions{}) {
+		t.Error("different branches repos have the same key")
+	}
+}
@@ -0,0 +1,225 @@
+package shards
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	// shardLatencyWindow is the number of recent searches of a shard whose
+	// latency is tracked. A shard is only quarantined once the window is
+	// full, so a single slow search doesn't trip it.
+	shardLatencyWindow = 100
+
+	// maxSlowQueries is the number of recent slow queries kept per shard.
+	maxSlowQueries = 5
+)
+
+// QuarantineOptions configures how slow shards are handled. A single
+// pathological shard can otherwise make every search hit MaxWallTime.
+type QuarantineOptions struct {
+	// ShardTimeout, if positive, stops searching a shard after this long.
+	// The matches found until then are kept, and the shard is counted in
+	// Stats.ShardsTimedOut.
+	ShardTimeout time.Duration
+
+	// Threshold, if positive, quarantines shards whose p99 latency over
+	// their last searches exceeds it. Interactive searches skip
+	// quarantined shards and count them in Stats.ShardsTimedOut, other
+	// searches search them last.
+	Threshold time.Duration
+
+	// Duration is how long a shard stays quarantined. Defaults to a minute.
+	Duration time.Duration
+}
+
+// SlowQuery is a search of a shard which took longer than
+// QuarantineOptions.Threshold.
+type SlowQuery struct {
+	Query    string
+	Duration time.Duration
+	Time     time.Time
+}
+
+// QuarantinedShard describes a shard quarantined for slow searches.
+type QuarantinedShard struct {
+	// Name is the name of the shard, eg. its path.
+	Name string
+
+	// P99 is the latency which tripped the quarantine.
+	P99 time.Duration
+
+	Since time.Time
+	Until time.Time
+
+	// Queries are the most recent slow queries before the shard was
+	// quarantined.
+	Queries []SlowQuery
+}
+
+// QuarantineLister is implemented by the searchers returned by
+// NewDirectorySearcher and its variants.
+type QuarantineLister interface {
+	// QuarantinedShards returns the shards which are currently
+	// quarantined.
+	QuarantinedShards() []QuarantinedShard
+}
+
+// shardHealth tracks the latency of searching a shard. A nil *shardHealth
+// tracks nothing.
+type shardHealth struct {
+	name string
+	opts QuarantineOptions
+
+	mu sync.Mutex
+
+	// latencies is a ring buffer of the last n latencies, next is the
+	// index of the next sample.
+	latencies [shardLatencyWindow]time.Duration
+	n, next   int
+
+	// slow holds the last maxSlowQueries slow queries.
+	slow []SlowQuery
+
+	// quarantine is set while the shard is quarantined.
+	quarantine *QuarantinedShard
+}
+
+func newShardHealth(name string, opts QuarantineOptions) *shardHealth {
+	if opts.Duration <= 0 {
+		opts.Duration = time.Minute
+	}
+	return &shardHealth{name: name, opts: opts}
+}
+
+func (h *shardHealth) timeout() time.Duration {
+	if h == nil {
+		return 0
+	}
+	return h.opts.ShardTimeout
+}
+
+// observe records that searching q took d, and quarantines the shard if it
+// has been slow recently.
+func (h *shardHealth) observe(q query.Q, d time.Duration, now time.Time) {
+	if h == nil {
+		return
+	}
+	metricSearchShardDuration.Observe(d.Seconds())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % shardLatencyWindow
+	if h.n < shardLatencyWindow {
+		h.n++
+	}
+
+	if h.opts.Threshold <= 0 || d <= h.opts.Threshold {
+		return
+	}
+
+	if len(h.slow) == maxSlowQueries {
+		h.slow = h.slow[1:]
+	}
+	h.slow = append(h.slow, SlowQuery{Query: q.String(), Duration: d, Time: now})
+
+	if h.n < shardLatencyWindow || h.quarantinedLocked(now) {
+		return
+	}
+	p99 := h.p99Locked()
+	if p99 <= h.opts.Threshold {
+		return
+	}
+
+	h.quarantine = &QuarantinedShard{
+		Name:    h.name,
+		P99:     p99,
+		Since:   now,
+		Until:   now.Add(h.opts.Duration),
+		Queries: append([]SlowQuery(nil), h.slow...),
+	}
+	// The shard has to be slow for a full window again to be quarantined
+	// again.
+	h.n, h.next = 0, 0
+	h.slow = nil
+
+	metricShardsQuarantinedTotal.Inc()
+	log.Printf("quarantining %s until %s: p99 latency %s", h.name, h.quarantine.Until.Format(time.RFC3339), p99)
+}
+
+func (h *shardHealth) p99Locked() time.Duration {
+	sorted := make([]time.Duration, h.n)
+	copy(sorted, h.latencies[:h.n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(h.n*99+99)/100-1]
+}
+
+func (h *shardHealth) quarantinedLocked(now time.Time) bool {
+	if h.quarantine != nil && now.After(h.quarantine.Until) {
+		h.quarantine = nil
+	}
+	return h.quarantine != nil
+}
+
+// quarantined returns the quarantine of the shard, or nil if it isn't
+// quarantined.
+func (h *shardHealth) quarantined(now time.Time) *QuarantinedShard {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.quarantinedLocked(now) {
+		return nil
+	}
+	return h.quarantine
+}
+
+// selectQuarantined returns shards without the quarantined shards for
+// interactive searches, and with the quarantined shards last for other
+// searches. It also returns the number of shards it skipped.
+func selectQuarantined(shards []*rankedShard, class zoekt.SearchClass) ([]*rankedShard, int) {
+	now := time.Now()
+	var healthy, quarantined []*rankedShard
+	for i, s := range shards {
+		if s.health.quarantined(now) == nil {
+			if healthy != nil {
+				healthy = append(healthy, s)
+			}
+			continue
+		}
+		if healthy == nil {
+			// shards is shared with other searches, so we filter into a
+			// copy.
+			healthy = append(make([]*rankedShard, 0, len(shards)), shards[:i]...)
+		}
+		quarantined = append(quarantined, s)
+	}
+	if len(quarantined) == 0 {
+		return shards, 0
+	}
+	if class == zoekt.SearchClassInteractive {
+		return healthy, len(quarantined)
+	}
+	return append(healthy, quarantined...), 0
+}
+
+// QuarantinedShards implements QuarantineLister.
+func (ss *shardedSearcher) QuarantinedShards() []QuarantinedShard {
+	now := time.Now()
+	var qs []QuarantinedShard
+	for _, s := range ss.getLoaded().shards {
+		if q := s.health.quarantined(now); q != nil {
+			qs = append(qs, *q)
+		}
+	}
+	sort.Slice(qs, func(i, j int) bool { return qs[i].Since.After(qs[j].Since) })
+	return qs
+}
@@ -0,0 +1,109 @@
+package shards
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestShardHealthQuarantine(t *testing.T) {
+	q := &query.Substring{Pattern: "needle"}
+	now := time.Now()
+
+	observe := func(h *shardHealth, slow int) {
+		for i := 0; i < shardLatencyWindow; i++ {
+			d := time.Millisecond
+			if i >= shardLatencyWindow-slow {
+				d = time.Second
+			}
+			h.observe(q, d, now)
+		}
+	}
+
+	// A single slow search in the window is below the p99.
+	h := newShardHealth("shard", QuarantineOptions{Threshold: 100 * time.Millisecond})
+	observe(h, 1)
+	if got := h.quarantined(now); got != nil {
+		t.Fatalf("quarantined after a single slow search: %+v", got)
+	}
+
+	h = newShardHealth("shard", QuarantineOptions{Threshold: 100 * time.Millisecond})
+	observe(h, 2)
+	got := h.quarantined(now)
+	if got == nil {
+		t.Fatal("not quarantined")
+	}
+	if got.P99 != time.Second || len(got.Queries) != 2 || got.Queries[0].Query != q.String() {
+		t.Errorf("unexpected quarantine %+v", got)
+	}
+
+	if got := h.quarantined(now.Add(time.Minute + time.Second)); got != nil {
+		t.Errorf("still quarantined after the quarantine duration: %+v", got)
+	}
+}
+
+func TestSearchSkipsQuarantinedShards(t *testing.T) {
+	ss := newShardedSearcher(1)
+	ss.replace(map[string]zoekt.Searcher{
+		"healthy": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{Name: "healthy"},
+			zoekt.Document{Name: "f", Content: []byte("needle")})),
+		"slow": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{Name: "slow"},
+			zoekt.Document{Name: "f", Content: []byte("needle")})),
+	})
+	ss.markReady()
+	defer ss.Close()
+
+	now := time.Now()
+	slow := ss.shards["slow"].health
+	slow.quarantine = &QuarantinedShard{Name: "slow", Since: now, Until: now.Add(time.Hour)}
+
+	q := &query.Substring{Pattern: "needle"}
+	for _, class := range []zoekt.SearchClass{zoekt.SearchClassInteractive, zoekt.SearchClassBatch} {
+		res, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{Class: class})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantFiles, wantTimedOut := 2, 0
+		if class == zoekt.SearchClassInteractive {
+			wantFiles, wantTimedOut = 1, 1
+		}
+		if len(res.Files) != wantFiles || res.Stats.ShardsTimedOut != wantTimedOut {
+			t.Errorf("%s: got %d files, %d shards timed out, want %d, %d", class, len(res.Files), res.Stats.ShardsTimedOut, wantFiles, wantTimedOut)
+		}
+	}
+
+	if got := ss.QuarantinedShards(); len(got) != 1 || got[0].Name != "slow" {
+		t.Errorf("got quarantined shards %+v, want slow", got)
+	}
+}
+
+// blockingSearcher blocks searches until they are canceled.
+type blockingSearcher struct {
+	zoekt.Searcher
+}
+
+func (s *blockingSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.SearchOptions) (*zoekt.SearchResult, error) {
+	<-ctx.Done()
+	return &zoekt.SearchResult{}, nil
+}
+
+func TestShardTimeout(t *testing.T) {
+	ss := newShardedSearcher(1)
+	ss.quarantine = QuarantineOptions{ShardTimeout: 10 * time.Millisecond}
+	ss.replace(map[string]zoekt.Searcher{
+		"blocking": &blockingSearcher{Searcher: searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{Name: "blocking"}))},
+	})
+	ss.markReady()
+
+	res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.ShardsTimedOut != 1 {
+		t.Errorf("got %d shards timed out, want 1", res.Stats.ShardsTimedOut)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +12,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"golang.org/x/sync/semaphore"
+
+	"github.com/sourcegraph/zoekt"
 )
 
 // Note: This is a Sourcegraph specific addition to allow long running queries
@@ -19,9 +22,23 @@ import (
 // scheduler is for managing concurrent searches.
 type scheduler interface {
 	// Acquire blocks until a normal process is created (ie for a search
-	// request). See process documentation. It will only return an error if the
-	// context expires.
-	Acquire(ctx context.Context) (*process, error)
+	// request of class). See process documentation. It will only return an
+	// error if the context expires.
+	Acquire(ctx context.Context, class zoekt.SearchClass) (*process, error)
+}
+
+// SchedulerOptions sets the number of searches of each zoekt.SearchClass
+// which run concurrently. Zero values use the defaults.
+type SchedulerOptions struct {
+	// Interactive defaults to GOMAXPROCS.
+	Interactive int64
+
+	// Batch defaults to a quarter of Interactive, or the batchdiv fraction
+	// set in ZOEKTSCHED.
+	Batch int64
+
+	// Background defaults to 1.
+	Background int64
 }
 
 // The ZOEKTSCHED environment variable controls variables within the
@@ -44,16 +61,20 @@ var zoektSched = parseTuneables(os.Getenv("ZOEKTSCHED"))
 
 // newScheduler returns a scheduler for use in searches. It will return a
 // multiScheduler unless that has been disabled with the environment variable
-// SCHED_DISABLE. If so it will an equivalent scheduler as upstream zoekt.
-func newScheduler(capacity int64) scheduler {
+// SCHED_DISABLE. If so it will an equivalent scheduler as upstream zoekt,
+// which ignores the classes of searches.
+func newScheduler(opts SchedulerOptions) scheduler {
+	if opts.Interactive <= 0 {
+		opts.Interactive = int64(runtime.GOMAXPROCS(0))
+	}
 	if zoektSched["disable"] == 1 {
 		log.Println("ZOEKTSCHED=disable=1 specified. Using old zoekt scheduler.")
 		return &semaphoreScheduler{
-			throttle: semaphore.NewWeighted(capacity),
-			capacity: capacity,
+			throttle: semaphore.NewWeighted(opts.Interactive),
+			capacity: opts.Interactive,
 		}
 	}
-	return newMultiScheduler(capacity)
+	return newMultiScheduler(opts)
 }
 
 // multiScheduler is for managing concurrent searches. Its goals are:
@@ -87,29 +108,44 @@ func newScheduler(capacity int64) scheduler {
 // process starts as fast, but is downgraded to slow after a period of time.
 // time. Downgrading relies on a process co-operatively deciding to downgrade.
 //
+// Callers which know a search is slow declare it with its class: batch
+// searches start as slow, and background searches run in a separate
+// semaphore, so they neither delay nor are delayed by the other searches.
+//
 // We intentionally keep the algorithm simple, but have a general interface to
 // allow improvements as we learn more.
 type multiScheduler struct {
 	semInteractive *sema
 	semBatch       *sema
+	semBackground  *sema
 
 	// interactiveDuration is how long we run a search query at interactive
 	// priority before downgrading it to a batch/slow query.
 	interactiveDuration time.Duration
 }
 
-func newMultiScheduler(capacity int64) *multiScheduler {
-	batchdiv := zoektSched["batchdiv"]
-	if batchdiv == 0 {
-		// Burst up to 1/4 of interactive capacity for batch.
-		batchdiv = 4
-	} else {
-		log.Printf("ZOEKTSCHED=batchdiv=%d specified. Batch queue size 1/%d of %d.", batchdiv, batchdiv, capacity)
+func newMultiScheduler(opts SchedulerOptions) *multiScheduler {
+	capacity := opts.Interactive
+
+	batchCap := opts.Batch
+	if batchCap <= 0 {
+		batchdiv := zoektSched["batchdiv"]
+		if batchdiv == 0 {
+			// Burst up to 1/4 of interactive capacity for batch.
+			batchdiv = 4
+		} else {
+			log.Printf("ZOEKTSCHED=batchdiv=%d specified. Batch queue size 1/%d of %d.", batchdiv, batchdiv, capacity)
+		}
+
+		batchCap = capacity / int64(batchdiv)
+		if batchCap == 0 {
+			batchCap = 1
+		}
 	}
 
-	batchCap := capacity / int64(batchdiv)
-	if batchCap == 0 {
-		batchCap = 1
+	backgroundCap := opts.Background
+	if backgroundCap <= 0 {
+		backgroundCap = 1
 	}
 
 	interactiveseconds := zoektSched["interactiveseconds"]
@@ -122,13 +158,35 @@ func newMultiScheduler(capacity int64) *multiScheduler {
 	return &multiScheduler{
 		semInteractive: newSema(capacity, "interactive"),
 		semBatch:       newSema(batchCap, "batch"),
+		semBackground:  newSema(backgroundCap, "background"),
 
 		interactiveDuration: time.Duration(interactiveseconds) * time.Second,
 	}
 }
 
+type batchPriorityKey struct{}
+
+// WithBatchPriority returns a copy of ctx which makes searches skip the
+// interactive queue and run in the batch queue from the start. It is used to
+// demote requests from clients which exceeded their quota.
+func WithBatchPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, batchPriorityKey{}, true)
+}
+
+func isBatchPriority(ctx context.Context) bool {
+	v, _ := ctx.Value(batchPriorityKey{}).(bool)
+	return v
+}
+
 // Acquire implements scheduler.Acquire.
-func (s *multiScheduler) Acquire(ctx context.Context) (*process, error) {
+func (s *multiScheduler) Acquire(ctx context.Context, class zoekt.SearchClass) (*process, error) {
+	switch {
+	case class == zoekt.SearchClassBackground:
+		return s.acquireFixed(ctx, s.semBackground)
+	case class == zoekt.SearchClassBatch || isBatchPriority(ctx):
+		return s.acquireFixed(ctx, s.semBatch)
+	}
+
 	// Start in interactive. yieldFunc will switch us to batch. sem can be nil
 	// if we fail while switching to batch. nil value prevents us releasing
 	// twice.
@@ -166,6 +224,18 @@ func (s *multiScheduler) Acquire(ctx context.Context) (*process, error) {
 	}, nil
 }
 
+// acquireFixed acquires a process which runs in the queue of sem for its
+// whole lifetime.
+func (s *multiScheduler) acquireFixed(ctx context.Context, sem *sema) (*process, error) {
+	if err := sem.Acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	return &process{
+		releaseFunc: sem.Release,
+	}, nil
+}
+
 // semaphoreScheduler shares a single semaphore for all searches. An exclusive
 // process acquires the full semaphore. This is equivalent to how concurrency
 // is managed in upstream. It exists as a fallback while we test
@@ -176,7 +246,7 @@ type semaphoreScheduler struct {
 }
 
 // Acquire implements scheduler.Acquire.
-func (s *semaphoreScheduler) Acquire(ctx context.Context) (*process, error) {
+func (s *semaphoreScheduler) Acquire(ctx context.Context, class zoekt.SearchClass) (*process, error) {
 	return s.acquire(ctx, 1)
 }
 
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
 )
 
 func BenchmarkYield(b *testing.B) {
@@ -52,9 +54,9 @@ func BenchmarkYield(b *testing.B) {
 	// Bencmark of actual yield function
 	b.Run("yield", func(b *testing.B) {
 		ctx := context.Background()
-		sched := newMultiScheduler(1)
+		sched := newMultiScheduler(SchedulerOptions{Interactive: 1})
 		sched.interactiveDuration = quantum
-		proc, err := sched.Acquire(ctx)
+		proc, err := sched.Acquire(ctx, zoekt.SearchClassInteractive)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -73,9 +75,9 @@ func TestYield(t *testing.T) {
 	quantum := 10 * time.Millisecond
 	deadline := time.Now().Add(quantum)
 
-	sched := newMultiScheduler(1)
+	sched := newMultiScheduler(SchedulerOptions{Interactive: 1})
 	sched.interactiveDuration = quantum
-	proc, err := sched.Acquire(ctx)
+	proc, err := sched.Acquire(ctx, zoekt.SearchClassInteractive)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,13 +121,13 @@ func TestMultiScheduler(t *testing.T) {
 
 	capacity := 8
 	batchCap := capacity / 4
-	sched := newMultiScheduler(int64(capacity))
+	sched := newMultiScheduler(SchedulerOptions{Interactive: int64(capacity)})
 	sched.interactiveDuration = 0 // instantly downgrade to batch on call to yield.
 
 	var procs []*process
 	addProc := func() {
 		t.Helper()
-		proc, err := sched.Acquire(ctx)
+		proc, err := sched.Acquire(ctx, zoekt.SearchClassInteractive)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -143,7 +145,7 @@ func TestMultiScheduler(t *testing.T) {
 	}
 
 	// We expect this to fail since the queue is at capacity
-	if _, err := sched.Acquire(quickCtx(t)); err == nil {
+	if _, err := sched.Acquire(quickCtx(t), zoekt.SearchClassInteractive); err == nil {
 		t.Fatal("expected first acquire after cap to fail")
 	}
 
@@ -154,7 +156,7 @@ func TestMultiScheduler(t *testing.T) {
 	addProc()
 
 	// We expect this to fail since the queue is at capacity again.
-	if _, err := sched.Acquire(quickCtx(t)); err == nil {
+	if _, err := sched.Acquire(quickCtx(t), zoekt.SearchClassInteractive); err == nil {
 		t.Fatal("expected second acquire after cap to fail")
 	}
 
@@ -196,3 +198,69 @@ func TestParseTuneables(t *testing.T) {
 		}
 	}
 }
+
+func TestBatchPriority(t *testing.T) {
+	sched := newMultiScheduler(SchedulerOptions{Interactive: 4})
+
+	batchCtx := WithBatchPriority(context.Background())
+	proc, err := sched.Acquire(batchCtx, zoekt.SearchClassInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch queue has capacity 1, so a second batch process must wait.
+	ctx, cancel := context.WithTimeout(batchCtx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := sched.Acquire(ctx, zoekt.SearchClassInteractive); err == nil {
+		t.Fatal("expected second batch process to block")
+	}
+
+	// Interactive processes are unaffected.
+	interactive, err := sched.Acquire(context.Background(), zoekt.SearchClassInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interactive.Release()
+
+	proc.Release()
+
+	proc, err = sched.Acquire(batchCtx, zoekt.SearchClassInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Release()
+}
+
+func TestSearchClass(t *testing.T) {
+	sched := newMultiScheduler(SchedulerOptions{Interactive: 1, Batch: 1, Background: 1})
+
+	acquire := func(ctx context.Context, class zoekt.SearchClass) *process {
+		t.Helper()
+		proc, err := sched.Acquire(ctx, class)
+		if err != nil {
+			t.Fatalf("%s: %v", class, err)
+		}
+		return proc
+	}
+
+	// Each class has its own queue.
+	var procs []*process
+	for _, class := range []zoekt.SearchClass{zoekt.SearchClassInteractive, zoekt.SearchClassBatch, zoekt.SearchClassBackground} {
+		procs = append(procs, acquire(context.Background(), class))
+		if _, err := sched.Acquire(quickCtx(t), class); err == nil {
+			t.Errorf("expected second %s process to block", class)
+		}
+	}
+	for _, p := range procs {
+		p.Release()
+	}
+
+	// Background searches don't compete with demoted searches.
+	batch := acquire(WithBatchPriority(context.Background()), zoekt.SearchClassInteractive)
+	background := acquire(WithBatchPriority(context.Background()), zoekt.SearchClassBackground)
+	if _, err := sched.Acquire(quickCtx(t), zoekt.SearchClassBatch); err == nil {
+		t.Error("expected batch process to block")
+	}
+	batch.Release()
+	background.Release()
+}
@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"runtime"
 	"runtime/debug"
 	"sort"
@@ -60,6 +59,15 @@ var (
 		Name: "zoekt_search_shard_running",
 		Help: "The number of concurrent search requests in a shard running",
 	})
+	metricSearchShardDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "zoekt_search_shard_duration_seconds",
+		Help:    "The duration of searching a single shard",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8), // 1ms -> 16s
+	})
+	metricShardsQuarantinedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_shards_quarantined_total",
+		Help: "The number of times a shard was quarantined for slow searches",
+	})
 	metricSearchFailedTotal = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "zoekt_search_failed_total",
 		Help: "The total number of search requests that failed",
@@ -107,6 +115,10 @@ var (
 		Name: "zoekt_search_shards_skipped_total",
 		Help: "Total shards that we did not process because a query was canceled",
 	})
+	metricSearchShardsTimedOutTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zoekt_search_shards_timed_out_total",
+		Help: "Total shards that we did not process completely because they were slow",
+	})
 	metricSearchMatchCountTotal = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "zoekt_search_match_count_total",
 		Help: "Total number of non-overlapping matches",
@@ -166,8 +178,23 @@ var (
 type rankedShard struct {
 	zoekt.Searcher
 
+	// id identifies the shard in the result cache. Reloaded shards get a new
+	// id.
+	id uint64
+
 	priority float64 // maximum priority across all repos in the shard
 
+	// filter is nil if the searcher has no ngram filter.
+	filter *zoekt.NgramFilter
+
+	// snapshot is the repository of a snapshot shard, and nil for other
+	// shards. See zoekt.Repository.Snapshot.
+	snapshot *zoekt.Repository
+
+	// health tracks the latency of searching the shard. It is nil if not
+	// tracked.
+	health *shardHealth
+
 	// We have out of band ranking on compound shards which can change even if
 	// the shard file does not. So we compute a rank in getShards. We store
 	// repos here to avoid the cost of List in the search request path.
@@ -198,12 +225,21 @@ type shardedSearcher struct {
 
 	ready  atomic.Bool
 	ranked atomic.Value
+
+	// cache is nil if results aren't cached.
+	cache *resultCache
+
+	// quarantine configures the health of the loaded shards.
+	quarantine QuarantineOptions
 }
 
+// nextShardID is the id of the next rankedShard.
+var nextShardID atomic.Uint64
+
 func newShardedSearcher(n int64) *shardedSearcher {
 	ss := &shardedSearcher{
 		shards: make(map[string]*rankedShard),
-		sched:  newScheduler(n),
+		sched:  newScheduler(SchedulerOptions{Interactive: n}),
 	}
 	return ss
 }
@@ -224,25 +260,91 @@ func NewDirectorySearcherFast(dir string) (zoekt.Streamer, error) {
 	return newDirectorySearcher(dir, false)
 }
 
+// DirectorySearcherOptions configures a searcher returned by
+// NewDirectorySearcherWithOptions.
+type DirectorySearcherOptions struct {
+	// WaitUntilReady blocks until the initial set of shards has been loaded.
+	WaitUntilReady bool
+
+	// OnLoad, if non-nil, is called after a batch of shards has been loaded
+	// and is searchable. repos contains the repositories of the newly loaded
+	// shards. It is called from the loading goroutine, so it should not block.
+	OnLoad func(repos []*zoekt.Repository)
+
+	// ResultCacheBytes, if positive, caches the results of searching each
+	// shard, up to this many bytes. Dashboards and bots re-running the same
+	// queries then only search the shards which changed in between.
+	ResultCacheBytes int64
+
+	// Scheduler sets the number of concurrent searches of each
+	// zoekt.SearchClass.
+	Scheduler SchedulerOptions
+
+	// Quarantine configures per shard timeouts and the quarantine of slow
+	// shards.
+	Quarantine QuarantineOptions
+}
+
+// NewDirectorySearcherWithOptions is like NewDirectorySearcher, but allows
+// customizing the behaviour of the returned searcher.
+func NewDirectorySearcherWithOptions(dir string, opts DirectorySearcherOptions) (zoekt.Streamer, error) {
+	return newDirectorySearcherOpts(dir, opts)
+}
+
 func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, error) {
+	return newDirectorySearcherOpts(dir, DirectorySearcherOptions{WaitUntilReady: waitUntilReady})
+}
+
+func newDirectorySearcherOpts(dir string, opts DirectorySearcherOptions) (zoekt.Streamer, error) {
+	return NewSourceSearcher([]ShardSource{DirectorySource(dir)}, opts)
+}
+
+// NewSourceSearcher is like NewDirectorySearcherWithOptions, but searches
+// the shards of all sources, eg. of several index directories. Shards of a
+// repository should only be in one of them.
+func NewSourceSearcher(sources []ShardSource, opts DirectorySearcherOptions) (zoekt.Streamer, error) {
 	ss := newShardedSearcher(int64(runtime.GOMAXPROCS(0)))
-	tl := &loader{
-		ss: ss,
+	if opts.Scheduler != (SchedulerOptions{}) {
+		ss.sched = newScheduler(opts.Scheduler)
 	}
-	dw, err := newDirectoryWatcher(dir, tl)
-	if err != nil {
-		return nil, err
+	if opts.ResultCacheBytes > 0 {
+		ss.cache = newResultCache(uint64(opts.ResultCacheBytes))
 	}
-
-	if waitUntilReady {
-		if err := dw.WaitUntilReady(); err != nil {
+	ss.quarantine = opts.Quarantine
+
+	ds := &directorySearcher{Streamer: ss}
+	for _, src := range sources {
+		tl := &loader{
+			ss:     ss,
+			source: src,
+			onLoad: opts.OnLoad,
+		}
+		w, err := src.watch(tl)
+		if err != nil {
+			ds.Close()
 			return nil, err
 		}
+		ds.watchers = append(ds.watchers, w)
 	}
 
-	ds := &directorySearcher{
-		Streamer:         ss,
-		directoryWatcher: dw,
+	// Once every source has loaded its initial set of shards, ss is ready.
+	ready := make(chan error, 1)
+	go func() {
+		for _, w := range ds.watchers {
+			if err := w.WaitUntilReady(); err != nil {
+				ready <- err
+				return
+			}
+		}
+		ss.markReady()
+		ready <- nil
+	}()
+
+	if opts.WaitUntilReady {
+		if err := <-ready; err != nil {
+			ds.Close()
+			return nil, err
+		}
 	}
 
 	return &typeRepoSearcher{Streamer: ds}, nil
@@ -251,25 +353,77 @@ func newDirectorySearcher(dir string, waitUntilReady bool) (zoekt.Streamer, erro
 type directorySearcher struct {
 	zoekt.Streamer
 
-	directoryWatcher *DirectoryWatcher
+	watchers []sourceWatcher
+}
+
+// ShardReloader is implemented by the searchers returned by
+// NewDirectorySearcher and its variants.
+type ShardReloader interface {
+	// ReloadShard loads, reloads or drops the shard file name in the index
+	// directories, according to its state on disk.
+	ReloadShard(name string) error
+}
+
+// ReloadShard reloads the shard name in each index directory.
+func (s *directorySearcher) ReloadShard(name string) error {
+	reloaded := false
+	for _, w := range s.watchers {
+		if dw, ok := w.(*DirectoryWatcher); ok {
+			if err := dw.ReloadShard(name); err != nil {
+				return err
+			}
+			reloaded = true
+		}
+	}
+	if !reloaded {
+		return fmt.Errorf("%s doesn't load shards from a directory", s)
+	}
+	return nil
+}
+
+func (s *typeRepoSearcher) ReloadShard(name string) error {
+	r, ok := s.Streamer.(ShardReloader)
+	if !ok {
+		return fmt.Errorf("%s doesn't load shards from a directory", s.Streamer)
+	}
+	return r.ReloadShard(name)
+}
+
+func (s *directorySearcher) QuarantinedShards() []QuarantinedShard {
+	if l, ok := s.Streamer.(QuarantineLister); ok {
+		return l.QuarantinedShards()
+	}
+	return nil
+}
+
+func (s *typeRepoSearcher) QuarantinedShards() []QuarantinedShard {
+	if l, ok := s.Streamer.(QuarantineLister); ok {
+		return l.QuarantinedShards()
+	}
+	return nil
 }
 
 func (s *directorySearcher) Close() {
-	// We need to Stop directoryWatcher first since it calls load/unload on
+	// We need to Stop the watchers first since they call load/unload on
 	// Searcher.
-	s.directoryWatcher.Stop()
+	for _, w := range s.watchers {
+		w.Stop()
+	}
 	s.Streamer.Close()
 }
 
 type loader struct {
 	ss *shardedSearcher
+
+	// source opens the shards passed to load.
+	source ShardSource
+
+	// onLoad is optional and called with the repositories of each batch of
+	// shards once it is searchable.
+	onLoad func(repos []*zoekt.Repository)
 }
 
 func (tl *loader) load(keys ...string) {
-	// This is called with all keys on startup, so once this function has
-	// finished running shardedSearcher will be ready.
-	defer tl.ss.markReady()
-
 	var (
 		mu           sync.Mutex     // synchronizes writes to the shards map
 		wg           sync.WaitGroup // used to wait for all shards to load
@@ -283,6 +437,16 @@ func (tl *loader) load(keys ...string) {
 		loadedShards = make(map[string]zoekt.Searcher)
 		mu.Unlock()
 		tl.ss.replace(chunk)
+
+		if tl.onLoad != nil && len(chunk) > 0 {
+			keys := make([]string, 0, len(chunk))
+			for key := range chunk {
+				keys = append(keys, key)
+			}
+			if repos := tl.ss.reposForKeys(keys); len(repos) > 0 {
+				tl.onLoad(repos)
+			}
+		}
 	}
 
 	log.Printf("loading %d shard(s): %s", len(keys), humanTruncateList(keys, 5))
@@ -304,7 +468,7 @@ func (tl *loader) load(keys ...string) {
 			defer sem.Release(1)
 			defer wg.Done()
 
-			shard, err := loadShard(key)
+			shard, err := loadShard(tl.source, key)
 			if err != nil {
 				metricShardsLoadFailedTotal.Inc()
 				log.Printf("reloading: %s, err %v ", key, err)
@@ -476,7 +640,7 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	collectSender := newCollectSender(opts)
 
 	start := time.Now()
-	proc, err := ss.sched.Acquire(ctx)
+	proc, err := ss.sched.Acquire(ctx, opts.Class)
 	if err != nil {
 		return nil, err
 	}
@@ -487,7 +651,7 @@ func (ss *shardedSearcher) Search(ctx context.Context, q query.Q, opts *zoekt.Se
 	start = time.Now()
 
 	loaded := ss.getLoaded()
-	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, collectSender)
+	done, err := streamSearch(ctx, proc, q, opts, loaded.shards, ss.cache, collectSender)
 	defer done()
 	if err != nil {
 		return nil, err
@@ -525,7 +689,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	}()
 
 	start := time.Now()
-	proc, err := ss.sched.Acquire(ctx)
+	proc, err := ss.sched.Acquire(ctx, opts.Class)
 	if err != nil {
 		return err
 	}
@@ -566,6 +730,18 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	// For streaming, the wrapping has to happen in the inverted order.
 	sender = copyFileSender(sender)
 
+	if opts.TopK > 0 {
+		// The top K files are only known once all shards are searched, so we
+		// collect them instead of streaming.
+		collectSender := newCollectSender(opts)
+		done, err := streamSearch(ctx, proc, q, opts, shards, ss.cache, collectSender)
+		if agg, ok := collectSender.Done(); ok {
+			sender.Send(agg)
+		}
+		done()
+		return err
+	}
+
 	if opts.MaxDocDisplayCount > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithCancel(ctx)
@@ -575,7 +751,7 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 
 	sender, flush := newFlushCollectSender(opts, sender)
 
-	done, err := streamSearch(ctx, proc, q, opts, shards, sender)
+	done, err := streamSearch(ctx, proc, q, opts, shards, ss.cache, sender)
 
 	// Even though streaming is done, we may have results sitting in a buffer we
 	// need to flush. So we need to send those before calling done.
@@ -585,6 +761,26 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 	return err
 }
 
+// selectNgramFilter returns the shards whose ngram filter may match q, and
+// the number of shards it skipped. Skipping shards before they are searched
+// avoids scheduling searches which can't match, eg. of rare strings across
+// many small shards.
+func selectNgramFilter(shards []*rankedShard, q query.Q) ([]*rankedShard, int) {
+	fq := zoekt.NewNgramFilterQuery(q)
+	if fq == nil {
+		return shards, 0
+	}
+
+	// shards is shared with other searches, so we filter into a copy.
+	selected := make([]*rankedShard, 0, len(shards))
+	for _, s := range shards {
+		if s.filter.MayMatch(fq) {
+			selected = append(selected, s)
+		}
+	}
+	return selected, len(shards) - len(selected)
+}
+
 // streamSearch is an internal helper since both Search and StreamSearch are
 // largely similar.
 //
@@ -593,7 +789,9 @@ func (ss *shardedSearcher) StreamSearch(ctx context.Context, q query.Q, opts *zo
 // collector can't see. Calling done informs the garbage collector it is free
 // to collect those shards. The caller must call copyFiles on any
 // SearchResults it returns/streams out before calling done.
-func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, sender zoekt.Sender) (done func(), err error) {
+//
+// cache, if not nil, holds the results of previous searches of shards.
+func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.SearchOptions, shards []*rankedShard, cache *resultCache, sender zoekt.Sender) (done func(), err error) {
 	tr, ctx := trace.New(ctx, "shardedSearcher.streamSearch", "")
 	tr.LazyLog(q, true)
 	tr.LazyPrintf("opts: %+v", opts)
@@ -615,10 +813,50 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 	shards, q = selectRepoSet(shards, q)
 	tr.LazyPrintf("after selectRepoSet shards:%d %s", len(shards), q)
 
+	shards = selectSnapshots(shards, q)
+	tr.LazyPrintf("after selectSnapshots shards:%d", len(shards))
+
+	shards, skipped := selectNgramFilter(shards, q)
+	tr.LazyPrintf("after selectNgramFilter shards:%d", len(shards))
+	if skipped > 0 {
+		maxPendingPriority := math.Inf(-1)
+		if len(shards) > 0 {
+			maxPendingPriority = shards[0].priority
+		}
+		sender.Send(&zoekt.SearchResult{
+			Stats: zoekt.Stats{ShardsSkippedFilter: skipped},
+			Progress: zoekt.Progress{
+				Priority:           maxPendingPriority,
+				MaxPendingPriority: maxPendingPriority,
+			},
+		})
+	}
+
+	shards, timedOut := selectQuarantined(shards, opts.Class)
+	tr.LazyPrintf("after selectQuarantined shards:%d", len(shards))
+	if timedOut > 0 {
+		maxPendingPriority := math.Inf(-1)
+		if len(shards) > 0 {
+			maxPendingPriority = shards[0].priority
+		}
+		sender.Send(&zoekt.SearchResult{
+			Stats: zoekt.Stats{ShardsTimedOut: timedOut},
+			Progress: zoekt.Progress{
+				Priority:           maxPendingPriority,
+				MaxPendingPriority: maxPendingPriority,
+			},
+		})
+	}
+
 	if len(shards) == 0 {
 		return func() {}, nil
 	}
 
+	var cacheKey resultCacheKey
+	if cache != nil {
+		cacheKey = newResultCacheKey(q, opts)
+	}
+
 	var cancel context.CancelFunc
 	if opts.MaxWallTime == 0 {
 		ctx, cancel = context.WithCancel(ctx)
@@ -655,7 +893,7 @@ func streamSearch(ctx context.Context, proc *process, q query.Q, opts *zoekt.Sea
 		go func() {
 			defer wg.Done()
 			for s := range search {
-				sr, err := searchOneShard(ctx, s, q, opts)
+				sr, err := cache.search(ctx, cacheKey, s, q, opts)
 				r := &result{priority: s.priority, SearchResult: sr, err: err}
 				results <- r
 			}
@@ -720,7 +958,7 @@ search:
 			}
 
 			totalMatchCount += r.SearchResult.Stats.MatchCount
-			if opts.TotalMaxMatchCount > 0 && totalMatchCount > opts.TotalMaxMatchCount {
+			if opts.TopK <= 0 && opts.TotalMaxMatchCount > 0 && totalMatchCount > opts.TotalMaxMatchCount {
 				stop()
 			}
 
@@ -794,6 +1032,7 @@ func observeMetrics(sr *zoekt.SearchResult) {
 	metricSearchFilesLoadedTotal.Add(float64(sr.Stats.FilesLoaded))
 	metricSearchFilesSkippedTotal.Add(float64(sr.Stats.FilesSkipped))
 	metricSearchShardsSkippedTotal.Add(float64(sr.Stats.ShardsSkipped))
+	metricSearchShardsTimedOutTotal.Add(float64(sr.Stats.ShardsTimedOut))
 	metricSearchMatchCountTotal.Add(float64(sr.Stats.MatchCount))
 	metricSearchNgramMatchesTotal.Add(float64(sr.Stats.NgramMatches))
 }
@@ -822,7 +1061,17 @@ func copyFiles(sr *zoekt.SearchResult) {
 	}
 }
 
-func searchOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoekt.SearchOptions) (sr *zoekt.SearchResult, err error) {
+// searchOneShard searches s, stopping after the shard timeout, and tracks
+// the latency of s.
+func searchOneShard(ctx context.Context, s *rankedShard, q query.Q, opts *zoekt.SearchOptions) (sr *zoekt.SearchResult, err error) {
+	shardCtx := ctx
+	if timeout := s.health.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		shardCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
 	metricSearchShardRunning.Inc()
 	defer func() {
 		metricSearchShardRunning.Dec()
@@ -834,9 +1083,18 @@ func searchOneShard(ctx context.Context, s zoekt.Searcher, q query.Q, opts *zoek
 			}
 			sr.Stats.Crashes = 1
 		}
+
+		// Searches stopped by the caller say nothing about the shard.
+		if ctx.Err() != nil {
+			return
+		}
+		s.health.observe(q, time.Since(start), time.Now())
+		if shardCtx.Err() != nil && sr != nil {
+			sr.Stats.ShardsTimedOut = 1
+		}
 	}()
 
-	return s.Search(ctx, q, opts)
+	return s.Search(shardCtx, q, opts)
 }
 
 type shardListResult struct {
@@ -885,7 +1143,7 @@ func (ss *shardedSearcher) List(ctx context.Context, r query.Q, opts *zoekt.List
 		isAll = c.Value
 	}
 
-	proc, err := ss.sched.Acquire(ctx)
+	proc, err := ss.sched.Acquire(ctx, zoekt.SearchClassInteractive)
 	if err != nil {
 		return nil, err
 	}
@@ -893,7 +1151,7 @@ func (ss *shardedSearcher) List(ctx context.Context, r query.Q, opts *zoekt.List
 	tr.LazyPrintf("acquired process")
 
 	loaded := ss.getLoaded()
-	shards := loaded.shards
+	shards := selectSnapshots(loaded.shards, r)
 	shardCount := len(shards)
 	all := make(chan shardListResult, shardCount)
 	tr.LazyPrintf("shardCount: %d", len(shards))
@@ -935,10 +1193,15 @@ func (ss *shardedSearcher) List(ctx context.Context, r query.Q, opts *zoekt.List
 		agg.Stats.Add(&r.rl.Stats)
 
 		for _, r := range r.rl.Repos {
-			prev, ok := uniq[r.Repository.Name]
+			// Snapshots of a repository are listed apart.
+			key := r.Repository.Name
+			if r.Repository.Snapshot != "" {
+				key += "@" + r.Repository.Snapshot
+			}
+			prev, ok := uniq[key]
 			if !ok {
 				cp := *r // We need to copy because we mutate r.Stats when merging duplicates
-				uniq[r.Repository.Name] = &cp
+				uniq[key] = &cp
 			} else {
 				prev.Stats.Add(&r.Stats)
 			}
@@ -996,13 +1259,22 @@ func (s *shardedSearcher) getLoaded() loaded {
 }
 
 func mkRankedShard(s zoekt.Searcher) *rankedShard {
-	q := query.Const{Value: true}
-	result, err := s.List(context.Background(), &q, nil)
+	id := nextShardID.Inc()
+
+	var filter *zoekt.NgramFilter
+	if f, ok := s.(interface{ NgramFilter() *zoekt.NgramFilter }); ok {
+		filter = f.NgramFilter()
+	}
+
+	// History and snapshot shards are only listed if the query asks for
+	// them.
+	q := query.NewOr(&query.Const{Value: true}, &query.At{}, &query.HistoryType{})
+	result, err := s.List(context.Background(), q, nil)
 	if err != nil {
-		return &rankedShard{Searcher: s}
+		return &rankedShard{Searcher: s, id: id, filter: filter}
 	}
 	if len(result.Repos) == 0 {
-		return &rankedShard{Searcher: s}
+		return &rankedShard{Searcher: s, id: id, filter: filter}
 	}
 
 	var (
@@ -1020,13 +1292,36 @@ func mkRankedShard(s zoekt.Searcher) *rankedShard {
 		}
 	}
 
+	var snapshot *zoekt.Repository
+	if repos[0].Snapshot != "" {
+		snapshot = repos[0]
+	}
+
 	return &rankedShard{
 		Searcher: s,
+		id:       id,
+		filter:   filter,
+		snapshot: snapshot,
 		repos:    repos,
 		priority: maxPriority,
 	}
 }
 
+// reposForKeys returns the repositories contained in the currently loaded
+// shards identified by keys.
+func (s *shardedSearcher) reposForKeys(keys []string) []*zoekt.Repository {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var repos []*zoekt.Repository
+	for _, key := range keys {
+		if r, ok := s.shards[key]; ok {
+			repos = append(repos, r.repos...)
+		}
+	}
+	return repos
+}
+
 // markReady should be called once all shards have been passed into replace on
 // startup. Once s is marked as ready it stops reporting a Crash in the
 // response Stats.
@@ -1046,10 +1341,16 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var (
+		added   = make([]*rankedShard, 0, len(shards))
+		removed = make(map[*rankedShard]bool, len(shards))
+	)
 	for key, shard := range shards {
 		var r *rankedShard
 		if shard != nil {
 			r = mkRankedShard(shard)
+			r.health = newShardHealth(key, s.quarantine)
+			added = append(added, r)
 		}
 
 		old := s.shards[key]
@@ -1059,6 +1360,11 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 			s.shards[key] = r
 		}
 
+		if old != nil {
+			removed[old] = true
+			s.cache.invalidate(old.id)
+		}
+
 		if old != nil && old.Searcher != nil {
 			//                 _ ___                /^^\ /^\  /^^\_
 			//     _          _@)@) \            ,,/ '` ~ `'~~ ', `\.
@@ -1087,36 +1393,47 @@ func (s *shardedSearcher) replace(shards map[string]zoekt.Searcher) {
 		}
 	}
 
+	// Instead of sorting all shards, we merge the sorted added shards into
+	// the previous ranking, since usually only a few shards change.
+	sort.Slice(added, func(i, j int) bool {
+		return rankedLess(added[i], added[j])
+	})
+	prev, _ := s.ranked.Load().([]*rankedShard)
 	ranked := make([]*rankedShard, 0, len(s.shards))
-	for _, r := range s.shards {
-		ranked = append(ranked, r)
-	}
-
-	sort.Slice(ranked, func(i, j int) bool {
-		priorityDiff := ranked[i].priority - ranked[j].priority
-		if priorityDiff != 0 {
-			return priorityDiff > 0
+	for _, r := range prev {
+		if removed[r] {
+			continue
 		}
-		if len(ranked[i].repos) == 0 || len(ranked[j].repos) == 0 {
-			// Protect against empty names which can happen if we fail to List or
-			// the shard is full of tombstones. Prefer the shard which has names.
-			return len(ranked[i].repos) >= len(ranked[j].repos)
+		for len(added) > 0 && rankedLess(added[0], r) {
+			ranked = append(ranked, added[0])
+			added = added[1:]
 		}
-		return ranked[i].repos[0].Name < ranked[j].repos[0].Name
-	})
+		ranked = append(ranked, r)
+	}
+	ranked = append(ranked, added...)
 
 	s.ranked.Store(ranked)
 
 	metricShardsLoaded.Set(float64(len(ranked)))
 }
 
-func loadShard(fn string) (zoekt.Searcher, error) {
-	f, err := os.Open(fn)
-	if err != nil {
-		return nil, err
+// rankedLess orders shards by decreasing priority, then by the name of their
+// first repository.
+func rankedLess(a, b *rankedShard) bool {
+	priorityDiff := a.priority - b.priority
+	if priorityDiff != 0 {
+		return priorityDiff > 0
+	}
+	if len(a.repos) == 0 || len(b.repos) == 0 {
+		// Protect against empty names which can happen if we fail to List or
+		// the shard is full of tombstones. Prefer the shard which has names.
+		return len(a.repos) > len(b.repos)
 	}
+	return a.repos[0].Name < b.repos[0].Name
+}
 
-	iFile, err := zoekt.NewIndexFile(f)
+func loadShard(src ShardSource, fn string) (zoekt.Searcher, error) {
+	iFile, err := src.open(fn)
 	if err != nil {
 		return nil, err
 	}
@@ -21,7 +21,9 @@ import (
 	"hash/fnv"
 	"log"
 	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
@@ -1002,3 +1004,162 @@ func testShardedSearch(t *testing.T, q query.Q, ib *zoekt.IndexBuilder) []zoekt.
 	sres, _ := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
 	return sres.Files
 }
+
+func TestDirectorySearcherOnLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	b := testIndexBuilder(t, &zoekt.Repository{Name: "repo"},
+		zoekt.Document{Name: "f1", Content: []byte("needle")})
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("repo_v%d.00000.zoekt", zoekt.IndexFormatVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded []string
+	ss, err := NewDirectorySearcherWithOptions(dir, DirectorySearcherOptions{
+		WaitUntilReady: true,
+		OnLoad: func(repos []*zoekt.Repository) {
+			for _, r := range repos {
+				loaded = append(loaded, r.Name)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if want := []string{"repo"}; !reflect.DeepEqual(loaded, want) {
+		t.Fatalf("got %v, want %v", loaded, want)
+	}
+}
+
+func TestNgramFilterSkipsShards(t *testing.T) {
+	ss := newShardedSearcher(1)
+	for i, content := range []string{"needle in a haystack", "just hay", "more hay"} {
+		b := testIndexBuilder(t, &zoekt.Repository{Name: fmt.Sprintf("repo%d", i)},
+			zoekt.Document{Name: "f", Content: []byte(content)})
+		ss.replace(map[string]zoekt.Searcher{fmt.Sprintf("shard%d", i): searcherForTest(t, b)})
+	}
+
+	for _, search := range []func(q query.Q) ([]zoekt.FileMatch, zoekt.Stats){
+		func(q query.Q) ([]zoekt.FileMatch, zoekt.Stats) {
+			res, err := ss.Search(context.Background(), q, &zoekt.SearchOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res.Files, res.Stats
+		},
+		func(q query.Q) ([]zoekt.FileMatch, zoekt.Stats) {
+			var files []zoekt.FileMatch
+			var stats zoekt.Stats
+			err := ss.StreamSearch(context.Background(), q, &zoekt.SearchOptions{}, stream.SenderFunc(func(r *zoekt.SearchResult) {
+				files = append(files, r.Files...)
+				stats.Add(r.Stats)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return files, stats
+		},
+	} {
+		files, stats := search(&query.Substring{Pattern: "NEEDLE"})
+		if len(files) != 1 || files[0].Repository != "repo0" {
+			t.Errorf("got %v, want the file of repo0", files)
+		}
+		if stats.ShardsSkippedFilter != 2 || stats.ShardsScanned != 1 {
+			t.Errorf("got %d shards skipped and %d scanned, want 2 and 1", stats.ShardsSkippedFilter, stats.ShardsScanned)
+		}
+
+		if _, stats := search(&query.Substring{Pattern: "hay"}); stats.ShardsSkippedFilter != 0 {
+			t.Errorf("got %d shards skipped, want 0", stats.ShardsSkippedFilter)
+		}
+	}
+}
+
+func TestTopKMergesShards(t *testing.T) {
+	ss := newShardedSearcher(1)
+	symbol := []zoekt.DocumentSection{{Start: 5, End: 11}}
+	for i := 0; i < 3; i++ {
+		docs := []zoekt.Document{
+			{Name: "a", Content: []byte("needle")},
+			{Name: "b", Content: []byte("needle")},
+		}
+		// Only the last shards have symbol matches, which score highest.
+		if i > 0 {
+			docs = append(docs, zoekt.Document{Name: "sym", Content: []byte("func needle"), Symbols: symbol})
+		}
+		b := testIndexBuilder(t, &zoekt.Repository{Name: fmt.Sprintf("repo%d", i)}, docs...)
+		ss.replace(map[string]zoekt.Searcher{fmt.Sprintf("shard%d", i): searcherForTest(t, b)})
+	}
+
+	opts := &zoekt.SearchOptions{TopK: 2}
+	q := &query.Substring{Pattern: "needle"}
+
+	res, err := ss.Search(context.Background(), q, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []zoekt.FileMatch
+	err = ss.StreamSearch(context.Background(), q, opts, stream.SenderFunc(func(r *zoekt.SearchResult) {
+		streamed = append(streamed, r.Files...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, files := range [][]zoekt.FileMatch{res.Files, streamed} {
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Repository+"/"+f.FileName)
+		}
+		sort.Strings(got)
+		if d := cmp.Diff([]string{"repo1/sym", "repo2/sym"}, got); d != "" {
+			t.Errorf("top 2 mismatch (-want +got):\n%s", d)
+		}
+	}
+}
+
+func TestReplaceKeepsRanking(t *testing.T) {
+	ss := newShardedSearcher(1)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		batch := map[string]zoekt.Searcher{}
+		for j := rng.Intn(5); j >= 0; j-- {
+			key := fmt.Sprintf("shard%d", rng.Intn(50))
+			if rng.Intn(3) == 0 {
+				batch[key] = nil
+				continue
+			}
+			batch[key] = &rankSearcher{repo: &zoekt.Repository{
+				Name:      fmt.Sprintf("repo%d", rng.Intn(50)),
+				RawConfig: map[string]string{"priority": strconv.Itoa(rng.Intn(3))},
+			}}
+		}
+		ss.replace(batch)
+
+		ranked := ss.getLoaded().shards
+		if len(ranked) != len(ss.shards) {
+			t.Fatalf("got %d ranked shards, want %d", len(ranked), len(ss.shards))
+		}
+		loaded := map[*rankedShard]bool{}
+		for _, r := range ss.shards {
+			loaded[r] = true
+		}
+		for j, r := range ranked {
+			if !loaded[r] {
+				t.Fatalf("ranked shard %d isn't loaded", j)
+			}
+			if j > 0 && rankedLess(r, ranked[j-1]) {
+				t.Fatalf("shard %d ranked after a lower shard", j)
+			}
+		}
+	}
+}
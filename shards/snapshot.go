@@ -0,0 +1,69 @@
+package shards
+
+import (
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+// selectSnapshots returns the shards q may match. Snapshot shards are only
+// searched if q has an query.At atom, and for atoms with a Time only the
+// latest snapshot of each repository at that time is.
+func selectSnapshots(shards []*rankedShard, q query.Q) []*rankedShard {
+	var ats []*query.At
+	query.VisitAtoms(q, func(q query.Q) {
+		if at, ok := q.(*query.At); ok {
+			ats = append(ats, at)
+		}
+	})
+
+	// latest holds the latest snapshot of each repository matching the Time
+	// of the atom at the same index in ats.
+	latest := make([]map[string]*zoekt.Repository, len(ats))
+	for i, at := range ats {
+		if at.Revision != "" || at.Time.IsZero() {
+			continue
+		}
+		latest[i] = map[string]*zoekt.Repository{}
+		for _, s := range shards {
+			r := s.snapshot
+			if r == nil || r.LatestCommitDate.After(at.Time) {
+				continue
+			}
+			if l := latest[i][r.Name]; l == nil || r.LatestCommitDate.After(l.LatestCommitDate) ||
+				(r.LatestCommitDate.Equal(l.LatestCommitDate) && r.Snapshot > l.Snapshot) {
+				latest[i][r.Name] = r
+			}
+		}
+	}
+
+	keep := func(s *rankedShard) bool {
+		if s.snapshot == nil {
+			return true
+		}
+		for i := range ats {
+			if latest[i] == nil {
+				return true
+			}
+			if l := latest[i][s.snapshot.Name]; l != nil && l.Snapshot == s.snapshot.Snapshot {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, s := range shards {
+		if keep(s) {
+			continue
+		}
+
+		// shards is shared with other searches, so we filter into a copy.
+		selected := append(make([]*rankedShard, 0, len(shards)), shards[:i]...)
+		for _, s := range shards[i+1:] {
+			if keep(s) {
+				selected = append(selected, s)
+			}
+		}
+		return selected
+	}
+	return shards
+}
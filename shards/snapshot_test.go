@@ -0,0 +1,102 @@
+package shards
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestSelectSnapshots(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	shard := func(snapshot string, date time.Time) zoekt.Searcher {
+		name := snapshot
+		if name == "" {
+			name = "live"
+		}
+		return searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{
+			Name:             "repo",
+			Snapshot:         snapshot,
+			LatestCommitDate: date,
+		}, zoekt.Document{Name: name + ".go", Content: []byte("needle")}))
+	}
+
+	ss := newShardedSearcher(1)
+	ss.replace(map[string]zoekt.Searcher{
+		"live":   shard("", day(10)),
+		"v1":     shard("v1", day(1)),
+		"v2.0":   shard("v2", day(3)),
+		"v2.1":   shard("v2", day(3)),
+		"v3":     shard("v3", day(5)),
+		"other1": searcherForTest(t, testIndexBuilder(t, &zoekt.Repository{Name: "other", Snapshot: "v1", LatestCommitDate: day(2)})),
+	})
+	ss.markReady()
+	defer ss.Close()
+
+	search := func(q string) []string {
+		t.Helper()
+		parsed, err := query.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ss.Search(context.Background(), parsed, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range res.Files {
+			got = append(got, f.FileName)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	cases := []struct {
+		q    string
+		want []string
+	}{
+		{"needle", []string{"live.go"}},
+		{"needle at:v2", []string{"v2.go", "v2.go"}},
+		{"needle at:2023-01-04", []string{"v2.go", "v2.go"}},
+		{"needle at:2023-01-02", []string{"v1.go"}},
+		{"needle at:2022-12-31", nil},
+		{"needle (at:v1 or at:v3)", []string{"v1.go", "v3.go"}},
+	}
+	for _, tc := range cases {
+		if d := cmp.Diff(tc.want, search(tc.q)); d != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.q, d)
+		}
+	}
+
+	// The latest snapshot of each repository is selected.
+	parsed, _ := query.Parse("at:2023-01-04")
+	var got []string
+	for _, s := range selectSnapshots(ss.getLoaded().shards, parsed) {
+		if s.snapshot != nil {
+			got = append(got, s.snapshot.Name+"@"+s.snapshot.Snapshot)
+		}
+	}
+	sort.Strings(got)
+	if d := cmp.Diff([]string{"other@v1", "repo@v2", "repo@v2"}, got); d != "" {
+		t.Errorf("selected snapshots mismatch (-want +got):\n%s", d)
+	}
+
+	// Snapshots are only listed if asked for.
+	for q, want := range map[query.Q]int{
+		&query.Const{Value: true}: 1,
+		&query.At{}:               4,
+	} {
+		rl, err := ss.List(context.Background(), q, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rl.Repos) != want {
+			t.Errorf("List(%s): got %d repos, want %d", q, len(rl.Repos), want)
+		}
+	}
+}
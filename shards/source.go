@@ -0,0 +1,267 @@
+package shards
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+)
+
+// ShardSource is a location shards are loaded from, eg. an index directory.
+// Sources are created by DirectorySource and HTTPSource and passed to
+// NewSourceSearcher.
+type ShardSource interface {
+	// watch loads the shards of the source into loader, and keeps them up
+	// to date until the returned watcher is stopped.
+	watch(loader shardLoader) (sourceWatcher, error)
+
+	// open opens the shard key, as passed to loader.load.
+	open(key string) (zoekt.IndexFile, error)
+}
+
+type sourceWatcher interface {
+	// WaitUntilReady blocks until the initial set of shards was loaded.
+	WaitUntilReady() error
+	Stop()
+}
+
+// DirectorySource returns the shards in dir, which are reloaded as they
+// change on disk.
+func DirectorySource(dir string) ShardSource {
+	return directorySource(dir)
+}
+
+type directorySource string
+
+func (dir directorySource) watch(loader shardLoader) (sourceWatcher, error) {
+	dw, err := newDirectoryWatcher(string(dir), loader)
+	if err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+func (dir directorySource) open(key string) (zoekt.IndexFile, error) {
+	f, err := os.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	return zoekt.NewIndexFile(f)
+}
+
+func (dir directorySource) String() string {
+	return string(dir)
+}
+
+// HTTPSource returns the shards listed by the page at baseURL, eg. a
+// directory listing of a static file server or a local stand-in for an
+// object store. Shards are read with HTTP range requests instead of being
+// copied first, so the server must support them.
+//
+// The listing is polled every interval. Shards which were added, removed
+// or changed according to their ETag, Last-Modified and Content-Length
+// headers are loaded or dropped. Metadata overlays (.meta files) are not
+// read. client may be nil to use a client with zoekt.DefaultHTTPTimeout.
+func HTTPSource(baseURL string, client *http.Client, interval time.Duration) ShardSource {
+	if client == nil {
+		client = &http.Client{Timeout: zoekt.DefaultHTTPTimeout}
+	}
+	return &httpSource{
+		baseURL:  baseURL,
+		client:   client,
+		interval: interval,
+	}
+}
+
+type httpSource struct {
+	baseURL  string
+	client   *http.Client
+	interval time.Duration
+}
+
+func (s *httpSource) watch(loader shardLoader) (sourceWatcher, error) {
+	base, err := url.Parse(s.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if s.interval <= 0 {
+		return nil, fmt.Errorf("HTTPSource(%s): interval must be positive", s.baseURL)
+	}
+
+	w := &httpWatcher{
+		src:      s,
+		base:     base,
+		loader:   loader,
+		versions: map[string]string{},
+		ready:    make(chan struct{}),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (s *httpSource) open(key string) (zoekt.IndexFile, error) {
+	return zoekt.NewHTTPIndexFile(s.client, key)
+}
+
+func (s *httpSource) String() string {
+	return s.baseURL
+}
+
+// httpWatcher polls the listing of an httpSource.
+type httpWatcher struct {
+	src    *httpSource
+	base   *url.URL
+	loader shardLoader
+
+	// versions maps the URLs of the loaded shards to the headers identifying
+	// their content. It is only accessed by the polling goroutine.
+	versions map[string]string
+
+	// closed once ready
+	ready    chan struct{}
+	readyErr error
+
+	closeOnce sync.Once
+	quit      chan struct{}
+	stopped   chan struct{}
+}
+
+func (w *httpWatcher) run() {
+	defer close(w.stopped)
+
+	w.readyErr = w.poll()
+	close(w.ready)
+	if w.readyErr != nil {
+		log.Printf("%s: %v", w, w.readyErr)
+	}
+
+	t := time.NewTicker(w.src.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := w.poll(); err != nil {
+				log.Printf("%s: %v", w, err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+func (w *httpWatcher) WaitUntilReady() error {
+	<-w.ready
+	return w.readyErr
+}
+
+func (w *httpWatcher) Stop() {
+	w.closeOnce.Do(func() {
+		close(w.quit)
+		<-w.stopped
+	})
+}
+
+func (w *httpWatcher) String() string {
+	return fmt.Sprintf("httpWatcher(%s)", w.src.baseURL)
+}
+
+// poll loads the shards which changed since the last poll. If the listing
+// can't be read, the loaded shards are kept.
+func (w *httpWatcher) poll() error {
+	urls, err := w.list()
+	if err != nil {
+		return err
+	}
+
+	versions := map[string]string{}
+	for _, u := range latestShards(urls) {
+		v, err := w.version(u)
+		if err != nil {
+			log.Printf("%s: %v", w, err)
+			if old, ok := w.versions[u]; ok {
+				versions[u] = old
+			}
+			continue
+		}
+		versions[u] = v
+	}
+
+	var toLoad, toDrop []string
+	for u, v := range versions {
+		if old, ok := w.versions[u]; !ok || old != v {
+			toLoad = append(toLoad, u)
+		}
+	}
+	for u := range w.versions {
+		if _, ok := versions[u]; !ok {
+			toDrop = append(toDrop, u)
+		}
+	}
+	w.versions = versions
+
+	if len(toDrop) > 0 {
+		log.Printf("unloading %d shard(s): %s", len(toDrop), humanTruncateList(toDrop, 5))
+		w.loader.drop(toDrop...)
+	}
+	if len(toLoad) > 0 {
+		w.loader.load(toLoad...)
+	}
+	return nil
+}
+
+// hrefRegexp matches the links of HTML directory listings.
+var hrefRegexp = regexp.MustCompile(`href="([^"]+)"`)
+
+// list returns the URLs of the shards linked from the base URL.
+func (w *httpWatcher) list() ([]string, error) {
+	resp, err := w.src.client.Get(w.base.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", w.base, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var urls []string
+	for _, m := range hrefRegexp.FindAllSubmatch(body, -1) {
+		ref, err := url.Parse(string(m[1]))
+		if err != nil || !strings.HasSuffix(ref.Path, ".zoekt") {
+			continue
+		}
+		u := w.base.ResolveReference(ref).String()
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+// version returns the headers identifying the content of the shard at u.
+func (w *httpWatcher) version(u string) (string, error) {
+	resp, err := w.src.client.Head(u)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HEAD %s: %s", u, resp.Status)
+	}
+	return fmt.Sprintf("%s|%s|%d", resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), resp.ContentLength), nil
+}
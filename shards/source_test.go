@@ -0,0 +1,109 @@
+package shards
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func writeTestShard(t *testing.T, dir, repo string) string {
+	b := testIndexBuilder(t, &zoekt.Repository{Name: repo},
+		zoekt.Document{Name: "f1", Content: []byte("needle")})
+	fn := filepath.Join(dir, fmt.Sprintf("%s_v%d.00000.zoekt", repo, zoekt.IndexFormatVersion))
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestSourceSearcher(t *testing.T) {
+	hot, cold, remote := t.TempDir(), t.TempDir(), t.TempDir()
+	writeTestShard(t, hot, "hot")
+	writeTestShard(t, cold, "cold")
+	remoteShard := writeTestShard(t, remote, "remote")
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(remote)))
+	defer srv.Close()
+
+	ss, err := NewSourceSearcher([]ShardSource{
+		DirectorySource(hot),
+		DirectorySource(cold),
+		HTTPSource(srv.URL+"/", srv.Client(), 10*time.Millisecond),
+	}, DirectorySearcherOptions{WaitUntilReady: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	repos := func() []string {
+		res, err := ss.Search(context.Background(), &query.Substring{Pattern: "needle"}, &zoekt.SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var repos []string
+		for _, f := range res.Files {
+			repos = append(repos, f.Repository)
+		}
+		sort.Strings(repos)
+		return repos
+	}
+
+	if got, want := repos(), []string{"cold", "hot", "remote"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Shards removed from the listing are dropped by the next poll.
+	if err := os.Remove(remoteShard); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cold", "hot"}
+	deadline := time.Now().Add(5 * time.Second)
+	for got := repos(); !reflect.DeepEqual(got, want); got = repos() {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPSourceLatestVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="repo_v15.00000.zoekt">old</a>
+<a href="repo_v16.00000.zoekt">new</a>
+<a href="repo_v16.00000.zoekt.meta">meta</a>
+<a href="sub/">dir</a>`)
+	}))
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL + "/shards/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &httpWatcher{src: HTTPSource(srv.URL, srv.Client(), time.Minute).(*httpSource), base: base}
+	urls, err := w.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{srv.URL + "/shards/repo_v16.00000.zoekt"}
+	if got := latestShards(urls); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
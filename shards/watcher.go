@@ -15,6 +15,7 @@
 package shards
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -29,6 +30,15 @@ import (
 	"github.com/sourcegraph/zoekt"
 )
 
+// reloadScanInterval is the minimum time between the scans of the index
+// directory which ReloadShard falls back to.
+const reloadScanInterval = 10 * time.Second
+
+// ErrReloadThrottled is returned by ReloadShard if a shard needs a scan of
+// the index directory, but ReloadShard ran one less than reloadScanInterval
+// ago. The file system watch still picks up the shard.
+var ErrReloadThrottled = errors.New("reloading the shard needs a scan of the index directory, which ran too recently")
+
 type shardLoader interface {
 	// Load a new file.
 	load(filenames ...string)
@@ -36,9 +46,21 @@ type shardLoader interface {
 }
 
 type DirectoryWatcher struct {
-	dir        string
+	dir    string
+	loader shardLoader
+
+	// mu serializes scans and updates of the loaded shards.
+	mu         sync.Mutex
 	timestamps map[string]time.Time
-	loader     shardLoader
+
+	// versions maps the names of the loaded shards, as returned by
+	// versionFromPath, to their index format version and number of files.
+	// It lets updates of single shards apply the rules of scan.
+	versions map[string]shardVersion
+
+	// lastReloadScan is the time ReloadShard last scanned the directory.
+	reloadMu       sync.Mutex
+	lastReloadScan time.Time
 
 	// closed once ready
 	ready    chan struct{}
@@ -62,6 +84,7 @@ func newDirectoryWatcher(dir string, loader shardLoader) (*DirectoryWatcher, err
 	sw := &DirectoryWatcher{
 		dir:        dir,
 		timestamps: map[string]time.Time{},
+		versions:   map[string]shardVersion{},
 		loader:     loader,
 		ready:      make(chan struct{}),
 		quit:       make(chan struct{}),
@@ -116,19 +139,42 @@ func versionFromPath(path string) (string, int) {
 	return path[:und], version
 }
 
-func (s *DirectoryWatcher) scan() error {
-	fs, err := filepath.Glob(filepath.Join(s.dir, "*.zoekt"))
+type shardVersion struct {
+	version int
+	files   int
+}
+
+// supportedVersion returns false for index formats which are too new to
+// read, eg. after downgrades.
+func supportedVersion(version int) bool {
+	return version <= zoekt.IndexFormatVersion || version <= zoekt.NextIndexFormatVersion
+}
+
+// shardModTime returns the time the shard fn or its metadata was last
+// modified.
+func shardModTime(fn string) (time.Time, error) {
+	fi, err := os.Lstat(fn)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 
+	mtime := fi.ModTime()
+	if fiMeta, err := os.Lstat(fn + ".meta"); err == nil && fiMeta.ModTime().After(mtime) {
+		mtime = fiMeta.ModTime()
+	}
+	return mtime, nil
+}
+
+// latestShards returns the shards of fs in the latest supported index
+// format version of their repository.
+func latestShards(fs []string) []string {
 	latest := map[string]int{}
 	for _, fn := range fs {
 		name, version := versionFromPath(fn)
 
 		// In the case of downgrades, avoid reading
 		// newer index formats.
-		if version > zoekt.IndexFormatVersion && version > zoekt.NextIndexFormatVersion {
+		if !supportedVersion(version) {
 			continue
 		}
 
@@ -137,26 +183,31 @@ func (s *DirectoryWatcher) scan() error {
 		}
 	}
 
-	ts := map[string]time.Time{}
+	var shards []string
 	for _, fn := range fs {
-		if name, version := versionFromPath(fn); latest[name] != version {
-			continue
+		if name, version := versionFromPath(fn); latest[name] == version {
+			shards = append(shards, fn)
 		}
+	}
+	return shards
+}
 
-		fi, err := os.Lstat(fn)
-		if err != nil {
-			continue
-		}
+func (s *DirectoryWatcher) scan() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		ts[fn] = fi.ModTime()
+	fs, err := filepath.Glob(filepath.Join(s.dir, "*.zoekt"))
+	if err != nil {
+		return err
+	}
 
-		fiMeta, err := os.Lstat(fn + ".meta")
+	ts := map[string]time.Time{}
+	for _, fn := range latestShards(fs) {
+		mtime, err := shardModTime(fn)
 		if err != nil {
 			continue
 		}
-		if fiMeta.ModTime().After(fi.ModTime()) {
-			ts[fn] = fiMeta.ModTime()
-		}
+		ts[fn] = mtime
 	}
 
 	var toLoad []string
@@ -176,6 +227,73 @@ func (s *DirectoryWatcher) scan() error {
 		}
 	}
 
+	s.versions = map[string]shardVersion{}
+	for fn := range s.timestamps {
+		name, version := versionFromPath(fn)
+		s.versions[name] = shardVersion{version: version, files: s.versions[name].files + 1}
+	}
+
+	s.apply(toLoad, toDrop)
+
+	return nil
+}
+
+// update loads, reloads or drops the shards at paths according to their
+// state on disk, without scanning the whole directory. It returns true if a
+// scan is needed instead, because a shard was written in a newer index
+// format than the loaded one.
+//
+// If the last file of a shard is dropped, files of the shard in older index
+// formats are only loaded by the next scan.
+func (s *DirectoryWatcher) update(paths ...string) (rescan bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var toLoad, toDrop []string
+	for _, fn := range paths {
+		name, version := versionFromPath(fn)
+		loaded, isLoaded := s.versions[name]
+
+		mtime, err := shardModTime(fn)
+		if err != nil {
+			if _, ok := s.timestamps[fn]; ok {
+				toDrop = append(toDrop, fn)
+				delete(s.timestamps, fn)
+				if loaded.files--; loaded.files == 0 {
+					delete(s.versions, name)
+				} else {
+					s.versions[name] = loaded
+				}
+			}
+			continue
+		}
+
+		switch {
+		case !supportedVersion(version):
+			continue
+		case isLoaded && version < loaded.version:
+			continue
+		case isLoaded && version > loaded.version:
+			rescan = true
+			continue
+		}
+
+		if t, ok := s.timestamps[fn]; !ok || t != mtime {
+			if !ok {
+				s.versions[name] = shardVersion{version: version, files: loaded.files + 1}
+			}
+			toLoad = append(toLoad, fn)
+			s.timestamps[fn] = mtime
+		}
+	}
+
+	s.apply(toLoad, toDrop)
+
+	return rescan
+}
+
+// apply must be called with s.mu held.
+func (s *DirectoryWatcher) apply(toLoad, toDrop []string) {
 	if len(toDrop) > 0 {
 		log.Printf("unloading %d shard(s): %s", len(toDrop), humanTruncateList(toDrop, 5))
 	}
@@ -183,12 +301,45 @@ func (s *DirectoryWatcher) scan() error {
 	s.loader.drop(toDrop...)
 
 	if len(toLoad) == 0 {
-		return nil
+		return
 	}
 
 	s.loader.load(toLoad...)
+}
 
-	return nil
+// ReloadShard loads, reloads or drops the shard with the file name name in
+// the watched directory according to its state on disk. Indexers can use it
+// to make new shards searchable without waiting for the file system watch.
+// If the shard needs a scan of the whole directory, because it was written
+// in a newer index format, it returns ErrReloadThrottled unless the last
+// such scan is at least reloadScanInterval old.
+func (s *DirectoryWatcher) ReloadShard(name string) error {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".zoekt") {
+		return fmt.Errorf("invalid shard file name %q", name)
+	}
+
+	if !s.update(filepath.Join(s.dir, name)) {
+		return nil
+	}
+
+	// Scans are expensive and ReloadShard is called by other processes, so
+	// they are rate limited.
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if time.Since(s.lastReloadScan) < reloadScanInterval {
+		return ErrReloadThrottled
+	}
+	s.lastReloadScan = time.Now()
+	return s.scan()
+}
+
+// shardPath returns the shard an event for the file fn in dir affects.
+func shardPath(dir, fn string) (string, bool) {
+	name := strings.TrimSuffix(filepath.Base(fn), ".meta")
+	if !strings.HasSuffix(name, ".zoekt") {
+		return "", false
+	}
+	return filepath.Join(dir, name), true
 }
 
 func humanTruncateList(paths []string, max int) string {
@@ -217,21 +368,45 @@ func (s *DirectoryWatcher) watch() error {
 	}
 
 	// intermediate signal channel so if there are multiple watcher.Events we
-	// only call scan once.
+	// only update once.
 	signal := make(chan struct{}, 1)
 
+	// pending holds the shards changed by events since the last update. If
+	// events were lost, we scan the whole directory instead.
+	var (
+		mu      sync.Mutex
+		pending = map[string]struct{}{}
+		rescan  bool
+	)
+	notify := func() {
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
+	}
+
 	go func() {
 		for {
 			select {
-			case <-watcher.Events:
-				select {
-				case signal <- struct{}{}:
-				default:
+			case ev := <-watcher.Events:
+				if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
 				}
+				fn, ok := shardPath(s.dir, ev.Name)
+				if !ok {
+					continue
+				}
+				mu.Lock()
+				pending[fn] = struct{}{}
+				mu.Unlock()
+				notify()
 			case err := <-watcher.Errors:
-				// Ignore ErrEventOverflow since we rely on the presence of events so
-				// safe to ignore.
-				if err != nil && err != fsnotify.ErrEventOverflow {
+				if err == fsnotify.ErrEventOverflow {
+					mu.Lock()
+					rescan = true
+					mu.Unlock()
+					notify()
+				} else if err != nil {
 					log.Println("watcher error:", err)
 				}
 			case <-s.quit:
@@ -245,8 +420,23 @@ func (s *DirectoryWatcher) watch() error {
 	go func() {
 		defer close(s.stopped)
 		for range signal {
-			if err := s.scan(); err != nil {
-				log.Println("watcher error:", err)
+			mu.Lock()
+			paths := make([]string, 0, len(pending))
+			for fn := range pending {
+				paths = append(paths, fn)
+			}
+			pending = map[string]struct{}{}
+			scan := rescan
+			rescan = false
+			mu.Unlock()
+
+			if !scan {
+				scan = s.update(paths...)
+			}
+			if scan {
+				if err := s.scan(); err != nil {
+					log.Println("watcher error:", err)
+				}
 			}
 		}
 	}()
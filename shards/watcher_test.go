@@ -15,6 +15,7 @@
 package shards
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -215,6 +216,94 @@ func TestDirWatcherLoadLatest(t *testing.T) {
 	}
 }
 
+func TestDirWatcherReloadShard(t *testing.T) {
+	dir := t.TempDir()
+	logger := &loggingLoader{
+		loads: make(chan string, 10),
+		drops: make(chan string, 10),
+	}
+	// Not started, so only ReloadShard loads shards.
+	dw := &DirectoryWatcher{
+		dir:        dir,
+		loader:     logger,
+		timestamps: map[string]time.Time{},
+		versions:   map[string]shardVersion{},
+	}
+
+	write := func(version int) string {
+		t.Helper()
+		name := fmt.Sprintf("foo_v%d.00000.zoekt", version)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	reload := func(name string) {
+		t.Helper()
+		if err := dw.ReloadShard(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantEvents := func(loads, drops []string) {
+		t.Helper()
+		for _, want := range []struct {
+			events chan string
+			names  []string
+		}{{logger.loads, loads}, {logger.drops, drops}} {
+			for _, name := range want.names {
+				if got := <-want.events; got != filepath.Join(dir, name) {
+					t.Fatalf("got event %v, want %v", got, name)
+				}
+			}
+		}
+		select {
+		case k := <-logger.loads:
+			t.Fatalf("spurious load of %q", k)
+		case k := <-logger.drops:
+			t.Fatalf("spurious drop of %q", k)
+		default:
+		}
+	}
+
+	cur := write(zoekt.IndexFormatVersion)
+	reload(cur)
+	wantEvents([]string{cur}, nil)
+
+	// Unchanged and older shards aren't loaded.
+	reload(cur)
+	reload(write(zoekt.IndexFormatVersion - 1))
+	wantEvents(nil, nil)
+
+	// A newer format replaces the loaded one.
+	next := write(zoekt.NextIndexFormatVersion)
+	reload(next)
+	wantEvents([]string{next}, []string{cur})
+
+	if err := os.Remove(filepath.Join(dir, next)); err != nil {
+		t.Fatal(err)
+	}
+	reload(next)
+	wantEvents(nil, []string{next})
+
+	// Rescans are rate limited.
+	reload(write(zoekt.IndexFormatVersion))
+	wantEvents([]string{cur}, nil)
+	write(zoekt.NextIndexFormatVersion)
+	if err := dw.ReloadShard(next); !errors.Is(err, ErrReloadThrottled) {
+		t.Fatalf("got %v, want %v", err, ErrReloadThrottled)
+	}
+	wantEvents(nil, nil)
+	dw.lastReloadScan = time.Time{}
+	reload(next)
+	wantEvents([]string{next}, []string{cur})
+
+	for _, name := range []string{"../foo.zoekt", "foo.txt"} {
+		if err := dw.ReloadShard(name); err == nil {
+			t.Errorf("ReloadShard(%q) succeeded", name)
+		}
+	}
+}
+
 func TestHumanTruncateList(t *testing.T) {
 	paths := []string{
 		"dir/1",
@@ -98,6 +98,14 @@ type indexTOC struct {
 
 	repos simpleSection
 
+	symbolRefs compoundSection
+	symbolIDs  compoundSection
+
+	originalNames compoundSection
+	encodings     compoundSection
+	commits       compoundSection
+	blames        compoundSection
+
 	ranks simpleSection
 }
 
@@ -183,14 +191,32 @@ func (t *indexTOC) sectionsTaggedList() []taggedSection {
 		{"repos", &t.repos},
 
 		// We no longer write bloom sections, but we still return them here to
-		// avoid warnings about unknown sections.
+		// avoid warnings about unknown sections. NgramFilter, which is built
+		// from the ngram sections when loading, replaces them.
 		{"nameBloom", &unusedSimple},
 		{"contentBloom", &unusedSimple},
 
 		{"ranks", &t.ranks},
+		{"symbolRefs", &t.symbolRefs},
+		{"symbolIDs", &t.symbolIDs},
+		{"originalNames", &t.originalNames},
+		{"encodings", &t.encodings},
+		{"commits", &t.commits},
+		{"blames", &t.blames},
 	}
 }
 
+// optionalSections are only written if they have content, so shards not
+// using the corresponding feature are unchanged.
+var optionalSections = map[string]bool{
+	"symbolRefs":    true,
+	"symbolIDs":     true,
+	"originalNames": true,
+	"encodings":     true,
+	"commits":       true,
+	"blames":        true,
+}
+
 // sectionsTaggedCompatibilityList returns a list of sections that will be
 // handled or converted for backwards compatiblity, but aren't written by
 // the current iteration of the indexer.
@@ -0,0 +1,133 @@
+package zoekt
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+)
+
+// maxScoreKind is the largest boost scoreKind returns.
+const maxScoreKind = 10 * scoreKindMatch
+
+// topKFiles keeps the K highest scoring file matches of a shard, for
+// SearchOptions.TopK. Ties are resolved in favour of earlier documents, like
+// the doc-order score does.
+type topKFiles struct {
+	k       int
+	entries topKHeap
+}
+
+type topKEntry struct {
+	doc uint32
+	fm  FileMatch
+}
+
+// topKHeap is a min-heap of scores, with the latest document first among
+// equal scores.
+type topKHeap []topKEntry
+
+func (h topKHeap) Len() int { return len(h) }
+func (h topKHeap) Less(i, j int) bool {
+	if h[i].fm.Score != h[j].fm.Score {
+		return h[i].fm.Score < h[j].fm.Score
+	}
+	return h[i].doc > h[j].doc
+}
+func (h topKHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topKHeap) Push(x interface{}) { *h = append(*h, x.(topKEntry)) }
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newTopKFiles(k int) *topKFiles {
+	return &topKFiles{k: k, entries: make(topKHeap, 0, k)}
+}
+
+// threshold returns the score a document must exceed to enter the top K.
+// It is false until K documents were added.
+func (t *topKFiles) threshold() (float64, bool) {
+	if len(t.entries) < t.k {
+		return 0, false
+	}
+	return t.entries[0].fm.Score, true
+}
+
+// add adds fm of doc, which must be later than the documents added before.
+// It returns whether a file match was dropped from the top K.
+func (t *topKFiles) add(doc uint32, fm FileMatch) bool {
+	if len(t.entries) < t.k {
+		heap.Push(&t.entries, topKEntry{doc: doc, fm: fm})
+		return false
+	}
+	if fm.Score > t.entries[0].fm.Score {
+		t.entries[0] = topKEntry{doc: doc, fm: fm}
+		heap.Fix(&t.entries, 0)
+	}
+	return true
+}
+
+// files returns the top K file matches in document order, which keeps the
+// file matches of a repository next to each other.
+func (t *topKFiles) files() []FileMatch {
+	sort.Slice(t.entries, func(i, j int) bool { return t.entries[i].doc < t.entries[j].doc })
+	fms := make([]FileMatch, 0, len(t.entries))
+	for _, e := range t.entries {
+		fms = append(fms, e.fm)
+	}
+	return fms
+}
+
+// fragmentScoreBound returns an upper bound of the fragment and
+// repetition-boost scores Search gives doc for cands, without reading the
+// content of doc. Only file name matches can get the base score, and only
+// documents with symbols the symbol score.
+func (d *indexData) fragmentScoreBound(doc uint32, cands []*candidateMatch, chunkMatches bool) float64 {
+	// Shards without the symbol section may have symbols anywhere.
+	hasSymbols := int(doc)+1 >= len(d.fileEndSymbol) || d.fileEndSymbol[doc+1] > d.fileEndSymbol[doc]
+
+	fragment := 0.0
+	lines := 0
+	for _, c := range cands {
+		s := scoreWordMatch
+		if c.fileName {
+			s += scoreBase
+		} else if hasSymbols {
+			s += scoreSymbol + maxScoreKind
+		}
+		if s > fragment {
+			fragment = s
+		}
+
+		// Matches of the file name are a single line. Other candidates span
+		// as many lines as the newlines they match. Candidates merged by
+		// gatherMatches are longer than their substring.
+		switch {
+		case c.fileName:
+			lines = 1
+		case int(c.byteMatchSz) == len(c.substrBytes):
+			lines += 1 + bytes.Count(c.substrBytes, []byte{'\n'})
+		default:
+			lines += 1 + int(c.byteMatchSz)
+		}
+	}
+
+	// Only line matches with the highest score count as repetitions.
+	repetitions := 0
+	if !chunkMatches && lines > 1 {
+		repetitions = lines - 1
+	}
+	return fragment + scoreRepetitionFactor*float64(repetitions)
+}
+
+// matchCount returns the number of matches of fm, as counted in
+// Stats.MatchCount.
+func matchCount(fm *FileMatch) int {
+	n := len(fm.LineMatches)
+	for _, cm := range fm.ChunkMatches {
+		n += len(cm.Ranges)
+	}
+	return n
+}
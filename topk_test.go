@@ -0,0 +1,109 @@
+package zoekt
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sourcegraph/zoekt/query"
+)
+
+func TestTopK(t *testing.T) {
+	// "func needle" has needle as symbol at offset 5.
+	symbol := []DocumentSection{{Start: 5, End: 11}}
+	docs := []Document{
+		{Name: "a.go", Content: []byte("func needle"), Symbols: symbol},
+		{Name: "b.go", Content: []byte("func needle"), Symbols: symbol},
+	}
+	for i := 0; i < 50; i++ {
+		docs = append(docs, Document{Name: fmt.Sprintf("plain%d.go", i), Content: []byte("needle")})
+	}
+	// The best document comes last, since it matches both atoms.
+	docs = append(docs, Document{Name: "z.go", Content: []byte("func needle haystack"), Symbols: symbol})
+
+	searcher := searcherForTest(t, testIndexBuilder(t, nil, docs...))
+	q := query.NewOr(&query.Substring{Pattern: "needle"}, &query.Substring{Pattern: "haystack"})
+
+	// searchForTest clears the scores we rank by.
+	search := func(opts SearchOptions) *SearchResult {
+		t.Helper()
+		res, err := searcher.Search(context.Background(), q, &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	names := func(res *SearchResult) []string {
+		SortFiles(res.Files, &SearchOptions{})
+		var names []string
+		for _, f := range res.Files {
+			names = append(names, f.FileName)
+		}
+		return names
+	}
+
+	all := search(SearchOptions{})
+	want := names(all)[:2]
+	if d := cmp.Diff([]string{"z.go", "a.go"}, want); d != "" {
+		t.Fatalf("unexpected ranking (-want +got):\n%s", d)
+	}
+
+	// A match cap misses the best document.
+	capped := search(SearchOptions{ShardMaxMatchCount: 2})
+	if got := names(capped); len(got) != 2 || got[0] == "z.go" {
+		t.Errorf("capped search found %v, expected it to miss z.go", got)
+	}
+
+	for _, chunkMatches := range []bool{false, true} {
+		res := search(SearchOptions{TopK: 2, ChunkMatches: chunkMatches})
+		if d := cmp.Diff(want, names(res)); d != "" {
+			t.Errorf("ChunkMatches=%v: top 2 mismatch (-want +got):\n%s", chunkMatches, d)
+		}
+
+		// The plain documents can't beat the symbol matches found before.
+		if res.Stats.FilesSkipped < 50 {
+			t.Errorf("ChunkMatches=%v: skipped %d files, want at least 50", chunkMatches, res.Stats.FilesSkipped)
+		}
+
+		// z.go has a line, or a chunk with two ranges.
+		wantMatches := 2
+		if chunkMatches {
+			wantMatches = 3
+		}
+		if res.Stats.FileCount != 2 || res.Stats.MatchCount != wantMatches {
+			t.Errorf("ChunkMatches=%v: got FileCount %d, MatchCount %d, want 2, %d", chunkMatches, res.Stats.FileCount, res.Stats.MatchCount, wantMatches)
+		}
+	}
+
+	// ShardMaxMatchCount bounds the matches evaluated, including those of
+	// skipped documents.
+	res := search(SearchOptions{TopK: 2, ShardMaxMatchCount: 10})
+	if got := names(res); len(got) != 2 || got[0] == "z.go" {
+		t.Errorf("capped top 2 found %v, expected it to miss z.go", got)
+	}
+	if res.Stats.FilesConsidered != 10 {
+		t.Errorf("capped top 2 considered %d files, want 10", res.Stats.FilesConsidered)
+	}
+}
+
+func TestTopKFiles(t *testing.T) {
+	topK := newTopKFiles(2)
+	for i, score := range []float64{1, 3, 2, 3, 0} {
+		topK.add(uint32(i), FileMatch{FileName: fmt.Sprint(i), Score: score})
+	}
+	if threshold, ok := topK.threshold(); !ok || threshold != 3 {
+		t.Errorf("got threshold %v, %v, want 3", threshold, ok)
+	}
+
+	// Equal scores keep the earlier document, and files are in doc order.
+	var got []string
+	for _, f := range topK.files() {
+		got = append(got, f.FileName)
+	}
+	if d := cmp.Diff([]string{"1", "3"}, got); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
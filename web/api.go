@@ -52,6 +52,9 @@ type FileMatch struct {
 	Repo     string
 	ResultID string
 	Language string
+	// Encoding is set if the file was converted to UTF-8 from another
+	// character encoding.
+	Encoding string `json:",omitempty"`
 	// If this was a duplicate result, this will contain the file
 	// of the first match.
 	DuplicateID string
@@ -17,12 +17,16 @@ package web
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -30,7 +34,10 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/authz"
 	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/rpc"
+	"github.com/sourcegraph/zoekt/stream"
 )
 
 // TODO(hanwen): cut & paste from ../ . Should create internal test
@@ -955,3 +962,290 @@ func TestHealthz(t *testing.T) {
 		t.Fatal("empty result in response")
 	}
 }
+
+type headerTransport struct {
+	header, value string
+}
+
+func (t headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(t.header, t.value)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestAuthorizer(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name: "name",
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	if err := b.Add(zoekt.Document{
+		Name:    "f2",
+		Content: []byte("to carry water in the no later bla"),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	aclPath := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(aclPath, []byte(`{"alice": ["name"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := authz.NewFileProvider(aclPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+		RPC:      true,
+		Authorizer: &authz.Authorizer{
+			Identify: authz.HeaderIdentity("X-Zoekt-User"),
+			Provider: provider,
+		},
+	}
+
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	clients := map[string]*http.Client{
+		"alice":     {Transport: headerTransport{"X-Zoekt-User", "alice"}},
+		"mallory":   {Transport: headerTransport{"X-Zoekt-User", "mallory"}},
+		"anonymous": http.DefaultClient,
+	}
+
+	for identity, client := range clients {
+		wantFiles := 0
+		if identity == "alice" {
+			wantFiles = 1
+		}
+
+		t.Run(identity, func(t *testing.T) {
+			// HTML
+			res, err := client.Get(ts.URL + "/search?q=water")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if got := strings.Contains(string(body), "carry <b>water</b>"); got != (wantFiles > 0) {
+				t.Errorf("html: got match %v, want %v: %s", got, wantFiles > 0, body)
+			}
+
+			// JSON
+			res, err = client.Post(ts.URL+"/api/search", "application/json", strings.NewReader(`{"Q": "water"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reply struct{ Result zoekt.SearchResult }
+			err = json.NewDecoder(res.Body).Decode(&reply)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(reply.Result.Files); got != wantFiles {
+				t.Errorf("json: got %d files, want %d", got, wantFiles)
+			}
+
+			// Stream
+			got := 0
+			err = stream.NewClient(ts.URL, client).StreamSearch(context.Background(), &query.Substring{Pattern: "water"}, &zoekt.SearchOptions{}, stream.SenderFunc(func(sr *zoekt.SearchResult) {
+				got += len(sr.Files)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != wantFiles {
+				t.Errorf("stream: got %d files, want %d", got, wantFiles)
+			}
+		})
+	}
+
+	// net/rpc can't send custom headers, so RPC clients are anonymous.
+	cl := rpc.Client(strings.TrimPrefix(ts.URL, "http://"))
+	defer cl.Close()
+	sr, err := cl.Search(context.Background(), &query.Substring{Pattern: "water"}, &zoekt.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Files) != 0 {
+		t.Errorf("rpc: got %d files for anonymous caller, want 0", len(sr.Files))
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name: "github.com/org/zoekt",
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	if err := b.Add(zoekt.Document{
+		Name:    "cmd/zoekt-webserver/main.go",
+		Content: []byte("func zoektMain() {}"),
+		Symbols: []zoekt.DocumentSection{{Start: 5, End: 14}},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	checkNeedles(t, ts, "/opensearch.xml", []string{
+		`<?xml version="1.0"`,
+		`template="` + ts.URL + `/search?q={searchTerms}"`,
+		`template="` + ts.URL + `/api/suggest?q={searchTerms}"`,
+	})
+	checkNeedles(t, ts, "/", []string{`href="/opensearch.xml"`, `list="suggestions"`})
+
+	for q, want := range map[string][]string{
+		"foo r:github.com/o": {"foo r:github.com/org/zoekt"},
+		"f:zoekt-web":        {"f:cmd/zoekt-webserver/main.go"},
+		"sym:zoektm":         {"sym:zoektMain"},
+		"needle zoek":        {"needle zoektMain", "needle f:cmd/zoekt-webserver/main.go", "needle r:github.com/org/zoekt"},
+		"r:nomatch":          {},
+		"foo ":               {},
+	} {
+		res, err := http.Get(ts.URL + "/api/suggest?q=" + url.QueryEscape(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []interface{}
+		err = json.NewDecoder(res.Body).Decode(&got)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%q: %v", q, err)
+		}
+
+		var completions []string
+		for _, c := range got[1].([]interface{}) {
+			completions = append(completions, c.(string))
+		}
+		if got[0] != q || !reflect.DeepEqual(completions, want) && len(completions)+len(want) > 0 {
+			t.Errorf("%q: got %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	b, err := zoekt.NewIndexBuilder(&zoekt.Repository{
+		Name:     "name",
+		Branches: []zoekt.RepositoryBranch{{Name: "main", Version: "1234"}, {Name: "dev", Version: "5678"}},
+	})
+	if err != nil {
+		t.Fatalf("NewIndexBuilder: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.Add(zoekt.Document{
+			Name:     fmt.Sprintf("f%d", i),
+			Content:  []byte("needle, \"quoted\"\nhay\nneedle again\n"),
+			Branches: []string{"main", "dev"},
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	srv := Server{
+		Searcher: searcherForTest(t, b),
+		Top:      Top,
+		HTML:     true,
+	}
+	mux, err := NewMux(&srv)
+	if err != nil {
+		t.Fatalf("NewMux: %v", err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(req string) (*http.Response, string) {
+		t.Helper()
+		res, err := http.Get(ts.URL + req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+
+	// num doesn't cap exports.
+	res, body := get("/search?q=needle&format=csv&num=1")
+	if got, want := res.Header.Get("Content-Type"), "text/csv; charset=utf-8"; got != want {
+		t.Errorf("got Content-Type %q, want %q", got, want)
+	}
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 7 {
+		t.Fatalf("got %d rows, want header and 6 matches: %q", len(rows), rows)
+	}
+	if diff := cmp.Diff([]string{"repository", "branches", "file", "line_number", "line"}, rows[0]); diff != "" {
+		t.Errorf("header mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"name", "main,dev", "f0", "1", `needle, "quoted"`}, rows[1]); diff != "" {
+		t.Errorf("row mismatch (-want +got):\n%s", diff)
+	}
+
+	_, body = get("/search?q=again&format=jsonl")
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3: %s", len(lines), body)
+	}
+	var row exportRow
+	if err := json.Unmarshal([]byte(lines[2]), &row); err != nil {
+		t.Fatal(err)
+	}
+	want := exportRow{Repository: "name", Branches: []string{"main", "dev"}, FileName: "f2", LineNumber: 3, Line: "needle again"}
+	if diff := cmp.Diff(want, row); diff != "" {
+		t.Errorf("row mismatch (-want +got):\n%s", diff)
+	}
+
+	// No matches still yields a valid export.
+	if _, body = get("/search?q=nomatch&format=csv"); body != "repository,branches,file,line_number,line\n" {
+		t.Errorf("got %q for empty export", body)
+	}
+	if res, _ = get("/search?q=(&format=jsonl"); res.StatusCode == http.StatusOK {
+		t.Errorf("got status %d for invalid query", res.StatusCode)
+	}
+	for _, req := range []string{"/search?q=type:repo+needle&format=csv", "/search?q=needle&format=csv&timeout=soon"} {
+		if res, _ = get(req); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", req, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// Exports cut off by a limit end with a marker.
+	srv.ExportMaxMatchCount = 2
+	_, body = get("/search?q=needle&format=csv&timeout=1m")
+	if rows, err = csv.NewReader(strings.NewReader(body)).ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if last := rows[len(rows)-1]; last[0] != "#truncated" || last[4] == "" {
+		t.Errorf("got last row %q, want a truncation marker", last)
+	}
+	_, body = get("/search?q=needle&format=jsonl")
+	lines = strings.Split(strings.TrimSpace(body), "\n")
+	var trailer struct{ Truncated string }
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &trailer); err != nil || trailer.Truncated == "" {
+		t.Errorf("got last line %s, want a truncation marker", lines[len(lines)-1])
+	}
+}
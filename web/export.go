@@ -0,0 +1,288 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/quota"
+	"github.com/sourcegraph/zoekt/query"
+	"github.com/sourcegraph/zoekt/stream"
+)
+
+// exportFormats maps the supported values of the "format" parameter for
+// exports to their content type.
+var exportFormats = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+}
+
+// exportRow is a single matching line of an export. File name matches are
+// exported with LineNumber 0 and an empty Line.
+type exportRow struct {
+	Repository string
+	Branches   []string
+	FileName   string
+	LineNumber int
+	Line       string
+}
+
+var exportHeader = []string{"repository", "branches", "file", "line_number", "line"}
+
+const (
+	// defaultExportMaxWallTime is used if Server.ExportMaxWallTime is 0.
+	defaultExportMaxWallTime = time.Minute
+
+	// defaultExportMaxMatchCount is used if Server.ExportMaxMatchCount is 0.
+	defaultExportMaxMatchCount = 10_000_000
+)
+
+// rowWriter writes export rows in one of the exportFormats.
+type rowWriter interface {
+	Write(row *exportRow) error
+
+	// Truncated writes a last row which marks the export as incomplete for
+	// reason.
+	Truncated(reason string) error
+
+	Flush() error
+}
+
+// newRowWriter sets the response headers for format and returns a rowWriter
+// writing to w. CSV exports start with a header row.
+func newRowWriter(w http.ResponseWriter, format string) (rowWriter, error) {
+	w.Header().Set("Content-Type", exportFormats[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "zoekt-results."+format))
+
+	if format == "jsonl" {
+		return jsonlRowWriter{enc: json.NewEncoder(w)}, nil
+	}
+	c := csvRowWriter{w: csv.NewWriter(w)}
+	return c, c.w.Write(exportHeader)
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c csvRowWriter) Write(row *exportRow) error {
+	return c.w.Write([]string{
+		row.Repository,
+		strings.Join(row.Branches, ","),
+		row.FileName,
+		strconv.Itoa(row.LineNumber),
+		row.Line,
+	})
+}
+
+// Truncated writes a row whose repository is "#truncated", which no
+// repository is named, and whose line is reason.
+func (c csvRowWriter) Truncated(reason string) error {
+	return c.w.Write([]string{"#truncated", "", "", "", reason})
+}
+
+func (c csvRowWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlRowWriter struct {
+	enc *json.Encoder
+}
+
+func (j jsonlRowWriter) Write(row *exportRow) error {
+	return j.enc.Encode(row)
+}
+
+// Truncated writes an object whose only field is Truncated.
+func (j jsonlRowWriter) Truncated(reason string) error {
+	return j.enc.Encode(struct{ Truncated string }{reason})
+}
+
+func (j jsonlRowWriter) Flush() error {
+	return nil
+}
+
+// serveExport streams all matches of the query in the "q" parameter as CSV
+// or JSON Lines. Unlike the HTML interface, the number of files isn't capped,
+// so results are written as they arrive rather than being collected first.
+// The search is bounded by Server.ExportMaxWallTime, which the "timeout"
+// parameter may lower, and Server.ExportMaxMatchCount. If results were left
+// out, the export ends with a row saying so, see rowWriter.Truncated.
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request, format string) {
+	queryStr := r.URL.Query().Get("q")
+	if queryStr == "" {
+		http.Error(w, "no query found", http.StatusTeapot)
+		return
+	}
+
+	q, err := query.Parse(queryStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+
+	// Exports have a row per matching line or file, repository results have
+	// neither.
+	isRepoType := false
+	query.Map(q, func(q query.Q) query.Q {
+		if t, ok := q.(*query.Type); ok && t.Type == query.TypeRepo {
+			isRepoType = true
+		}
+		return q
+	})
+	if isRepoType {
+		http.Error(w, "type:repo queries can't be exported", http.StatusBadRequest)
+		return
+	}
+
+	wallTime := s.ExportMaxWallTime
+	if wallTime == 0 {
+		wallTime = defaultExportMaxWallTime
+	}
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", t), http.StatusBadRequest)
+			return
+		}
+		if d < wallTime {
+			wallTime = d
+		}
+	}
+	maxMatchCount := s.ExportMaxMatchCount
+	if maxMatchCount == 0 {
+		maxMatchCount = defaultExportMaxMatchCount
+	}
+
+	sOpts := zoekt.SearchOptions{
+		MaxWallTime:        wallTime,
+		ShardMaxMatchCount: maxMatchCount,
+		TotalMaxMatchCount: maxMatchCount,
+		Class:              zoekt.SearchClassBackground,
+	}
+	sOpts.SetDefaults()
+
+	// The response is only started once the first result arrives, so errors
+	// before that still get a proper status code.
+	var (
+		mu       sync.Mutex
+		rw       rowWriter
+		writeErr error
+		stats    zoekt.Stats
+	)
+	flusher, _ := w.(http.Flusher)
+
+	err = s.Searcher.StreamSearch(r.Context(), q, &sOpts, stream.SenderFunc(func(event *zoekt.SearchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		stats.Add(event.Stats)
+		if len(event.Files) == 0 || writeErr != nil {
+			return
+		}
+		if rw == nil {
+			if rw, writeErr = newRowWriter(w, format); writeErr != nil {
+				return
+			}
+		}
+		for i := range event.Files {
+			if writeErr = writeFileMatch(rw, &event.Files[i]); writeErr != nil {
+				return
+			}
+		}
+		if writeErr = rw.Flush(); writeErr == nil && flusher != nil {
+			flusher.Flush()
+		}
+	}))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if rw == nil {
+		if errors.Is(err, quota.ErrOverQuota) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusTeapot)
+			return
+		}
+		// No results, still write a valid (empty) export.
+		rw, writeErr = newRowWriter(w, format)
+	}
+	if writeErr == nil {
+		if reason := truncationReason(err, &stats, maxMatchCount); reason != "" {
+			writeErr = rw.Truncated(reason)
+		}
+	}
+	if err == nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		// We already sent a 200, the best we can do is to truncate the
+		// export and log.
+		log.Printf("export of %q failed: %v", queryStr, err)
+	}
+}
+
+// truncationReason returns why the results of a search with stats, which
+// ended with err, are incomplete, or "" if they are complete.
+func truncationReason(err error, stats *zoekt.Stats, maxMatchCount int) string {
+	var reasons []string
+	if err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	// Shards which weren't searched after the limit was hit aren't counted
+	// as skipped.
+	if stats.MatchCount >= maxMatchCount {
+		reasons = append(reasons, fmt.Sprintf("match limit of %d reached", maxMatchCount))
+	}
+	if stats.Crashes > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d shards crashed", stats.Crashes))
+	}
+	if stats.ShardsTimedOut > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d shards timed out", stats.ShardsTimedOut))
+	}
+	if stats.ShardsSkipped > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d shards skipped", stats.ShardsSkipped))
+	}
+	if stats.FilesSkipped > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d files skipped", stats.FilesSkipped))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func writeFileMatch(rw rowWriter, f *zoekt.FileMatch) error {
+	row := exportRow{
+		Repository: f.Repository,
+		Branches:   f.Branches,
+		FileName:   f.FileName,
+	}
+
+	if len(f.LineMatches) == 0 {
+		return rw.Write(&row)
+	}
+	for _, l := range f.LineMatches {
+		row.LineNumber, row.Line = 0, ""
+		if !l.FileName {
+			row.LineNumber = l.LineNumber
+			row.Line = string(bytes.TrimSuffix(l.Line, []byte{'\n'}))
+		}
+		if err := rw.Write(&row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
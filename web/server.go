@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -32,6 +33,8 @@ import (
 
 	"github.com/grafana/regexp"
 	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/internal/authz"
+	"github.com/sourcegraph/zoekt/internal/quota"
 	zjson "github.com/sourcegraph/zoekt/json"
 	"github.com/sourcegraph/zoekt/query"
 	"github.com/sourcegraph/zoekt/rpc"
@@ -99,6 +102,25 @@ type Server struct {
 	// domains.
 	HostCustomQueries map[string]string
 
+	// Authorizer, if non-nil, restricts the repositories each caller can
+	// search. It applies to the HTML interface as well as the RPC, JSON and
+	// streaming APIs.
+	Authorizer *authz.Authorizer
+
+	// Limiter, if non-nil, enforces per client request rates and search cost
+	// budgets on the HTML interface as well as the RPC, JSON and streaming
+	// APIs.
+	Limiter *quota.Limiter
+
+	// ExportMaxWallTime bounds the time a CSV or JSON Lines export searches.
+	// Requests may lower it with the "timeout" parameter. If 0, one minute is
+	// used.
+	ExportMaxWallTime time.Duration
+
+	// ExportMaxMatchCount caps the number of matches of an export, in each
+	// shard and in total. If 0, 10 million is used.
+	ExportMaxMatchCount int
+
 	// This should contain the following templates: "repolist"
 	// (for the repo search result page), "result" for
 	// the search results, "search" (for the opening page),
@@ -106,12 +128,13 @@ type Server struct {
 	// "print" for the show file functionality.
 	Top *template.Template
 
-	repolist *template.Template
-	search   *template.Template
-	result   *template.Template
-	print    *template.Template
-	about    *template.Template
-	robots   *template.Template
+	repolist   *template.Template
+	search     *template.Template
+	result     *template.Template
+	print      *template.Template
+	about      *template.Template
+	robots     *template.Template
+	opensearch *template.Template
 
 	startTime time.Time
 
@@ -147,12 +170,13 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	}
 
 	for k, v := range map[string]**template.Template{
-		"results":  &s.result,
-		"print":    &s.print,
-		"search":   &s.search,
-		"repolist": &s.repolist,
-		"about":    &s.about,
-		"robots":   &s.robots,
+		"results":    &s.result,
+		"print":      &s.print,
+		"search":     &s.search,
+		"repolist":   &s.repolist,
+		"about":      &s.about,
+		"robots":     &s.robots,
+		"opensearch": &s.opensearch,
 	} {
 		*v = s.Top.Lookup(k)
 		if *v == nil {
@@ -163,19 +187,28 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	s.templateCache = map[string]*template.Template{}
 	s.startTime = time.Now()
 
+	if s.Limiter != nil {
+		s.Searcher = s.Limiter.Searcher(s.Searcher)
+	}
+	if s.Authorizer != nil {
+		s.Searcher = s.Authorizer.Searcher(s.Searcher)
+	}
+
 	mux := http.NewServeMux()
 
 	if s.HTML {
 		mux.HandleFunc("/robots.txt", s.serveRobots)
-		mux.HandleFunc("/search", s.serveSearch)
-		mux.HandleFunc("/", s.serveSearchBox)
-		mux.HandleFunc("/about", s.serveAbout)
-		mux.HandleFunc("/print", s.servePrint)
+		mux.HandleFunc("/opensearch.xml", s.serveOpenSearch)
+		mux.Handle("/api/suggest", s.authorize(http.HandlerFunc(s.serveSuggest)))
+		mux.Handle("/search", s.authorize(http.HandlerFunc(s.serveSearch)))
+		mux.Handle("/", s.authorize(http.HandlerFunc(s.serveSearchBox)))
+		mux.Handle("/about", s.authorize(http.HandlerFunc(s.serveAbout)))
+		mux.Handle("/print", s.authorize(http.HandlerFunc(s.servePrint)))
 	}
 	if s.RPC {
-		mux.Handle(rpc.DefaultRPCPath, rpc.Server(traceAwareSearcher{s.Searcher})) // /rpc
-		mux.Handle("/api/", http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.Searcher})))
-		mux.Handle(stream.DefaultSSEPath, stream.Server(traceAwareSearcher{s.Searcher})) // /stream
+		mux.Handle(rpc.DefaultRPCPath, s.rpcHandler()) // /rpc
+		mux.Handle("/api/", s.authorize(http.StripPrefix("/api", zjson.JSONServer(traceAwareSearcher{s.Searcher}))))
+		mux.Handle(stream.DefaultSSEPath, s.authorize(stream.Server(traceAwareSearcher{s.Searcher}))) // /stream
 	}
 
 	mux.HandleFunc("/healthz", s.serveHealthz)
@@ -183,6 +216,39 @@ func NewMux(s *Server) (*http.ServeMux, error) {
 	return mux, nil
 }
 
+// authorize attaches the identity and quota client of the caller to the
+// request context if authorization or rate limiting is enabled. The quota
+// client is derived from the identity, so the identity is attached first.
+func (s *Server) authorize(h http.Handler) http.Handler {
+	if s.Limiter != nil {
+		h = s.Limiter.Middleware(h)
+	}
+	if s.Authorizer != nil {
+		h = s.Authorizer.Middleware(h)
+	}
+	return h
+}
+
+// rpcHandler returns the handler for the RPC API. RPC calls don't inherit the
+// context of the HTTP request which opened the connection, so with
+// authorization or rate limiting enabled we serve each connection with a
+// searcher bound to the caller.
+func (s *Server) rpcHandler() http.Handler {
+	if s.Authorizer == nil && s.Limiter == nil {
+		return rpc.Server(traceAwareSearcher{s.Searcher})
+	}
+	return s.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searcher := s.Searcher
+		if s.Authorizer != nil {
+			searcher = authz.BindIdentity(searcher, s.Authorizer.Identity(r))
+		}
+		if s.Limiter != nil {
+			searcher = s.Limiter.BindClient(searcher, quota.ClientKey(r))
+		}
+		rpc.Server(traceAwareSearcher{searcher}).ServeHTTP(w, r)
+	}))
+}
+
 func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
 	q := &query.Const{Value: true}
 	opts := &zoekt.SearchOptions{ShardMaxMatchCount: 1, TotalMaxMatchCount: 1, MaxDocDisplayCount: 1}
@@ -199,8 +265,16 @@ func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); exportFormats[format] != "" {
+		s.serveExport(w, r, format)
+		return
+	}
+
 	result, err := s.serveSearchErr(r)
-	if err != nil {
+	if errors.Is(err, quota.ErrOverQuota) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusTeapot)
 		return
 	}
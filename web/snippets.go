@@ -97,6 +97,7 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			ResultID:   f.Repository + ":" + f.FileName,
 			Branches:   f.Branches,
 			Language:   f.Language,
+			Encoding:   f.Encoding,
 			Score:      f.Score,
 			ScoreDebug: f.Debug,
 		}
@@ -107,11 +108,16 @@ func (s *Server) formatResults(result *zoekt.SearchResult, query string, localPr
 			seenFiles[string(f.Checksum)] = fMatch.ResultID
 		}
 
+		// Extracted documents link to the file they were extracted from.
+		fileName := f.FileName
+		if f.OriginalFileName != "" {
+			fileName = f.OriginalFileName
+		}
 		if f.SubRepositoryName != "" {
-			fn := strings.TrimPrefix(fMatch.FileName[len(f.SubRepositoryPath):], "/")
+			fn := strings.TrimPrefix(fileName[len(f.SubRepositoryPath):], "/")
 			fMatch.URL = getURL(f.SubRepositoryName, fn, f.Branches, f.Version)
 		} else {
-			fMatch.URL = getURL(f.Repository, f.FileName, f.Branches, f.Version)
+			fMatch.URL = getURL(f.Repository, fileName, f.Branches, f.Version)
 		}
 
 		for _, m := range f.LineMatches {
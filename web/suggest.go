@@ -0,0 +1,271 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/regexp"
+
+	"github.com/sourcegraph/zoekt"
+	"github.com/sourcegraph/zoekt/query"
+)
+
+const (
+	defaultNumSuggestions = 10
+	maxNumSuggestions     = 50
+
+	// suggestions are requested on every keystroke, so they have to be cheap.
+	suggestMaxWallTime = 500 * time.Millisecond
+)
+
+// OpenSearchInput is the input to the "opensearch" template.
+type OpenSearchInput struct {
+	// BaseURL is the scheme and host the description was requested from,
+	// eg. "https://zoekt.example.com".
+	BaseURL string
+}
+
+func (s *Server) serveOpenSearch(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	d := OpenSearchInput{BaseURL: scheme + "://" + r.Host}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := s.opensearch.Execute(&buf, &d); err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// serveSuggest returns completions for the query in the "q" parameter in the
+// OpenSearch suggestions format, ie. a JSON array of the query and the list
+// of completed queries.
+func (s *Server) serveSuggest(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	q := qvals.Get("q")
+
+	num, err := strconv.Atoi(qvals.Get("num"))
+	if err != nil || num <= 0 {
+		num = defaultNumSuggestions
+	}
+	if num > maxNumSuggestions {
+		num = maxNumSuggestions
+	}
+
+	completions, err := s.suggest(r.Context(), q, num)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTeapot)
+		return
+	}
+	if completions == nil {
+		completions = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	_ = json.NewEncoder(w).Encode([]interface{}{q, completions})
+}
+
+// suggest completes the last word of q. Words with a "r:", "f:" or "sym:"
+// prefix are completed with repository, file and symbol names respectively.
+// Other words are completed with any of them.
+func (s *Server) suggest(ctx context.Context, q string, num int) ([]string, error) {
+	head, word := "", q
+	if i := strings.LastIndexAny(q, " \t"); i >= 0 {
+		head, word = q[:i+1], q[i+1:]
+	}
+
+	field, prefix := "", word
+	if i := strings.IndexByte(word, ':'); i >= 0 {
+		field, prefix = word[:i], word[i+1:]
+	}
+	if prefix == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, suggestMaxWallTime)
+	defer cancel()
+
+	var completions []string
+	add := func(atom string, names []string) {
+		for _, n := range names {
+			if len(completions) >= num {
+				return
+			}
+			completions = append(completions, head+atom+n)
+		}
+	}
+
+	switch field {
+	case "r", "repo":
+		names, err := s.suggestRepos(ctx, prefix, num)
+		if err != nil {
+			return nil, err
+		}
+		add(field+":", names)
+	case "f", "file":
+		names, err := s.suggestFiles(ctx, prefix, num)
+		if err != nil {
+			return nil, err
+		}
+		add(field+":", names)
+	case "sym":
+		names, err := s.suggestSymbols(ctx, prefix, num)
+		if err != nil {
+			return nil, err
+		}
+		add(field+":", names)
+	case "":
+		syms, err := s.suggestSymbols(ctx, prefix, num)
+		if err != nil {
+			return nil, err
+		}
+		files, err := s.suggestFiles(ctx, prefix, num)
+		if err != nil {
+			return nil, err
+		}
+		repos, err := s.suggestRepos(ctx, prefix, num)
+		if err != nil {
+			return nil, err
+		}
+		add("", syms)
+		add("f:", files)
+		add("r:", repos)
+	}
+	return completions, nil
+}
+
+// suggestRepos returns the names of repositories containing prefix. Names
+// starting with prefix come first.
+func (s *Server) suggestRepos(ctx context.Context, prefix string, num int) ([]string, error) {
+	re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := s.Searcher.List(ctx, &query.Repo{Regexp: re}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range repos.Repos {
+		names = append(names, r.Repository.Name)
+	}
+
+	lower := strings.ToLower(prefix)
+	sort.Slice(names, func(i, j int) bool {
+		pi := strings.HasPrefix(strings.ToLower(names[i]), lower)
+		pj := strings.HasPrefix(strings.ToLower(names[j]), lower)
+		if pi != pj {
+			return pi
+		}
+		return names[i] < names[j]
+	})
+	return dedupFirst(names, num), nil
+}
+
+// suggestFiles returns file names with a path component starting with
+// prefix. The file name query is answered from the file name ngram index.
+func (s *Server) suggestFiles(ctx context.Context, prefix string, num int) ([]string, error) {
+	re, err := syntax.Parse(`(^|/)`+regexp.QuoteMeta(prefix), syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.Searcher.Search(ctx, &query.Regexp{Regexp: re, FileName: true}, suggestSearchOptions(num))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range result.Files {
+		names = append(names, f.FileName)
+	}
+	sort.Strings(names)
+	return dedupFirst(names, num), nil
+}
+
+// suggestSymbols returns the names of symbol definitions starting with
+// prefix.
+func (s *Server) suggestSymbols(ctx context.Context, prefix string, num int) ([]string, error) {
+	re, err := syntax.Parse("^"+regexp.QuoteMeta(prefix), syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &query.Symbol{Expr: &query.Regexp{Regexp: re}}
+	result, err := s.Searcher.Search(ctx, q, suggestSearchOptions(num))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range result.Files {
+		for _, l := range f.LineMatches {
+			for _, fr := range l.LineFragments {
+				if fr.SymbolInfo != nil {
+					names = append(names, fr.SymbolInfo.Sym)
+					continue
+				}
+				// Without ctags metadata the fragment only covers the
+				// prefix, so extend it to the end of the identifier.
+				if fr.LineOffset < len(l.Line) {
+					names = append(names, identifierAt(l.Line[fr.LineOffset:]))
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return dedupFirst(names, num), nil
+}
+
+func suggestSearchOptions(num int) *zoekt.SearchOptions {
+	return &zoekt.SearchOptions{
+		ShardMaxMatchCount: 10 * num,
+		TotalMaxMatchCount: 100 * num,
+		MaxDocDisplayCount: 10 * num,
+		MaxWallTime:        suggestMaxWallTime,
+	}
+}
+
+func identifierAt(b []byte) string {
+	end := 0
+	for end < len(b) {
+		c := b[end]
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') && c < 0x80 {
+			break
+		}
+		end++
+	}
+	return string(b[:end])
+}
+
+// dedupFirst returns the first num distinct non-empty entries of names.
+func dedupFirst(names []string, num int) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range names {
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+		if len(out) >= num {
+			break
+		}
+	}
+	return out
+}
@@ -30,6 +30,7 @@ var TemplateText = map[string]string{
 <meta http-equiv="X-UA-Compatible" content="IE=edge">
 <meta name="viewport" content="width=device-width, initial-scale=1">
 <!-- Licensed under MIT (https://github.com/twbs/bootstrap/blob/master/LICENSE) -->
+<link rel="search" type="application/opensearchdescription+xml" title="Zoekt" href="/opensearch.xml">
 <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css" integrity="sha384-BVYiiSIFeK1dGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
 <style>
   #navsearchbox { width: 350px !important; }
@@ -83,13 +84,50 @@ var TemplateText = map[string]string{
               {{if .Query}}
               value={{.Query}}
               {{end}}
-              id="searchbox" type="text" name="q">
+              id="searchbox" type="text" name="q" list="suggestions" autocomplete="off">
       <div class="input-group-btn">
         <button class="btn btn-primary">Search</button>
       </div>
     </div>
   </div>
 </form>
+{{template "autocomplete" "searchbox"}}
+`,
+
+	// suggests completions from /api/suggest for the input element whose
+	// id is passed as the template argument.
+	"autocomplete": `
+<datalist id="suggestions"></datalist>
+<script>
+(function() {
+  var input = document.getElementById({{.}});
+  var list = document.getElementById("suggestions");
+  var pending = null;
+  input.addEventListener("input", function() {
+    var q = input.value;
+    if (pending) {
+      clearTimeout(pending);
+    }
+    pending = setTimeout(function() {
+      var req = new XMLHttpRequest();
+      req.open("GET", "/api/suggest?q=" + encodeURIComponent(q));
+      req.onload = function() {
+        if (req.status != 200 || input.value != q) {
+          return;
+        }
+        var completions = JSON.parse(req.responseText)[1];
+        list.innerHTML = "";
+        for (var i = 0; i < completions.length; i++) {
+          var option = document.createElement("option");
+          option.value = completions[i];
+          list.appendChild(option);
+        }
+      };
+      req.send();
+    }, 150);
+  });
+})();
+</script>
 `,
 
 	"navbar": `
@@ -110,6 +148,7 @@ var TemplateText = map[string]string{
           <input class="form-control"
                 placeholder="Search for some code..." role="search"
                 id="navsearchbox" type="text" name="q" autofocus
+                list="suggestions" autocomplete="off"
                 {{if .Query}}
                 value={{.Query}}
                 {{end}}>
@@ -125,6 +164,7 @@ var TemplateText = map[string]string{
     </div>
   </div>
 </nav>
+{{template "autocomplete" "navsearchbox"}}
 <script>
 document.onkeydown=function(e){
   var e = e || window.event;
@@ -232,6 +272,7 @@ document.onkeydown=function(e){
               {{if .Language}}<button
                    title="restrict search to files written in {{.Language}}"
                    onclick="zoektAddQ('lang:&quot;{{.Language}}&quot;')" class="label label-primary">language {{.Language}}</button></span>{{end}}
+              {{if .Encoding}}<span class="label label-default" title="converted to UTF-8 for display">{{.Encoding}}</span>{{end}}
               {{if .DuplicateID}}<a class="label label-dup" href="#{{.DuplicateID}}">Duplicate result</a>{{end}}
             </small>
           </th>
@@ -266,6 +307,9 @@ document.onkeydown=function(e){
       {{.Stats.ShardsSkippedFilter}} shards filtered
       {{- if or .Stats.FilesSkipped .Stats.ShardsSkipped -}}
         , {{.Stats.FilesSkipped}} docs skipped, {{.Stats.ShardsSkipped}} shards skipped
+      {{- end -}}
+      {{- if .Stats.ShardsTimedOut -}}
+        , {{.Stats.ShardsTimedOut}} shards timed out
       {{- end -}}
 	  .
       </p>
@@ -392,6 +436,16 @@ document.onkeydown=function(e){
       </p>
     </div>
   </nav>
+`,
+	// OpenSearch description, the XML declaration is written by the handler
+	// since html/template escapes it.
+	"opensearch": `<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>Zoekt</ShortName>
+  <Description>Search code with Zoekt</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <Url type="text/html" method="get" template="{{.BaseURL}}/search?q={searchTerms}"/>
+  <Url type="application/x-suggestions+json" method="get" template="{{.BaseURL}}/api/suggest?q={searchTerms}"/>
+</OpenSearchDescription>
 `,
 	"robots": `
 user-agent: *
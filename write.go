@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -39,6 +40,9 @@ func (w *writer) writeTOC(toc *indexTOC) {
 	w.U32(0)
 	secs := toc.sectionsTaggedList()
 	for _, s := range secs {
+		if c, ok := s.sec.(*compoundSection); ok && optionalSections[s.tag] && len(c.offsets) == 0 {
+			continue
+		}
 		w.String(s.tag)
 		w.Varint(uint32(s.sec.kind()))
 		s.sec.write(w)
@@ -205,6 +209,27 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 	}
 	toc.ranks.end(w)
 
+	if b.hasSymbolRefs() {
+		toc.symbolRefs.start(w)
+		for _, refs := range b.symbolRefs {
+			toc.symbolRefs.addItem(w, marshalDocSections(refs))
+		}
+		toc.symbolRefs.end(w)
+	}
+
+	if b.hasSymbolIDs() {
+		toc.symbolIDs.start(w)
+		for _, ids := range b.symbolIDs {
+			toc.symbolIDs.addItem(w, []byte(strings.Join(ids, "\n")))
+		}
+		toc.symbolIDs.end(w)
+	}
+
+	writeDocStrings(w, &toc.originalNames, b.originalNames)
+	writeDocStrings(w, &toc.encodings, b.encodings)
+	writeDocStrings(w, &toc.commits, b.commits)
+	writeDocStrings(w, &toc.blames, b.blames)
+
 	var tocSection simpleSection
 
 	tocSection.start(w)
@@ -214,6 +239,45 @@ func (b *IndexBuilder) Write(out io.Writer) error {
 	return w.err
 }
 
+func (b *IndexBuilder) hasSymbolRefs() bool {
+	for _, refs := range b.symbolRefs {
+		if len(refs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *IndexBuilder) hasSymbolIDs() bool {
+	for _, ids := range b.symbolIDs {
+		if ids != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDocStrings writes an optional section holding a string per document.
+// It is only written if one of the strings is non-empty.
+func writeDocStrings(w *writer, sec *compoundSection, values []string) {
+	empty := true
+	for _, v := range values {
+		if v != "" {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return
+	}
+
+	sec.start(w)
+	for _, v := range values {
+		sec.addItem(w, []byte(v))
+	}
+	sec.end(w)
+}
+
 func (b *IndexBuilder) writeJSON(data interface{}, sec *simpleSection, w *writer) error {
 	blob, err := json.Marshal(data)
 	if err != nil {